@@ -152,8 +152,7 @@ export default function DatasetApprovalsPage() {
                         </button>
 
                         {ch.status === 'pending' && me && (() => {
-                          let ids: number[] = []
-                          try { if (typeof (ch as any).reviewers === 'string' && (ch as any).reviewers.trim().startsWith('[')) ids = JSON.parse((ch as any).reviewers) } catch { }
+                          const ids: number[] = ((ch as any).reviewers || []).filter((r: any) => r.status !== 'delegated').map((r: any) => r.user_id)
                           const isAssigned = (ch.reviewer_id && me.id === ch.reviewer_id) || ids.includes(me.id)
                           return isAssigned || isApprover
                         })() && (
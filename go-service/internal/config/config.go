@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -34,6 +35,20 @@ type Config struct {
 	GoogleClientID     string
 	GoogleClientSecret string
 
+	// Queries
+	QueryTimeout         int // seconds
+	MaxConcurrentQueries int // per user
+	AsyncQueryTimeout    int // seconds
+	QueryResultsDir      string
+	QueryResultRetention int // hours
+	QueryCacheEntries    int // 0 disables the result cache
+
+	// Jobs
+	JobWorkers          int // jobs run at once per instance
+	JobLeaseSeconds     int
+	JobMaxAttempts      int
+	JobRetryBaseSeconds int // backoff after the first failed attempt, doubled after each
+
 	// Features
 	DisableWorker bool
 }
@@ -56,6 +71,16 @@ func Load() (*Config, error) {
 		SessionTimeout:        getIntEnv("SESSION_TIMEOUT_HOURS", 24),
 		GoogleClientID:        os.Getenv("GOOGLE_CLIENT_ID"),
 		GoogleClientSecret:    os.Getenv("GOOGLE_CLIENT_SECRET"),
+		QueryTimeout:          getIntEnv("QUERY_TIMEOUT_SECONDS", 15),
+		MaxConcurrentQueries:  getIntEnv("MAX_CONCURRENT_QUERIES_PER_USER", 3),
+		AsyncQueryTimeout:     getIntEnv("QUERY_ASYNC_TIMEOUT_SECONDS", 600),
+		QueryResultsDir:       getEnv("QUERY_RESULTS_DIR", filepath.Join(os.TempDir(), "oreo-query-results")),
+		QueryResultRetention:  getIntEnv("QUERY_RESULT_RETENTION_HOURS", 24),
+		QueryCacheEntries:     getIntEnv("QUERY_CACHE_ENTRIES", 256),
+		JobWorkers:            getIntEnv("JOB_WORKERS", 4),
+		JobLeaseSeconds:       getIntEnv("JOB_LEASE_SECONDS", 60),
+		JobMaxAttempts:        getIntEnv("JOB_MAX_ATTEMPTS", 5),
+		JobRetryBaseSeconds:   getIntEnv("JOB_RETRY_BASE_SECONDS", 10),
 		DisableWorker:         getBoolEnv("DISABLE_WORKER", false),
 	}
 
@@ -93,9 +118,9 @@ func (c *Config) Validate() error {
 	}
 
 	// Storage backend validation
-	validBackends := map[string]bool{"delta": true, "postgres": true, "sqlite": true}
+	validBackends := map[string]bool{"delta": true, "delta-native": true, "postgres": true, "sqlite": true}
 	if !validBackends[c.DefaultStorageBackend] {
-		errors = append(errors, fmt.Sprintf("DEFAULT_STORAGE_BACKEND must be one of: delta, postgres, sqlite (got: %s)", c.DefaultStorageBackend))
+		errors = append(errors, fmt.Sprintf("DEFAULT_STORAGE_BACKEND must be one of: delta, delta-native, postgres, sqlite (got: %s)", c.DefaultStorageBackend))
 	}
 
 	// Python service URL validation
@@ -103,6 +128,34 @@ func (c *Config) Validate() error {
 		errors = append(errors, "PYTHON_SERVICE_URL must start with http:// or https://")
 	}
 
+	if c.QueryTimeout <= 0 {
+		errors = append(errors, "QUERY_TIMEOUT_SECONDS must be positive")
+	}
+	if c.MaxConcurrentQueries <= 0 {
+		errors = append(errors, "MAX_CONCURRENT_QUERIES_PER_USER must be positive")
+	}
+	if c.AsyncQueryTimeout <= 0 {
+		errors = append(errors, "QUERY_ASYNC_TIMEOUT_SECONDS must be positive")
+	}
+	if c.QueryResultRetention <= 0 {
+		errors = append(errors, "QUERY_RESULT_RETENTION_HOURS must be positive")
+	}
+	if c.QueryCacheEntries < 0 {
+		errors = append(errors, "QUERY_CACHE_ENTRIES must not be negative")
+	}
+	if c.JobWorkers <= 0 {
+		errors = append(errors, "JOB_WORKERS must be positive")
+	}
+	if c.JobLeaseSeconds <= 0 {
+		errors = append(errors, "JOB_LEASE_SECONDS must be positive")
+	}
+	if c.JobMaxAttempts <= 0 {
+		errors = append(errors, "JOB_MAX_ATTEMPTS must be positive")
+	}
+	if c.JobRetryBaseSeconds <= 0 {
+		errors = append(errors, "JOB_RETRY_BASE_SECONDS must be positive")
+	}
+
 	if len(errors) > 0 {
 		return fmt.Errorf("configuration errors:\n  - %s", strings.Join(errors, "\n  - "))
 	}
@@ -0,0 +1,579 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"gorm.io/gorm"
+)
+
+// Appends of CSV and JSON files are validated here, in one pass over the file, instead of by
+// the Python /compare-schema, /validate and /rules/validate endpoints; other formats still go
+// to Python. The responses keep the Python shapes. The file is compared with the dataset
+// schema as Python did: expected columns it lacks, and columns whose values are of a type the
+// schema column does not take, make it incompatible. Rows are then validated against the
+// JSON Schema keywords dataset schemas use (type, required, enum, minimum, maximum and their
+// exclusive forms, minLength, maxLength, pattern and additionalProperties: false; others are
+// not checked) and against the dataset's rules.
+//
+// CSV has no types and no nulls: a value is read as the schema type of its column when it
+// parses as one, and an empty cell is left out of its row, so only required catches it.
+// Positions are data row indices from 0 and, for columns, the index in the CSV header or, as
+// JSON objects have no order, in the dataset schema followed by other columns by name.
+
+// schemaErrorsLimit caps the schema errors a response lists; error_count covers them all.
+const schemaErrorsLimit = 1000
+
+// schemaProp is the schema of a column.
+type schemaProp struct {
+	types                []string
+	enum                 []any
+	minimum, maximum     *float64
+	exclusiveMin         *float64
+	exclusiveMax         *float64
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+	patternSrc           string
+}
+
+// rowSchema is a dataset schema, {properties: {...}, required: [...]} or a bare column map.
+type rowSchema struct {
+	order    []string
+	props    map[string]schemaProp
+	required []string
+	closed   bool
+}
+
+func parseRowSchema(text string) *rowSchema {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(text), &obj); err != nil {
+		return nil
+	}
+	props := []byte(text)
+	s := &rowSchema{props: map[string]schemaProp{}}
+	if p, ok := obj["properties"]; ok {
+		props = p
+		_ = json.Unmarshal(obj["required"], &s.required)
+		var additional any
+		_ = json.Unmarshal(obj["additionalProperties"], &additional)
+		s.closed = additional == false
+	}
+	order, err := orderedJSONKeys(props)
+	if err != nil {
+		return nil
+	}
+	var defs map[string]struct {
+		Type             any      `json:"type"`
+		Enum             []any    `json:"enum"`
+		Minimum          *float64 `json:"minimum"`
+		Maximum          *float64 `json:"maximum"`
+		ExclusiveMinimum *float64 `json:"exclusiveMinimum"`
+		ExclusiveMaximum *float64 `json:"exclusiveMaximum"`
+		MinLength        *int     `json:"minLength"`
+		MaxLength        *int     `json:"maxLength"`
+		Pattern          string   `json:"pattern"`
+	}
+	_ = json.Unmarshal(props, &defs)
+	s.order = order
+	for _, name := range order {
+		d := defs[name]
+		p := schemaProp{enum: d.Enum, minimum: d.Minimum, maximum: d.Maximum, exclusiveMin: d.ExclusiveMinimum,
+			exclusiveMax: d.ExclusiveMaximum, minLength: d.MinLength, maxLength: d.MaxLength}
+		switch t := d.Type.(type) {
+		case string:
+			p.types = []string{t}
+		case []any:
+			for _, x := range t {
+				if ts, ok := x.(string); ok {
+					p.types = append(p.types, ts)
+				}
+			}
+		}
+		// Patterns Go cannot compile are not checked
+		if re, err := regexp.Compile(d.Pattern); d.Pattern != "" && err == nil {
+			p.pattern, p.patternSrc = re, d.Pattern
+		}
+		s.props[name] = p
+	}
+	return s
+}
+
+// primaryType is the first type of a column other than null, as Delta columns take it.
+func (p schemaProp) primaryType() string {
+	for _, t := range p.types {
+		if t != "null" {
+			return t
+		}
+	}
+	return ""
+}
+
+// schemaTypeMatches reports whether a value is of a JSON Schema type.
+func schemaTypeMatches(v any, t string) bool {
+	switch t {
+	case "null":
+		return v == nil
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "integer", "number":
+		var f float64
+		switch x := v.(type) {
+		case float64:
+			f = x
+		case int, int64:
+			return true
+		case json.Number:
+			var err error
+			if f, err = x.Float64(); err != nil {
+				return false
+			}
+		default:
+			return false
+		}
+		return t == "number" || f == float64(int64(f))
+	}
+	return true
+}
+
+// schemaViolation is a schema error in the shape of the Python /validate errors.
+type schemaViolation struct {
+	Row         int      `json:"row"`
+	Path        []string `json:"path"`
+	Message     string   `json:"message"`
+	Keyword     string   `json:"keyword"`
+	ColumnIndex *int     `json:"column_index,omitempty"`
+	column      string
+}
+
+func jsonText(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// check validates row i, passing each error to add.
+func (s *rowSchema) check(i int, row map[string]any, add func(schemaViolation)) {
+	for _, col := range s.required {
+		if _, ok := row[col]; !ok {
+			add(schemaViolation{Row: i, Path: []string{}, Keyword: "required", Message: fmt.Sprintf("'%s' is a required property", col), column: col})
+		}
+	}
+	cols := make([]string, 0, len(row))
+	for col := range row {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	for _, col := range cols {
+		v := row[col]
+		p, known := s.props[col]
+		if !known {
+			if s.closed {
+				add(schemaViolation{Row: i, Path: []string{}, Keyword: "additionalProperties", Message: fmt.Sprintf("Additional properties are not allowed ('%s' was unexpected)", col), column: col})
+			}
+			continue
+		}
+		fail := func(keyword, format string, args ...any) {
+			add(schemaViolation{Row: i, Path: []string{col}, Keyword: keyword, Message: fmt.Sprintf(format, args...), column: col})
+		}
+		if len(p.types) > 0 {
+			ok := false
+			for _, t := range p.types {
+				ok = ok || schemaTypeMatches(v, t)
+			}
+			if !ok {
+				fail("type", "%s is not of type '%s'", jsonText(v), strings.Join(p.types, "', '"))
+				continue
+			}
+		}
+		if len(p.enum) > 0 {
+			ok := false
+			for _, e := range p.enum {
+				ok = ok || (e == nil && v == nil) || (e != nil && v != nil && dqKey(e) == dqKey(v))
+			}
+			if !ok {
+				fail("enum", "%s is not one of %s", jsonText(v), jsonText(p.enum))
+			}
+		}
+		if f, ok := exprNumber(v); ok && v != nil {
+			if _, isString := v.(string); !isString {
+				switch {
+				case p.minimum != nil && f < *p.minimum:
+					fail("minimum", "%s is less than the minimum of %v", jsonText(v), *p.minimum)
+				case p.exclusiveMin != nil && f <= *p.exclusiveMin:
+					fail("exclusiveMinimum", "%s is less than or equal to the minimum of %v", jsonText(v), *p.exclusiveMin)
+				}
+				switch {
+				case p.maximum != nil && f > *p.maximum:
+					fail("maximum", "%s is greater than the maximum of %v", jsonText(v), *p.maximum)
+				case p.exclusiveMax != nil && f >= *p.exclusiveMax:
+					fail("exclusiveMaximum", "%s is greater than or equal to the maximum of %v", jsonText(v), *p.exclusiveMax)
+				}
+			}
+		}
+		if str, ok := v.(string); ok {
+			n := utf8.RuneCountInString(str)
+			if p.minLength != nil && n < *p.minLength {
+				fail("minLength", "%s is too short", jsonText(v))
+			}
+			if p.maxLength != nil && n > *p.maxLength {
+				fail("maxLength", "%s is too long", jsonText(v))
+			}
+			if p.pattern != nil && !p.pattern.MatchString(str) {
+				fail("pattern", "%s does not match '%s'", jsonText(v), p.patternSrc)
+			}
+		}
+	}
+}
+
+// schemaTypeMismatch is a file column whose values the schema column does not take.
+type schemaTypeMismatch struct {
+	Column       string `json:"column"`
+	ExpectedType string `json:"expected_type"`
+	ActualType   string `json:"actual_type"`
+	Message      string `json:"message"`
+}
+
+// schemaMismatchResponse is the response to an append whose file does not fit the schema.
+func schemaMismatchResponse(uploadID uint, missing, extra []string, mismatches []schemaTypeMismatch, messages []string) gin.H {
+	var userMessage string
+	if len(missing) > 0 {
+		userMessage = fmt.Sprintf("The data you are trying to upload does not match the destination schema. Missing columns: %s. Please contact your admin to review the data structure.", strings.Join(missing, ", "))
+	} else if len(mismatches) > 0 {
+		userMessage = fmt.Sprintf("The data you are trying to upload has column type mismatches. %s. Please contact your admin to review the data format.", mismatches[0].Message)
+	} else {
+		userMessage = "The data you are trying to upload does not match the destination schema. Please contact your admin to review."
+	}
+	return gin.H{
+		"ok":              false,
+		"upload_id":       uploadID,
+		"schema_mismatch": true,
+		"error":           "schema_mismatch",
+		"message":         userMessage,
+		"details": gin.H{
+			"missing_columns": missing,
+			"extra_columns":   extra,
+			"type_mismatches": mismatches,
+			"messages":        messages,
+		},
+	}
+}
+
+// appendCheck validates the rows of an append as they are read.
+type appendCheck struct {
+	schema *rowSchema
+	rules  *rulesCheck
+	// columns are the file's columns in order, with the type their values have so far
+	columns []string
+	types   map[string]string
+	csv     bool
+
+	schemaErrs     []schemaViolation
+	schemaErrCount int
+	rows           int
+}
+
+// newAppendCheck prepares the check of rows appended to ds against its schema and rules.
+func newAppendCheck(ctx context.Context, gdb *gorm.DB, ds *models.Dataset) (*appendCheck, error) {
+	rules, err := newRulesCheck(ctx, gdb, ds, datasetRules(ds))
+	if err != nil {
+		return nil, err
+	}
+	return &appendCheck{schema: parseRowSchema(ds.Schema), rules: rules, types: map[string]string{}}, nil
+}
+
+// checkRows checks rows already read, such as those of a stored upload, against the schema and
+// rules of ds. It returns the reports keyed "schema" and "rules", as validateRows does; rules
+// that cannot be prepared make the rows invalid.
+func checkRows(ctx context.Context, gdb *gorm.DB, ds *models.Dataset, rows []map[string]any) (bool, map[string]any) {
+	ac, err := newAppendCheck(ctx, gdb, ds)
+	if err != nil {
+		return false, map[string]any{"rules": gin.H{"valid": false, "error": err.Error()}}
+	}
+	ac.readRows(rows)
+	idx := ac.columnIndex()
+	results := map[string]any{}
+	valid := true
+	if schema := ac.schemaReport(idx); schema != nil {
+		results["schema"] = schema
+		valid = valid && schema["valid"] == true
+	}
+	if rules := ac.rulesReport(idx); rules != nil {
+		results["rules"] = rules
+		valid = valid && rules["valid"] == true
+	}
+	return valid, results
+}
+
+// nativeUpload reports whether appendCheck reads files of this name.
+func nativeUpload(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv", ".json":
+		return true
+	}
+	return false
+}
+
+// readFile checks every row of an uploaded .csv or .json file.
+func (ac *appendCheck) readFile(content []byte, filename string) error {
+	if strings.ToLower(filepath.Ext(filename)) == ".csv" {
+		return ac.readCSV(content)
+	}
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+		return errors.New("JSON uploads must be an array of objects")
+	}
+	for dec.More() {
+		var row map[string]any
+		if err := dec.Decode(&row); err != nil {
+			return fmt.Errorf("row %d: %v", ac.rows, err)
+		}
+		ac.add(row)
+	}
+	return nil
+}
+
+func (ac *appendCheck) readCSV(content []byte) error {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))))
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err != nil {
+		return errors.New("the file has no header row")
+	}
+	ac.csv = true
+	// The reader reuses its record, header included
+	header = append([]string(nil), header...)
+	ac.columns = header
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		row := make(map[string]any, len(header))
+		for i := 0; i < len(header) && i < len(rec); i++ {
+			if rec[i] == "" {
+				continue
+			}
+			ac.types[header[i]] = mergeColumnType(ac.types[header[i]], inferJSONType(rec[i]))
+			row[header[i]] = ac.csvValue(header[i], rec[i])
+		}
+		ac.check(row)
+	}
+}
+
+// csvValue reads a CSV cell as the schema type of its column, when it parses as one.
+func (ac *appendCheck) csvValue(col, s string) any {
+	if ac.schema == nil {
+		return s
+	}
+	switch ac.schema.props[col].primaryType() {
+	case "integer", "number":
+		if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+			return f
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(strings.ToLower(strings.TrimSpace(s))); err == nil {
+			return b
+		}
+	}
+	return s
+}
+
+// readRows checks rows sent as JSON.
+func (ac *appendCheck) readRows(rows []map[string]any) {
+	for _, row := range rows {
+		ac.add(row)
+	}
+}
+
+// add checks a JSON row, noting its columns.
+func (ac *appendCheck) add(row map[string]any) {
+	for col, v := range row {
+		t, seen := ac.types[col]
+		if !seen {
+			ac.columns = append(ac.columns, col)
+		}
+		ac.types[col] = mergeColumnType(t, inferJSONType(v))
+	}
+	ac.check(row)
+}
+
+func (ac *appendCheck) check(row map[string]any) {
+	if ac.schema != nil {
+		ac.schema.check(ac.rows, row, func(v schemaViolation) {
+			ac.schemaErrCount++
+			if len(ac.schemaErrs) < schemaErrorsLimit {
+				ac.schemaErrs = append(ac.schemaErrs, v)
+			}
+		})
+	}
+	ac.rules.add(row)
+	ac.rows++
+}
+
+// mergeColumnType is the type of a column with values of type prev and t; "" is no value.
+func mergeColumnType(prev, t string) string {
+	switch {
+	case t == "" || prev == t:
+		return prev
+	case prev == "":
+		return t
+	case (prev == "integer" && t == "number") || (prev == "number" && t == "integer"):
+		return "number"
+	}
+	return "string"
+}
+
+// columnIndex gives the position of each column: in the CSV header or, for JSON, in the schema
+// and then by name.
+func (ac *appendCheck) columnIndex() map[string]int {
+	idx := map[string]int{}
+	if ac.csv {
+		for i, col := range ac.columns {
+			if _, dup := idx[col]; !dup {
+				idx[col] = i
+			}
+		}
+		return idx
+	}
+	if ac.schema != nil {
+		for _, col := range ac.schema.order {
+			idx[col] = len(idx)
+		}
+	}
+	extra := []string{}
+	for _, col := range ac.columns {
+		if _, ok := idx[col]; !ok {
+			extra = append(extra, col)
+		}
+	}
+	sort.Strings(extra)
+	for _, col := range extra {
+		idx[col] = len(idx)
+	}
+	return idx
+}
+
+// mismatch compares the file's columns with the schema and returns the mismatch response, or
+// nil when the file fits.
+func (ac *appendCheck) mismatch(uploadID uint) gin.H {
+	if ac.schema == nil {
+		return nil
+	}
+	inFile := map[string]bool{}
+	for _, col := range ac.columns {
+		inFile[col] = true
+	}
+	missing, extra := []string{}, []string{}
+	var mismatches []schemaTypeMismatch
+	for _, col := range ac.schema.order {
+		if !inFile[col] {
+			missing = append(missing, col)
+		}
+	}
+	for col := range inFile {
+		p, ok := ac.schema.props[col]
+		if !ok {
+			extra = append(extra, col)
+			continue
+		}
+		expected, actual := p.primaryType(), ac.types[col]
+		if expected == "" {
+			expected = "string"
+		}
+		// As in Python, integers and numbers are interchangeable and strings take anything
+		if actual == "" || actual == expected || expected == "string" ||
+			(actual == "integer" && expected == "number") || (actual == "number" && expected == "integer") {
+			continue
+		}
+		mismatches = append(mismatches, schemaTypeMismatch{Column: col, ExpectedType: expected, ActualType: actual,
+			Message: fmt.Sprintf("Column '%s' has type '%s' but expected '%s'", col, actual, expected)})
+	}
+	if len(missing) == 0 && len(mismatches) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Column < mismatches[j].Column })
+	var messages []string
+	if len(missing) > 0 {
+		messages = append(messages, "Missing columns: "+strings.Join(missing, ", "))
+	}
+	if len(extra) > 0 {
+		messages = append(messages, "Extra columns (will be ignored): "+strings.Join(extra, ", "))
+	}
+	for _, m := range mismatches {
+		messages = append(messages, m.Message)
+	}
+	return schemaMismatchResponse(uploadID, missing, extra, mismatches, messages)
+}
+
+// schemaReport is the outcome of schema validation as the Python /validate response, or nil
+// without a schema.
+func (ac *appendCheck) schemaReport(colIndex map[string]int) gin.H {
+	if ac.schema == nil {
+		return nil
+	}
+	for i := range ac.schemaErrs {
+		if at, ok := colIndex[ac.schemaErrs[i].column]; ok {
+			ac.schemaErrs[i].ColumnIndex = &at
+		}
+	}
+	errs := ac.schemaErrs
+	if errs == nil {
+		errs = []schemaViolation{}
+	}
+	return gin.H{"valid": ac.schemaErrCount == 0, "errors": errs, "error_count": ac.schemaErrCount}
+}
+
+// rulesReport is the outcome of the rules as the Python /rules/validate response, or nil when
+// the dataset has none.
+func (ac *appendCheck) rulesReport(colIndex map[string]int) gin.H {
+	if len(ac.rules.checks) == 0 {
+		return nil
+	}
+	return ac.rules.report(colIndex)
+}
+
+// response is the AppendValidate response for the checked upload.
+func (ac *appendCheck) response(uploadID uint) gin.H {
+	if m := ac.mismatch(uploadID); m != nil {
+		return m
+	}
+	idx := ac.columnIndex()
+	schema, rules := ac.schemaReport(idx), ac.rulesReport(idx)
+	ok := (schema == nil || schema["valid"] == true) && (rules == nil || rules["valid"] == true)
+	return gin.H{"ok": ok, "upload_id": uploadID, "rows": ac.rows, "schema": schema, "rules": rules}
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "mime/multipart"
+    "net/http/httptest"
+    "sort"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+)
+
+func TestRuleExpr(t *testing.T) {
+    row := map[string]any{"start": "2024-01-01", "end": "2024-02-01", "net": "10", "tax": 2.5, "total": 12.5, "note": nil, "ok": true, "my col": 3.0}
+    cases := []struct {
+        expr  string
+        fails bool
+    }{
+        {"end >= start", false},
+        {"end < start", true},
+        {"total = net + tax", false},
+        {"total == net * 2 OR tax > 3", true},
+        {"-net < 0 and not (tax <> 2.5)", false},
+        {"note > 1", false}, // unknown passes
+        {"note > 1 or total < 0", false},
+        {"note > 1 and total < 0", true},
+        {"note is null and start is not null", false},
+        {"ok = true", false},
+        {"`my col` / 0 > 1", true}, // cannot be worked out
+        {`"my col" = 3 and start = '2024-01-01'`, false},
+        {"start + 1 > 0", true},
+    }
+    for _, tc := range cases {
+        e, err := parseRuleExpr(tc.expr)
+        if err != nil {
+            t.Fatalf("%s: %v", tc.expr, err)
+        }
+        if got := e.failsRow(row); got != tc.fails {
+            t.Errorf("%s: fails %v, want %v", tc.expr, got, tc.fails)
+        }
+    }
+    if e, _ := parseRuleExpr("a + b > c and a != 'x'"); len(e.columns) != 3 || e.columns[2] != "c" {
+        t.Errorf("columns: %v", e.columns)
+    }
+    for _, bad := range []string{"", "a >", "a + b", "1 = 1", "(a = 1", "a = 'x", "a ! b", "a is 1", "a and"} {
+        if _, err := parseRuleExpr(bad); err == nil {
+            t.Errorf("%q parsed", bad)
+        }
+    }
+}
+
+func uploadCSV(t *testing.T, r *gin.Engine, path, csv string) (int, map[string]any) {
+    t.Helper()
+    var buf bytes.Buffer
+    mw := multipart.NewWriter(&buf)
+    fw, _ := mw.CreateFormFile("file", "orders.csv")
+    fw.Write([]byte(csv))
+    mw.Close()
+    req := httptest.NewRequest("POST", path, &buf)
+    req.Header.Set("Content-Type", mw.FormDataContentType())
+    req.Header.Set("X-User", "1")
+    w := httptest.NewRecorder()
+    r.ServeHTTP(w, req)
+    var out map[string]any
+    _ = json.Unmarshal(w.Body.Bytes(), &out)
+    return w.Code, out
+}
+
+// The Python service is unreachable in rowChangeEnv, so these run on the Go checks alone.
+func TestAppendValidate_Native(t *testing.T) {
+    gdb, r := rowChangeEnv(t)
+    if err := gdb.AutoMigrate(&models.DataQualityRule{}); err != nil {
+        t.Fatalf("migrate: %v", err)
+    }
+    r.POST("/projects/:id/datasets/:datasetId/append/validate", AppendValidate)
+    r.POST("/projects/:id/datasets/:datasetId/append/json/validate", AppendJSONValidate)
+    r.POST("/datasets/:id/rules", DatasetRulesSet)
+
+    gdb.Create(&models.Project{ID: 2, Name: "other"})
+    customers := models.Dataset{ID: 6, ProjectID: 1, Name: "customers"}
+    elsewhere := models.Dataset{ID: 7, ProjectID: 2, Name: "elsewhere"}
+    gdb.Create(&customers)
+    gdb.Create(&elsewhere)
+    ensureDatasetTable(gdb, &customers)
+    gdb.Exec(`INSERT INTO ds_6 (data) VALUES ('{"code":"A"}'), ('{"code":"B"}')`)
+    gdb.Create(&models.Dataset{ID: 5, ProjectID: 1, Name: "orders", Schema: `{"type":"object","properties":{"id":{"type":"integer"},` +
+        `"customer":{"type":"string"},"qty":{"type":"integer","minimum":1},"start":{"type":"string"},"end":{"type":"string"}},"required":["id","customer"]}`})
+
+    rules := func(fk uint) string {
+        b, _ := json.Marshal([]gin.H{
+            {"type": "unique", "column": "id"},
+            {"type": "foreign_key", "column": "customer", "dataset_id": fk, "ref_column": "code"},
+            {"type": "expression", "expression": "end >= start", "severity": "warn", "message": "ends before it starts"},
+        })
+        return string(b)
+    }
+    if code, resp := doJSON(t, r, "POST", "/datasets/5/rules", 1, gin.H{"rules": rules(7)}); code != 400 || resp["error"] != "invalid_rules" {
+        t.Fatalf("foreign key to another project: %d %v", code, resp)
+    }
+    if code, resp := doJSON(t, r, "POST", "/datasets/5/rules", 1, gin.H{"rules": rules(6)}); code != 200 {
+        t.Fatalf("set rules: %d %v", code, resp)
+    }
+
+    path := "/projects/1/datasets/5/append/validate"
+    code, resp := uploadCSV(t, r, path, "id,customer,start,end\n1,A,2024-01-01,2024-01-02\n")
+    details, _ := resp["details"].(map[string]any)
+    if code != 200 || resp["error"] != "schema_mismatch" || details["missing_columns"].([]any)[0] != "qty" {
+        t.Fatalf("missing column: %d %v", code, resp)
+    }
+    code, resp = uploadCSV(t, r, path, "id,customer,qty,start,end\n1,A,two,2024-01-01,2024-01-02\n")
+    details, _ = resp["details"].(map[string]any)
+    if mm, _ := details["type_mismatches"].([]any); code != 200 || len(mm) != 1 || mm[0].(map[string]any)["column"] != "qty" {
+        t.Fatalf("type mismatch: %d %v", code, resp)
+    }
+    if code, resp := uploadCSV(t, r, path, "id,customer\n\"1,A\n"); code != 400 || resp["error"] != "invalid_file" {
+        t.Fatalf("broken csv: %d %v", code, resp)
+    }
+
+    code, resp = uploadCSV(t, r, path, "id,customer,qty,start,end,note\n"+
+        "1,A,2,2024-01-01,2024-01-05,x\n"+
+        "1,C,0,2024-02-01,2024-01-01,\n"+
+        "2,,3,2024-01-01,2024-01-02,\n")
+    if code != 200 || resp["ok"] != false || resp["rows"] != float64(3) || resp["upload_id"] == nil {
+        t.Fatalf("validate: %d %v", code, resp)
+    }
+    type pos struct {
+        what   string
+        rows   string
+        column float64
+    }
+    var got []pos
+    for _, e := range resp["schema"].(map[string]any)["errors"].([]any) {
+        e := e.(map[string]any)
+        b, _ := json.Marshal([]any{e["row"]})
+        got = append(got, pos{e["keyword"].(string), string(b), e["column_index"].(float64)})
+    }
+    for _, e := range resp["rules"].(map[string]any)["errors"].([]any) {
+        e := e.(map[string]any)
+        b, _ := json.Marshal(e["rows"])
+        col, ok := e["column_index"].(float64)
+        if !ok {
+            col = -1
+        }
+        got = append(got, pos{e["rule"].(string) + "/" + e["severity"].(string), string(b), col})
+    }
+    want := []pos{
+        {"minimum", "[1]", 2}, {"required", "[2]", 1},
+        {"unique/block", "[0,1]", 0}, {"foreign_key/block", "[1]", 1}, {"expression/warn", "[1]", -1},
+    }
+    if fmt.Sprint(got) != fmt.Sprint(want) {
+        t.Fatalf("errors: %+v\n%v", got, resp)
+    }
+
+    // JSON rows: warn failures do not block the append
+    jsonPath := "/projects/1/datasets/5/append/json/validate"
+    code, resp = doJSON(t, r, "POST", jsonPath, 1, gin.H{"rows": []gin.H{
+        {"id": 3, "customer": "B", "qty": 1, "start": "2024-03-01", "end": "2024-02-01"},
+        {"id": 4, "customer": "A", "qty": 5, "start": "2024-03-01", "end": "2024-03-02"},
+    }})
+    if code != 200 || resp["ok"] != true || resp["upload_id"] == nil || len(resp["rules"].(map[string]any)["errors"].([]any)) != 1 {
+        t.Fatalf("json validate: %d %v", code, resp)
+    }
+    code, resp = doJSON(t, r, "POST", jsonPath, 1, gin.H{"rows": []gin.H{{"id": 1.5, "customer": "B", "qty": 1, "start": "a", "end": "b"}}})
+    if code != 200 || resp["ok"] != false || resp["schema"].(map[string]any)["errors"].([]any)[0].(map[string]any)["keyword"] != "type" {
+        t.Fatalf("json schema: %d %v", code, resp)
+    }
+    code, resp = doJSON(t, r, "POST", jsonPath, 1, gin.H{"rows": []gin.H{{"id": 9, "customer": "Z", "qty": 1, "start": "a", "end": "b"}}})
+    if code != 200 || resp["ok"] != false || resp["rules"].(map[string]any)["errors"].([]any)[0].(map[string]any)["rule"] != "foreign_key" {
+        t.Fatalf("json rules: %d %v", code, resp)
+    }
+}
+
+func TestRuleCheck_CountsFailingRowsOnce(t *testing.T) {
+    // Rows 0 and 1 repeat each other on both columns; row 2 repeats row 0 on b alone
+    rc, err := newRuleCheck(context.Background(), nil, &models.Dataset{}, models.DataQualityRule{RuleType: "unique", Definition: models.JSONB{"columns": []any{"a", "b"}}})
+    if err != nil {
+        t.Fatalf("rule: %v", err)
+    }
+    for i, row := range []map[string]any{{"a": 1, "b": 1}, {"a": 1, "b": 1}, {"a": 2, "b": 1}, {"a": 3, "b": 3}} {
+        rc.check(i, row)
+    }
+    sort.Ints(rc.failingRows)
+    if rc.failingCount != 3 || fmt.Sprint(rc.failingRows) != "[0 1 2]" || rc.failed["a"].Count != 2 || rc.failed["b"].Count != 3 {
+        t.Fatalf("failing rows %d %v, violations %+v %+v", rc.failingCount, rc.failingRows, rc.failed["a"], rc.failed["b"])
+    }
+}
@@ -0,0 +1,395 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbpkg "github.com/oreo-io/oreo.io-v2/go-service/internal/database"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"gorm.io/gorm"
+)
+
+// approvalDecision is the outcome of evaluating a change request's reviews against its
+// approval policy. It is stored in the metadata of the approve/reject audit events.
+type approvalDecision struct {
+	Outcome   string   `json:"outcome"` // approved|pending|rejected|auto_approved
+	PolicyID  uint     `json:"policy_id,omitempty"`
+	Approvals int      `json:"approvals"`
+	Required  int      `json:"required"`
+	Unmet     []string `json:"unmet,omitempty"`
+}
+
+func (d approvalDecision) metadata() models.JSONB {
+	return models.JSONB{"policy_decision": d}
+}
+
+// loadApprovalPolicy returns the policy governing a dataset: its own, else the project default,
+// else the implicit policy (ID 0) under which every assigned reviewer must approve.
+func loadApprovalPolicy(gdb *gorm.DB, projectID, datasetID uint) (models.ApprovalPolicy, string) {
+	var p models.ApprovalPolicy
+	if datasetID != 0 {
+		if err := gdb.Where("project_id = ? AND dataset_id = ?", projectID, datasetID).First(&p).Error; err == nil {
+			return p, "dataset"
+		}
+	}
+	if err := gdb.Where("project_id = ? AND dataset_id = ?", projectID, 0).First(&p).Error; err == nil {
+		return p, "project"
+	}
+	return models.ApprovalPolicy{ProjectID: projectID, DatasetID: datasetID}, "default"
+}
+
+func policyMandatoryRoles(p models.ApprovalPolicy) map[string]bool {
+	var roles []string
+	_ = json.Unmarshal([]byte(p.MandatoryRoles), &roles)
+	out := make(map[string]bool, len(roles))
+	for _, r := range roles {
+		out[normalizeRole(r)] = true
+	}
+	return out
+}
+
+// projectMemberRoles maps the members of a project to their normalized role.
+func projectMemberRoles(gdb *gorm.DB, projectID uint) map[uint]string {
+	var prs []models.ProjectRole
+	_ = gdb.Where("project_id = ?", projectID).Find(&prs).Error
+	out := make(map[uint]string, len(prs))
+	for _, pr := range prs {
+		out[pr.UserID] = normalizeRole(pr.Role)
+	}
+	return out
+}
+
+// evaluateApproval applies policy p to the reviewers of a change by author. The change is
+// approved once the quorum, the owner approval and every mandatory reviewer are satisfied, and
+// rejected as soon as one of them no longer can be. Delegated reviews are not counted; the
+// delegate's own row is.
+func evaluateApproval(p models.ApprovalPolicy, author uint, reviewers []models.ChangeRequestReviewer, roles map[uint]string) approvalDecision {
+	d := approvalDecision{PolicyID: p.ID}
+	mandatory := policyMandatoryRoles(p)
+	counted, pending := 0, 0
+	ownerApproved, ownerPossible, impossible := false, false, false
+	for _, st := range reviewers {
+		if st.Status == "delegated" || (p.ForbidSelfApproval && st.UserID == author) {
+			continue
+		}
+		counted++
+		switch st.Status {
+		case "approved":
+			d.Approvals++
+			ownerApproved = ownerApproved || roles[st.UserID] == "owner"
+		case "rejected":
+		default:
+			pending++
+			ownerPossible = ownerPossible || roles[st.UserID] == "owner"
+		}
+		if mandatory[roles[st.UserID]] && st.Status != "approved" {
+			d.Unmet = append(d.Unmet, fmt.Sprintf("mandatory_reviewer:%d", st.UserID))
+			impossible = impossible || st.Status == "rejected"
+		}
+	}
+	d.Required = p.MinApprovals
+	if d.Required <= 0 {
+		d.Required = counted
+	}
+	if d.Required == 0 {
+		d.Required = 1
+	}
+	if d.Approvals < d.Required {
+		d.Unmet = append([]string{"quorum"}, d.Unmet...)
+		impossible = impossible || d.Approvals+pending < d.Required
+	}
+	if p.RequireOwnerApproval && !ownerApproved {
+		d.Unmet = append(d.Unmet, "owner_approval")
+		impossible = impossible || !ownerPossible
+	}
+	switch {
+	case impossible:
+		d.Outcome = "rejected"
+	case len(d.Unmet) == 0:
+		d.Outcome = "approved"
+	default:
+		d.Outcome = "pending"
+	}
+	return d
+}
+
+// recordReview records uid's decision and comment on cr and evaluates the change against
+// policy p. Decisions are only recorded while the request is pending.
+func recordReview(gdb *gorm.DB, cr *models.ChangeRequest, p models.ApprovalPolicy, uid uint, status, comment string) approvalDecision {
+	now := time.Now()
+	_ = gdb.Model(&models.ChangeRequestReviewer{}).
+		Where("change_request_id = ? AND user_id = ? AND status <> ?", cr.ID, uid, "delegated").
+		Where("EXISTS (SELECT 1 FROM change_requests WHERE id = ? AND status = ?)", cr.ID, "pending").
+		Updates(map[string]any{"status": status, "decided_at": now, "comment": comment}).Error
+	cr.Reviewers = changeReviewers(gdb, cr.ID)
+	return evaluateApproval(p, cr.UserID, cr.Reviewers, projectMemberRoles(gdb, cr.ProjectID))
+}
+
+// rejectChange moves a pending change request to "rejected", conditional on it still being
+// pending so a reject cannot overwrite an approval being applied. It responds itself on failure.
+func rejectChange(c *gin.Context, gdb *gorm.DB, cr *models.ChangeRequest) bool {
+	res := gdb.Model(&models.ChangeRequest{}).Where("id = ? AND status = ?", cr.ID, "pending").Update("status", "rejected")
+	if res.Error != nil {
+		c.JSON(500, gin.H{"error": "db"})
+		return false
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(409, gin.H{"error": "not_pending"})
+		return false
+	}
+	cr.Status = "rejected"
+	return true
+}
+
+// policyReviewers completes the reviewers an author picked for a change on ds with those the
+// dataset's approval policy requires: members of the mandatory roles, and the project owners
+// when an owner approval is required and none was picked. It reports false when the policy
+// cannot be satisfied by the resulting reviewers.
+func policyReviewers(gdb *gorm.DB, ds *models.Dataset, author uint, reviewers []uint) (models.ApprovalPolicy, []uint, bool) {
+	p, _ := loadApprovalPolicy(gdb, ds.ProjectID, ds.ID)
+	roles := projectMemberRoles(gdb, ds.ProjectID)
+	out := append([]uint(nil), reviewers...)
+	seen := make(map[uint]bool, len(reviewers))
+	for _, id := range reviewers {
+		seen[id] = true
+	}
+	eligible := func(id uint) bool { return !(p.ForbidSelfApproval && id == author) }
+	add := func(match func(role string) bool) {
+		var ids []uint
+		for id, role := range roles {
+			if match(role) && !seen[id] && eligible(id) {
+				ids = append(ids, id)
+			}
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	if mandatory := policyMandatoryRoles(p); len(mandatory) > 0 {
+		add(func(role string) bool { return mandatory[role] })
+	}
+	if p.RequireOwnerApproval {
+		hasOwner := false
+		for _, id := range out {
+			hasOwner = hasOwner || (roles[id] == "owner" && eligible(id))
+		}
+		if !hasOwner {
+			add(func(role string) bool { return role == "owner" })
+		}
+	}
+	counted := 0
+	for _, id := range out {
+		if eligible(id) {
+			counted++
+		}
+	}
+	return p, out, !((len(out) > 0 && counted == 0) || counted < p.MinApprovals)
+}
+
+// applyPolicyReviewers is policyReviewers for the create endpoints: it responds 400 itself
+// when the policy cannot be satisfied.
+func applyPolicyReviewers(c *gin.Context, gdb *gorm.DB, ds *models.Dataset, author uint, reviewers []uint) ([]uint, bool) {
+	p, out, ok := policyReviewers(gdb, ds, author, reviewers)
+	if !ok {
+		c.JSON(400, gin.H{"error": "not_enough_reviewers", "min_approvals": p.MinApprovals})
+		return nil, false
+	}
+	return out, true
+}
+
+// validateRows checks rows against the dataset's JSON schema and rules with the Python
+// service and returns the results keyed "schema" and "rules". It fails closed: a service that
+// cannot be reached or answers badly makes the rows invalid.
+func validateRows(ds *models.Dataset, rows []map[string]any) (bool, map[string]any) {
+	results := map[string]any{}
+	check := func(name, path string, body gin.H) bool {
+		b, _ := json.Marshal(body)
+		resp, err := http.Post(getPythonServiceURL()+path, "application/json", bytes.NewReader(b))
+		if err != nil {
+			results[name] = map[string]any{"valid": false, "error": "python_unreachable"}
+			return false
+		}
+		defer resp.Body.Close()
+		var out map[string]any
+		if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&out) != nil {
+			results[name] = map[string]any{"valid": false, "error": fmt.Sprintf("python status %d", resp.StatusCode)}
+			return false
+		}
+		results[name] = out
+		return getBool(out, "valid", false)
+	}
+	valid := true
+	var schemaObj, rulesObj any
+	if strings.TrimSpace(ds.Schema) != "" && json.Unmarshal([]byte(ds.Schema), &schemaObj) == nil && schemaObj != nil {
+		valid = check("schema", "/validate", gin.H{"json_schema": schemaObj, "data": rows}) && valid
+	}
+	if strings.TrimSpace(ds.Rules) != "" && json.Unmarshal([]byte(ds.Rules), &rulesObj) == nil && rulesObj != nil {
+		valid = check("rules", "/rules/validate", gin.H{"rules": rulesObj, "data": rows}) && valid
+	}
+	return valid, results
+}
+
+// rowsPassCheck reports whether rows satisfy the dataset's JSON schema and rules.
+func rowsPassCheck(c *gin.Context, gdb *gorm.DB, ds *models.Dataset, rows []map[string]any) bool {
+	valid, _ := checkRows(c.Request.Context(), gdb, ds, rows)
+	return valid
+}
+
+// autoApproveAppend applies a freshly opened append change without review when the dataset's
+// policy auto-approves appends of its size and the rows pass schema and rules. It then
+// responds with the approval result and reports true; otherwise it does nothing.
+func autoApproveAppend(c *gin.Context, gdb *gorm.DB, ds *models.Dataset, cr *models.ChangeRequest, rows []map[string]any) bool {
+	p, _ := loadApprovalPolicy(gdb, ds.ProjectID, ds.ID)
+	if p.AutoApproveMaxRows <= 0 || len(rows) >= p.AutoApproveMaxRows || !rowsPassCheck(c, gdb, ds, rows) {
+		return false
+	}
+	d := approvalDecision{Outcome: "auto_approved", PolicyID: p.ID}
+	crID := cr.ID
+	_ = RecordAuditEventWithMetadata(cr.ProjectID, cr.DatasetID, 0, models.AuditEventTypeCRApproved,
+		fmt.Sprintf("Change Request #%d auto-approved", cr.ID),
+		fmt.Sprintf("%d rows, under the auto-approval limit of %d", len(rows), p.AutoApproveMaxRows),
+		&crID,
+		models.AuditEventSummary{RowsAdded: len(rows)},
+		d.metadata(),
+	)
+	approveAppendChange(c, gdb, cr, 0)
+	return true
+}
+
+// approvalPolicyScope resolves the project and optional dataset of a policy route.
+func approvalPolicyScope(c *gin.Context, gdb *gorm.DB) (uint, uint, bool) {
+	pid, _ := strconv.Atoi(c.Param("id"))
+	if !HasProjectRole(c, uint(pid), "owner", "contributor", "viewer") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return 0, 0, false
+	}
+	var dsid uint
+	if s := c.Param("datasetId"); s != "" {
+		n, _ := strconv.Atoi(s)
+		var ds models.Dataset
+		if err := gdb.Where("project_id = ?", pid).First(&ds, n).Error; err != nil {
+			c.JSON(404, gin.H{"error": "dataset_not_found"})
+			return 0, 0, false
+		}
+		dsid = ds.ID
+	}
+	return uint(pid), dsid, true
+}
+
+// ApprovalPolicyGet returns the policy in effect for a project, or for a dataset when the route
+// names one, and where it comes from: "dataset", "project" or "default".
+func ApprovalPolicyGet(c *gin.Context) {
+	gdb := dbpkg.Get()
+	if gdb == nil {
+		if _, err := dbpkg.Init(); err != nil {
+			c.JSON(500, gin.H{"error": "db"})
+			return
+		}
+		gdb = dbpkg.Get()
+	}
+	pid, dsid, ok := approvalPolicyScope(c, gdb)
+	if !ok {
+		return
+	}
+	p, source := loadApprovalPolicy(gdb, pid, dsid)
+	if dsid == 0 && source != "project" {
+		source = "default"
+	}
+	c.JSON(200, gin.H{"policy": p, "source": source})
+}
+
+// ApprovalPolicyPut creates or replaces the project default policy, or a dataset's policy.
+// Body: { min_approvals, require_owner_approval, forbid_self_approval, auto_approve_max_rows, mandatory_roles: [..] }
+func ApprovalPolicyPut(c *gin.Context) {
+	gdb := dbpkg.Get()
+	if gdb == nil {
+		if _, err := dbpkg.Init(); err != nil {
+			c.JSON(500, gin.H{"error": "db"})
+			return
+		}
+		gdb = dbpkg.Get()
+	}
+	pid, dsid, ok := approvalPolicyScope(c, gdb)
+	if !ok {
+		return
+	}
+	if !HasProjectRole(c, pid, "owner") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	var body struct {
+		MinApprovals         int      `json:"min_approvals"`
+		RequireOwnerApproval bool     `json:"require_owner_approval"`
+		ForbidSelfApproval   bool     `json:"forbid_self_approval"`
+		AutoApproveMaxRows   int      `json:"auto_approve_max_rows"`
+		MandatoryRoles       []string `json:"mandatory_roles"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.MinApprovals < 0 || body.AutoApproveMaxRows < 0 {
+		c.JSON(400, gin.H{"error": "invalid_payload"})
+		return
+	}
+	roles := make([]string, 0, len(body.MandatoryRoles))
+	for _, r := range body.MandatoryRoles {
+		r = normalizeRole(r)
+		if r != "owner" && r != "contributor" && r != "viewer" {
+			c.JSON(400, gin.H{"error": "invalid_role", "role": r})
+			return
+		}
+		roles = append(roles, r)
+	}
+	rolesJSON, _ := json.Marshal(roles)
+	var p models.ApprovalPolicy
+	if err := gdb.Where("project_id = ? AND dataset_id = ?", pid, dsid).First(&p).Error; err != nil {
+		p = models.ApprovalPolicy{ProjectID: pid, DatasetID: dsid}
+	}
+	p.MinApprovals = body.MinApprovals
+	p.RequireOwnerApproval = body.RequireOwnerApproval
+	p.ForbidSelfApproval = body.ForbidSelfApproval
+	p.AutoApproveMaxRows = body.AutoApproveMaxRows
+	p.MandatoryRoles = string(rolesJSON)
+	p.UpdatedBy = contextUserID(c)
+	if err := gdb.Save(&p).Error; err != nil {
+		c.JSON(500, gin.H{"error": "db"})
+		return
+	}
+	source := "project"
+	if dsid != 0 {
+		source = "dataset"
+	}
+	c.JSON(200, gin.H{"policy": p, "source": source})
+}
+
+// ApprovalPolicyDelete removes the project default policy, or a dataset's own policy so that
+// the project default applies again.
+func ApprovalPolicyDelete(c *gin.Context) {
+	gdb := dbpkg.Get()
+	if gdb == nil {
+		if _, err := dbpkg.Init(); err != nil {
+			c.JSON(500, gin.H{"error": "db"})
+			return
+		}
+		gdb = dbpkg.Get()
+	}
+	pid, dsid, ok := approvalPolicyScope(c, gdb)
+	if !ok {
+		return
+	}
+	if !HasProjectRole(c, pid, "owner") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	if err := gdb.Where("project_id = ? AND dataset_id = ?", pid, dsid).Delete(&models.ApprovalPolicy{}).Error; err != nil {
+		c.JSON(500, gin.H{"error": "db"})
+		return
+	}
+	c.JSON(200, gin.H{"ok": true})
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+    "fmt"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+)
+
+func TestEvaluateApproval(t *testing.T) {
+    roles := map[uint]string{1: "owner", 2: "contributor", 3: "contributor", 4: "viewer"}
+    st := func(pairs ...any) []models.ChangeRequestReviewer {
+        var out []models.ChangeRequestReviewer
+        for i := 0; i < len(pairs); i += 2 {
+            out = append(out, models.ChangeRequestReviewer{UserID: uint(pairs[i].(int)), Status: pairs[i+1].(string)})
+        }
+        return out
+    }
+    cases := []struct {
+        name   string
+        policy models.ApprovalPolicy
+        author uint
+        states []models.ChangeRequestReviewer
+        want   string
+    }{
+        {"default needs everyone", models.ApprovalPolicy{}, 9, st(2, "approved", 3, "pending"), "pending"},
+        {"default all approved", models.ApprovalPolicy{}, 9, st(2, "approved", 3, "approved"), "approved"},
+        {"default any reject", models.ApprovalPolicy{}, 9, st(2, "approved", 3, "rejected"), "rejected"},
+        {"quorum met", models.ApprovalPolicy{MinApprovals: 2}, 9, st(2, "approved", 3, "approved", 4, "pending"), "approved"},
+        {"quorum survives a reject", models.ApprovalPolicy{MinApprovals: 2}, 9, st(2, "approved", 3, "rejected", 4, "pending"), "pending"},
+        {"quorum unreachable", models.ApprovalPolicy{MinApprovals: 2}, 9, st(2, "rejected", 3, "rejected", 4, "pending"), "rejected"},
+        {"self approval not counted", models.ApprovalPolicy{MinApprovals: 1, ForbidSelfApproval: true}, 2, st(2, "approved", 3, "pending"), "pending"},
+        {"owner approval missing", models.ApprovalPolicy{MinApprovals: 1, RequireOwnerApproval: true}, 9, st(1, "pending", 2, "approved"), "pending"},
+        {"owner approval given", models.ApprovalPolicy{MinApprovals: 1, RequireOwnerApproval: true}, 9, st(1, "approved", 2, "pending"), "approved"},
+        {"owner rejected", models.ApprovalPolicy{MinApprovals: 1, RequireOwnerApproval: true}, 9, st(1, "rejected", 2, "approved"), "rejected"},
+        {"mandatory role pending", models.ApprovalPolicy{MinApprovals: 1, MandatoryRoles: `["viewer"]`}, 9, st(2, "approved", 4, "pending"), "pending"},
+        {"delegated review not counted", models.ApprovalPolicy{}, 9, st(2, "delegated", 3, "approved"), "approved"},
+        {"mandatory role rejected", models.ApprovalPolicy{MinApprovals: 1, MandatoryRoles: `["viewer"]`}, 9, st(2, "approved", 4, "rejected"), "rejected"},
+    }
+    for _, tc := range cases {
+        if got := evaluateApproval(tc.policy, tc.author, tc.states, roles).Outcome; got != tc.want {
+            t.Errorf("%s: outcome %q, want %q", tc.name, got, tc.want)
+        }
+    }
+}
+
+func TestApprovalPolicy_Workflow(t *testing.T) {
+    gdb, r := rowChangeEnv(t)
+    gdb.Create(&models.ProjectRole{ProjectID: 1, UserID: 3, Role: "contributor"})
+    ds := models.Dataset{ID: 5, ProjectID: 1, Name: "people", Schema: peopleSchema}
+    gdb.Create(&ds)
+    if err := ensureDatasetTable(gdb, &ds); err != nil {
+        t.Fatalf("ensure table: %v", err)
+    }
+    for _, row := range []string{`{"id":1,"name":"ann","age":31}`, `{"id":2,"name":"bob","age":42}`, `{"id":3,"name":"cy","age":7}`} {
+        gdb.Exec("INSERT INTO ds_5 (data) VALUES (?)", row)
+    }
+    deleteName := func(name string, reviewers ...uint) (int, map[string]any) {
+        return doJSON(t, r, "POST", "/projects/1/datasets/5/changes/delete", 2, gin.H{"where": []gin.H{{"column": "name", "op": "eq", "value": name}}, "reviewer_ids": reviewers})
+    }
+
+    policy := gin.H{"min_approvals": 1, "forbid_self_approval": true}
+    if code, _ := doJSON(t, r, "PUT", "/projects/1/approval-policy", 2, policy); code != 403 {
+        t.Fatalf("contributor set policy: %d", code)
+    }
+    if code, resp := doJSON(t, r, "PUT", "/projects/1/approval-policy", 1, policy); code != 200 || resp["source"] != "project" {
+        t.Fatalf("set policy: %d %v", code, resp)
+    }
+    if code, resp := doJSON(t, r, "GET", "/projects/1/datasets/5/approval-policy", 3, nil); code != 200 || resp["source"] != "project" {
+        t.Fatalf("inherited policy: %d %v", code, resp)
+    }
+    if code, resp := doJSON(t, r, "PUT", "/projects/1/approval-policy", 1, gin.H{"mandatory_roles": []string{"admin"}}); code != 400 || resp["error"] != "invalid_role" {
+        t.Fatalf("bad role: %d %v", code, resp)
+    }
+
+    // The author cannot count as a reviewer nor approve their own change
+    if code, resp := deleteName("bob", 2); code != 400 || resp["error"] != "not_enough_reviewers" {
+        t.Fatalf("self review only: %d %v", code, resp)
+    }
+    code, resp := deleteName("bob", 2, 3)
+    if code != 201 {
+        t.Fatalf("create: %d %v", code, resp)
+    }
+    selfReviewed := changeID(t, resp)
+    if code, resp := doJSON(t, r, "POST", fmt.Sprintf("/projects/1/changes/%d/approve", selfReviewed), 2, nil); code != 403 || resp["error"] != "author_cannot_approve" {
+        t.Fatalf("self approve: %d %v", code, resp)
+    }
+
+    // One reject of two eligible reviewers leaves the 1-approval quorum reachable
+    code, resp = deleteName("cy", 1, 3)
+    if code != 201 {
+        t.Fatalf("create: %d %v", code, resp)
+    }
+    quorum := changeID(t, resp)
+    code, resp = doJSON(t, r, "POST", fmt.Sprintf("/projects/1/changes/%d/reject", quorum), 3, nil)
+    if code != 200 || resp["change_request"].(map[string]any)["status"] != "pending" || resp["decision"].(map[string]any)["outcome"] != "pending" {
+        t.Fatalf("reject under quorum: %d %v", code, resp)
+    }
+    code, resp = doJSON(t, r, "POST", fmt.Sprintf("/projects/1/changes/%d/approve", quorum), 1, nil)
+    if code != 200 {
+        t.Fatalf("approve: %d %v", code, resp)
+    }
+    var cr models.ChangeRequest
+    gdb.First(&cr, quorum)
+    if cr.Status != "completed" {
+        t.Fatalf("quorum change status %q", cr.Status)
+    }
+    var n int64
+    gdb.Raw("SELECT COUNT(*) FROM ds_5").Scan(&n)
+    if n != 2 {
+        t.Fatalf("rows after delete: %d", n)
+    }
+    var rejections int64
+    gdb.Model(&models.AuditEvent{}).Where("change_request_id = ? AND event_type = ?", quorum, models.AuditEventTypeCRRejected).Count(&rejections)
+    if rejections != 0 {
+        t.Fatalf("a vote that left the change open was audited as a rejection")
+    }
+    var ev models.AuditEvent
+    if err := gdb.Where("change_request_id = ? AND event_type = ?", quorum, models.AuditEventTypeCRApproved).First(&ev).Error; err != nil {
+        t.Fatalf("approval audit event: %v", err)
+    }
+    if d, ok := ev.Metadata["policy_decision"].(map[string]any); !ok || d["outcome"] != "approved" {
+        t.Fatalf("audit decision: %v", ev.Metadata)
+    }
+
+    // With its only eligible reviewer rejecting, the quorum is unreachable
+    code, resp = doJSON(t, r, "POST", fmt.Sprintf("/projects/1/changes/%d/reject", selfReviewed), 3, nil)
+    if code != 200 || resp["change_request"].(map[string]any)["status"] != "rejected" {
+        t.Fatalf("reject: %d %v", code, resp)
+    }
+    if err := gdb.Where("change_request_id = ? AND event_type = ?", selfReviewed, models.AuditEventTypeCRRejected).First(&models.AuditEvent{}).Error; err != nil {
+        t.Fatalf("rejection audit event: %v", err)
+    }
+
+    // A dataset policy overrides the project default until it is deleted
+    if code, resp := doJSON(t, r, "PUT", "/projects/1/datasets/5/approval-policy", 1, gin.H{"min_approvals": 3}); code != 200 || resp["source"] != "dataset" {
+        t.Fatalf("dataset policy: %d %v", code, resp)
+    }
+    if code, resp := deleteName("ann", 1, 3); code != 400 || resp["error"] != "not_enough_reviewers" {
+        t.Fatalf("quorum above reviewers: %d %v", code, resp)
+    }
+    if code, _ := doJSON(t, r, "DELETE", "/projects/1/datasets/5/approval-policy", 1, nil); code != 200 {
+        t.Fatalf("delete dataset policy: %d", code)
+    }
+    if code, resp := deleteName("ann", 1, 3); code != 201 {
+        t.Fatalf("create after delete: %d %v", code, resp)
+    }
+}
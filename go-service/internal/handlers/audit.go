@@ -110,8 +110,8 @@ func DatasetAuditList(c *gin.Context) {
 	}
 
 	// 2. Fetch Delta history from Python service (if Delta backend)
-	if strings.EqualFold(dataset.StorageBackend, "delta") {
-		deltaEvents := fetchDeltaHistory(dataset.ProjectID, uint(datasetID))
+	if isDeltaBackend(&dataset) {
+		deltaEvents := fetchDeltaHistory(&dataset)
 		allEvents = append(allEvents, deltaEvents...)
 	}
 
@@ -138,41 +138,14 @@ func DatasetAuditList(c *gin.Context) {
 	})
 }
 
-// fetchDeltaHistory calls Python service to get Delta table history
+// fetchDeltaHistory gets Delta table history (via the Python service, or in-process for delta-native)
 // Only returns events that aren't already tracked by our audit system
-func fetchDeltaHistory(projectID, datasetID uint) []models.AuditEventListResponse {
+func fetchDeltaHistory(dataset *models.Dataset) []models.AuditEventListResponse {
 	var events []models.AuditEventListResponse
 
-	cfg := config.Get()
-	pyBase := cfg.PythonServiceURL
-	if pyBase == "" {
-		pyBase = "http://python-service:8000"
-	}
-
-	// Call /delta/history/{project_id}/{dataset_id}
-	url := fmt.Sprintf("%s/delta/history/%d/%d", pyBase, projectID, datasetID)
-	resp, err := http.Get(url)
-	if err != nil {
-		// Delta history not available, return empty
-		return events
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return events
-	}
-
-	body, _ := io.ReadAll(resp.Body)
-	var historyResp struct {
-		History []map[string]interface{} `json:"history"`
-	}
-	if err := json.Unmarshal(body, &historyResp); err != nil {
-		return events
-	}
-
 	// Convert Delta history entries to audit events
 	// Skip WRITE and RESTORE operations as they are tracked via CR Merged and Restore audit events
-	for _, entry := range historyResp.History {
+	for _, entry := range deltaHistoryEntries(dataset) {
 		version := int64(0)
 		if v, ok := entry["version"].(float64); ok {
 			version = int64(v)
@@ -227,6 +200,12 @@ func fetchDeltaHistory(projectID, datasetID uint) []models.AuditEventListRespons
 			if v, ok := metrics["numTargetRowsDeleted"].(float64); ok {
 				rowsDeleted = int(v)
 			}
+			// DELETE commits: camelCase from delta-native, snake_case from delta-rs
+			for _, k := range []string{"numDeletedRows", "num_deleted_rows"} {
+				if v, ok := metrics[k].(float64); ok {
+					rowsDeleted = int(v)
+				}
+			}
 		}
 
 		events = append(events, models.AuditEventListResponse{
@@ -592,6 +571,15 @@ func backfillAuditEventsFromChangeRequests(datasetID uint) {
 			case "withdrawn":
 				eventType = models.AuditEventTypeCRWithdrawn
 				title = fmt.Sprintf("Change Request #%d withdrawn", cr.ID)
+			case "changes_requested":
+				eventType = models.AuditEventTypeCRChangesReq
+				title = fmt.Sprintf("Change Request #%d: changes requested", cr.ID)
+			case "scheduled":
+				eventType = models.AuditEventTypeCRScheduled
+				title = fmt.Sprintf("Change Request #%d scheduled", cr.ID)
+			case "expired":
+				eventType = models.AuditEventTypeCRExpired
+				title = fmt.Sprintf("Change Request #%d expired", cr.ID)
 			case "merged":
 				eventType = models.AuditEventTypeCRMerged
 				title = fmt.Sprintf("Change Request #%d merged", cr.ID)
@@ -694,6 +682,16 @@ func generateDiffViaPython(datasetID uint, version int64) (map[string]interface{
 
 // RecordAuditEvent is a convenience function to record an audit event during operations
 func RecordAuditEvent(projectID, datasetID, actorID uint, eventType, title, description string, changeRequestID *uint, summary models.AuditEventSummary, paths map[string]string) error {
+	return recordAuditEvent(projectID, datasetID, actorID, eventType, title, description, changeRequestID, summary, paths, nil)
+}
+
+// RecordAuditEventWithMetadata records an audit event carrying structured metadata, e.g. the
+// approval policy decision behind a change request approval.
+func RecordAuditEventWithMetadata(projectID, datasetID, actorID uint, eventType, title, description string, changeRequestID *uint, summary models.AuditEventSummary, metadata models.JSONB) error {
+	return recordAuditEvent(projectID, datasetID, actorID, eventType, title, description, changeRequestID, summary, nil, metadata)
+}
+
+func recordAuditEvent(projectID, datasetID, actorID uint, eventType, title, description string, changeRequestID *uint, summary models.AuditEventSummary, paths map[string]string, metadata models.JSONB) error {
 	gdb := dbpkg.Get()
 	if gdb == nil {
 		return fmt.Errorf("database not available")
@@ -723,6 +721,7 @@ func RecordAuditEvent(projectID, datasetID, actorID uint, eventType, title, desc
 		CellsChanged:    summary.CellsChanged,
 		Warnings:        summary.Warnings,
 		Errors:          summary.Errors,
+		Metadata:        metadata,
 		CreatedAt:       time.Now(),
 	}
 
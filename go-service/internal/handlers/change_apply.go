@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/storage"
+	"gorm.io/gorm"
+)
+
+// Applying an approved change request is claimed first: the request moves from "pending" to
+// "applying" under its apply key, so only one approval can apply it. JSONB datasets then change
+// the data, record the version and complete the request in one transaction. Delta commits cannot
+// join that transaction, so they carry the apply key as userMetadata instead: a Delta write with a
+// key the table already has is skipped, and an interrupted apply is resolved from the table
+// history (see recoverChangeApply).
+
+// applyStaleAfter is how long a request may stay "applying" before another approval, or the
+// startup sweep, treats the apply as interrupted and recovers it.
+const applyStaleAfter = 10 * time.Minute
+
+// errNotPending aborts a transaction whose conditional update found the request no longer
+// pending.
+var errNotPending = errors.New("change request is not pending")
+
+// errApplyLost is returned when a request is no longer "applying" under our key at completion,
+// i.e. a recovery already resolved it.
+var errApplyLost = errors.New("change request apply was taken over")
+
+// claimChangeApply moves a pending change request, or a scheduled one whose merge window came,
+// to "applying". The apply key is kept across attempts, so a retry after a failed or interrupted
+// apply reuses it. It reports false when the request was not pending or scheduled any more.
+func claimChangeApply(gdb *gorm.DB, cr *models.ChangeRequest) (bool, error) {
+	key := cr.ApplyKey
+	if key == "" {
+		key = uuid.NewString()
+	}
+	now := time.Now()
+	res := gdb.Model(&models.ChangeRequest{}).Where("id = ? AND status IN ?", cr.ID, []string{"pending", "scheduled"}).
+		Updates(map[string]any{"status": "applying", "apply_key": key, "apply_started_at": now})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return false, nil
+	}
+	cr.Status, cr.ApplyKey, cr.ApplyStartedAt = "applying", key, &now
+	return true, nil
+}
+
+// finishChangeApply completes a change request this apply still holds, and queues a check of the
+// dataset's quality rules. Call it in the transaction that records the change so both commit or
+// neither does.
+func finishChangeApply(tx *gorm.DB, cr *models.ChangeRequest, summary string) error {
+	now := time.Now()
+	res := tx.Model(&models.ChangeRequest{}).Where("id = ? AND status = ? AND apply_key = ?", cr.ID, "applying", cr.ApplyKey).
+		Updates(map[string]any{"status": "completed", "summary": summary, "applied_at": now})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errApplyLost
+	}
+	cr.Status, cr.Summary, cr.AppliedAt = "completed", summary, &now
+	return enqueueDQCheckAfterChange(tx, cr)
+}
+
+// releaseChangeApply returns a change request whose apply failed to "pending", or to "scheduled"
+// when it has a merge time so the sweep retries it; the apply key is kept for the next attempt.
+func releaseChangeApply(gdb *gorm.DB, cr *models.ChangeRequest) {
+	status := "pending"
+	if cr.MergeAt != nil {
+		status = "scheduled"
+	}
+	err := gdb.Model(&models.ChangeRequest{}).Where("id = ? AND status = ? AND apply_key = ?", cr.ID, "applying", cr.ApplyKey).
+		Updates(map[string]any{"status": status, "apply_started_at": nil}).Error
+	if err != nil {
+		log.Printf("[ChangeApprove] release cr=%d: %v", cr.ID, err)
+		return
+	}
+	cr.Status, cr.ApplyStartedAt = status, nil
+}
+
+// deltaApplied reports whether the dataset's Delta table has a commit tagged with key.
+func deltaApplied(ctx context.Context, ds *models.Dataset, key string) bool {
+	if key == "" {
+		return false
+	}
+	if nd, ok := nativeDelta(ds); ok {
+		hist, err := nd.History(ctx, storage.DeltaTableID(ds.ProjectID, ds.ID))
+		if err != nil {
+			return false
+		}
+		for _, h := range hist {
+			if h.UserMetadata == key {
+				return true
+			}
+		}
+		return false
+	}
+	for _, h := range deltaHistoryEntries(ds) {
+		if u, _ := h["userMetadata"].(string); u == key {
+			return true
+		}
+	}
+	return false
+}
+
+// recordChangeVersion records the dataset version produced by an applied change request, which
+// also retires cached query results for the dataset.
+func recordChangeVersion(tx *gorm.DB, ds *models.Dataset, cr *models.ChangeRequest, verData map[string]any) error {
+	b, err := json.Marshal(verData)
+	if err != nil {
+		return err
+	}
+	approvers, _ := json.Marshal(changeReviewers(tx, cr.ID))
+	if err := tx.Create(&models.DatasetVersion{DatasetID: ds.ID, Data: string(b), EditedBy: cr.UserID, EditedAt: time.Now(), Status: "approved", Approvers: string(approvers)}).Error; err != nil {
+		return err
+	}
+	return bumpDatasetVersion(tx, ds.ID)
+}
+
+// recoverChangeApply resolves an interrupted apply. A Delta commit tagged with the apply key
+// means the data landed, so the request is completed; otherwise nothing was applied (JSONB
+// applies are transactional) and it is released for another attempt.
+func recoverChangeApply(ctx context.Context, gdb *gorm.DB, cr *models.ChangeRequest) error {
+	var ds models.Dataset
+	if err := gdb.First(&ds, cr.DatasetID).Error; err != nil {
+		return err
+	}
+	if !isDeltaBackend(&ds) || !deltaApplied(ctx, &ds, cr.ApplyKey) {
+		releaseChangeApply(gdb, cr)
+		return nil
+	}
+	err := gdb.Transaction(func(tx *gorm.DB) error {
+		err := recordChangeVersion(tx, &ds, cr, map[string]any{
+			"table":       storage.DeltaTableID(ds.ProjectID, ds.ID),
+			"change_id":   cr.ID,
+			"change_type": cr.Type,
+			"recovered":   true,
+			"applied_at":  time.Now().Format(time.RFC3339),
+		})
+		if err != nil {
+			return err
+		}
+		return finishChangeApply(tx, cr, fmt.Sprintf("Applied %s at %s (recovered)", cr.Type, time.Now().Format(time.RFC3339)))
+	})
+	if err != nil {
+		return err
+	}
+	upsertDatasetMeta(gdb, &ds)
+	return nil
+}
+
+// RecoverStaleApplies recovers change requests left "applying" longer than applyStaleAfter,
+// e.g. by a crash. It runs at startup; younger applies may still be running on another instance.
+func RecoverStaleApplies(gdb *gorm.DB) {
+	var stuck []models.ChangeRequest
+	if err := gdb.Where("status = ? AND apply_started_at < ?", "applying", time.Now().Add(-applyStaleAfter)).Find(&stuck).Error; err != nil {
+		return
+	}
+	for i := range stuck {
+		if err := recoverChangeApply(context.Background(), gdb, &stuck[i]); err != nil {
+			log.Printf("[RecoverStaleApplies] cr=%d: %v", stuck[i].ID, err)
+		}
+	}
+}
+
+// respondNotPending answers an approval of a change request that is not pending: a completed or
+// scheduled request replays its result, an apply in progress is a conflict unless it has gone stale, in
+// which case it is recovered first. It reports true when the request is pending again and the
+// approval should go on.
+func respondNotPending(c *gin.Context, gdb *gorm.DB, cr *models.ChangeRequest) bool {
+	if cr.Status == "applying" && cr.ApplyStartedAt != nil && time.Since(*cr.ApplyStartedAt) > applyStaleAfter {
+		if err := recoverChangeApply(c.Request.Context(), gdb, cr); err != nil {
+			c.JSON(500, gin.H{"error": "apply_recovery_failed", "message": err.Error()})
+			return false
+		}
+	}
+	switch cr.Status {
+	case "pending":
+		return true
+	case "completed":
+		c.JSON(200, gin.H{"ok": true, "change_request": cr, "already_applied": true})
+	case "applying":
+		c.JSON(409, gin.H{"error": "apply_in_progress"})
+	case "changes_requested":
+		c.JSON(409, gin.H{"error": "changes_requested"})
+	case "scheduled":
+		c.JSON(200, gin.H{"ok": true, "change_request": cr, "scheduled": true})
+	default:
+		c.JSON(409, gin.H{"error": "not_pending"})
+	}
+	return false
+}
+
+// claimOrRespond claims the apply of cr for this approval. When another approval got there
+// first it answers as respondNotPending would for the request's new state.
+func claimOrRespond(c *gin.Context, gdb *gorm.DB, cr *models.ChangeRequest) bool {
+	claimed, err := claimChangeApply(gdb, cr)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db"})
+		return false
+	}
+	if claimed {
+		return true
+	}
+	if err := gdb.First(cr, cr.ID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "not_found"})
+		return false
+	}
+	if respondNotPending(c, gdb, cr) {
+		// pending again: another approval claimed and released it meanwhile
+		c.JSON(409, gin.H{"error": "apply_in_progress"})
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+    "context"
+    "fmt"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/storage"
+)
+
+func TestChangeApprove_IdempotentAndRecoverable(t *testing.T) {
+    for _, backend := range []string{"", "delta-native"} {
+        t.Run("backend="+backend, func(t *testing.T) {
+            gdb, r := rowChangeEnv(t)
+            ds := models.Dataset{ID: 7, ProjectID: 1, Name: "people", StorageBackend: backend, Schema: peopleSchema}
+            gdb.Create(&ds)
+            ctx := context.Background()
+            if nd, ok := nativeDelta(&ds); ok {
+                if _, err := nativeDeltaAppend(ctx, nd, &ds, []byte("id,name,age\n1,ann,31\n2,bob,42\n3,cy,7\n"), "people.csv"); err != nil {
+                    t.Fatalf("append: %v", err)
+                }
+            } else {
+                if err := ensureDatasetTable(gdb, &ds); err != nil {
+                    t.Fatalf("ensure table: %v", err)
+                }
+                for _, row := range []string{`{"id":1,"name":"ann","age":31}`, `{"id":2,"name":"bob","age":42}`, `{"id":3,"name":"cy","age":7}`} {
+                    gdb.Exec("INSERT INTO ds_7 (data) VALUES (?)", row)
+                }
+            }
+            openDelete := func(name string) uint {
+                code, resp := doJSON(t, r, "POST", "/projects/1/datasets/7/changes/delete", 1, gin.H{"where": []gin.H{{"column": "name", "op": "eq", "value": name}}, "reviewer_ids": []uint{2}})
+                if code != 201 {
+                    t.Fatalf("create delete: %d %v", code, resp)
+                }
+                return changeID(t, resp)
+            }
+            versions := func() int64 {
+                var n int64
+                gdb.Model(&models.DatasetVersion{}).Where("dataset_id = ?", ds.ID).Count(&n)
+                return n
+            }
+
+            // A repeated approval replays the result instead of applying again
+            id := openDelete("bob")
+            approve := fmt.Sprintf("/projects/1/changes/%d/approve", id)
+            if code, resp := doJSON(t, r, "POST", approve, 2, nil); code != 200 || resp["rows_deleted"] != float64(1) {
+                t.Fatalf("approve: %d %v", code, resp)
+            }
+            if code, resp := doJSON(t, r, "POST", approve, 2, nil); code != 200 || resp["already_applied"] != true {
+                t.Fatalf("second approve: %d %v", code, resp)
+            }
+            if n := versions(); n != 1 {
+                t.Fatalf("versions after double approve: %d", n)
+            }
+
+            // An apply in progress blocks other approvals
+            id = openDelete("ann")
+            approve = fmt.Sprintf("/projects/1/changes/%d/approve", id)
+            var cr models.ChangeRequest
+            gdb.First(&cr, id)
+            if ok, err := claimChangeApply(gdb, &cr); !ok || err != nil {
+                t.Fatalf("claim: %v %v", ok, err)
+            }
+            if ok, _ := claimChangeApply(gdb, &models.ChangeRequest{ID: id}); ok {
+                t.Fatalf("second claim succeeded")
+            }
+            if code, resp := doJSON(t, r, "POST", approve, 2, nil); code != 409 || resp["error"] != "apply_in_progress" {
+                t.Fatalf("approve while applying: %d %v", code, resp)
+            }
+
+            // The apply crashes: Delta after committing, JSONB before its transaction commits.
+            if backend != "" {
+                tagged := storage.WithCommitTag(ctx, cr.ApplyKey)
+                if err := storage.GetAdapterForDataset(&ds).Delete(tagged, storage.DeltaTableID(1, 7), storage.Predicate{{Column: "name", Op: "eq", Value: "ann"}}); err != nil {
+                    t.Fatalf("delete: %v", err)
+                }
+            }
+            gdb.Model(&models.ChangeRequest{}).Where("id = ?", id).Update("apply_started_at", time.Now().Add(-2*applyStaleAfter))
+            RecoverStaleApplies(gdb)
+            gdb.First(&cr, id)
+            if backend != "" {
+                if cr.Status != "completed" || cr.AppliedAt == nil {
+                    t.Fatalf("delta apply not completed by recovery: %+v", cr)
+                }
+                if code, resp := doJSON(t, r, "POST", approve, 2, nil); code != 200 || resp["already_applied"] != true {
+                    t.Fatalf("approve after recovery: %d %v", code, resp)
+                }
+            } else {
+                if cr.Status != "pending" || cr.ApplyKey == "" {
+                    t.Fatalf("jsonb apply not released by recovery: %+v", cr)
+                }
+                if code, resp := doJSON(t, r, "POST", approve, 2, nil); code != 200 || resp["rows_deleted"] != float64(1) {
+                    t.Fatalf("approve after recovery: %d %v", code, resp)
+                }
+            }
+            if n := versions(); n != 2 {
+                t.Fatalf("versions after recovery: %d", n)
+            }
+            rows, err := selectDatasetRows(ctx, gdb, &ds, nil)
+            if err != nil || len(rows) != 1 {
+                t.Fatalf("rows: %+v %v", rows, err)
+            }
+        })
+    }
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"gorm.io/gorm"
+)
+
+// A change request records the table state it was opened against: the Delta version, or for a
+// JSONB table its DataVersion, row count and highest id. When the table has moved by the time the
+// request is approved, checkChangeBase re-validates appended and merged uploads against the
+// current schema and rules and compares row edits with the rows they were made against. An
+// approval with conflicts has to acknowledge them; one that no longer validates is refused.
+
+// changeBase identifies a state of a dataset's table.
+type changeBase struct {
+	Version  *int64
+	RowCount int64
+	Checksum string
+}
+
+// changeConflict is a change the table moved under since the request was opened.
+type changeConflict struct {
+	// Type is cell_conflict, row_deleted, key_not_unique, matched_rows_changed or
+	// merge_plan_changed
+	Type  string         `json:"type"`
+	Key   map[string]any `json:"key,omitempty"`
+	Cells []conflictCell `json:"cells,omitempty"`
+	// Before and Now compare the counts of deletes and merges
+	Before any `json:"before,omitempty"`
+	Now    any `json:"now,omitempty"`
+}
+
+// conflictCell is a cell an update sets that was changed by someone else meanwhile.
+type conflictCell struct {
+	Column   string `json:"column"`
+	Base     any    `json:"base"`
+	Current  any    `json:"current"`
+	Proposed any    `json:"proposed"`
+}
+
+// baseCheck is the result of checkChangeBase.
+type baseCheck struct {
+	Moved      bool             `json:"moved"`
+	Conflicts  []changeConflict `json:"conflicts"`
+	Valid      bool             `json:"valid"`
+	Validation map[string]any   `json:"validation,omitempty"`
+}
+
+// datasetBase reads the current state of the dataset's table. A Delta table without commits is
+// version -1. A JSONB table is identified by its DataVersion, which applied changes advance, with
+// its row count and highest id for rows added outside change requests.
+func datasetBase(gdb *gorm.DB, ds *models.Dataset) (changeBase, error) {
+	if isDeltaBackend(ds) {
+		v := int64(-1)
+		if entries := deltaHistoryEntries(ds); len(entries) > 0 {
+			if f, ok := entries[0]["version"].(float64); ok {
+				v = int64(f)
+			}
+		}
+		return changeBase{Version: &v}, nil
+	}
+	var dataVersion int64
+	if err := gdb.Model(&models.Dataset{}).Where("id = ?", ds.ID).Select("data_version").Scan(&dataVersion).Error; err != nil {
+		return changeBase{}, err
+	}
+	var stats struct {
+		N     int64
+		MaxID int64
+	}
+	if table := datasetPhysicalTable(ds); tableExists(gdb, table) {
+		if err := gdb.Raw(fmt.Sprintf("SELECT COUNT(*) AS n, COALESCE(MAX(id), 0) AS max_id FROM %s", table)).Scan(&stats).Error; err != nil {
+			return changeBase{}, err
+		}
+	}
+	return changeBase{RowCount: stats.N, Checksum: fmt.Sprintf("v%d:id%d", dataVersion, stats.MaxID)}, nil
+}
+
+// stampChangeBase records the current table state on a change request about to be saved. A
+// request without a base is approved without the check.
+func stampChangeBase(gdb *gorm.DB, ds *models.Dataset, cr *models.ChangeRequest) {
+	base, err := datasetBase(gdb, ds)
+	if err != nil {
+		log.Printf("[stampChangeBase] dataset=%d: %v", ds.ID, err)
+		return
+	}
+	cr.BaseVersion, cr.BaseRowCount, cr.BaseChecksum = base.Version, base.RowCount, base.Checksum
+}
+
+// baseUpdates are the columns stampChangeBase sets, for conditional updates.
+func baseUpdates(cr *models.ChangeRequest) map[string]any {
+	return map[string]any{"base_version": cr.BaseVersion, "base_row_count": cr.BaseRowCount, "base_checksum": cr.BaseChecksum}
+}
+
+// errChangeUnusable marks base checks that fail for good, whatever the retries: the dataset or
+// upload is gone, or the payload or upload does not parse.
+var errChangeUnusable = errors.New("change request can no longer be checked")
+
+func unusableChange(err error) error { return fmt.Errorf("%w: %v", errChangeUnusable, err) }
+
+// checkChangeBase reports whether the dataset moved since cr was opened and, if so, the
+// conflicts with its row edits. With revalidate, uploads are also validated again.
+func checkChangeBase(ctx context.Context, gdb *gorm.DB, cr *models.ChangeRequest, revalidate bool) (baseCheck, error) {
+	check := baseCheck{Conflicts: []changeConflict{}, Valid: true}
+	if cr.BaseVersion == nil && cr.BaseChecksum == "" {
+		return check, nil
+	}
+	var ds models.Dataset
+	if err := gdb.First(&ds, cr.DatasetID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = unusableChange(err)
+		}
+		return check, err
+	}
+	cur, err := datasetBase(gdb, &ds)
+	if err != nil {
+		return check, err
+	}
+	if cr.BaseVersion != nil && cur.Version != nil {
+		check.Moved = *cur.Version != *cr.BaseVersion
+	} else {
+		check.Moved = cur.RowCount != cr.BaseRowCount || cur.Checksum != cr.BaseChecksum
+	}
+	if !check.Moved {
+		return check, nil
+	}
+	switch cr.Type {
+	case "append":
+		if !revalidate {
+			break
+		}
+		var payload struct {
+			UploadID uint `json:"upload_id"`
+		}
+		_ = json.Unmarshal([]byte(cr.Payload), &payload)
+		var up models.DatasetUpload
+		if err := gdb.First(&up, payload.UploadID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				err = unusableChange(err)
+			}
+			return check, err
+		}
+		rows, err := recordsFromUpload(up.Content, up.Filename)
+		if err != nil {
+			return check, unusableChange(err)
+		}
+		check.Valid, check.Validation = checkRows(ctx, gdb, &ds, rows)
+	case "delete":
+		var p deleteChangePayload
+		if err := json.Unmarshal([]byte(cr.Payload), &p); err != nil {
+			return check, unusableChange(err)
+		}
+		matched, err := selectDatasetRows(ctx, gdb, &ds, p.Where)
+		if err != nil {
+			return check, err
+		}
+		if len(matched) != p.MatchedRows {
+			check.Conflicts = append(check.Conflicts, changeConflict{Type: "matched_rows_changed", Before: p.MatchedRows, Now: len(matched)})
+		}
+	case "update":
+		var p updateChangePayload
+		if err := json.Unmarshal([]byte(cr.Payload), &p); err != nil {
+			return check, unusableChange(err)
+		}
+		// Requests opened before the edited rows were recorded have nothing to compare
+		if len(p.Base) != len(p.Edits) {
+			break
+		}
+		matches, err := matchRowEdits(ctx, gdb, &ds, p.KeyColumns, p.Edits)
+		if err != nil {
+			return check, err
+		}
+		for i, e := range p.Edits {
+			rows := matches[i]
+			switch {
+			case len(rows) == 0:
+				check.Conflicts = append(check.Conflicts, changeConflict{Type: "row_deleted", Key: e.Key})
+			case len(rows) > 1:
+				check.Conflicts = append(check.Conflicts, changeConflict{Type: "key_not_unique", Key: e.Key})
+			default:
+				var cells []conflictCell
+				for col, v := range e.Set {
+					if !sameCell(p.Base[i], col, rows[0].Data[col]) {
+						cells = append(cells, conflictCell{Column: col, Base: p.Base[i][col], Current: rows[0].Data[col], Proposed: v})
+					}
+				}
+				if len(cells) > 0 {
+					sort.Slice(cells, func(a, b int) bool { return cells[a].Column < cells[b].Column })
+					check.Conflicts = append(check.Conflicts, changeConflict{Type: "cell_conflict", Key: e.Key, Cells: cells})
+				}
+			}
+		}
+	case "merge":
+		var p mergeChangePayload
+		if err := json.Unmarshal([]byte(cr.Payload), &p); err != nil {
+			return check, unusableChange(err)
+		}
+		source, _, err := loadMergeSource(gdb, &ds, p.UploadID, p.KeyColumns)
+		if err != nil {
+			return check, err
+		}
+		if revalidate {
+			check.Valid, check.Validation = checkRows(ctx, gdb, &ds, source)
+		}
+		plan, err := planMerge(ctx, gdb, &ds, p.KeyColumns, source)
+		if re, ok := err.(*rowEditError); ok {
+			check.Conflicts = append(check.Conflicts, changeConflict{Type: re.Code, Key: re.Key})
+			break
+		}
+		if err != nil {
+			return check, err
+		}
+		if len(plan.Inserts) != p.Inserts || len(plan.Updates) != p.Updates {
+			check.Conflicts = append(check.Conflicts, changeConflict{Type: "merge_plan_changed",
+				Before: gin.H{"inserts": p.Inserts, "updates": p.Updates}, Now: gin.H{"inserts": len(plan.Inserts), "updates": len(plan.Updates)}})
+		}
+	}
+	return check, nil
+}
+
+// respondBaseCheck refuses an approval of a request that no longer validates, or whose
+// conflicts the reviewer has not acknowledged. It reports whether the approval may go on.
+func respondBaseCheck(c *gin.Context, check baseCheck, acknowledged bool) bool {
+	if !check.Valid {
+		c.JSON(409, gin.H{"error": "revalidation_failed", "base_check": check})
+		return false
+	}
+	if len(check.Conflicts) > 0 && !acknowledged {
+		c.JSON(409, gin.H{"error": "conflicts", "base_check": check})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+    "fmt"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+)
+
+func TestChangeConflicts_MovedDataset(t *testing.T) {
+    gdb, r := rowChangeEnv(t)
+    ds := models.Dataset{ID: 5, ProjectID: 1, Name: "people", Schema: peopleSchema}
+    gdb.Create(&ds)
+    if err := ensureDatasetTable(gdb, &ds); err != nil {
+        t.Fatalf("ensure table: %v", err)
+    }
+    for _, row := range []string{`{"id":1,"name":"ann","age":31}`, `{"id":2,"name":"bob","age":42}`} {
+        gdb.Exec("INSERT INTO ds_5 (data) VALUES (?)", row)
+    }
+    setAge := func(age int) uint {
+        code, resp := doJSON(t, r, "POST", "/projects/1/datasets/5/changes/update", 2, gin.H{"key_columns": []string{"id"},
+            "edits": []gin.H{{"key": gin.H{"id": 1}, "set": gin.H{"age": age}}}, "reviewer_ids": []uint{1}})
+        if code != 201 {
+            t.Fatalf("update: %d %v", code, resp)
+        }
+        return changeID(t, resp)
+    }
+    first, second := setAge(32), setAge(33)
+    code, resp := doJSON(t, r, "POST", "/projects/1/datasets/5/changes/delete", 2, gin.H{"where": []gin.H{{"column": "name", "op": "eq", "value": "bob"}}, "reviewer_ids": []uint{1}})
+    if code != 201 {
+        t.Fatalf("delete: %d %v", code, resp)
+    }
+    del := changeID(t, resp)
+    // An append validated against the same table
+    up := models.DatasetUpload{ProjectID: 1, DatasetID: 5, Filename: "rows.json", Content: []byte(`[{"id":3,"name":"cy","age":7}]`)}
+    gdb.Create(&up)
+    appendCR := models.ChangeRequest{ProjectID: 1, DatasetID: 5, UserID: 2, ReviewerID: 1, Type: "append", Status: "pending",
+        Payload: fmt.Sprintf(`{"upload_id":%d,"filename":"rows.json"}`, up.ID), Reviewers: newReviewerRows([]uint{1})}
+    stampChangeBase(gdb, &ds, &appendCR)
+    gdb.Create(&appendCR)
+
+    baseCheckOf := func(id uint) map[string]any {
+        _, resp := doJSON(t, r, "GET", fmt.Sprintf("/projects/1/changes/%d", id), 1, nil)
+        return resp["base_check"].(map[string]any)
+    }
+    approve := func(id uint, body any) (int, map[string]any) {
+        return doJSON(t, r, "POST", fmt.Sprintf("/projects/1/changes/%d/approve", id), 1, body)
+    }
+    if check := baseCheckOf(first); check["moved"] != false {
+        t.Fatalf("before any apply: %v", check)
+    }
+    if code, resp := approve(first, nil); code != 200 {
+        t.Fatalf("approve first: %d %v", code, resp)
+    }
+
+    // The second update edits the cell the first one changed
+    conflicts := baseCheckOf(second)["conflicts"].([]any)
+    if len(conflicts) != 1 {
+        t.Fatalf("conflicts: %v", conflicts)
+    }
+    cell := conflicts[0].(map[string]any)["cells"].([]any)[0].(map[string]any)
+    if cell["column"] != "age" || cell["base"] != float64(31) || cell["current"] != float64(32) || cell["proposed"] != float64(33) {
+        t.Fatalf("conflicting cell: %v", cell)
+    }
+    if code, resp := approve(second, nil); code != 409 || resp["error"] != "conflicts" {
+        t.Fatalf("approve with conflicts: %d %v", code, resp)
+    }
+    if code, resp := approve(second, gin.H{"acknowledge_conflicts": true}); code != 200 {
+        t.Fatalf("acknowledged approve: %d %v", code, resp)
+    }
+    var age float64
+    gdb.Raw("SELECT json_extract(data, '$.age') FROM ds_5 WHERE json_extract(data, '$.id') = 1").Scan(&age)
+    if age != 33 {
+        t.Fatalf("age %v", age)
+    }
+
+    // The delete still matches the rows it was opened for
+    if check := baseCheckOf(del); check["moved"] != true || len(check["conflicts"].([]any)) != 0 {
+        t.Fatalf("delete check: %v", check)
+    }
+    if code, resp := approve(del, nil); code != 200 {
+        t.Fatalf("approve delete: %d %v", code, resp)
+    }
+
+    // Appends are validated again once the table moved, against the dataset's schema and rules now
+    gdb.Model(&ds).Update("schema", `{"type":"object","properties":{"id":{"type":"integer"},"name":{"type":"string"},"age":{"type":"integer","minimum":18}}}`)
+    if code, resp := approve(appendCR.ID, gin.H{"acknowledge_conflicts": true}); code != 409 || resp["error"] != "revalidation_failed" {
+        t.Fatalf("approve stale append: %d %v", code, resp)
+    }
+    gdb.Model(&ds).Update("schema", peopleSchema)
+    if code, resp := approve(appendCR.ID, gin.H{"acknowledge_conflicts": true}); code != 200 {
+        t.Fatalf("approve revalidated append: %d %v", code, resp)
+    }
+}
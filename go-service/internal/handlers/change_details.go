@@ -37,77 +37,53 @@ func ChangeGet(c *gin.Context) {
 		c.JSON(404, gin.H{"error": "not_found"})
 		return
 	}
+	cr.Reviewers = changeReviewers(gdb, cr.ID)
 	// Add reviewer email(s) for display
 	var reviewerEmail string
 	var reviewerEmails []string
 	// Add requester info
 	var requestorEmail string
 	var requestorName string
-	// Enriched reviewer states if available
-	var reviewerStates any
-	if cr.ReviewerID != 0 {
-		var u models.User
-		if err := gdb.First(&u, cr.ReviewerID).Error; err == nil {
-			reviewerEmail = u.Email
-		}
+	ids := []uint{cr.ReviewerID, cr.UserID}
+	for _, r := range cr.Reviewers {
+		ids = append(ids, r.UserID)
 	}
-	// Lookup requester
-	if cr.UserID != 0 {
-		var u models.User
-		if err := gdb.First(&u, cr.UserID).Error; err == nil {
-			requestorEmail = u.Email
-			requestorName = u.Name
-		}
+	var users []models.User
+	_ = gdb.Where("id IN ?", ids).Find(&users).Error
+	byID := make(map[uint]models.User, len(users))
+	for _, u := range users {
+		byID[u.ID] = u
 	}
-	if strings.TrimSpace(cr.Reviewers) != "" {
-		// cr.Reviewers stores a JSON array of user IDs
-		var ids []uint
-		_ = json.Unmarshal([]byte(cr.Reviewers), &ids)
-		if len(ids) > 0 {
-			var users []models.User
-			if err := gdb.Where("id IN ?", ids).Find(&users).Error; err == nil {
-				for _, u := range users {
-					reviewerEmails = append(reviewerEmails, u.Email)
-				}
-			}
+	reviewerEmail = byID[cr.ReviewerID].Email
+	if u, ok := byID[cr.UserID]; ok {
+		requestorEmail = u.Email
+		requestorName = u.Name
+	}
+	// Reviewer states enriched with emails; delegated reviews are listed for history
+	reviewerStates := make([]gin.H, 0, len(cr.Reviewers))
+	for _, r := range cr.Reviewers {
+		email := byID[r.UserID].Email
+		if r.Status != "delegated" {
+			reviewerEmails = append(reviewerEmails, email)
 		}
+		reviewerStates = append(reviewerStates, gin.H{"id": r.UserID, "email": email, "status": r.Status, "decided_at": r.DecidedAt,
+			"comment": r.Comment, "delegated_from": r.DelegatedFrom, "delegated_to": r.DelegatedTo})
 	}
-	if strings.TrimSpace(cr.ReviewerStates) != "" {
-		var states []map[string]any
-		_ = json.Unmarshal([]byte(cr.ReviewerStates), &states)
-		// attach emails for each id
-		if len(states) > 0 {
-			idSet := []uint{}
-			for _, st := range states {
-				if idv, ok := st["id"].(float64); ok {
-					idSet = append(idSet, uint(idv))
-				} else if idu, ok := st["id"].(uint); ok {
-					idSet = append(idSet, idu)
-				}
-			}
-			if len(idSet) > 0 {
-				var users []models.User
-				if err := gdb.Where("id IN ?", idSet).Find(&users).Error; err == nil {
-					emailMap := map[uint]string{}
-					for _, u := range users {
-						emailMap[u.ID] = u.Email
-					}
-					for _, st := range states {
-						if idv, ok := st["id"].(float64); ok {
-							st["email"] = emailMap[uint(idv)]
-						} else if idu, ok := st["id"].(uint); ok {
-							st["email"] = emailMap[idu]
-						}
-					}
-				}
-			}
+	revisions := changeRevisions(gdb, cr.ID)
+	out := gin.H{"change": cr, "reviewer_email": reviewerEmail, "reviewer_emails": reviewerEmails, "reviewer_states": reviewerStates, "requestor_email": requestorEmail, "requestor_name": requestorName,
+		"revisions": revisions, "revision_diff": changeRevisionDiff(c, gdb, revisions)}
+	// Show reviewers of an open request whether the table moved under it; uploads are only
+	// validated again on approval
+	if cr.Status == "pending" || cr.Status == "changes_requested" || cr.Status == "scheduled" {
+		if check, err := checkChangeBase(c.Request.Context(), gdb, &cr, false); err == nil {
+			out["base_check"] = check
 		}
-		reviewerStates = states
 	}
-	c.JSON(200, gin.H{"change": cr, "reviewer_email": reviewerEmail, "reviewer_emails": reviewerEmails, "reviewer_states": reviewerStates, "requestor_email": requestorEmail, "requestor_name": requestorName})
+	c.JSON(200, out)
 }
 
-// ChangePreview streams a JSON preview for append-type change using stored payload path
+// ChangePreview streams a JSON preview for append-type change using stored payload path;
+// delete and update changes preview the affected rows against the current data
 func ChangePreview(c *gin.Context) {
 	gdb := dbpkg.Get()
 	if gdb == nil {
@@ -128,6 +104,10 @@ func ChangePreview(c *gin.Context) {
 		c.JSON(404, gin.H{"error": "not_found"})
 		return
 	}
+	if (cr.Type == "delete" || cr.Type == "update" || cr.Type == "merge") && cr.Payload != "" {
+		rowChangePreview(c, gdb, &cr)
+		return
+	}
 	if cr.Type != "append" || cr.Payload == "" {
 		c.JSON(400, gin.H{"error": "no_preview"})
 		return
@@ -0,0 +1,831 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbpkg "github.com/oreo-io/oreo.io-v2/go-service/internal/database"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/storage"
+	"gorm.io/gorm"
+)
+
+// Row-level change requests: "delete" removes the rows matching a structured predicate and
+// "update" sets cell values on rows addressed by key columns ("merge", in change_merge.go, upserts
+// an upload on key columns). They go through the same reviewer workflow as appends and are
+// applied by ChangeApprove.
+
+// maxRowEdits bounds the number of rows a single update change request may touch.
+const maxRowEdits = 1000
+
+// rowEdit addresses one row by its key column values and lists the cells to set.
+type rowEdit struct {
+	Key map[string]any `json:"key"`
+	Set map[string]any `json:"set"`
+}
+
+// deleteChangePayload is stored in ChangeRequest.Payload for type "delete".
+type deleteChangePayload struct {
+	Where       storage.Predicate `json:"where"`
+	Reason      string            `json:"reason,omitempty"`
+	MatchedRows int               `json:"matched_rows"`
+}
+
+// updateChangePayload is stored in ChangeRequest.Payload for type "update".
+type updateChangePayload struct {
+	KeyColumns []string  `json:"key_columns"`
+	Edits      []rowEdit `json:"edits"`
+	Reason     string    `json:"reason,omitempty"`
+	// Base holds the rows the edits were made against, in edit order
+	Base []map[string]any `json:"base,omitempty"`
+}
+
+// datasetRow is a row read for a row-level change. ID is the JSONB table's primary key and is
+// zero for Delta datasets.
+type datasetRow struct {
+	ID   int64
+	Data map[string]any
+}
+
+// keyPredicate turns an edit's key into equality conditions.
+func (e rowEdit) keyPredicate(keys []string) storage.Predicate {
+	p := make(storage.Predicate, 0, len(keys))
+	for _, k := range keys {
+		p = append(p, storage.Condition{Column: k, Op: "eq", Value: e.Key[k]})
+	}
+	return p
+}
+
+// datasetColumnSet returns the dataset's declared columns, or nil when it has no schema.
+func datasetColumnSet(ds *models.Dataset) map[string]bool {
+	cols := deltaColumnsFromSchema(ds.Schema)
+	if len(cols) == 0 {
+		return nil
+	}
+	out := make(map[string]bool, len(cols))
+	for _, col := range cols {
+		out[col.Name] = true
+	}
+	return out
+}
+
+// checkColumns reports the first name that is not a dataset column. Datasets without a schema
+// accept any column; the backend rejects unknown ones where it can.
+func checkColumns(known map[string]bool, names []string) error {
+	if known == nil {
+		return nil
+	}
+	for _, n := range names {
+		if !known[n] {
+			return fmt.Errorf("unknown column %q", n)
+		}
+	}
+	return nil
+}
+
+// selectDatasetRows returns the dataset rows matching where, in storage order.
+func selectDatasetRows(ctx context.Context, gdb *gorm.DB, ds *models.Dataset, where storage.Predicate) ([]datasetRow, error) {
+	if nd, ok := nativeDelta(ds); ok {
+		res, err := nd.Query(ctx, storage.QueryRequest{DatasetID: storage.DeltaTableID(ds.ProjectID, ds.ID), Where: where})
+		if err != nil {
+			return nil, err
+		}
+		return rowsFromMatrix(res.Columns, res.Rows), nil
+	}
+	if isDeltaBackend(ds) {
+		return queryDeltaRowsViaPython(ctx, ds, where)
+	}
+	tbl := datasetPhysicalTable(ds)
+	if gdb == nil || !tableExists(gdb, tbl) {
+		return nil, nil
+	}
+	rows, err := gdb.WithContext(ctx).Raw(fmt.Sprintf("SELECT id, data FROM %s ORDER BY id", tbl)).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []datasetRow
+	for rows.Next() {
+		var id int64
+		var raw any
+		if err := rows.Scan(&id, &raw); err != nil {
+			return nil, err
+		}
+		var obj map[string]any
+		switch v := raw.(type) {
+		case []byte:
+			_ = json.Unmarshal(v, &obj)
+		case string:
+			_ = json.Unmarshal([]byte(v), &obj)
+		}
+		if obj != nil && where.Matches(obj) {
+			out = append(out, datasetRow{ID: id, Data: obj})
+		}
+	}
+	return out, rows.Err()
+}
+
+func rowsFromMatrix(cols []string, matrix [][]interface{}) []datasetRow {
+	out := make([]datasetRow, 0, len(matrix))
+	for _, r := range matrix {
+		rec := make(map[string]any, len(cols))
+		for i, col := range cols {
+			if i < len(r) {
+				rec[col] = r[i]
+			}
+		}
+		out = append(out, datasetRow{Data: rec})
+	}
+	return out
+}
+
+// queryDeltaRowsViaPython runs the predicate through the Python /delta/query endpoint. The
+// predicate renders to SQL with quoted identifiers and escaped literals. More than
+// maxDeltaRowSelect matching rows fail with errTooManyDeltaRows rather than being cut short.
+func queryDeltaRowsViaPython(ctx context.Context, ds *models.Dataset, where storage.Predicate) ([]datasetRow, error) {
+	sql := "SELECT * FROM t"
+	if len(where) > 0 {
+		sql += " WHERE " + where.String()
+	}
+	cols, rows, err := selectDeltaViaPython(ctx, ds, sql)
+	if err != nil {
+		return nil, err
+	}
+	return rowsFromMatrix(cols, rows), nil
+}
+
+// selectDeltaViaPython runs a query of the Delta table of ds, named t, on the Python service.
+// More than maxDeltaRowSelect rows fail with errTooManyDeltaRows.
+func selectDeltaViaPython(ctx context.Context, ds *models.Dataset, sql string) ([]string, [][]interface{}, error) {
+	body, _ := json.Marshal(map[string]any{
+		"sql":            sql,
+		"table_mappings": map[string]string{"t": storage.DeltaTableID(ds.ProjectID, ds.ID)},
+		"limit":          maxDeltaRowSelect + 1,
+		"offset":         0,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, getPythonServiceURL()+"/delta/query", bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := deltaRowsClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, nil, fmt.Errorf("delta query failed: %s", strings.TrimSpace(string(b)))
+	}
+	var out struct {
+		Columns []string        `json:"columns"`
+		Rows    [][]interface{} `json:"rows"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, nil, err
+	}
+	if len(out.Rows) > maxDeltaRowSelect {
+		return nil, nil, errTooManyDeltaRows
+	}
+	return out.Columns, out.Rows, nil
+}
+
+// maxDeltaRowSelect caps rows fetched from the Python service for one row selection.
+const maxDeltaRowSelect = 1000000
+
+// errTooManyDeltaRows is returned by a row selection matching more than maxDeltaRowSelect rows.
+var errTooManyDeltaRows = fmt.Errorf("more than %d rows to read from the Delta table", maxDeltaRowSelect)
+
+// deltaRowsClient calls the Python service for row selections; a stuck service fails the
+// request instead of holding it open.
+var deltaRowsClient = &http.Client{Timeout: 2 * time.Minute}
+
+// matchRowEdits returns the rows each edit's key addresses. Rows are fetched with one query
+// (an IN list per key column) and then matched exactly per edit.
+func matchRowEdits(ctx context.Context, gdb *gorm.DB, ds *models.Dataset, keys []string, edits []rowEdit) ([][]datasetRow, error) {
+	where := make(storage.Predicate, 0, len(keys))
+	for _, k := range keys {
+		vals := make([]interface{}, 0, len(edits))
+		for _, e := range edits {
+			vals = append(vals, e.Key[k])
+		}
+		where = append(where, storage.Condition{Column: k, Op: "in", Value: vals})
+	}
+	candidates, err := selectDatasetRows(ctx, gdb, ds, where)
+	if err != nil {
+		return nil, err
+	}
+	// Bucket the candidates by key so matching stays linear in the number of edits
+	byKey := map[string][]datasetRow{}
+	for _, r := range candidates {
+		if k, ok := storage.RowKey(r.Data, keys); ok {
+			byKey[k] = append(byKey[k], r)
+		}
+	}
+	out := make([][]datasetRow, len(edits))
+	for i, e := range edits {
+		k, ok := storage.RowKey(e.Key, keys)
+		if !ok {
+			continue
+		}
+		exact := e.keyPredicate(keys)
+		for _, r := range byKey[k] {
+			if exact.Matches(r.Data) {
+				out[i] = append(out[i], r)
+			}
+		}
+	}
+	return out, nil
+}
+
+// resolveRowEdits finds the single row each edit addresses.
+func resolveRowEdits(ctx context.Context, gdb *gorm.DB, ds *models.Dataset, keys []string, edits []rowEdit) ([]datasetRow, error) {
+	matches, err := matchRowEdits(ctx, gdb, ds, keys, edits)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]datasetRow, len(edits))
+	for i, e := range edits {
+		switch {
+		case len(matches[i]) == 0:
+			return nil, &rowEditError{Code: "row_not_found", Key: e.Key}
+		case len(matches[i]) > 1:
+			return nil, &rowEditError{Code: "key_not_unique", Key: e.Key}
+		}
+		out[i] = matches[i][0]
+	}
+	return out, nil
+}
+
+// rowEditError reports an edit whose key does not address exactly one row.
+type rowEditError struct {
+	Code string
+	Key  map[string]any
+}
+
+func (e *rowEditError) Error() string { return fmt.Sprintf("%s: %v", e.Code, e.Key) }
+
+// requireReviewers dedupes the requested reviewers, checks that there is at least one and that
+// each is a project member, and adds the reviewers the dataset's approval policy requires,
+// responding with the error otherwise.
+func requireReviewers(c *gin.Context, gdb *gorm.DB, ds *models.Dataset, ids []uint) ([]uint, bool) {
+	uniq := map[uint]struct{}{}
+	cleaned := make([]uint, 0, len(ids))
+	for _, rid := range ids {
+		if rid != 0 {
+			if _, ok := uniq[rid]; !ok {
+				uniq[rid] = struct{}{}
+				cleaned = append(cleaned, rid)
+			}
+		}
+	}
+	if len(cleaned) == 0 {
+		c.JSON(400, gin.H{"error": "reviewer_required"})
+		return nil, false
+	}
+	var count int64
+	if err := gdb.Model(&models.ProjectRole{}).Where("project_id = ? AND user_id IN ?", ds.ProjectID, cleaned).Count(&count).Error; err != nil || count != int64(len(cleaned)) {
+		c.JSON(400, gin.H{"error": "reviewer_not_member"})
+		return nil, false
+	}
+	return applyPolicyReviewers(c, gdb, ds, contextUserID(c), cleaned)
+}
+
+// openRowChange creates a pending change request of the given type and notifies its reviewers.
+func openRowChange(c *gin.Context, gdb *gorm.DB, ds *models.Dataset, crType, title string, payload any, reviewers []uint) (*models.ChangeRequest, error) {
+	pb, _ := json.Marshal(payload)
+	cr := models.ChangeRequest{ProjectID: ds.ProjectID, DatasetID: ds.ID, Type: crType, Status: "pending", Title: title, Payload: string(pb), Reviewers: newReviewerRows(reviewers), UserID: contextUserID(c)}
+	if len(reviewers) > 0 {
+		cr.ReviewerID = reviewers[0]
+	}
+	stampChangeBase(gdb, ds, &cr)
+	if err := gdb.Create(&cr).Error; err != nil {
+		return nil, err
+	}
+	_ = AddNotificationsBulk(reviewers, "You were requested to review a change", models.JSONB{"type": "reviewer_assigned", "project_id": ds.ProjectID, "dataset_id": ds.ID, "change_request_id": cr.ID, "title": title})
+	return &cr, nil
+}
+
+// loadRowChangeDataset resolves the project and dataset for the row-level create endpoints.
+func loadRowChangeDataset(c *gin.Context) (*gorm.DB, *models.Dataset, bool) {
+	gdb := dbpkg.Get()
+	if gdb == nil {
+		if _, err := dbpkg.Init(); err != nil {
+			c.JSON(500, gin.H{"error": "db"})
+			return nil, nil, false
+		}
+		gdb = dbpkg.Get()
+	}
+	pid, _ := strconv.Atoi(c.Param("id"))
+	dsid, _ := strconv.Atoi(c.Param("datasetId"))
+	if !HasProjectRole(c, uint(pid), "owner", "contributor") {
+		c.JSON(403, gin.H{"error": "forbidden"})
+		return nil, nil, false
+	}
+	var ds models.Dataset
+	if err := gdb.Where("project_id = ?", pid).First(&ds, dsid).Error; err != nil {
+		c.JSON(404, gin.H{"error": "not_found"})
+		return nil, nil, false
+	}
+	return gdb, &ds, true
+}
+
+// ChangeDeleteCreate opens a "delete" change request for the rows matching a predicate.
+// Body: { where: [{column, op, value}], title?, reason?, reviewer_ids }
+func ChangeDeleteCreate(c *gin.Context) {
+	gdb, ds, ok := loadRowChangeDataset(c)
+	if !ok {
+		return
+	}
+	var body struct {
+		Where       storage.Predicate `json:"where"`
+		Title       string            `json:"title"`
+		Reason      string            `json:"reason"`
+		ReviewerIDs []uint            `json:"reviewer_ids"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"error": "invalid_payload"})
+		return
+	}
+	if err := body.Where.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": "invalid_predicate", "message": err.Error()})
+		return
+	}
+	if err := checkColumns(datasetColumnSet(ds), body.Where.Columns()); err != nil {
+		c.JSON(400, gin.H{"error": "invalid_predicate", "message": err.Error()})
+		return
+	}
+	reviewers, ok := requireReviewers(c, gdb, ds, body.ReviewerIDs)
+	if !ok {
+		return
+	}
+	matched, err := selectDatasetRows(c.Request.Context(), gdb, ds, body.Where)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "predicate_failed", "message": err.Error()})
+		return
+	}
+	if len(matched) == 0 {
+		c.JSON(400, gin.H{"error": "no_matching_rows"})
+		return
+	}
+	title := strings.TrimSpace(body.Title)
+	if title == "" {
+		title = fmt.Sprintf("Delete %d rows", len(matched))
+	}
+	cr, err := openRowChange(c, gdb, ds, "delete", title, deleteChangePayload{Where: body.Where, Reason: body.Reason, MatchedRows: len(matched)}, reviewers)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db"})
+		return
+	}
+	crID := cr.ID
+	_ = RecordAuditEvent(cr.ProjectID, cr.DatasetID, cr.UserID, models.AuditEventTypeCRCreated,
+		fmt.Sprintf("Change Request #%d created: %s", cr.ID, cr.Title),
+		fmt.Sprintf("%d rows to delete where %s", len(matched), body.Where.String()),
+		&crID,
+		models.AuditEventSummary{RowsDeleted: len(matched)},
+		nil,
+	)
+	c.JSON(201, gin.H{"ok": true, "change_request": cr, "matched_rows": len(matched)})
+}
+
+// ChangeUpdateCreate opens an "update" change request that sets cells on rows addressed by key.
+// Body: { key_columns: [..], edits: [{key: {..}, set: {..}}], title?, reason?, reviewer_ids }
+func ChangeUpdateCreate(c *gin.Context) {
+	gdb, ds, ok := loadRowChangeDataset(c)
+	if !ok {
+		return
+	}
+	var body struct {
+		KeyColumns  []string  `json:"key_columns"`
+		Edits       []rowEdit `json:"edits"`
+		Title       string    `json:"title"`
+		Reason      string    `json:"reason"`
+		ReviewerIDs []uint    `json:"reviewer_ids"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"error": "invalid_payload"})
+		return
+	}
+	if len(body.KeyColumns) == 0 || len(body.Edits) == 0 {
+		c.JSON(400, gin.H{"error": "empty_edits"})
+		return
+	}
+	if len(body.Edits) > maxRowEdits {
+		c.JSON(400, gin.H{"error": "too_many_edits", "max": maxRowEdits})
+		return
+	}
+	known := datasetColumnSet(ds)
+	if err := checkColumns(known, body.KeyColumns); err != nil {
+		c.JSON(400, gin.H{"error": "invalid_key_columns", "message": err.Error()})
+		return
+	}
+	isKey := map[string]bool{}
+	for _, k := range body.KeyColumns {
+		isKey[k] = true
+	}
+	cells := 0
+	for _, e := range body.Edits {
+		for _, k := range body.KeyColumns {
+			if e.Key[k] == nil {
+				c.JSON(400, gin.H{"error": "missing_key", "key": e.Key, "column": k})
+				return
+			}
+		}
+		if len(e.Set) == 0 {
+			c.JSON(400, gin.H{"error": "empty_edits", "key": e.Key})
+			return
+		}
+		for col := range e.Set {
+			if isKey[col] {
+				c.JSON(400, gin.H{"error": "key_column_edit", "column": col})
+				return
+			}
+			if err := checkColumns(known, []string{col}); err != nil {
+				c.JSON(400, gin.H{"error": "invalid_column", "message": err.Error()})
+				return
+			}
+		}
+		cells += len(e.Set)
+	}
+	reviewers, ok := requireReviewers(c, gdb, ds, body.ReviewerIDs)
+	if !ok {
+		return
+	}
+	targets, err := resolveRowEdits(c.Request.Context(), gdb, ds, body.KeyColumns, body.Edits)
+	if err != nil {
+		if re, ok := err.(*rowEditError); ok {
+			c.JSON(400, gin.H{"error": re.Code, "key": re.Key})
+			return
+		}
+		c.JSON(400, gin.H{"error": "lookup_failed", "message": err.Error()})
+		return
+	}
+	title := strings.TrimSpace(body.Title)
+	if title == "" {
+		title = fmt.Sprintf("Update %d rows", len(body.Edits))
+	}
+	base := make([]map[string]any, len(targets))
+	for i, t := range targets {
+		base[i] = t.Data
+	}
+	cr, err := openRowChange(c, gdb, ds, "update", title, updateChangePayload{KeyColumns: body.KeyColumns, Edits: body.Edits, Reason: body.Reason, Base: base}, reviewers)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db"})
+		return
+	}
+	crID := cr.ID
+	_ = RecordAuditEvent(cr.ProjectID, cr.DatasetID, cr.UserID, models.AuditEventTypeCRCreated,
+		fmt.Sprintf("Change Request #%d created: %s", cr.ID, cr.Title),
+		fmt.Sprintf("%d rows to update, %d cells edited", len(body.Edits), cells),
+		&crID,
+		models.AuditEventSummary{RowsUpdated: len(body.Edits), CellsChanged: cells},
+		nil,
+	)
+	c.JSON(201, gin.H{"ok": true, "change_request": cr})
+}
+
+// rowChangePreview renders the preview of a delete, update or merge change against the current data.
+func rowChangePreview(c *gin.Context, gdb *gorm.DB, cr *models.ChangeRequest) {
+	var ds models.Dataset
+	if err := gdb.Where("project_id = ?", cr.ProjectID).First(&ds, cr.DatasetID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "dataset_not_found"})
+		return
+	}
+	ctx := c.Request.Context()
+	if cr.Type == "merge" {
+		mergeChangePreview(c, gdb, &ds, cr)
+		return
+	}
+	if cr.Type == "delete" {
+		var p deleteChangePayload
+		if err := json.Unmarshal([]byte(cr.Payload), &p); err != nil {
+			c.JSON(400, gin.H{"error": "invalid_payload"})
+			return
+		}
+		matched, err := selectDatasetRows(ctx, gdb, &ds, p.Where)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "preview_failed", "message": err.Error()})
+			return
+		}
+		data := make([]map[string]any, 0, len(matched))
+		colsSet := map[string]struct{}{}
+		for i, r := range matched {
+			if i >= 500 {
+				break
+			}
+			data = append(data, r.Data)
+			for k := range r.Data {
+				colsSet[k] = struct{}{}
+			}
+		}
+		cols := make([]string, 0, len(colsSet))
+		for k := range colsSet {
+			cols = append(cols, k)
+		}
+		c.JSON(200, gin.H{"type": "delete", "predicate": p.Where.String(), "where": p.Where, "columns": cols, "data": data, "rows": len(data), "total_rows": len(matched)})
+		return
+	}
+	var p updateChangePayload
+	if err := json.Unmarshal([]byte(cr.Payload), &p); err != nil {
+		c.JSON(400, gin.H{"error": "invalid_payload"})
+		return
+	}
+	// Report each edit on its own so one missing row does not hide the rest of the preview
+	matches, err := matchRowEdits(ctx, gdb, &ds, p.KeyColumns, p.Edits)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "preview_failed", "message": err.Error()})
+		return
+	}
+	items := make([]gin.H, 0, len(p.Edits))
+	for i, e := range p.Edits {
+		item := gin.H{"key": e.Key, "set": e.Set}
+		rows := matches[i]
+		switch {
+		case len(rows) == 0:
+			item["status"] = "row_not_found"
+		case len(rows) > 1:
+			item["status"] = "key_not_unique"
+		default:
+			after := map[string]any{}
+			for k, v := range rows[0].Data {
+				after[k] = v
+			}
+			for k, v := range e.Set {
+				after[k] = v
+			}
+			item["status"] = "ok"
+			item["before"] = rows[0].Data
+			item["after"] = after
+		}
+		items = append(items, item)
+	}
+	c.JSON(200, gin.H{"type": "update", "key_columns": p.KeyColumns, "edits": items, "rows": len(items), "total_rows": len(items)})
+}
+
+// rowChangeResult counts what applying a row-level change did.
+type rowChangeResult struct {
+	Added   int
+	Deleted int
+	Updated int
+	Cells   int
+}
+
+// applyRowChange applies an approved delete, update or merge change to the dataset.
+func applyRowChange(ctx context.Context, gdb *gorm.DB, ds *models.Dataset, cr *models.ChangeRequest) (rowChangeResult, error) {
+	var res rowChangeResult
+	switch cr.Type {
+	case "delete":
+		var p deleteChangePayload
+		if err := json.Unmarshal([]byte(cr.Payload), &p); err != nil {
+			return res, err
+		}
+		if err := p.Where.Validate(); err != nil {
+			return res, err
+		}
+		if isDeltaBackend(ds) {
+			matched, err := selectDatasetRows(ctx, gdb, ds, p.Where)
+			if err != nil || len(matched) == 0 {
+				return res, err
+			}
+			if err := storage.GetAdapterForDataset(ds).Delete(ctx, storage.DeltaTableID(ds.ProjectID, ds.ID), p.Where); err != nil {
+				return res, err
+			}
+			res.Deleted = len(matched)
+			return res, nil
+		}
+		err := gdb.Transaction(func(tx *gorm.DB) error {
+			matched, err := selectDatasetRows(ctx, tx, ds, p.Where)
+			if err != nil {
+				return err
+			}
+			ids := make([]int64, 0, len(matched))
+			for _, r := range matched {
+				ids = append(ids, r.ID)
+			}
+			if len(ids) == 0 {
+				return nil
+			}
+			ex := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE id IN ?", datasetPhysicalTable(ds)), ids)
+			res.Deleted = int(ex.RowsAffected)
+			return ex.Error
+		})
+		return res, err
+	case "update":
+		var p updateChangePayload
+		if err := json.Unmarshal([]byte(cr.Payload), &p); err != nil {
+			return res, err
+		}
+		if isDeltaBackend(ds) {
+			targets, err := resolveRowEdits(ctx, gdb, ds, p.KeyColumns, p.Edits)
+			if err != nil {
+				return res, err
+			}
+			rows := make([]map[string]interface{}, 0, len(p.Edits))
+			for i, e := range p.Edits {
+				if next, n := applyEdit(targets[i].Data, e.Set); n > 0 {
+					rows = append(rows, next)
+					res.Updated++
+					res.Cells += n
+				}
+			}
+			return res, stageAndMergeDelta(ctx, ds, cr, rows, p.KeyColumns)
+		}
+		err := gdb.Transaction(func(tx *gorm.DB) error {
+			targets, err := resolveRowEdits(ctx, tx, ds, p.KeyColumns, p.Edits)
+			if err != nil {
+				return err
+			}
+			for i, e := range p.Edits {
+				next, n := applyEdit(targets[i].Data, e.Set)
+				if n == 0 {
+					continue
+				}
+				if err := updateJSONRow(tx, ds, targets[i].ID, next); err != nil {
+					return err
+				}
+				res.Updated++
+				res.Cells += n
+			}
+			return nil
+		})
+		return res, err
+	case "merge":
+		return applyMergeChange(ctx, gdb, ds, cr)
+	}
+	return res, fmt.Errorf("unsupported change type %q", cr.Type)
+}
+
+// updateJSONRow replaces the data of one row of a JSONB dataset table.
+func updateJSONRow(tx *gorm.DB, ds *models.Dataset, id int64, data map[string]any) error {
+	placeholder := "?"
+	if dialect(tx) == "postgres" {
+		placeholder = "?::jsonb"
+	}
+	b, _ := json.Marshal(data)
+	return tx.Exec(fmt.Sprintf("UPDATE %s SET data = %s WHERE id = ?", datasetPhysicalTable(ds), placeholder), string(b), id).Error
+}
+
+// applyEdit returns a copy of row with set applied and the number of cells whose value changed.
+func applyEdit(row, set map[string]any) (map[string]any, int) {
+	next := make(map[string]any, len(row)+len(set))
+	for k, v := range row {
+		next[k] = v
+	}
+	changed := 0
+	for k, v := range set {
+		if !sameCell(row, k, v) {
+			changed++
+		}
+		next[k] = v
+	}
+	return next, changed
+}
+
+// sameCell reports whether row[col] already holds v, comparing numbers by value.
+func sameCell(row map[string]any, col string, v any) bool {
+	if v == nil || row[col] == nil {
+		return v == nil && row[col] == nil
+	}
+	return storage.Predicate{{Column: col, Op: "eq", Value: v}}.Matches(row)
+}
+
+// stageAndMergeDelta writes rows to the change request's staging table and merges it into the
+// main table on keys, so the change lands as a single MERGE version. The staging table only has
+// the dataset columns present in rows, so columns the change does not mention are kept.
+func stageAndMergeDelta(ctx context.Context, ds *models.Dataset, cr *models.ChangeRequest, rows []map[string]interface{}, keys []string) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	// Staging is written in-process for both Delta backends; the Python service reads it from
+	// the shared DELTA_DATA_ROOT.
+	// Only the main table commit carries the apply key.
+	stager := storage.NewDeltaNativeAdapter()
+	stage := storage.DeltaStagingID(ds.ProjectID, ds.ID, cr.ID)
+	sctx := storage.WithCommitTag(ctx, "")
+	_ = stager.DropTable(sctx, stage)
+	defer func() { _ = stager.DropTable(context.Background(), stage) }()
+	present := map[string]bool{}
+	for _, r := range rows {
+		for k := range r {
+			present[k] = true
+		}
+	}
+	var cols []storage.DeltaColumn
+	for _, col := range deltaColumnsFromSchema(ds.Schema) {
+		if present[col.Name] {
+			cols = append(cols, col)
+		}
+	}
+	if len(cols) > 0 {
+		if err := stager.EnsureTable(sctx, stage, cols); err != nil {
+			return err
+		}
+	}
+	if err := stager.Insert(sctx, stage, rows); err != nil {
+		return err
+	}
+	return storage.GetAdapterForDataset(ds).Merge(ctx, storage.DeltaTableID(ds.ProjectID, ds.ID), stage, keys)
+}
+
+// approveRowChange is ChangeApprove's final step for delete, update and merge change requests.
+// JSONB datasets apply the change, record the version and complete the request in one
+// transaction; Delta datasets commit first (tagged with the apply key) and then complete it.
+func approveRowChange(c *gin.Context, gdb *gorm.DB, cr *models.ChangeRequest, actingUID uint) {
+	var ds models.Dataset
+	if err := gdb.Where("project_id = ?", cr.ProjectID).First(&ds, cr.DatasetID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "dataset_not_found"})
+		return
+	}
+	if !claimOrRespond(c, gdb, cr) {
+		return
+	}
+	ctx := storage.WithCommitTag(c.Request.Context(), cr.ApplyKey)
+	table := datasetPhysicalTable(&ds)
+	if isDeltaBackend(&ds) {
+		table = storage.DeltaTableID(ds.ProjectID, ds.ID)
+	}
+	var res rowChangeResult
+	complete := func(tx *gorm.DB) error {
+		var rowCount int64
+		var meta models.DatasetMeta
+		if err := tx.Where("dataset_id = ?", ds.ID).First(&meta).Error; err == nil {
+			rowCount = meta.RowCount
+		}
+		err := recordChangeVersion(tx, &ds, cr, map[string]any{
+			"table":         table,
+			"row_count":     rowCount,
+			"change_id":     cr.ID,
+			"change_type":   cr.Type,
+			"rows_added":    res.Added,
+			"rows_deleted":  res.Deleted,
+			"rows_updated":  res.Updated,
+			"cells_changed": res.Cells,
+			"applied_at":    time.Now().Format(time.RFC3339),
+		})
+		if err != nil {
+			return err
+		}
+		return finishChangeApply(tx, cr, fmt.Sprintf("Applied %s at %s", cr.Type, time.Now().Format(time.RFC3339)))
+	}
+	var err error
+	if isDeltaBackend(&ds) {
+		res, err = applyRowChange(ctx, gdb, &ds, cr)
+		// A failed call may still have committed; the table history decides
+		if err == nil || deltaApplied(ctx, &ds, cr.ApplyKey) {
+			upsertDatasetMeta(gdb, &ds)
+			if cerr := gdb.Transaction(complete); cerr != nil {
+				// The data is in; the request stays "applying" and is completed by recovery
+				c.JSON(500, gin.H{"error": "db", "message": cerr.Error()})
+				return
+			}
+			err = nil
+		}
+	} else {
+		err = gdb.Transaction(func(tx *gorm.DB) error {
+			var aerr error
+			if res, aerr = applyRowChange(ctx, tx, &ds, cr); aerr != nil {
+				return aerr
+			}
+			upsertDatasetMeta(tx, &ds)
+			return complete(tx)
+		})
+	}
+	if err != nil {
+		releaseChangeApply(gdb, cr)
+		if re, ok := err.(*rowEditError); ok {
+			c.JSON(409, gin.H{"error": re.Code, "key": re.Key})
+			return
+		}
+		c.JSON(500, gin.H{"error": "apply_failed", "message": err.Error()})
+		return
+	}
+	var detail string
+	switch cr.Type {
+	case "delete":
+		detail = fmt.Sprintf("%d rows deleted", res.Deleted)
+	case "merge":
+		detail = fmt.Sprintf("%d rows inserted, %d rows updated", res.Added, res.Updated)
+	default:
+		detail = fmt.Sprintf("%d rows updated, %d cells changed", res.Updated, res.Cells)
+	}
+	if cr.UserID != 0 {
+		_ = AddNotification(cr.UserID, fmt.Sprintf("Your %s request has been applied. %s.", cr.Type, detail), models.JSONB{"type": cr.Type + "_completed", "project_id": cr.ProjectID, "dataset_id": cr.DatasetID, "change_request_id": cr.ID, "rows_added": res.Added, "rows_deleted": res.Deleted, "rows_updated": res.Updated, "cells_changed": res.Cells})
+	}
+	crID := cr.ID
+	_ = RecordAuditEvent(cr.ProjectID, cr.DatasetID, actingUID, models.AuditEventTypeCRMerged,
+		fmt.Sprintf("Change Request #%d merged", cr.ID),
+		detail,
+		&crID,
+		models.AuditEventSummary{RowsAdded: res.Added, RowsDeleted: res.Deleted, RowsUpdated: res.Updated, CellsChanged: res.Cells},
+		nil,
+	)
+	c.JSON(200, gin.H{"ok": true, "change_request": cr, "rows_added": res.Added, "rows_deleted": res.Deleted, "rows_updated": res.Updated, "cells_changed": res.Cells})
+}
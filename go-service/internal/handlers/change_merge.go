@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/storage"
+	"gorm.io/gorm"
+)
+
+// mergeChangePayload is stored in ChangeRequest.Payload for type "merge". The counts are the
+// preview taken when the change was opened; ChangePreview recomputes them against current data.
+type mergeChangePayload struct {
+	UploadID   uint     `json:"upload_id"`
+	Filename   string   `json:"filename"`
+	KeyColumns []string `json:"key_columns"`
+	Inserts    int      `json:"inserts"`
+	Updates    int      `json:"updates"`
+	Unchanged  int      `json:"unchanged"`
+}
+
+// mergeUpdate is a matched target row whose values change.
+type mergeUpdate struct {
+	Target datasetRow
+	Source map[string]any
+	Next   map[string]any
+	Cells  int
+}
+
+// mergePlan classifies the staged rows of a merge against the current data.
+type mergePlan struct {
+	Inserts   []map[string]any
+	Updates   []mergeUpdate
+	Unchanged int
+}
+
+// maxMergeKeyFilter is the largest upload whose keys are pushed down as IN lists; bigger
+// uploads are matched against a full scan.
+const maxMergeKeyFilter = 1000
+
+// planMerge matches source rows to dataset rows on keys. Every source row must have all keys set
+// and a key may appear only once in the source and at most once in the dataset.
+func planMerge(ctx context.Context, gdb *gorm.DB, ds *models.Dataset, keys []string, source []map[string]any) (*mergePlan, error) {
+	bySource := make(map[string]bool, len(source))
+	for _, rec := range source {
+		k, ok := storage.RowKey(rec, keys)
+		if !ok {
+			return nil, &rowEditError{Code: "missing_key", Key: keyOf(rec, keys)}
+		}
+		if bySource[k] {
+			return nil, &rowEditError{Code: "duplicate_key", Key: keyOf(rec, keys)}
+		}
+		bySource[k] = true
+	}
+	var where storage.Predicate
+	if len(source) <= maxMergeKeyFilter {
+		for _, k := range keys {
+			vals := make([]interface{}, 0, len(source))
+			for _, rec := range source {
+				vals = append(vals, rec[k])
+			}
+			where = append(where, storage.Condition{Column: k, Op: "in", Value: vals})
+		}
+	}
+	candidates, err := selectDatasetRows(ctx, gdb, ds, where)
+	if err != nil {
+		return nil, err
+	}
+	targets := map[string]datasetRow{}
+	for _, r := range candidates {
+		k, ok := storage.RowKey(r.Data, keys)
+		if !ok || !bySource[k] {
+			continue
+		}
+		if _, dup := targets[k]; dup {
+			return nil, &rowEditError{Code: "key_not_unique", Key: keyOf(r.Data, keys)}
+		}
+		targets[k] = r
+	}
+	plan := &mergePlan{}
+	for _, rec := range source {
+		k, _ := storage.RowKey(rec, keys)
+		t, hit := targets[k]
+		if !hit {
+			plan.Inserts = append(plan.Inserts, rec)
+			continue
+		}
+		next, n := applyEdit(t.Data, rec)
+		if n == 0 {
+			plan.Unchanged++
+			continue
+		}
+		plan.Updates = append(plan.Updates, mergeUpdate{Target: t, Source: rec, Next: next, Cells: n})
+	}
+	return plan, nil
+}
+
+func keyOf(rec map[string]any, keys []string) map[string]any {
+	out := make(map[string]any, len(keys))
+	for _, k := range keys {
+		out[k] = rec[k]
+	}
+	return out
+}
+
+// loadMergeSource reads the upload rows of a merge change and checks them against the dataset.
+func loadMergeSource(gdb *gorm.DB, ds *models.Dataset, uploadID uint, keys []string) ([]map[string]any, *models.DatasetUpload, error) {
+	var up models.DatasetUpload
+	if err := gdb.Where("project_id = ? AND dataset_id = ?", ds.ProjectID, ds.ID).First(&up, uploadID).Error; err != nil {
+		return nil, nil, &rowEditError{Code: "upload_not_found"}
+	}
+	source, err := recordsFromUpload(up.Content, up.Filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse upload: %w", err)
+	}
+	if len(source) == 0 {
+		return nil, nil, &rowEditError{Code: "empty_rows"}
+	}
+	known := datasetColumnSet(ds)
+	if err := checkColumns(known, keys); err != nil {
+		return nil, nil, err
+	}
+	for _, rec := range source {
+		for col := range rec {
+			if err := checkColumns(known, []string{col}); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return source, &up, nil
+}
+
+// ChangeMergeCreate opens a "merge" change request that upserts a validated upload on key
+// columns: rows whose keys exist are updated, the others inserted.
+// Body: { upload_id, key_columns: [..], title?, reviewer_ids }
+func ChangeMergeCreate(c *gin.Context) {
+	gdb, ds, ok := loadRowChangeDataset(c)
+	if !ok {
+		return
+	}
+	var body struct {
+		UploadID    uint     `json:"upload_id"`
+		KeyColumns  []string `json:"key_columns"`
+		Title       string   `json:"title"`
+		ReviewerIDs []uint   `json:"reviewer_ids"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"error": "invalid_payload"})
+		return
+	}
+	if body.UploadID == 0 {
+		c.JSON(400, gin.H{"error": "upload_required"})
+		return
+	}
+	if len(body.KeyColumns) == 0 {
+		c.JSON(400, gin.H{"error": "key_columns_required"})
+		return
+	}
+	reviewers, ok := requireReviewers(c, gdb, ds, body.ReviewerIDs)
+	if !ok {
+		return
+	}
+	source, up, err := loadMergeSource(gdb, ds, body.UploadID, body.KeyColumns)
+	if err != nil {
+		respondMergeError(c, err)
+		return
+	}
+	plan, err := planMerge(c.Request.Context(), gdb, ds, body.KeyColumns, source)
+	if err != nil {
+		respondMergeError(c, err)
+		return
+	}
+	title := strings.TrimSpace(body.Title)
+	if title == "" {
+		title = "Merge data"
+	}
+	payload := mergeChangePayload{UploadID: up.ID, Filename: up.Filename, KeyColumns: body.KeyColumns, Inserts: len(plan.Inserts), Updates: len(plan.Updates), Unchanged: plan.Unchanged}
+	cr, err := openRowChange(c, gdb, ds, "merge", title, payload, reviewers)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db"})
+		return
+	}
+	cells := 0
+	for _, u := range plan.Updates {
+		cells += u.Cells
+	}
+	crID := cr.ID
+	_ = RecordAuditEvent(cr.ProjectID, cr.DatasetID, cr.UserID, models.AuditEventTypeCRCreated,
+		fmt.Sprintf("Change Request #%d created: %s", cr.ID, cr.Title),
+		fmt.Sprintf("%d rows to insert, %d rows to update, %d unchanged", payload.Inserts, payload.Updates, payload.Unchanged),
+		&crID,
+		models.AuditEventSummary{RowsAdded: payload.Inserts, RowsUpdated: payload.Updates, CellsChanged: cells},
+		nil,
+	)
+	c.JSON(201, gin.H{"ok": true, "change_request": cr, "inserts": payload.Inserts, "updates": payload.Updates, "unchanged": payload.Unchanged})
+}
+
+// respondMergeError maps merge planning errors to 400 responses.
+func respondMergeError(c *gin.Context, err error) {
+	if re, ok := err.(*rowEditError); ok {
+		if re.Code == "upload_not_found" {
+			c.JSON(404, gin.H{"error": re.Code})
+			return
+		}
+		c.JSON(400, gin.H{"error": re.Code, "key": re.Key})
+		return
+	}
+	c.JSON(400, gin.H{"error": "invalid_merge", "message": err.Error()})
+}
+
+// mergeChangePreview reports how the merge would apply to the current data: counts of inserted,
+// updated and unchanged rows plus a sample of the inserts and updates.
+func mergeChangePreview(c *gin.Context, gdb *gorm.DB, ds *models.Dataset, cr *models.ChangeRequest) {
+	var p mergeChangePayload
+	if err := json.Unmarshal([]byte(cr.Payload), &p); err != nil {
+		c.JSON(400, gin.H{"error": "invalid_payload"})
+		return
+	}
+	source, _, err := loadMergeSource(gdb, ds, p.UploadID, p.KeyColumns)
+	if err != nil {
+		respondMergeError(c, err)
+		return
+	}
+	plan, err := planMerge(c.Request.Context(), gdb, ds, p.KeyColumns, source)
+	if err != nil {
+		respondMergeError(c, err)
+		return
+	}
+	const maxSample = 500
+	sample := make([]gin.H, 0, maxSample)
+	for _, u := range plan.Updates {
+		if len(sample) >= maxSample {
+			break
+		}
+		sample = append(sample, gin.H{"status": "update", "key": keyOf(u.Source, p.KeyColumns), "before": u.Target.Data, "after": u.Next})
+	}
+	for _, rec := range plan.Inserts {
+		if len(sample) >= maxSample {
+			break
+		}
+		sample = append(sample, gin.H{"status": "insert", "key": keyOf(rec, p.KeyColumns), "after": rec})
+	}
+	colsSet := map[string]struct{}{}
+	for _, rec := range source {
+		for k := range rec {
+			colsSet[k] = struct{}{}
+		}
+	}
+	cols := make([]string, 0, len(colsSet))
+	for k := range colsSet {
+		cols = append(cols, k)
+	}
+	sort.Strings(cols)
+	c.JSON(200, gin.H{
+		"type":        "merge",
+		"key_columns": p.KeyColumns,
+		"columns":     cols,
+		"inserts":     len(plan.Inserts),
+		"updates":     len(plan.Updates),
+		"unchanged":   plan.Unchanged,
+		"data":        sample,
+		"rows":        len(sample),
+		"total_rows":  len(source),
+	})
+}
+
+// applyMergeChange applies an approved merge change: Delta datasets go through
+// StorageAdapter.Merge with the changed rows staged, JSONB tables update and insert in one
+// transaction.
+func applyMergeChange(ctx context.Context, gdb *gorm.DB, ds *models.Dataset, cr *models.ChangeRequest) (rowChangeResult, error) {
+	var res rowChangeResult
+	var p mergeChangePayload
+	if err := json.Unmarshal([]byte(cr.Payload), &p); err != nil {
+		return res, err
+	}
+	source, _, err := loadMergeSource(gdb, ds, p.UploadID, p.KeyColumns)
+	if err != nil {
+		return res, err
+	}
+	count := func(plan *mergePlan) {
+		res.Added, res.Updated = len(plan.Inserts), len(plan.Updates)
+		for _, u := range plan.Updates {
+			res.Cells += u.Cells
+		}
+	}
+	if isDeltaBackend(ds) {
+		plan, err := planMerge(ctx, gdb, ds, p.KeyColumns, source)
+		if err != nil {
+			return res, err
+		}
+		rows := make([]map[string]interface{}, 0, len(plan.Updates)+len(plan.Inserts))
+		for _, u := range plan.Updates {
+			rows = append(rows, u.Source)
+		}
+		rows = append(rows, plan.Inserts...)
+		if err := stageAndMergeDelta(ctx, ds, cr, rows, p.KeyColumns); err != nil {
+			return res, err
+		}
+		count(plan)
+		return res, nil
+	}
+	err = gdb.Transaction(func(tx *gorm.DB) error {
+		if err := ensureDatasetTable(tx, ds); err != nil {
+			return err
+		}
+		plan, err := planMerge(ctx, tx, ds, p.KeyColumns, source)
+		if err != nil {
+			return err
+		}
+		for _, u := range plan.Updates {
+			if err := updateJSONRow(tx, ds, u.Target.ID, u.Next); err != nil {
+				return err
+			}
+		}
+		placeholder := "?"
+		if dialect(tx) == "postgres" {
+			placeholder = "?::jsonb"
+		}
+		for _, rec := range plan.Inserts {
+			b, _ := json.Marshal(rec)
+			if err := tx.Exec(fmt.Sprintf("INSERT INTO %s (data) VALUES (%s)", datasetPhysicalTable(ds), placeholder), string(b)).Error; err != nil {
+				return err
+			}
+		}
+		count(plan)
+		return nil
+	})
+	return res, err
+}
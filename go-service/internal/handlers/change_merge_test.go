@@ -0,0 +1,77 @@
+package handlers
+
+import (
+    "context"
+    "fmt"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/storage"
+)
+
+func TestMergeChange_PreviewAndApply(t *testing.T) {
+    for _, backend := range []string{"", "delta-native"} {
+        t.Run("backend="+backend, func(t *testing.T) {
+            gdb, r := rowChangeEnv(t)
+            ds := models.Dataset{ID: 8, ProjectID: 1, Name: "people", StorageBackend: backend, Schema: peopleSchema}
+            gdb.Create(&ds)
+            ctx := context.Background()
+            initial := []byte("id,name,age\n1,ann,31\n2,bob,42\n3,cy,7\n")
+            if nd, ok := nativeDelta(&ds); ok {
+                if _, err := nativeDeltaAppend(ctx, nd, &ds, initial, "people.csv"); err != nil {
+                    t.Fatalf("append: %v", err)
+                }
+            } else {
+                if err := ensureDatasetTable(gdb, &ds); err != nil {
+                    t.Fatalf("ensure table: %v", err)
+                }
+                if err := ingestBytesToTable(gdb, initial, "people.csv", datasetPhysicalTable(&ds)); err != nil {
+                    t.Fatalf("ingest: %v", err)
+                }
+            }
+
+            dup := models.DatasetUpload{ProjectID: 1, DatasetID: 8, Filename: "dup.csv", Content: []byte("id,age\n2,43\n2,44\n")}
+            gdb.Create(&dup)
+            code, resp := doJSON(t, r, "POST", "/projects/1/datasets/8/changes/merge", 1, gin.H{"upload_id": dup.ID, "key_columns": []string{"id"}, "reviewer_ids": []uint{2}})
+            if code != 400 || resp["error"] != "duplicate_key" {
+                t.Fatalf("duplicate key: %d %v", code, resp)
+            }
+
+            // bob's age changes, cy is unchanged, dee is new; name is left alone where not given
+            up := models.DatasetUpload{ProjectID: 1, DatasetID: 8, Filename: "fix.csv", Content: []byte("id,age\n2,43\n3,7\n4,19\n")}
+            gdb.Create(&up)
+            code, resp = doJSON(t, r, "POST", "/projects/1/datasets/8/changes/merge", 1, gin.H{"upload_id": up.ID, "key_columns": []string{"id"}, "reviewer_ids": []uint{2}})
+            if code != 201 || resp["inserts"] != float64(1) || resp["updates"] != float64(1) || resp["unchanged"] != float64(1) {
+                t.Fatalf("create merge: %d %v", code, resp)
+            }
+            id := changeID(t, resp)
+            code, resp = doJSON(t, r, "GET", fmt.Sprintf("/projects/1/changes/%d/preview", id), 2, nil)
+            if code != 200 || resp["type"] != "merge" || resp["updates"] != float64(1) || resp["total_rows"] != float64(3) {
+                t.Fatalf("preview: %d %v", code, resp)
+            }
+            code, resp = doJSON(t, r, "POST", fmt.Sprintf("/projects/1/changes/%d/approve", id), 2, nil)
+            if code != 200 || resp["rows_added"] != float64(1) || resp["rows_updated"] != float64(1) {
+                t.Fatalf("approve: %d %v", code, resp)
+            }
+
+            rows, err := selectDatasetRows(ctx, gdb, &ds, nil)
+            if err != nil || len(rows) != 4 {
+                t.Fatalf("rows: %+v %v", rows, err)
+            }
+            bob := storage.Predicate{{Column: "id", Op: "eq", Value: 2}, {Column: "name", Op: "eq", Value: "bob"}, {Column: "age", Op: "eq", Value: 43}}
+            found := false
+            for _, row := range rows {
+                found = found || bob.Matches(row.Data)
+            }
+            if !found {
+                t.Fatalf("bob not updated in place: %+v", rows)
+            }
+            if nd, ok := nativeDelta(&ds); ok {
+                if hist, _ := nd.History(ctx, storage.DeltaTableID(1, 8)); hist[0].Operation != "MERGE" {
+                    t.Fatalf("expected a MERGE version, got %+v", hist[0])
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,475 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbpkg "github.com/oreo-io/oreo.io-v2/go-service/internal/database"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"gorm.io/gorm"
+)
+
+// Reviewers of a change request live in change_request_reviewers, one row per reviewer with
+// their decision. A reviewer may delegate: their row becomes "delegated" and the delegate gets
+// a pending row of their own. The author (or a project owner) can add and remove reviewers
+// while the request is open, and re-request review.
+
+// newReviewerRows returns pending reviewer rows for ids, to be created with their request.
+func newReviewerRows(ids []uint) []models.ChangeRequestReviewer {
+	rows := make([]models.ChangeRequestReviewer, 0, len(ids))
+	for _, id := range ids {
+		rows = append(rows, models.ChangeRequestReviewer{UserID: id, Status: "pending"})
+	}
+	return rows
+}
+
+// changeReviewers loads the reviewer rows of a change request in assignment order.
+func changeReviewers(gdb *gorm.DB, crID uint) []models.ChangeRequestReviewer {
+	var rows []models.ChangeRequestReviewer
+	_ = gdb.Where("change_request_id = ?", crID).Order("id").Find(&rows).Error
+	return rows
+}
+
+// activeReviewerIDs lists the reviewers whose decision still counts, i.e. all but delegated ones.
+func activeReviewerIDs(rows []models.ChangeRequestReviewer) []uint {
+	ids := make([]uint, 0, len(rows))
+	for _, r := range rows {
+		if r.Status != "delegated" {
+			ids = append(ids, r.UserID)
+		}
+	}
+	return ids
+}
+
+// isActiveReviewer reports whether uid is an assigned, non-delegated reviewer of a request.
+func isActiveReviewer(gdb *gorm.DB, crID, uid uint) bool {
+	if uid == 0 {
+		return false
+	}
+	var n int64
+	_ = gdb.Model(&models.ChangeRequestReviewer{}).Where("change_request_id = ? AND user_id = ? AND status <> ?", crID, uid, "delegated").Count(&n).Error
+	return n > 0
+}
+
+// syncPrimaryReviewer points ChangeRequest.ReviewerID at the first active reviewer.
+func syncPrimaryReviewer(gdb *gorm.DB, cr *models.ChangeRequest) {
+	var first uint
+	if ids := activeReviewerIDs(changeReviewers(gdb, cr.ID)); len(ids) > 0 {
+		first = ids[0]
+	}
+	if first != cr.ReviewerID {
+		cr.ReviewerID = first
+		_ = gdb.Model(&models.ChangeRequest{}).Where("id = ?", cr.ID).Update("reviewer_id", first).Error
+	}
+}
+
+// MigrateChangeRequestReviewers copies the reviewers and reviewer_states JSON columns of
+// change_requests, used before reviewers had their own table, into change_request_reviewers
+// rows. It runs once, at startup after AutoMigrate: requests that already have reviewer rows
+// are left alone, the copy is checked against the legacy columns, and only then are the
+// columns renamed to legacy_reviewers and legacy_reviewer_states, which later starts ignore.
+// They are kept for an operator to compare and drop.
+func MigrateChangeRequestReviewers(gdb *gorm.DB) error {
+	present := map[string]bool{}
+	types, err := gdb.Migrator().ColumnTypes("change_requests")
+	if err != nil {
+		return err
+	}
+	// Exact names: the sqlite HasColumn also matches legacy_reviewers
+	for _, t := range types {
+		present[t.Name()] = true
+	}
+	hasReviewers, hasStates := present["reviewers"], present["reviewer_states"]
+	if !hasReviewers && !hasStates {
+		return nil
+	}
+	cols := "id, reviewer_id"
+	if hasReviewers {
+		cols += ", reviewers"
+	}
+	if hasStates {
+		cols += ", reviewer_states"
+	}
+	var legacy []map[string]any
+	if err := gdb.Table("change_requests").Select(cols).Find(&legacy).Error; err != nil {
+		return err
+	}
+	return gdb.Transaction(func(tx *gorm.DB) error {
+		want := map[uint]int{}
+		for _, row := range legacy {
+			crID := legacyID(row["id"])
+			rows := legacyReviewerRows(row)
+			if len(rows) == 0 {
+				continue
+			}
+			var have int64
+			if err := tx.Model(&models.ChangeRequestReviewer{}).Where("change_request_id = ?", crID).Count(&have).Error; err != nil {
+				return err
+			}
+			if have > 0 {
+				continue
+			}
+			for i := range rows {
+				rows[i].ChangeRequestID = crID
+			}
+			if err := tx.Create(&rows).Error; err != nil {
+				return fmt.Errorf("change request %d: %w", crID, err)
+			}
+			want[crID] = len(rows)
+		}
+		for crID, n := range want {
+			var got int64
+			if err := tx.Model(&models.ChangeRequestReviewer{}).Where("change_request_id = ?", crID).Count(&got).Error; err != nil {
+				return err
+			}
+			if got != int64(n) {
+				return fmt.Errorf("change request %d: copied %d reviewers, expected %d", crID, got, n)
+			}
+		}
+		for _, col := range []string{"reviewers", "reviewer_states"} {
+			if present[col] {
+				if err := tx.Exec(fmt.Sprintf("ALTER TABLE change_requests RENAME COLUMN %s TO legacy_%s", col, col)).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// legacyReviewerRows parses one change request's legacy reviewer columns. Reviewer states win;
+// reviewers without a state, and a lone reviewer_id, become pending rows. IDs were stored as
+// JSON numbers or, by some clients, strings.
+func legacyReviewerRows(row map[string]any) []models.ChangeRequestReviewer {
+	var out []models.ChangeRequestReviewer
+	seen := map[uint]bool{}
+	add := func(id uint, status string, decided *time.Time) {
+		if id == 0 || seen[id] {
+			return
+		}
+		seen[id] = true
+		switch status {
+		case "approved", "rejected":
+		default:
+			status, decided = "pending", nil
+		}
+		out = append(out, models.ChangeRequestReviewer{UserID: id, Status: status, DecidedAt: decided})
+	}
+	var states []map[string]any
+	if s, _ := row["reviewer_states"].(string); strings.TrimSpace(s) != "" {
+		_ = json.Unmarshal([]byte(s), &states)
+	}
+	for _, st := range states {
+		var decided *time.Time
+		if s, _ := st["decided_at"].(string); s != "" {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				decided = &t
+			}
+		}
+		status, _ := st["status"].(string)
+		add(legacyID(st["id"]), status, decided)
+	}
+	var ids []any
+	if s, _ := row["reviewers"].(string); strings.TrimSpace(s) != "" {
+		_ = json.Unmarshal([]byte(s), &ids)
+	}
+	for _, id := range ids {
+		add(legacyID(id), "pending", nil)
+	}
+	add(legacyID(row["reviewer_id"]), "pending", nil)
+	return out
+}
+
+// legacyID reads a user or change request ID stored as a number or numeric string.
+func legacyID(v any) uint {
+	switch n := v.(type) {
+	case int64:
+		return uint(n)
+	case int:
+		return uint(n)
+	case float64:
+		return uint(n)
+	case string:
+		u, _ := strconv.ParseUint(strings.TrimSpace(n), 10, 64)
+		return uint(u)
+	case []byte:
+		u, _ := strconv.ParseUint(strings.TrimSpace(string(n)), 10, 64)
+		return uint(u)
+	}
+	return 0
+}
+
+// reviewComment reads the optional { comment } body of an approve or reject.
+func reviewComment(c *gin.Context) string {
+	var body struct {
+		Comment string `json:"comment"`
+	}
+	_ = c.ShouldBindJSON(&body)
+	return strings.TrimSpace(body.Comment)
+}
+
+// approvalBody reads the optional body of an approval: { comment, acknowledge_conflicts }.
+func approvalBody(c *gin.Context) (string, bool) {
+	var body struct {
+		Comment              string `json:"comment"`
+		AcknowledgeConflicts bool   `json:"acknowledge_conflicts"`
+	}
+	_ = c.ShouldBindJSON(&body)
+	return strings.TrimSpace(body.Comment), body.AcknowledgeConflicts
+}
+
+// loadProjectChange resolves the change request of a /changes/:changeId route for a project
+// member.
+func loadProjectChange(c *gin.Context) (*gorm.DB, *models.ChangeRequest, bool) {
+	gdb := dbpkg.Get()
+	if gdb == nil {
+		if _, err := dbpkg.Init(); err != nil {
+			c.JSON(500, gin.H{"error": "db"})
+			return nil, nil, false
+		}
+		gdb = dbpkg.Get()
+	}
+	pid, _ := strconv.Atoi(c.Param("id"))
+	if !HasProjectRole(c, uint(pid), "owner", "contributor", "viewer") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return nil, nil, false
+	}
+	changeID, _ := strconv.Atoi(c.Param("changeId"))
+	var cr models.ChangeRequest
+	if err := gdb.Where("project_id = ?", pid).First(&cr, changeID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "not_found"})
+		return nil, nil, false
+	}
+	return gdb, &cr, true
+}
+
+// loadOpenChange is loadProjectChange for routes that need the request to be pending.
+func loadOpenChange(c *gin.Context) (*gorm.DB, *models.ChangeRequest, bool) {
+	gdb, cr, ok := loadProjectChange(c)
+	if !ok {
+		return nil, nil, false
+	}
+	if cr.Status != "pending" {
+		c.JSON(409, gin.H{"error": "not_pending"})
+		return nil, nil, false
+	}
+	return gdb, cr, true
+}
+
+// canManageReviewers reports whether the caller authored cr or owns its project.
+func canManageReviewers(c *gin.Context, cr *models.ChangeRequest) bool {
+	return contextUserID(c) == cr.UserID || HasProjectRole(c, cr.ProjectID, "owner")
+}
+
+// respondReviewers answers a reviewer change with the request, its reviewers and the policy
+// decision they now lead to.
+func respondReviewers(c *gin.Context, gdb *gorm.DB, cr *models.ChangeRequest) {
+	cr.Reviewers = changeReviewers(gdb, cr.ID)
+	p, _ := loadApprovalPolicy(gdb, cr.ProjectID, cr.DatasetID)
+	decision := evaluateApproval(p, cr.UserID, cr.Reviewers, projectMemberRoles(gdb, cr.ProjectID))
+	c.JSON(200, gin.H{"ok": true, "change_request": cr, "decision": decision})
+}
+
+// ChangeReviewersAdd assigns more reviewers to an open change request.
+// Body: { reviewer_ids: [..] }
+func ChangeReviewersAdd(c *gin.Context) {
+	gdb, cr, ok := loadOpenChange(c)
+	if !ok {
+		return
+	}
+	if !canManageReviewers(c, cr) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	var body struct {
+		ReviewerIDs []uint `json:"reviewer_ids"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"error": "invalid_payload"})
+		return
+	}
+	var ds models.Dataset
+	if err := gdb.First(&ds, cr.DatasetID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "dataset_not_found"})
+		return
+	}
+	ids, ok := requireReviewers(c, gdb, &ds, body.ReviewerIDs)
+	if !ok {
+		return
+	}
+	existing := changeReviewers(gdb, cr.ID)
+	assigned := map[uint]*models.ChangeRequestReviewer{}
+	for i := range existing {
+		assigned[existing[i].UserID] = &existing[i]
+	}
+	var added []uint
+	err := gdb.Transaction(func(tx *gorm.DB) error {
+		for _, id := range ids {
+			if r, ok := assigned[id]; ok {
+				if r.Status != "delegated" {
+					continue
+				}
+				// Taking a review back that had been delegated away
+				if err := tx.Model(r).Updates(map[string]any{"status": "pending", "decided_at": nil, "delegated_to": 0}).Error; err != nil {
+					return err
+				}
+			} else if err := tx.Create(&models.ChangeRequestReviewer{ChangeRequestID: cr.ID, UserID: id, Status: "pending"}).Error; err != nil {
+				return err
+			}
+			added = append(added, id)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db"})
+		return
+	}
+	syncPrimaryReviewer(gdb, cr)
+	_ = AddNotificationsBulk(added, "You were requested to review a change", models.JSONB{"type": "reviewer_assigned", "project_id": cr.ProjectID, "dataset_id": cr.DatasetID, "change_request_id": cr.ID, "title": cr.Title})
+	respondReviewers(c, gdb, cr)
+}
+
+// ChangeReviewerRemove unassigns a reviewer from an open change request. The remaining
+// reviewers must still satisfy the approval policy.
+func ChangeReviewerRemove(c *gin.Context) {
+	gdb, cr, ok := loadOpenChange(c)
+	if !ok {
+		return
+	}
+	if !canManageReviewers(c, cr) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	uid64, _ := strconv.ParseUint(c.Param("userId"), 10, 64)
+	uid := uint(uid64)
+	var remaining []uint
+	for _, id := range activeReviewerIDs(changeReviewers(gdb, cr.ID)) {
+		if id != uid {
+			remaining = append(remaining, id)
+		}
+	}
+	if !isActiveReviewer(gdb, cr.ID, uid) {
+		c.JSON(404, gin.H{"error": "reviewer_not_found"})
+		return
+	}
+	if len(remaining) == 0 {
+		c.JSON(400, gin.H{"error": "reviewer_required"})
+		return
+	}
+	var ds models.Dataset
+	if err := gdb.First(&ds, cr.DatasetID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "dataset_not_found"})
+		return
+	}
+	if _, required, ok := policyReviewers(gdb, &ds, cr.UserID, remaining); !ok || len(required) != len(remaining) {
+		c.JSON(400, gin.H{"error": "reviewer_required_by_policy"})
+		return
+	}
+	if err := gdb.Where("change_request_id = ? AND user_id = ?", cr.ID, uid).Delete(&models.ChangeRequestReviewer{}).Error; err != nil {
+		c.JSON(500, gin.H{"error": "db"})
+		return
+	}
+	syncPrimaryReviewer(gdb, cr)
+	respondReviewers(c, gdb, cr)
+}
+
+// ChangeReviewerDelegate lets a reviewer hand their pending review to another project member.
+// Body: { user_id }
+func ChangeReviewerDelegate(c *gin.Context) {
+	gdb, cr, ok := loadOpenChange(c)
+	if !ok {
+		return
+	}
+	uid := contextUserID(c)
+	var mine models.ChangeRequestReviewer
+	if err := gdb.Where("change_request_id = ? AND user_id = ? AND status = ?", cr.ID, uid, "pending").First(&mine).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	var body struct {
+		UserID uint `json:"user_id"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.UserID == 0 || body.UserID == uid {
+		c.JSON(400, gin.H{"error": "invalid_payload"})
+		return
+	}
+	var pr models.ProjectRole
+	if err := gdb.Where("project_id = ? AND user_id = ?", cr.ProjectID, body.UserID).First(&pr).Error; err != nil {
+		c.JSON(400, gin.H{"error": "reviewer_not_member"})
+		return
+	}
+	if isActiveReviewer(gdb, cr.ID, body.UserID) {
+		c.JSON(409, gin.H{"error": "already_reviewer"})
+		return
+	}
+	if p, _ := loadApprovalPolicy(gdb, cr.ProjectID, cr.DatasetID); p.ForbidSelfApproval && body.UserID == cr.UserID {
+		c.JSON(400, gin.H{"error": "author_cannot_approve"})
+		return
+	}
+	err := gdb.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&mine).Updates(map[string]any{"status": "delegated", "decided_at": now, "delegated_to": body.UserID}).Error; err != nil {
+			return err
+		}
+		// The delegate may have delegated this review away earlier
+		res := tx.Model(&models.ChangeRequestReviewer{}).Where("change_request_id = ? AND user_id = ?", cr.ID, body.UserID).
+			Updates(map[string]any{"status": "pending", "decided_at": nil, "delegated_from": uid, "delegated_to": 0})
+		if res.Error != nil || res.RowsAffected > 0 {
+			return res.Error
+		}
+		return tx.Create(&models.ChangeRequestReviewer{ChangeRequestID: cr.ID, UserID: body.UserID, Status: "pending", DelegatedFrom: uid}).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db"})
+		return
+	}
+	syncPrimaryReviewer(gdb, cr)
+	_ = AddNotification(body.UserID, "A review was delegated to you", models.JSONB{"type": "reviewer_assigned", "project_id": cr.ProjectID, "dataset_id": cr.DatasetID, "change_request_id": cr.ID, "title": cr.Title, "delegated_from": uid})
+	respondReviewers(c, gdb, cr)
+}
+
+// ChangeReRequestReview resets every decided review of an open change request to pending and
+// notifies the reviewers. With an upload_id it submits that upload as a new revision instead,
+// which resets the reviews as well (see submitRevision). Body: { upload_id? }
+func ChangeReRequestReview(c *gin.Context) {
+	gdb, cr, ok := loadOpenChange(c)
+	if !ok {
+		return
+	}
+	if contextUserID(c) != cr.UserID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	var body struct {
+		UploadID uint `json:"upload_id"`
+	}
+	_ = c.ShouldBindJSON(&body)
+	if body.UploadID != 0 {
+		if _, ok := submitRevision(c, gdb, cr, body.UploadID, ""); ok {
+			respondReviewers(c, gdb, cr)
+		}
+		return
+	}
+	res := gdb.Model(&models.ChangeRequestReviewer{}).Where("change_request_id = ? AND status IN ?", cr.ID, []string{"approved", "rejected"}).
+		Updates(map[string]any{"status": "pending", "decided_at": nil})
+	if res.Error != nil {
+		c.JSON(500, gin.H{"error": "db"})
+		return
+	}
+	ids := activeReviewerIDs(changeReviewers(gdb, cr.ID))
+	_ = AddNotificationsBulk(ids, "Your review was requested again", models.JSONB{"type": "review_rerequested", "project_id": cr.ProjectID, "dataset_id": cr.DatasetID, "change_request_id": cr.ID, "title": cr.Title})
+	crID := cr.ID
+	_ = RecordAuditEvent(cr.ProjectID, cr.DatasetID, cr.UserID, models.AuditEventTypeCRUpdated,
+		fmt.Sprintf("Change Request #%d review re-requested", cr.ID),
+		fmt.Sprintf("%d reviews reset to pending", res.RowsAffected),
+		&crID,
+		models.AuditEventSummary{},
+		nil,
+	)
+	respondReviewers(c, gdb, cr)
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+    "fmt"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    sqlite "github.com/glebarez/sqlite"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+    "gorm.io/gorm"
+)
+
+func TestMigrateChangeRequestReviewers(t *testing.T) {
+    gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+    if err != nil {
+        t.Fatalf("open sqlite: %v", err)
+    }
+    sqlDB, _ := gdb.DB()
+    sqlDB.SetMaxOpenConns(1)
+    if err := gdb.AutoMigrate(&models.ChangeRequest{}, &models.ChangeRequestReviewer{}); err != nil {
+        t.Fatalf("migrate: %v", err)
+    }
+    // The JSON columns of the old schema, with the ID encodings clients used
+    gdb.Exec("ALTER TABLE change_requests ADD COLUMN reviewers text")
+    gdb.Exec("ALTER TABLE change_requests ADD COLUMN reviewer_states text")
+    gdb.Exec(`INSERT INTO change_requests (id, reviewer_id, reviewers, reviewer_states, status) VALUES
+        (1, 2, '[2,3]', '[{"id":2,"status":"approved","decided_at":"2025-01-02T03:04:05Z"},{"id":"3","status":"pending","decided_at":null}]', 'pending'),
+        (2, 4, '', '', 'completed'),
+        (3, 0, '[5]', NULL, 'pending'),
+        (4, 6, '[6]', NULL, 'pending')`)
+    // Reviewers already in the new table are kept as they are
+    gdb.Create(&models.ChangeRequestReviewer{ChangeRequestID: 4, UserID: 7, Status: "approved"})
+
+    if err := MigrateChangeRequestReviewers(gdb); err != nil {
+        t.Fatalf("migrate reviewers: %v", err)
+    }
+    got := map[uint][]string{}
+    for _, r := range []uint{1, 2, 3, 4} {
+        for _, row := range changeReviewers(gdb, r) {
+            got[r] = append(got[r], fmt.Sprintf("%d:%s:%v", row.UserID, row.Status, row.DecidedAt != nil))
+        }
+    }
+    want := map[uint][]string{1: {"2:approved:true", "3:pending:false"}, 2: {"4:pending:false"}, 3: {"5:pending:false"}, 4: {"7:approved:false"}}
+    if fmt.Sprint(got) != fmt.Sprint(want) {
+        t.Fatalf("reviewers %v, want %v", got, want)
+    }
+    var kept string
+    gdb.Raw("SELECT legacy_reviewers FROM change_requests WHERE id = 1").Scan(&kept)
+    if kept != "[2,3]" || gdb.Exec("SELECT reviewers FROM change_requests").Error == nil {
+        t.Fatalf("legacy columns not renamed: %q", kept)
+    }
+    // Once converted, later starts leave the table alone
+    gdb.Where("change_request_id = ?", 3).Delete(&models.ChangeRequestReviewer{})
+    if err := MigrateChangeRequestReviewers(gdb); err != nil {
+        t.Fatalf("second run: %v", err)
+    }
+    if n := len(changeReviewers(gdb, 3)); n != 0 {
+        t.Fatalf("second run recreated %d reviewers", n)
+    }
+}
+
+func TestChangeReviewers_Manage(t *testing.T) {
+    gdb, r := rowChangeEnv(t)
+    gdb.Create(&models.ProjectRole{ProjectID: 1, UserID: 3, Role: "contributor"})
+    gdb.Create(&models.ProjectRole{ProjectID: 1, UserID: 4, Role: "viewer"})
+    ds := models.Dataset{ID: 5, ProjectID: 1, Name: "people", Schema: peopleSchema}
+    gdb.Create(&ds)
+    if err := ensureDatasetTable(gdb, &ds); err != nil {
+        t.Fatalf("ensure table: %v", err)
+    }
+    for _, row := range []string{`{"id":1,"name":"ann","age":31}`, `{"id":2,"name":"bob","age":42}`} {
+        gdb.Exec("INSERT INTO ds_5 (data) VALUES (?)", row)
+    }
+    code, resp := doJSON(t, r, "POST", "/projects/1/datasets/5/changes/delete", 2, gin.H{"where": []gin.H{{"column": "name", "op": "eq", "value": "bob"}}, "reviewer_ids": []uint{1}})
+    if code != 201 {
+        t.Fatalf("create: %d %v", code, resp)
+    }
+    id := changeID(t, resp)
+    path := func(suffix string) string { return fmt.Sprintf("/projects/1/changes/%d%s", id, suffix) }
+    states := func() map[float64]map[string]any {
+        _, resp := doJSON(t, r, "GET", path(""), 2, nil)
+        out := map[float64]map[string]any{}
+        for _, s := range resp["reviewer_states"].([]any) {
+            st := s.(map[string]any)
+            out[st["id"].(float64)] = st
+        }
+        return out
+    }
+
+    if code, _ := doJSON(t, r, "POST", path("/reviewers"), 3, gin.H{"reviewer_ids": []uint{3}}); code != 403 {
+        t.Fatalf("non-author add: %d", code)
+    }
+    if code, resp := doJSON(t, r, "POST", path("/reviewers"), 2, gin.H{"reviewer_ids": []uint{3}}); code != 200 || len(resp["change_request"].(map[string]any)["reviewers"].([]any)) != 2 {
+        t.Fatalf("add reviewer: %d %v", code, resp)
+    }
+    if code, resp := doJSON(t, r, "POST", path("/approve"), 1, nil); code != 200 || resp["decision"].(map[string]any)["outcome"] != "pending" {
+        t.Fatalf("first approval: %d %v", code, resp)
+    }
+
+    // Delegation hands the review over; the delegator can no longer decide
+    if code, resp := doJSON(t, r, "POST", path("/delegate"), 3, gin.H{"user_id": 1}); code != 409 || resp["error"] != "already_reviewer" {
+        t.Fatalf("delegate to reviewer: %d %v", code, resp)
+    }
+    if code, resp := doJSON(t, r, "POST", path("/delegate"), 3, gin.H{"user_id": 4}); code != 200 {
+        t.Fatalf("delegate: %d %v", code, resp)
+    }
+    st := states()
+    if st[3]["status"] != "delegated" || st[3]["delegated_to"] != float64(4) || st[4]["status"] != "pending" || st[4]["delegated_from"] != float64(3) {
+        t.Fatalf("after delegation: %v", st)
+    }
+    if code, _ := doJSON(t, r, "POST", path("/approve"), 3, nil); code != 403 {
+        t.Fatalf("delegator approve: %d", code)
+    }
+
+    // Re-requesting review resets decisions
+    if code, _ := doJSON(t, r, "POST", path("/rerequest-review"), 1, nil); code != 403 {
+        t.Fatalf("non-author re-request: %d", code)
+    }
+    if code, resp := doJSON(t, r, "POST", path("/rerequest-review"), 2, nil); code != 200 {
+        t.Fatalf("re-request: %d %v", code, resp)
+    }
+    if st := states(); st[1]["status"] != "pending" || st[1]["decided_at"] != nil {
+        t.Fatalf("after re-request: %v", st[1])
+    }
+
+    if code, resp := doJSON(t, r, "DELETE", path("/reviewers/9"), 2, nil); code != 404 || resp["error"] != "reviewer_not_found" {
+        t.Fatalf("remove unknown: %d %v", code, resp)
+    }
+    if code, resp := doJSON(t, r, "DELETE", path("/reviewers/4"), 2, nil); code != 200 {
+        t.Fatalf("remove: %d %v", code, resp)
+    }
+    if code, resp := doJSON(t, r, "DELETE", path("/reviewers/1"), 2, nil); code != 400 || resp["error"] != "reviewer_required" {
+        t.Fatalf("remove last: %d %v", code, resp)
+    }
+    if code, resp := doJSON(t, r, "POST", path("/approve"), 1, gin.H{"comment": "looks right"}); code != 200 {
+        t.Fatalf("approve: %d %v", code, resp)
+    }
+    var cr models.ChangeRequest
+    gdb.First(&cr, id)
+    if cr.Status != "completed" || cr.ReviewerID != 1 {
+        t.Fatalf("change %q reviewer %d", cr.Status, cr.ReviewerID)
+    }
+    if st := states(); st[1]["comment"] != "looks right" {
+        t.Fatalf("comment: %v", st[1])
+    }
+    if code, _ := doJSON(t, r, "POST", path("/reviewers"), 2, gin.H{"reviewer_ids": []uint{3}}); code != 409 {
+        t.Fatalf("add to closed request: %d", code)
+    }
+}
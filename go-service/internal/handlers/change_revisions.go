@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"gorm.io/gorm"
+)
+
+// A reviewer can ask for changes instead of approving or rejecting. The request then waits in
+// "changes_requested" until its author submits a new revision of the upload, which is validated,
+// re-staged and sent back to every reviewer. Each revision keeps its upload and validation
+// results so ChangeGet can show what changed between them.
+
+// maxRevisionDiffRows bounds the added and removed rows listed in a revision diff.
+const maxRevisionDiffRows = 50
+
+// ChangeRequestChanges records a "changes requested" review and hands the request back to its
+// author. Body: { comment }
+func ChangeRequestChanges(c *gin.Context) {
+	gdb, cr, ok := loadOpenChange(c)
+	if !ok {
+		return
+	}
+	uid := contextUserID(c)
+	if !isActiveReviewer(gdb, cr.ID, uid) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	comment := reviewComment(c)
+	if comment == "" {
+		c.JSON(400, gin.H{"error": "comment_required"})
+		return
+	}
+	err := gdb.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.ChangeRequest{}).Where("id = ? AND status = ?", cr.ID, "pending").Update("status", "changes_requested")
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errNotPending
+		}
+		return tx.Model(&models.ChangeRequestReviewer{}).Where("change_request_id = ? AND user_id = ?", cr.ID, uid).
+			Updates(map[string]any{"status": "changes_requested", "decided_at": time.Now(), "comment": comment}).Error
+	})
+	if err == errNotPending {
+		c.JSON(409, gin.H{"error": "not_pending"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "db"})
+		return
+	}
+	cr.Status = "changes_requested"
+	cr.Reviewers = changeReviewers(gdb, cr.ID)
+	_ = AddNotification(cr.UserID, "Changes were requested on your change", models.JSONB{"type": "changes_requested", "project_id": cr.ProjectID, "dataset_id": cr.DatasetID, "change_request_id": cr.ID, "title": cr.Title})
+	crID := cr.ID
+	_ = RecordAuditEventWithMetadata(cr.ProjectID, cr.DatasetID, uid, models.AuditEventTypeCRChangesReq,
+		fmt.Sprintf("Change Request #%d: changes requested", cr.ID),
+		comment,
+		&crID,
+		models.AuditEventSummary{},
+		models.JSONB{"revision": cr.Revision},
+	)
+	c.JSON(200, gin.H{"ok": true, "change_request": cr})
+}
+
+// ChangeRevisionCreate submits a new revision of an append change request's data: an upload of
+// the same dataset, e.g. one returned by the validate endpoints. Body: { upload_id, note? }
+func ChangeRevisionCreate(c *gin.Context) {
+	gdb, cr, ok := loadProjectChange(c)
+	if !ok {
+		return
+	}
+	if cr.Status != "pending" && cr.Status != "changes_requested" {
+		c.JSON(409, gin.H{"error": "not_pending"})
+		return
+	}
+	if contextUserID(c) != cr.UserID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	var body struct {
+		UploadID uint   `json:"upload_id"`
+		Note     string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.UploadID == 0 {
+		c.JSON(400, gin.H{"error": "invalid_payload"})
+		return
+	}
+	rev, ok := submitRevision(c, gdb, cr, body.UploadID, strings.TrimSpace(body.Note))
+	if !ok {
+		return
+	}
+	c.JSON(201, gin.H{"ok": true, "change_request": cr, "revision": rev})
+}
+
+// ChangeRevisionsList lists the revisions of a change request, oldest first.
+func ChangeRevisionsList(c *gin.Context) {
+	gdb, cr, ok := loadProjectChange(c)
+	if !ok {
+		return
+	}
+	c.JSON(200, gin.H{"revisions": changeRevisions(gdb, cr.ID)})
+}
+
+// changeRevisions loads the recorded revisions of a change request, oldest first.
+func changeRevisions(gdb *gorm.DB, crID uint) []models.ChangeRequestRevision {
+	var revs []models.ChangeRequestRevision
+	_ = gdb.Where("change_request_id = ?", crID).Order("revision").Find(&revs).Error
+	return revs
+}
+
+// submitRevision makes upload uploadID the next revision of cr: the rows are validated, the
+// original upload is recorded as revision 1 if this is the first resubmission, the request
+// points at the new upload with a fresh staging table, and every review goes back to pending.
+// It responds itself when the revision is refused.
+func submitRevision(c *gin.Context, gdb *gorm.DB, cr *models.ChangeRequest, uploadID uint, note string) (*models.ChangeRequestRevision, bool) {
+	if cr.Type != "append" {
+		c.JSON(400, gin.H{"error": "revision_not_supported"})
+		return nil, false
+	}
+	var ds models.Dataset
+	if err := gdb.First(&ds, cr.DatasetID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "dataset_not_found"})
+		return nil, false
+	}
+	var up models.DatasetUpload
+	if err := gdb.Where("project_id = ? AND dataset_id = ?", cr.ProjectID, cr.DatasetID).First(&up, uploadID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "upload_not_found"})
+		return nil, false
+	}
+	rows, err := recordsFromUpload(up.Content, up.Filename)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid_upload", "message": err.Error()})
+		return nil, false
+	}
+	valid, results := validateRows(&ds, rows)
+	if !valid {
+		c.JSON(200, gin.H{"ok": false, "validation": results})
+		return nil, false
+	}
+	uid := contextUserID(c)
+	rev := models.ChangeRequestRevision{ChangeRequestID: cr.ID, Revision: cr.Revision + 1, UploadID: up.ID, Filename: up.Filename,
+		RowCount: len(rows), Valid: true, Validation: models.JSONB(results), Note: note, CreatedBy: uid}
+	pb, _ := json.Marshal(map[string]any{"upload_id": up.ID, "filename": up.Filename})
+	// The revision was validated against the current table, which becomes its base
+	stampChangeBase(gdb, &ds, cr)
+	updates := baseUpdates(cr)
+	updates["payload"], updates["revision"], updates["status"] = string(pb), rev.Revision, "pending"
+	err = gdb.Transaction(func(tx *gorm.DB) error {
+		if err := recordOriginalRevision(tx, &ds, cr); err != nil {
+			return err
+		}
+		if err := tx.Create(&rev).Error; err != nil {
+			return err
+		}
+		res := tx.Model(&models.ChangeRequest{}).Where("id = ? AND status IN ? AND revision = ?", cr.ID, []string{"pending", "changes_requested"}, cr.Revision).
+			Updates(updates)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errNotPending
+		}
+		if err := tx.Model(&models.ChangeRequestReviewer{}).Where("change_request_id = ? AND status IN ?", cr.ID, []string{"approved", "rejected", "changes_requested"}).
+			Updates(map[string]any{"status": "pending", "decided_at": nil}).Error; err != nil {
+			return err
+		}
+		if isDeltaBackend(&ds) {
+			return nil
+		}
+		// Re-stage the new rows for JSONB datasets
+		stg := dsStagingTable(ds.ID, cr.ID)
+		if err := tx.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", stg)).Error; err != nil {
+			return err
+		}
+		if err := ensureStagingTable(tx, ds.ID, cr.ID); err != nil {
+			return err
+		}
+		return ingestBytesToTable(tx, up.Content, up.Filename, stg)
+	})
+	if err == errNotPending {
+		c.JSON(409, gin.H{"error": "not_pending"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "revision_failed", "message": err.Error()})
+		return nil, false
+	}
+	cr.Payload, cr.Revision, cr.Status = string(pb), rev.Revision, "pending"
+	cr.Reviewers = changeReviewers(gdb, cr.ID)
+	_ = AddNotificationsBulk(activeReviewerIDs(cr.Reviewers), "A change you review has a new revision", models.JSONB{"type": "review_rerequested", "project_id": cr.ProjectID, "dataset_id": cr.DatasetID, "change_request_id": cr.ID, "title": cr.Title, "revision": rev.Revision})
+	crID := cr.ID
+	_ = RecordAuditEventWithMetadata(cr.ProjectID, cr.DatasetID, uid, models.AuditEventTypeCRUpdated,
+		fmt.Sprintf("Change Request #%d revision %d", cr.ID, rev.Revision),
+		fmt.Sprintf("%d rows to append", rev.RowCount),
+		&crID,
+		models.AuditEventSummary{RowsAdded: rev.RowCount},
+		models.JSONB{"revision": rev.Revision, "upload_id": up.ID},
+	)
+	return &rev, true
+}
+
+// recordOriginalRevision records the upload a change request was opened with as revision 1,
+// once, when the first new revision is submitted.
+func recordOriginalRevision(tx *gorm.DB, ds *models.Dataset, cr *models.ChangeRequest) error {
+	var n int64
+	if err := tx.Model(&models.ChangeRequestRevision{}).Where("change_request_id = ?", cr.ID).Count(&n).Error; err != nil || n > 0 {
+		return err
+	}
+	var payload struct {
+		UploadID uint   `json:"upload_id"`
+		Filename string `json:"filename"`
+	}
+	_ = json.Unmarshal([]byte(cr.Payload), &payload)
+	rev := models.ChangeRequestRevision{ChangeRequestID: cr.ID, Revision: cr.Revision, UploadID: payload.UploadID, Filename: payload.Filename, CreatedBy: cr.UserID, CreatedAt: cr.CreatedAt}
+	var up models.DatasetUpload
+	if payload.UploadID != 0 && tx.First(&up, payload.UploadID).Error == nil {
+		if rows, err := recordsFromUpload(up.Content, up.Filename); err == nil {
+			valid, results := validateRows(ds, rows)
+			rev.RowCount, rev.Valid, rev.Validation = len(rows), valid, models.JSONB(results)
+		}
+	}
+	return tx.Create(&rev).Error
+}
+
+// revisionDiff compares the rows of two revisions as multisets: rows only in to are added,
+// rows only in from are removed.
+func revisionDiff(gdb *gorm.DB, from, to models.ChangeRequestRevision) (gin.H, error) {
+	load := func(rev models.ChangeRequestRevision) ([]map[string]any, error) {
+		var up models.DatasetUpload
+		if err := gdb.First(&up, rev.UploadID).Error; err != nil {
+			return nil, fmt.Errorf("revision %d: upload %d not found", rev.Revision, rev.UploadID)
+		}
+		return recordsFromUpload(up.Content, up.Filename)
+	}
+	before, err := load(from)
+	if err != nil {
+		return nil, err
+	}
+	after, err := load(to)
+	if err != nil {
+		return nil, err
+	}
+	key := func(row map[string]any) string {
+		b, _ := json.Marshal(row)
+		return string(b)
+	}
+	remaining := map[string]int{}
+	for _, row := range before {
+		remaining[key(row)]++
+	}
+	added := []map[string]any{}
+	addedN, unchanged := 0, 0
+	for _, row := range after {
+		k := key(row)
+		if remaining[k] > 0 {
+			remaining[k]--
+			unchanged++
+			continue
+		}
+		addedN++
+		if len(added) < maxRevisionDiffRows {
+			added = append(added, row)
+		}
+	}
+	removed := []map[string]any{}
+	removedN := 0
+	for _, row := range before {
+		k := key(row)
+		if remaining[k] > 0 {
+			remaining[k]--
+			removedN++
+			if len(removed) < maxRevisionDiffRows {
+				removed = append(removed, row)
+			}
+		}
+	}
+	return gin.H{"from": from.Revision, "to": to.Revision, "added": addedN, "removed": removedN, "unchanged": unchanged,
+		"added_rows": added, "removed_rows": removed}, nil
+}
+
+// changeRevisionDiff picks the revisions ChangeGet compares: diff_from and diff_to when given,
+// else the last two. It returns nil when the request has fewer than two revisions.
+func changeRevisionDiff(c *gin.Context, gdb *gorm.DB, revs []models.ChangeRequestRevision) gin.H {
+	if len(revs) < 2 {
+		return nil
+	}
+	byNum := make(map[int]models.ChangeRequestRevision, len(revs))
+	for _, r := range revs {
+		byNum[r.Revision] = r
+	}
+	from, to := revs[len(revs)-2], revs[len(revs)-1]
+	if n, err := strconv.Atoi(c.Query("diff_from")); err == nil {
+		if r, ok := byNum[n]; ok {
+			from = r
+		}
+	}
+	if n, err := strconv.Atoi(c.Query("diff_to")); err == nil {
+		if r, ok := byNum[n]; ok {
+			to = r
+		}
+	}
+	diff, err := revisionDiff(gdb, from, to)
+	if err != nil {
+		return gin.H{"from": from.Revision, "to": to.Revision, "error": err.Error()}
+	}
+	return diff
+}
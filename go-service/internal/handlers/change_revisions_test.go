@@ -0,0 +1,95 @@
+package handlers
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/config"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+)
+
+func TestChangeRevisions_RequestChangesAndRevise(t *testing.T) {
+    gdb, r := rowChangeEnv(t)
+    // Validation fails for rows with a non-numeric age
+    py := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        b, _ := io.ReadAll(req.Body)
+        fmt.Fprintf(w, `{"valid": %v}`, !bytes.Contains(b, []byte(`"age":"x"`)))
+    }))
+    defer py.Close()
+    t.Setenv("PYTHON_SERVICE_URL", py.URL)
+    if _, err := config.Load(); err != nil {
+        t.Fatalf("config: %v", err)
+    }
+    r.POST("/projects/:id/datasets/:datasetId/append/open", AppendOpen)
+    r.POST("/projects/:id/changes/:changeId/request-changes", ChangeRequestChanges)
+    r.POST("/projects/:id/changes/:changeId/revisions", ChangeRevisionCreate)
+
+    ds := models.Dataset{ID: 5, ProjectID: 1, Name: "people", Schema: peopleSchema}
+    gdb.Create(&ds)
+    upload := func(content string) uint {
+        up := models.DatasetUpload{ProjectID: 1, DatasetID: 5, Filename: "rows.json", Content: []byte(content)}
+        gdb.Create(&up)
+        return up.ID
+    }
+    first := upload(`[{"id":1,"name":"ann","age":31},{"id":2,"name":"bob","age":42}]`)
+    invalid := upload(`[{"id":1,"name":"ann","age":"x"}]`)
+    second := upload(`[{"id":1,"name":"ann","age":31},{"id":3,"name":"cy","age":7}]`)
+
+    code, resp := doJSON(t, r, "POST", "/projects/1/datasets/5/append/open", 1, gin.H{"upload_id": first, "reviewer_ids": []uint{2}})
+    if code != 201 {
+        t.Fatalf("open: %d %v", code, resp)
+    }
+    id := changeID(t, resp)
+    path := func(suffix string) string { return fmt.Sprintf("/projects/1/changes/%d%s", id, suffix) }
+
+    if code, resp := doJSON(t, r, "POST", path("/request-changes"), 2, nil); code != 400 || resp["error"] != "comment_required" {
+        t.Fatalf("no comment: %d %v", code, resp)
+    }
+    if code, resp := doJSON(t, r, "POST", path("/request-changes"), 2, gin.H{"comment": "drop bob"}); code != 200 || resp["change_request"].(map[string]any)["status"] != "changes_requested" {
+        t.Fatalf("request changes: %d %v", code, resp)
+    }
+    if code, resp := doJSON(t, r, "POST", path("/approve"), 2, nil); code != 409 || resp["error"] != "changes_requested" {
+        t.Fatalf("approve while changes requested: %d %v", code, resp)
+    }
+
+    if code, _ := doJSON(t, r, "POST", path("/revisions"), 2, gin.H{"upload_id": second}); code != 403 {
+        t.Fatalf("reviewer revises: %d", code)
+    }
+    if code, resp := doJSON(t, r, "POST", path("/revisions"), 1, gin.H{"upload_id": invalid}); code != 200 || resp["ok"] != false {
+        t.Fatalf("invalid revision: %d %v", code, resp)
+    }
+    code, resp = doJSON(t, r, "POST", path("/revisions"), 1, gin.H{"upload_id": second, "note": "without bob"})
+    if code != 201 || resp["revision"].(map[string]any)["revision"] != float64(2) {
+        t.Fatalf("revise: %d %v", code, resp)
+    }
+    if cr := resp["change_request"].(map[string]any); cr["status"] != "pending" || cr["revision"] != float64(2) {
+        t.Fatalf("revised request: %v", cr)
+    }
+
+    _, resp = doJSON(t, r, "GET", path(""), 1, nil)
+    revs := resp["revisions"].([]any)
+    if len(revs) != 2 || revs[0].(map[string]any)["upload_id"] != float64(first) || revs[0].(map[string]any)["valid"] != true {
+        t.Fatalf("revisions: %v", revs)
+    }
+    diff := resp["revision_diff"].(map[string]any)
+    if diff["added"] != float64(1) || diff["removed"] != float64(1) || diff["unchanged"] != float64(1) {
+        t.Fatalf("diff: %v", diff)
+    }
+    if st := resp["reviewer_states"].([]any)[0].(map[string]any); st["status"] != "pending" {
+        t.Fatalf("review not reset: %v", st)
+    }
+
+    if code, resp := doJSON(t, r, "POST", path("/approve"), 2, nil); code != 200 {
+        t.Fatalf("approve: %d %v", code, resp)
+    }
+    var names []string
+    gdb.Raw("SELECT json_extract(data, '$.name') FROM ds_5 ORDER BY id").Scan(&names)
+    if fmt.Sprint(names) != "[ann cy]" {
+        t.Fatalf("applied rows: %v", names)
+    }
+}
@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"gorm.io/gorm"
+)
+
+// A change request may carry a review deadline and, for appends, a merge time. The worker runs
+// SweepChangeRequests every minute: it reminds whoever the request waits on ahead of the
+// deadline, expires overdue requests or escalates them to the project owners, and applies
+// approved appends whose merge window has come. Approving an append before its merge time moves
+// it to "scheduled" instead of applying it.
+
+// reminderLead is how long before its deadline a change request's reminder goes out.
+const reminderLead = 24 * time.Hour
+
+// openChangeStatuses are the states in which a change request waits on a decision.
+var openChangeStatuses = []string{"pending", "changes_requested"}
+
+// ChangeScheduleSet sets or clears the deadline and merge time of a change request. Only its
+// author or a project owner may. A null clears a time; omitted fields are left as they are.
+// Body: { due_at?: RFC3339|null, on_overdue?: "expire"|"escalate", merge_at?: RFC3339|null }
+func ChangeScheduleSet(c *gin.Context) {
+	gdb, cr, ok := loadProjectChange(c)
+	if !ok {
+		return
+	}
+	if !canManageReviewers(c, cr) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	if cr.Status != "pending" && cr.Status != "changes_requested" && cr.Status != "scheduled" {
+		c.JSON(409, gin.H{"error": "not_pending"})
+		return
+	}
+	var body map[string]json.RawMessage
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"error": "invalid_payload"})
+		return
+	}
+	updates := map[string]any{}
+	if raw, ok := body["due_at"]; ok {
+		// A scheduled request is already decided
+		if cr.Status == "scheduled" {
+			c.JSON(409, gin.H{"error": "already_approved"})
+			return
+		}
+		due, err := scheduleTime(raw)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid_due_at"})
+			return
+		}
+		if due != nil && !due.After(time.Now()) {
+			c.JSON(400, gin.H{"error": "due_at_in_past"})
+			return
+		}
+		// A new deadline gets its own reminder and escalation
+		updates["due_at"], updates["reminder_sent_at"], updates["escalated_at"] = due, nil, nil
+	}
+	if raw, ok := body["on_overdue"]; ok {
+		var action string
+		if err := json.Unmarshal(raw, &action); err != nil || (action != "" && action != "expire" && action != "escalate") {
+			c.JSON(400, gin.H{"error": "invalid_on_overdue"})
+			return
+		}
+		updates["on_overdue"] = action
+	}
+	if raw, ok := body["merge_at"]; ok {
+		if cr.Type != "append" {
+			c.JSON(400, gin.H{"error": "merge_at_append_only"})
+			return
+		}
+		mergeAt, err := scheduleTime(raw)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid_merge_at"})
+			return
+		}
+		// The sweep finds scheduled requests by their merge time
+		if mergeAt == nil && cr.Status == "scheduled" {
+			c.JSON(400, gin.H{"error": "merge_at_required"})
+			return
+		}
+		updates["merge_at"] = mergeAt
+	}
+	if len(updates) == 0 {
+		c.JSON(400, gin.H{"error": "nothing_to_update"})
+		return
+	}
+	res := gdb.Model(&models.ChangeRequest{}).Where("id = ? AND status = ?", cr.ID, cr.Status).Updates(updates)
+	if res.Error != nil {
+		c.JSON(500, gin.H{"error": "db"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(409, gin.H{"error": "not_pending"})
+		return
+	}
+	if err := gdb.First(cr, cr.ID).Error; err != nil {
+		c.JSON(500, gin.H{"error": "db"})
+		return
+	}
+	crID := cr.ID
+	_ = RecordAuditEventWithMetadata(cr.ProjectID, cr.DatasetID, contextUserID(c), models.AuditEventTypeCRUpdated,
+		fmt.Sprintf("Change Request #%d schedule updated", cr.ID),
+		"Deadline or merge time changed",
+		&crID,
+		models.AuditEventSummary{},
+		models.JSONB{"due_at": cr.DueAt, "on_overdue": cr.OnOverdue, "merge_at": cr.MergeAt},
+	)
+	c.JSON(200, gin.H{"ok": true, "change_request": cr})
+}
+
+// scheduleTime parses an RFC3339 time of a schedule body; null clears the time.
+func scheduleTime(raw json.RawMessage) (*time.Time, error) {
+	if string(raw) == "null" {
+		return nil, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// scheduleMerge moves an approved append whose merge time is still ahead to "scheduled"; the
+// sweep applies it once the time has come.
+func scheduleMerge(c *gin.Context, gdb *gorm.DB, cr *models.ChangeRequest, actingUID uint) {
+	res := gdb.Model(&models.ChangeRequest{}).Where("id = ? AND status = ?", cr.ID, "pending").Update("status", "scheduled")
+	if res.Error != nil {
+		c.JSON(500, gin.H{"error": "db"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		if err := gdb.First(cr, cr.ID).Error; err != nil {
+			c.JSON(404, gin.H{"error": "not_found"})
+			return
+		}
+		if respondNotPending(c, gdb, cr) {
+			c.JSON(409, gin.H{"error": "apply_in_progress"})
+		}
+		return
+	}
+	cr.Status = "scheduled"
+	mergeAt := cr.MergeAt.Format(time.RFC3339)
+	_ = AddNotification(cr.UserID, "Your append request was approved and will be applied at "+mergeAt, models.JSONB{"type": "append_scheduled", "project_id": cr.ProjectID, "dataset_id": cr.DatasetID, "change_request_id": cr.ID, "merge_at": mergeAt})
+	crID := cr.ID
+	_ = RecordAuditEvent(cr.ProjectID, cr.DatasetID, actingUID, models.AuditEventTypeCRScheduled,
+		fmt.Sprintf("Change Request #%d scheduled", cr.ID),
+		"Approved; merging at "+mergeAt,
+		&crID,
+		models.AuditEventSummary{},
+		nil,
+	)
+	c.JSON(200, gin.H{"ok": true, "change_request": cr, "scheduled": true})
+}
+
+// SweepChangeRequests sends deadline reminders, expires or escalates overdue change requests and
+// applies scheduled appends that are due. The worker runs it periodically; each step claims its
+// request with a conditional update, so concurrent sweeps do not repeat one another.
+func SweepChangeRequests(gdb *gorm.DB, now time.Time) {
+	remindDueChanges(gdb, now)
+	sweepOverdueChanges(gdb, now)
+	mergeScheduledChanges(gdb, now)
+}
+
+func remindDueChanges(gdb *gorm.DB, now time.Time) {
+	var due []models.ChangeRequest
+	err := gdb.Where("status IN ? AND due_at > ? AND due_at <= ? AND reminder_sent_at IS NULL", openChangeStatuses, now, now.Add(reminderLead)).
+		Find(&due).Error
+	if err != nil {
+		log.Printf("[SweepChangeRequests] reminders: %v", err)
+		return
+	}
+	for i := range due {
+		cr := &due[i]
+		res := gdb.Model(&models.ChangeRequest{}).Where("id = ? AND reminder_sent_at IS NULL", cr.ID).Update("reminder_sent_at", now)
+		if res.Error != nil || res.RowsAffected == 0 {
+			continue
+		}
+		// A pending request waits on its undecided reviewers, one with changes requested on its author
+		to := []uint{cr.UserID}
+		if cr.Status == "pending" {
+			to = to[:0]
+			for _, r := range changeReviewers(gdb, cr.ID) {
+				if r.Status == "pending" {
+					to = append(to, r.UserID)
+				}
+			}
+		}
+		_ = AddNotificationsBulk(to, fmt.Sprintf("Change request #%d is due by %s", cr.ID, cr.DueAt.Format(time.RFC3339)),
+			models.JSONB{"type": "change_request_due", "project_id": cr.ProjectID, "dataset_id": cr.DatasetID, "change_request_id": cr.ID, "title": cr.Title, "due_at": cr.DueAt})
+	}
+}
+
+func sweepOverdueChanges(gdb *gorm.DB, now time.Time) {
+	var overdue []models.ChangeRequest
+	if err := gdb.Where("status IN ? AND due_at <= ? AND escalated_at IS NULL", openChangeStatuses, now).Find(&overdue).Error; err != nil {
+		log.Printf("[SweepChangeRequests] overdue: %v", err)
+		return
+	}
+	for i := range overdue {
+		if overdue[i].OnOverdue == "escalate" {
+			escalateChange(gdb, &overdue[i], now)
+		} else {
+			expireChange(gdb, &overdue[i], now)
+		}
+	}
+}
+
+// expireChange closes an overdue change request and tells its author and reviewers.
+func expireChange(gdb *gorm.DB, cr *models.ChangeRequest, now time.Time) {
+	summary := "Expired at " + now.Format(time.RFC3339) + " without a decision"
+	res := gdb.Model(&models.ChangeRequest{}).Where("id = ? AND status IN ?", cr.ID, openChangeStatuses).
+		Updates(map[string]any{"status": "expired", "summary": summary})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return
+	}
+	to := append([]uint{cr.UserID}, activeReviewerIDs(changeReviewers(gdb, cr.ID))...)
+	_ = AddNotificationsBulk(to, fmt.Sprintf("Change request #%d expired without a decision", cr.ID),
+		models.JSONB{"type": "change_request_expired", "project_id": cr.ProjectID, "dataset_id": cr.DatasetID, "change_request_id": cr.ID, "title": cr.Title})
+	crID := cr.ID
+	_ = RecordAuditEvent(cr.ProjectID, cr.DatasetID, 0, models.AuditEventTypeCRExpired,
+		fmt.Sprintf("Change Request #%d expired", cr.ID),
+		"Deadline "+cr.DueAt.Format(time.RFC3339)+" passed without a decision",
+		&crID,
+		models.AuditEventSummary{},
+		nil,
+	)
+}
+
+// escalateChange hands an overdue change request to the project owners: those not reviewing it
+// yet are added as reviewers, and all of them are notified. The request stays open.
+func escalateChange(gdb *gorm.DB, cr *models.ChangeRequest, now time.Time) {
+	res := gdb.Model(&models.ChangeRequest{}).Where("id = ? AND status IN ? AND escalated_at IS NULL", cr.ID, openChangeStatuses).
+		Update("escalated_at", now)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return
+	}
+	var owners []uint
+	_ = gdb.Model(&models.ProjectRole{}).Where("project_id = ? AND role = ?", cr.ProjectID, "owner").Pluck("user_id", &owners).Error
+	reviewing := map[uint]bool{cr.UserID: true}
+	for _, r := range changeReviewers(gdb, cr.ID) {
+		reviewing[r.UserID] = true
+	}
+	var added []uint
+	for _, id := range owners {
+		if !reviewing[id] {
+			reviewing[id] = true
+			added = append(added, id)
+		}
+	}
+	if len(added) > 0 {
+		rows := newReviewerRows(added)
+		for i := range rows {
+			rows[i].ChangeRequestID = cr.ID
+		}
+		if err := gdb.Create(&rows).Error; err != nil {
+			log.Printf("[SweepChangeRequests] escalate cr=%d: %v", cr.ID, err)
+		}
+	}
+	_ = AddNotificationsBulk(owners, fmt.Sprintf("Change request #%d is overdue and needs your review", cr.ID),
+		models.JSONB{"type": "change_request_escalated", "project_id": cr.ProjectID, "dataset_id": cr.DatasetID, "change_request_id": cr.ID, "title": cr.Title})
+	crID := cr.ID
+	_ = RecordAuditEventWithMetadata(cr.ProjectID, cr.DatasetID, 0, models.AuditEventTypeCREscalated,
+		fmt.Sprintf("Change Request #%d escalated", cr.ID),
+		"Deadline "+cr.DueAt.Format(time.RFC3339)+" passed; escalated to the project owners",
+		&crID,
+		models.AuditEventSummary{},
+		models.JSONB{"added_reviewers": added},
+	)
+}
+
+// mergeScheduledChanges applies the scheduled appends whose merge time has come. A failed apply
+// goes back to "scheduled" and is retried by the next sweep; an append that no longer validates
+// against the table it would land in goes back to its author.
+func mergeScheduledChanges(gdb *gorm.DB, now time.Time) {
+	var ready []models.ChangeRequest
+	if err := gdb.Where("status = ? AND merge_at <= ?", "scheduled", now).Find(&ready).Error; err != nil {
+		log.Printf("[SweepChangeRequests] merges: %v", err)
+		return
+	}
+	for i := range ready {
+		cr := &ready[i]
+		check, err := checkChangeBase(context.Background(), gdb, cr, true)
+		if errors.Is(err, errChangeUnusable) {
+			failScheduledMerge(gdb, cr, err)
+			continue
+		}
+		if err != nil {
+			log.Printf("[SweepChangeRequests] merge cr=%d: %v", cr.ID, err)
+			continue
+		}
+		if !check.Valid {
+			holdScheduledMerge(gdb, cr, check)
+			continue
+		}
+		claimed, err := claimChangeApply(gdb, cr)
+		if err != nil || !claimed {
+			continue
+		}
+		if status, body := applyAppendChange(context.Background(), gdb, cr, 0); status != 200 {
+			log.Printf("[SweepChangeRequests] merge cr=%d: %d %v", cr.ID, status, body)
+		}
+	}
+}
+
+// holdScheduledMerge hands a scheduled append that failed re-validation back to its author as
+// if changes were requested; a new revision goes through review again.
+func holdScheduledMerge(gdb *gorm.DB, cr *models.ChangeRequest, check baseCheck) {
+	res := gdb.Model(&models.ChangeRequest{}).Where("id = ? AND status = ?", cr.ID, "scheduled").Update("status", "changes_requested")
+	if res.Error != nil || res.RowsAffected == 0 {
+		return
+	}
+	_ = AddNotification(cr.UserID, fmt.Sprintf("The scheduled merge of change request #%d was held: the dataset changed and the data no longer validates", cr.ID),
+		models.JSONB{"type": "merge_held", "project_id": cr.ProjectID, "dataset_id": cr.DatasetID, "change_request_id": cr.ID, "title": cr.Title})
+	crID := cr.ID
+	_ = RecordAuditEventWithMetadata(cr.ProjectID, cr.DatasetID, 0, models.AuditEventTypeCRUpdated,
+		fmt.Sprintf("Change Request #%d merge held", cr.ID),
+		"The dataset changed since approval and the data no longer validates",
+		&crID,
+		models.AuditEventSummary{},
+		models.JSONB{"base_check": check},
+	)
+}
+
+// failScheduledMerge gives up on a scheduled append whose base check can never succeed, so the
+// sweep stops retrying it, and tells its author.
+func failScheduledMerge(gdb *gorm.DB, cr *models.ChangeRequest, cause error) {
+	log.Printf("[SweepChangeRequests] merge cr=%d failed: %v", cr.ID, cause)
+	res := gdb.Model(&models.ChangeRequest{}).Where("id = ? AND status = ?", cr.ID, "scheduled").Update("status", "failed")
+	if res.Error != nil || res.RowsAffected == 0 {
+		return
+	}
+	_ = AddNotification(cr.UserID, fmt.Sprintf("The scheduled merge of change request #%d failed: %v", cr.ID, cause),
+		models.JSONB{"type": "merge_failed", "project_id": cr.ProjectID, "dataset_id": cr.DatasetID, "change_request_id": cr.ID, "title": cr.Title})
+	crID := cr.ID
+	_ = RecordAuditEventWithMetadata(cr.ProjectID, cr.DatasetID, 0, models.AuditEventTypeCRUpdated,
+		fmt.Sprintf("Change Request #%d merge failed", cr.ID),
+		cause.Error(),
+		&crID,
+		models.AuditEventSummary{},
+		nil,
+	)
+}
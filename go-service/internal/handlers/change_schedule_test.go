@@ -0,0 +1,102 @@
+package handlers
+
+import (
+    "fmt"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+)
+
+func TestChangeSchedule_DeadlinesAndMergeWindow(t *testing.T) {
+    gdb, r := rowChangeEnv(t)
+    r.PUT("/projects/:id/changes/:changeId/schedule", ChangeScheduleSet)
+    gdb.Create(&models.ProjectRole{ProjectID: 1, UserID: 3, Role: "contributor"})
+    ds := models.Dataset{ID: 5, ProjectID: 1, Name: "people", Schema: peopleSchema}
+    gdb.Create(&ds)
+    if err := ensureDatasetTable(gdb, &ds); err != nil {
+        t.Fatalf("ensure table: %v", err)
+    }
+    up := models.DatasetUpload{ProjectID: 1, DatasetID: 5, Filename: "rows.json", Content: []byte(`[{"id":1,"name":"ann","age":31}]`)}
+    gdb.Create(&up)
+    open := func(due *time.Time, onOverdue string) uint {
+        cr := models.ChangeRequest{ProjectID: 1, DatasetID: 5, UserID: 2, ReviewerID: 3, Type: "append", Status: "pending",
+            Payload: fmt.Sprintf(`{"upload_id":%d,"filename":"rows.json"}`, up.ID), Reviewers: newReviewerRows([]uint{3}), DueAt: due, OnOverdue: onOverdue}
+        gdb.Create(&cr)
+        return cr.ID
+    }
+    now := time.Now()
+    soon := now.Add(time.Hour)
+    merged, expiring, escalated := open(nil, ""), open(&soon, ""), open(&soon, "escalate")
+    // A scheduled append whose upload is gone cannot be checked, now or later
+    past := now.Add(-time.Minute)
+    orphan := models.ChangeRequest{ProjectID: 1, DatasetID: 5, UserID: 2, Type: "append", Status: "scheduled", MergeAt: &past,
+        Payload: `{"upload_id":999}`, BaseChecksum: "v0:id99", Reviewers: newReviewerRows([]uint{3})}
+    gdb.Create(&orphan)
+    path := fmt.Sprintf("/projects/1/changes/%d/schedule", merged)
+
+    if code, _ := doJSON(t, r, "PUT", path, 3, gin.H{"merge_at": soon.Format(time.RFC3339)}); code != 403 {
+        t.Fatalf("reviewer schedules: %d", code)
+    }
+    if code, resp := doJSON(t, r, "PUT", path, 2, gin.H{"due_at": now.Add(-time.Hour).Format(time.RFC3339)}); code != 400 || resp["error"] != "due_at_in_past" {
+        t.Fatalf("past deadline: %d %v", code, resp)
+    }
+    code, resp := doJSON(t, r, "PUT", path, 2, gin.H{"due_at": now.Add(2 * time.Hour).Format(time.RFC3339), "merge_at": soon.Format(time.RFC3339)})
+    if code != 200 || resp["change_request"].(map[string]any)["merge_at"] == nil {
+        t.Fatalf("schedule: %d %v", code, resp)
+    }
+
+    // Approving before the merge time only schedules the append
+    approve := fmt.Sprintf("/projects/1/changes/%d/approve", merged)
+    for i := 0; i < 2; i++ {
+        if code, resp := doJSON(t, r, "POST", approve, 3, nil); code != 200 || resp["scheduled"] != true {
+            t.Fatalf("approve %d: %d %v", i, code, resp)
+        }
+    }
+    var rows int64
+    gdb.Raw("SELECT COUNT(*) FROM ds_5").Scan(&rows)
+    if rows != 0 {
+        t.Fatalf("applied before merge time: %d rows", rows)
+    }
+
+    // Within a day of the deadline the undecided reviewer is reminded, once
+    SweepChangeRequests(gdb, now)
+    SweepChangeRequests(gdb, now)
+    var reminders int64
+    gdb.Model(&models.Notification{}).Where("user_id = ? AND message LIKE ?", 3, "%is due by%").Count(&reminders)
+    if reminders != 2 {
+        t.Fatalf("reminders: %d", reminders)
+    }
+
+    SweepChangeRequests(gdb, now.Add(90*time.Minute))
+    status := func(id uint) models.ChangeRequest {
+        var cr models.ChangeRequest
+        gdb.First(&cr, id)
+        return cr
+    }
+    if cr := status(merged); cr.Status != "completed" {
+        t.Fatalf("merged: %q", cr.Status)
+    }
+    gdb.Raw("SELECT COUNT(*) FROM ds_5").Scan(&rows)
+    if rows != 1 {
+        t.Fatalf("rows after merge: %d", rows)
+    }
+    if cr := status(orphan.ID); cr.Status != "failed" {
+        t.Fatalf("orphaned merge: %q", cr.Status)
+    }
+    if cr := status(expiring); cr.Status != "expired" {
+        t.Fatalf("expiring: %q", cr.Status)
+    }
+    cr := status(escalated)
+    if cr.Status != "pending" || cr.EscalatedAt == nil || !isActiveReviewer(gdb, cr.ID, 1) {
+        t.Fatalf("escalated: %q %v owner reviewing %v", cr.Status, cr.EscalatedAt, isActiveReviewer(gdb, cr.ID, 1))
+    }
+    // Escalation happens once
+    SweepChangeRequests(gdb, now.Add(3*time.Hour))
+    var escalations int64
+    gdb.Model(&models.Notification{}).Where("user_id = ? AND message LIKE ?", 1, "%needs your review%").Count(&escalations)
+    if escalations != 1 {
+        t.Fatalf("escalation notifications: %d", escalations)
+    }
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -15,6 +16,7 @@ import (
 	"github.com/oreo-io/oreo.io-v2/go-service/internal/config"
 	dbpkg "github.com/oreo-io/oreo.io-v2/go-service/internal/database"
 	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/storage"
 	"gorm.io/gorm"
 )
 
@@ -38,7 +40,7 @@ func ChangesList(c *gin.Context) {
 		return
 	}
 	var items []models.ChangeRequest
-	if err := gdb.Where("project_id = ?", pid).Order("id desc").Find(&items).Error; err != nil {
+	if err := gdb.Preload("Reviewers").Where("project_id = ?", pid).Order("id desc").Find(&items).Error; err != nil {
 		c.JSON(500, gin.H{"error": "db"})
 		return
 	}
@@ -72,111 +74,142 @@ func ChangeApprove(c *gin.Context) {
 		c.JSON(404, gin.H{"error": "not_found"})
 		return
 	}
-	if cr.Status != "pending" {
-		c.JSON(409, gin.H{"error": "not_pending"})
+	// Completed requests replay their result so a retried approval is harmless
+	if cr.Status != "pending" && !respondNotPending(c, gdb, &cr) {
 		return
 	}
 	// Permission: allow if the acting user is an assigned reviewer OR has project role 'approver'
-	var uid uint
-	if uidVal, ok := c.Get("user_id"); ok {
-		switch v := uidVal.(type) {
-		case float64:
-			uid = uint(v)
-		case int:
-			uid = uint(v)
-		case uint:
-			uid = v
-		}
-	}
-	isAssigned := false
-	if uid != 0 {
-		if cr.ReviewerID != 0 && uid == cr.ReviewerID {
-			isAssigned = true
-		}
-		if !isAssigned && strings.TrimSpace(cr.Reviewers) != "" {
-			var ids []uint
-			_ = json.Unmarshal([]byte(cr.Reviewers), &ids)
-			for _, id := range ids {
-				if id == uid {
-					isAssigned = true
-					break
-				}
-			}
-		}
-	}
-	if !isAssigned {
+	uid := contextUserID(c)
+	if !isActiveReviewer(gdb, cr.ID, uid) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
 		return
 	}
 
-	// Mark reviewer state for acting user, then check if all reviewers have approved
-	var actingUID uint
-	if uv, ok := c.Get("user_id"); ok {
-		switch v := uv.(type) {
-		case float64:
-			actingUID = uint(v)
-		case int:
-			actingUID = uint(v)
-		case uint:
-			actingUID = v
-		}
+	policy, _ := loadApprovalPolicy(gdb, cr.ProjectID, cr.DatasetID)
+	if policy.ForbidSelfApproval && uid == cr.UserID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "author_cannot_approve"})
+		return
 	}
-	var allApproved = true
-	if strings.TrimSpace(cr.ReviewerStates) != "" && actingUID != 0 {
-		var states []map[string]any
-		_ = json.Unmarshal([]byte(cr.ReviewerStates), &states)
-		changed := false
-		for _, st := range states {
-			if st["id"] == actingUID || (st["id"] != nil && (fmt.Sprintf("%v", st["id"]) == fmt.Sprintf("%v", actingUID))) {
-				st["status"] = "approved"
-				st["decided_at"] = time.Now().Format(time.RFC3339)
-				changed = true
-			}
-		}
-		// Check if all reviewers have status 'approved'
-		for _, st := range states {
-			if st["status"] != "approved" {
-				allApproved = false
-				break
-			}
-		}
-		if changed {
-			if b, err := json.Marshal(states); err == nil {
-				cr.ReviewerStates = string(b)
-				_ = gdb.Save(&cr).Error
-			}
-		}
+	comment, acknowledged := approvalBody(c)
+	// The table may have moved since the request was opened
+	check, err := checkChangeBase(c.Request.Context(), gdb, &cr, true)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "base_check_failed", "message": err.Error()})
+		return
 	}
-	// If not all reviewers have approved, do not proceed to final approval
-	if strings.TrimSpace(cr.ReviewerStates) != "" && !allApproved {
-		c.JSON(200, gin.H{"ok": true, "change_request": cr, "message": "Waiting for all reviewers to approve."})
+	if !respondBaseCheck(c, check, acknowledged) {
+		return
+	}
+	actingUID := uid
+	decision := recordReview(gdb, &cr, policy, actingUID, "approved", comment)
+	metadata := decision.metadata()
+	if check.Moved {
+		metadata["base_check"] = check
+	}
+	crID := cr.ID
+	_ = RecordAuditEventWithMetadata(cr.ProjectID, cr.DatasetID, actingUID, models.AuditEventTypeCRApproved,
+		fmt.Sprintf("Change Request #%d approved by reviewer", cr.ID),
+		fmt.Sprintf("%d of %d required approvals; policy outcome %s", decision.Approvals, decision.Required, decision.Outcome),
+		&crID,
+		models.AuditEventSummary{},
+		metadata,
+	)
+	switch decision.Outcome {
+	case "pending":
+		c.JSON(200, gin.H{"ok": true, "change_request": cr, "decision": decision, "base_check": check, "message": "Waiting for more reviewers to approve."})
+		return
+	case "rejected":
+		// The approval came after the policy became unsatisfiable
+		if !rejectChange(c, gdb, &cr) {
+			return
+		}
+		c.JSON(200, gin.H{"ok": true, "change_request": cr, "decision": decision})
 		return
 	}
 
 	// Apply according to type. For append, prefer backend-specific path.
 	if cr.Type == "append" {
-		var ds models.Dataset
-		if err := gdb.Where("project_id = ?", pid).First(&ds, cr.DatasetID).Error; err != nil {
-			c.JSON(404, gin.H{"error": "dataset_not_found"})
-			return
-		}
-		// Payload holds { upload_id, filename }
-		var payload struct {
-			UploadID uint   `json:"upload_id"`
-			Filename string `json:"filename"`
-		}
-		_ = json.Unmarshal([]byte(cr.Payload), &payload)
-		if payload.UploadID == 0 {
-			c.JSON(400, gin.H{"error": "no_upload_ref"})
-			return
-		}
-		var up models.DatasetUpload
-		if err := gdb.Where("project_id = ? AND id = ?", pid, payload.UploadID).First(&up).Error; err != nil {
-			c.JSON(404, gin.H{"error": "upload_not_found"})
-			return
-		}
-		// Delta backend: stream upload directly to python /delta/append-file
-		if strings.EqualFold(ds.StorageBackend, "delta") {
+		approveAppendChange(c, gdb, &cr, actingUID)
+		return
+	}
+
+	// Row-level changes: delete by predicate, update cells by key, merge an upload on key
+	if cr.Type == "delete" || cr.Type == "update" || cr.Type == "merge" {
+		approveRowChange(c, gdb, &cr, actingUID)
+		return
+	}
+
+	// Unknown type: mark approved without side effects for now
+	res := gdb.Model(&models.ChangeRequest{}).Where("id = ? AND status = ?", cr.ID, "pending").Update("status", "approved")
+	if res.Error != nil {
+		c.JSON(500, gin.H{"error": "db"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(409, gin.H{"error": "not_pending"})
+		return
+	}
+	cr.Status = "approved"
+	c.JSON(200, gin.H{"ok": true, "change_request": cr})
+}
+
+// approveAppendChange is ChangeApprove's final step for append change requests. A request with
+// a merge time still ahead is scheduled for the sweep to apply in its window; otherwise it is
+// claimed and applied right away.
+func approveAppendChange(c *gin.Context, gdb *gorm.DB, cr *models.ChangeRequest, actingUID uint) {
+	if cr.MergeAt != nil && cr.MergeAt.After(time.Now()) {
+		scheduleMerge(c, gdb, cr, actingUID)
+		return
+	}
+	if !claimOrRespond(c, gdb, cr) {
+		return
+	}
+	c.JSON(applyAppendChange(c.Request.Context(), gdb, cr, actingUID))
+}
+
+// applyAppendChange appends the upload of a claimed append change request to the main table (a
+// Delta commit, or the JSONB staging table in one transaction). It returns the status and body
+// of the approval's response; on failure the claim is released.
+func applyAppendChange(ctx context.Context, gdb *gorm.DB, cr *models.ChangeRequest, actingUID uint) (int, gin.H) {
+	var ds models.Dataset
+	if err := gdb.Where("project_id = ?", cr.ProjectID).First(&ds, cr.DatasetID).Error; err != nil {
+		releaseChangeApply(gdb, cr)
+		return 404, gin.H{"error": "dataset_not_found"}
+	}
+	// Payload holds { upload_id, filename }
+	var payload struct {
+		UploadID uint   `json:"upload_id"`
+		Filename string `json:"filename"`
+	}
+	_ = json.Unmarshal([]byte(cr.Payload), &payload)
+	if payload.UploadID == 0 {
+		releaseChangeApply(gdb, cr)
+		return 400, gin.H{"error": "no_upload_ref"}
+	}
+	var up models.DatasetUpload
+	if err := gdb.Where("project_id = ? AND id = ?", cr.ProjectID, payload.UploadID).First(&up).Error; err != nil {
+		releaseChangeApply(gdb, cr)
+		return 404, gin.H{"error": "upload_not_found"}
+	}
+	// Delta commits carry the apply key, so a retried apply never appends twice
+	ctx = storage.WithCommitTag(ctx, cr.ApplyKey)
+	// Delta backend: stream upload directly to python /delta/append-file,
+	// or append in-process for delta-native datasets
+	if isDeltaBackend(&ds) {
+		// Parse Python response to get duplicate info
+		var pyResp struct {
+			Ok         bool `json:"ok"`
+			TotalRows  int  `json:"total_rows"`
+			Inserted   int  `json:"inserted"`
+			Duplicates int  `json:"duplicates"`
+		}
+		var applyErr error
+		applyStatus, applyCode := 500, "append_failed"
+		if nd, ok := nativeDelta(&ds); ok {
+			n, err := nativeDeltaAppend(ctx, nd, &ds, up.Content, payload.Filename)
+			applyErr = err
+			pyResp.Ok, pyResp.Inserted = err == nil, n
+		} else {
 			cfg := config.Get()
 			pyBase := cfg.PythonServiceURL
 			if strings.TrimSpace(pyBase) == "" {
@@ -185,267 +218,89 @@ func ChangeApprove(c *gin.Context) {
 			var mpBuf bytes.Buffer
 			mw := multipart.NewWriter(&mpBuf)
 			// Use hierarchical path (project_id + dataset_id) for proper main table location
-			_ = mw.WriteField("project_id", fmt.Sprintf("%d", pid))
+			_ = mw.WriteField("project_id", fmt.Sprintf("%d", cr.ProjectID))
 			_ = mw.WriteField("dataset_id", fmt.Sprintf("%d", ds.ID))
+			_ = mw.WriteField("commit_tag", cr.ApplyKey)
 			fw, _ := mw.CreateFormFile("file", payload.Filename)
 			_, _ = fw.Write(up.Content)
 			_ = mw.Close()
-			req, _ := http.NewRequest(http.MethodPost, pyBase+"/delta/append-file", &mpBuf)
+			req, _ := http.NewRequestWithContext(ctx, http.MethodPost, pyBase+"/delta/append-file", &mpBuf)
 			req.Header.Set("Content-Type", mw.FormDataContentType())
 			resp, err := http.DefaultClient.Do(req)
-			if err != nil || resp == nil {
-				c.JSON(502, gin.H{"error": "python_unreachable"})
-				return
-			}
-			defer resp.Body.Close()
-			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-				c.JSON(500, gin.H{"error": "append_failed"})
-				return
-			}
-			
-			// Parse Python response to get duplicate info
-			var pyResp struct {
-				Ok         bool `json:"ok"`
-				TotalRows  int  `json:"total_rows"`
-				Inserted   int  `json:"inserted"`
-				Duplicates int  `json:"duplicates"`
-			}
-			bodyBytes, _ := io.ReadAll(resp.Body)
-			_ = json.Unmarshal(bodyBytes, &pyResp)
-			
-			// Update timestamps and meta
-			now := time.Now()
-			ds.LastUploadAt = &now
-			_ = gdb.Save(&ds).Error
-			upsertDatasetMeta(gdb, &ds)
-			
-			// Fetch Delta operation stats for audit
-			rowsAdded, rowsUpdated, _, totalRows := FetchDeltaOperationStats(ds.ProjectID, ds.ID)
-			
-			// Update dataset meta with new row count
-			if totalRows > 0 {
-				var meta models.DatasetMeta
-				if err := gdb.Where("dataset_id = ?", ds.ID).First(&meta).Error; err == nil {
-					meta.RowCount = int64(totalRows)
-					meta.LastUpdateAt = time.Now()
-					_ = gdb.Save(&meta).Error
+			switch {
+			case err != nil || resp == nil:
+				applyErr = fmt.Errorf("python service unreachable: %v", err)
+				applyStatus, applyCode = 502, "python_unreachable"
+			default:
+				defer resp.Body.Close()
+				bodyBytes, _ := io.ReadAll(resp.Body)
+				if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+					applyErr = fmt.Errorf("python status %d: %s", resp.StatusCode, bytes.TrimSpace(bodyBytes))
+				} else {
+					_ = json.Unmarshal(bodyBytes, &pyResp)
 				}
 			}
-			
-			// Record version snapshot (delta path reference)
-			cfg = config.Get()
-			root := strings.TrimRight(cfg.DeltaDataRoot, "/\\")
-			if root == "" {
-				root = "/data/delta"
+		}
+		// A failed call may still have committed (e.g. a timeout after the write); the
+		// table history decides.
+		if applyErr != nil && !deltaApplied(ctx, &ds, cr.ApplyKey) {
+			releaseChangeApply(gdb, cr)
+			return applyStatus, gin.H{"error": applyCode, "message": applyErr.Error()}
+		}
+
+		// Fetch Delta operation stats for audit
+		rowsAdded, rowsUpdated, _, totalRows := deltaOperationStats(&ds)
+
+		// Record the version snapshot and complete the request together
+		cfg := config.Get()
+		root := strings.TrimRight(cfg.DeltaDataRoot, "/\\")
+		if root == "" {
+			root = "/data/delta"
+		}
+		main := fmt.Sprintf("%s/%d", root, ds.ID)
+		now := time.Now()
+		err := gdb.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&ds).Update("last_upload_at", now).Error; err != nil {
+				return err
 			}
-			main := fmt.Sprintf("%s/%d", root, ds.ID)
-			verData := map[string]any{
+			err := recordChangeVersion(tx, &ds, cr, map[string]any{
 				"table":      main,
 				"row_count":  totalRows,
 				"change_id":  cr.ID,
-				"applied_at": time.Now().Format(time.RFC3339),
+				"applied_at": now.Format(time.RFC3339),
+			})
+			if err != nil {
+				return err
 			}
-			if b, err := json.Marshal(verData); err == nil {
-				approvers := cr.ReviewerStates
-				if strings.TrimSpace(approvers) == "" {
-					approvers = cr.Reviewers
-				}
-				_ = gdb.Create(&models.DatasetVersion{DatasetID: ds.ID, Data: string(b), EditedBy: cr.UserID, EditedAt: time.Now(), Status: "approved", Approvers: approvers}).Error
-			}
-			cr.Status = "completed"
-			cr.Summary = "Applied append at " + time.Now().Format(time.RFC3339)
-			if err := gdb.Save(&cr).Error; err != nil {
-				c.JSON(500, gin.H{"error": "db"})
-				return
-			}
-			// Notify requester with duplicate info
-			if cr.UserID != 0 {
-				notifyMsg := "Your append request has been applied successfully"
-				if pyResp.Duplicates > 0 {
-					notifyMsg = fmt.Sprintf("Your append request has been applied. %d rows inserted, %d duplicate rows skipped.", pyResp.Inserted, pyResp.Duplicates)
-				} else if pyResp.Inserted > 0 {
-					notifyMsg = fmt.Sprintf("Your append request has been applied. %d rows inserted.", pyResp.Inserted)
-				}
-				_ = AddNotification(cr.UserID, notifyMsg, models.JSONB{"type": "append_completed", "project_id": uint(pid), "dataset_id": cr.DatasetID, "change_request_id": cr.ID, "inserted": pyResp.Inserted, "duplicates": pyResp.Duplicates})
-			}
-			
-			// Get cells changed from payload for audit
-			var cellsChanged int
-			var payloadData struct {
-				EditedCells []map[string]interface{} `json:"edited_cells"`
-			}
-			if json.Unmarshal([]byte(cr.Payload), &payloadData) == nil {
-				cellsChanged = len(payloadData.EditedCells)
-			}
-			
-			// Use actual inserted count from Python response if available
-			actualRowsAdded := rowsAdded
-			if pyResp.Inserted > 0 {
-				actualRowsAdded = pyResp.Inserted
-			}
-			
-			// Record audit event for CR merge with Delta stats
-			crID := cr.ID
-			_ = RecordAuditEvent(cr.ProjectID, cr.DatasetID, actingUID, models.AuditEventTypeCRMerged,
-				fmt.Sprintf("Change Request #%d merged", cr.ID),
-				fmt.Sprintf("%d rows added, %d duplicates skipped, %d cells changed", actualRowsAdded, pyResp.Duplicates, cellsChanged),
-				&crID,
-				models.AuditEventSummary{RowsAdded: actualRowsAdded, RowsUpdated: rowsUpdated, CellsChanged: cellsChanged},
-				nil,
-			)
-			c.JSON(200, gin.H{"ok": true, "change_request": cr, "inserted": pyResp.Inserted, "duplicates": pyResp.Duplicates})
-			return
+			return finishChangeApply(tx, cr, "Applied append at "+now.Format(time.RFC3339))
+		})
+		if err != nil {
+			// The data is in; the request stays "applying" and is completed by recovery
+			return 500, gin.H{"error": "db", "message": err.Error()}
 		}
+		upsertDatasetMeta(gdb, &ds)
 
-		// DB path: append staging table rows to main PHYSICAL table, then drop staging (transactional)
-		stg := dsStagingTable(ds.ID, cr.ID)
-		main := datasetPhysicalTable(&ds)
-		altMain := dsMainTable(ds.ID)
-		// Diagnostics
-		fmt.Printf("[ChangeApprove] append start ds=%d cr=%d stg=%s main=%s altMain=%s\n", ds.ID, cr.ID, stg, main, altMain)
-		usedDB := false
-		if tableExists(gdb, stg) {
-			// Non-transactional merge to avoid driver/visibility issues observed in dev
-			// ensure physical table exists
-			if err := ensureDatasetTable(gdb, &ds); err != nil {
-				fmt.Printf("[ChangeApprove] ensureDatasetTable failed: %v\n", err)
-			} else {
-				// Count rows to be appended (for auditing)
-				var toAppend int64
-				_ = gdb.Raw(fmt.Sprintf("SELECT COUNT(*) FROM %s", stg)).Row().Scan(&toAppend)
-				fmt.Printf("[ChangeApprove] stg rows to append = %d\n", toAppend)
-				// append into physical table; on failure, fallback to internal ds_<id> table
-				var ex *gorm.DB
-				if strings.EqualFold(gdb.Dialector.Name(), "postgres") {
-					ex = gdb.Exec(fmt.Sprintf("INSERT INTO %s (data) SELECT data::jsonb FROM %s", main, stg))
-				} else {
-					ex = gdb.Exec(fmt.Sprintf("INSERT INTO %s (data) SELECT data FROM %s", main, stg))
-				}
-				if ex.Error != nil {
-					fmt.Printf("[ChangeApprove] insert into main failed: %v\n", ex.Error)
-					// Ensure alt table exists and retry
-					if err := ensureMainTable(gdb, ds.ID); err == nil {
-						if strings.EqualFold(gdb.Dialector.Name(), "postgres") {
-							ex = gdb.Exec(fmt.Sprintf("INSERT INTO %s (data) SELECT data::jsonb FROM %s", altMain, stg))
-						} else {
-							ex = gdb.Exec(fmt.Sprintf("INSERT INTO %s (data) SELECT data FROM %s", altMain, stg))
-						}
-					}
-					if ex.Error == nil {
-						main = altMain
-					}
-				}
-				if ex != nil && ex.Error == nil {
-					fmt.Printf("[ChangeApprove] rows appended = %d into %s\n", ex.RowsAffected, main)
-					// drop staging (best-effort)
-					_ = gdb.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", stg)).Error
-					// timestamps and metadata
-					now := time.Now()
-					ds.LastUploadAt = &now
-					_ = gdb.Save(&ds).Error
-					upsertDatasetMeta(gdb, &ds)
-					// Post-merge count (best-effort)
-					var after int64
-					_ = gdb.Raw(fmt.Sprintf("SELECT COUNT(*) FROM %s", main)).Row().Scan(&after)
-					fmt.Printf("[ChangeApprove] post-merge row_count in %s = %d\n", main, after)
-					// audit
-					_ = gdb.AutoMigrate(&models.AuditLog{})
-					_ = gdb.Create(&models.AuditLog{
-						ActorID:    actingUID,
-						ProjectID:  ds.ProjectID,
-						EntityType: "dataset",
-						EntityID:   fmt.Sprintf("%d", ds.ID),
-						Action:     "append_approved",
-						NewValue:   models.JSONB{"change_request_id": cr.ID, "dataset_id": ds.ID, "rows_appended": ex.RowsAffected},
-						CreatedAt:  time.Now(),
-					}).Error
-					usedDB = true
-				}
-			}
-		}
-		if !usedDB {
-			// Fallback: Directly ingest upload content into the dataset's physical table
-			mainTbl := datasetPhysicalTable(&ds)
-			if err := gdb.Transaction(func(tx *gorm.DB) error {
-				if err := ensureDatasetTable(tx, &ds); err != nil {
-					return err
-				}
-				if err := ingestBytesToTable(tx, up.Content, payload.Filename, mainTbl); err != nil {
-					return err
-				}
-				// Drop staging if it exists (best-effort)
-				_ = tx.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", dsStagingTable(ds.ID, cr.ID))).Error
-				now := time.Now()
-				ds.LastUploadAt = &now
-				if err := tx.Save(&ds).Error; err != nil {
-					return err
-				}
-				upsertDatasetMeta(tx, &ds)
-				var after int64
-				_ = tx.Raw(fmt.Sprintf("SELECT COUNT(*) FROM %s", mainTbl)).Row().Scan(&after)
-				fmt.Printf("[ChangeApprove] fallback ingest into %s complete, row_count = %d\n", mainTbl, after)
-				return nil
-			}); err != nil {
-				c.JSON(500, gin.H{"error": "append_ingest_failed"})
-				return
+		// Update dataset meta with new row count
+		if totalRows > 0 {
+			var meta models.DatasetMeta
+			if err := gdb.Where("dataset_id = ?", ds.ID).First(&meta).Error; err == nil {
+				meta.RowCount = int64(totalRows)
+				meta.LastUpdateAt = time.Now()
+				_ = gdb.Save(&meta).Error
 			}
-			usedDB = true
 		}
-
-		// Record a dataset version snapshot (table reference and row count) on approved change
-		// Compute row count from main table if available
-		var rowCount int64
-		_ = gdb.Raw(fmt.Sprintf("SELECT COUNT(*) FROM %s", main)).Row().Scan(&rowCount)
-		fmt.Printf("[ChangeApprove] version snapshot: table=%s row_count=%d\n", main, rowCount)
-		verData := map[string]any{
-			"table":      main,
-			"row_count":  rowCount,
-			"change_id":  cr.ID,
-			"applied_at": time.Now().Format(time.RFC3339),
-		}
-		if b, err := json.Marshal(verData); err == nil {
-			approvers := cr.ReviewerStates
-			if strings.TrimSpace(approvers) == "" {
-				approvers = cr.Reviewers
-			}
-			_ = gdb.Create(&models.DatasetVersion{
-				DatasetID: ds.ID,
-				Data:      string(b),
-				EditedBy:  cr.UserID,
-				EditedAt:  time.Now(),
-				Status:    "approved",
-				Approvers: approvers,
-			}).Error
-		}
-		// Mark change status
-		if usedDB {
-			cr.Status = "completed"
-		} else {
-			cr.Status = "approved"
-		}
-		cr.Summary = "Applied append at " + time.Now().Format(time.RFC3339)
-		if err := gdb.Save(&cr).Error; err != nil {
-			c.JSON(500, gin.H{"error": "db"})
-			return
-		}
-		// Notify requester that their append request was applied
+		// Notify requester with duplicate info
 		if cr.UserID != 0 {
-			msg := "Your append request has been applied successfully"
-			if !usedDB {
-				msg = "Your append request was approved; data was stored for later processing"
+			notifyMsg := "Your append request has been applied successfully"
+			if pyResp.Duplicates > 0 {
+				notifyMsg = fmt.Sprintf("Your append request has been applied. %d rows inserted, %d duplicate rows skipped.", pyResp.Inserted, pyResp.Duplicates)
+			} else if pyResp.Inserted > 0 {
+				notifyMsg = fmt.Sprintf("Your append request has been applied. %d rows inserted.", pyResp.Inserted)
 			}
-			_ = AddNotification(cr.UserID, msg, models.JSONB{"type": "append_completed", "project_id": uint(pid), "dataset_id": cr.DatasetID, "change_request_id": cr.ID})
+			_ = AddNotification(cr.UserID, notifyMsg, models.JSONB{"type": "append_completed", "project_id": cr.ProjectID, "dataset_id": cr.DatasetID, "change_request_id": cr.ID, "inserted": pyResp.Inserted, "duplicates": pyResp.Duplicates})
 		}
-		// Record audit event for CR merge (DB path) with proper stats
-		crID := cr.ID
-		eventType := models.AuditEventTypeCRMerged
-		eventTitle := fmt.Sprintf("Change Request #%d merged", cr.ID)
-		if !usedDB {
-			eventType = models.AuditEventTypeCRApproved
-			eventTitle = fmt.Sprintf("Change Request #%d approved", cr.ID)
-		}
-		
-		// Get cells changed from payload
+
+		// Get cells changed from payload for audit
 		var cellsChanged int
 		var payloadData struct {
 			EditedCells []map[string]interface{} `json:"edited_cells"`
@@ -453,25 +308,115 @@ func ChangeApprove(c *gin.Context) {
 		if json.Unmarshal([]byte(cr.Payload), &payloadData) == nil {
 			cellsChanged = len(payloadData.EditedCells)
 		}
-		
-		_ = RecordAuditEvent(cr.ProjectID, cr.DatasetID, actingUID, eventType,
-			eventTitle,
-			fmt.Sprintf("%d rows added, %d cells changed", rowCount, cellsChanged),
+
+		// Use actual inserted count from Python response if available
+		actualRowsAdded := rowsAdded
+		if pyResp.Inserted > 0 {
+			actualRowsAdded = pyResp.Inserted
+		}
+
+		// Record audit event for CR merge with Delta stats
+		crID := cr.ID
+		_ = RecordAuditEvent(cr.ProjectID, cr.DatasetID, actingUID, models.AuditEventTypeCRMerged,
+			fmt.Sprintf("Change Request #%d merged", cr.ID),
+			fmt.Sprintf("%d rows added, %d duplicates skipped, %d cells changed", actualRowsAdded, pyResp.Duplicates, cellsChanged),
 			&crID,
-			models.AuditEventSummary{RowsAdded: int(rowCount), CellsChanged: cellsChanged},
+			models.AuditEventSummary{RowsAdded: actualRowsAdded, RowsUpdated: rowsUpdated, CellsChanged: cellsChanged},
 			nil,
 		)
-		c.JSON(200, gin.H{"ok": true, "change_request": cr})
-		return
+		return 200, gin.H{"ok": true, "change_request": cr, "inserted": pyResp.Inserted, "duplicates": pyResp.Duplicates}
 	}
 
-	// Unknown type: mark approved without side effects for now
-	cr.Status = "approved"
-	if err := gdb.Save(&cr).Error; err != nil {
-		c.JSON(500, gin.H{"error": "db"})
-		return
+	// DB path: append the staging table (or, without one, the upload itself) to the main
+	// physical table, drop staging, record the version and complete the request in one
+	// transaction.
+	stg := dsStagingTable(ds.ID, cr.ID)
+	main := datasetPhysicalTable(&ds)
+	var appended, rowCount int64
+	err := gdb.Transaction(func(tx *gorm.DB) error {
+		if err := ensureDatasetTable(tx, &ds); err != nil {
+			return err
+		}
+		if tableExists(tx, stg) {
+			sel := "data"
+			if dialect(tx) == "postgres" {
+				sel = "data::jsonb"
+			}
+			ex := tx.Exec(fmt.Sprintf("INSERT INTO %s (data) SELECT %s FROM %s", main, sel, stg))
+			if ex.Error != nil {
+				return ex.Error
+			}
+			appended = ex.RowsAffected
+			if err := tx.Exec(fmt.Sprintf("DROP TABLE %s", stg)).Error; err != nil {
+				return err
+			}
+		} else {
+			var before int64
+			_ = tx.Raw(fmt.Sprintf("SELECT COUNT(*) FROM %s", main)).Row().Scan(&before)
+			if err := ingestBytesToTable(tx, up.Content, payload.Filename, main); err != nil {
+				return err
+			}
+			_ = tx.Raw(fmt.Sprintf("SELECT COUNT(*) FROM %s", main)).Row().Scan(&appended)
+			appended -= before
+		}
+		now := time.Now()
+		ds.LastUploadAt = &now
+		if err := tx.Model(&ds).Update("last_upload_at", now).Error; err != nil {
+			return err
+		}
+		upsertDatasetMeta(tx, &ds)
+		if err := tx.Raw(fmt.Sprintf("SELECT COUNT(*) FROM %s", main)).Row().Scan(&rowCount); err != nil {
+			return err
+		}
+		err := recordChangeVersion(tx, &ds, cr, map[string]any{
+			"table":      main,
+			"row_count":  rowCount,
+			"change_id":  cr.ID,
+			"applied_at": now.Format(time.RFC3339),
+		})
+		if err != nil {
+			return err
+		}
+		return finishChangeApply(tx, cr, "Applied append at "+now.Format(time.RFC3339))
+	})
+	if err != nil {
+		releaseChangeApply(gdb, cr)
+		return 500, gin.H{"error": "append_ingest_failed", "message": err.Error()}
+	}
+	_ = gdb.AutoMigrate(&models.AuditLog{})
+	_ = gdb.Create(&models.AuditLog{
+		ActorID:    actingUID,
+		ProjectID:  ds.ProjectID,
+		EntityType: "dataset",
+		EntityID:   fmt.Sprintf("%d", ds.ID),
+		Action:     "append_approved",
+		NewValue:   models.JSONB{"change_request_id": cr.ID, "dataset_id": ds.ID, "rows_appended": appended},
+		CreatedAt:  time.Now(),
+	}).Error
+	// Notify requester that their append request was applied
+	if cr.UserID != 0 {
+		_ = AddNotification(cr.UserID, "Your append request has been applied successfully", models.JSONB{"type": "append_completed", "project_id": cr.ProjectID, "dataset_id": cr.DatasetID, "change_request_id": cr.ID})
+	}
+	// Record audit event for CR merge (DB path) with proper stats
+	crID := cr.ID
+
+	// Get cells changed from payload
+	var cellsChanged int
+	var payloadData struct {
+		EditedCells []map[string]interface{} `json:"edited_cells"`
 	}
-	c.JSON(200, gin.H{"ok": true, "change_request": cr})
+	if json.Unmarshal([]byte(cr.Payload), &payloadData) == nil {
+		cellsChanged = len(payloadData.EditedCells)
+	}
+
+	_ = RecordAuditEvent(cr.ProjectID, cr.DatasetID, actingUID, models.AuditEventTypeCRMerged,
+		fmt.Sprintf("Change Request #%d merged", cr.ID),
+		fmt.Sprintf("%d rows added, %d cells changed", appended, cellsChanged),
+		&crID,
+		models.AuditEventSummary{RowsAdded: int(appended), CellsChanged: cellsChanged},
+		nil,
+	)
+	return 200, gin.H{"ok": true, "change_request": cr}
 }
 
 // ChangeReject rejects a pending change request
@@ -505,89 +450,30 @@ func ChangeReject(c *gin.Context) {
 		return
 	}
 	// Permission: allow if the acting user is an assigned reviewer OR has project role 'approver'
-	var uid uint
-	if uidVal, ok := c.Get("user_id"); ok {
-		switch v := uidVal.(type) {
-		case float64:
-			uid = uint(v)
-		case int:
-			uid = uint(v)
-		case uint:
-			uid = v
-		}
-	}
-	isAssigned := false
-	if uid != 0 {
-		if cr.ReviewerID != 0 && uid == cr.ReviewerID {
-			isAssigned = true
-		}
-		if !isAssigned && strings.TrimSpace(cr.Reviewers) != "" {
-			var ids []uint
-			_ = json.Unmarshal([]byte(cr.Reviewers), &ids)
-			for _, id := range ids {
-				if id == uid {
-					isAssigned = true
-					break
-				}
-			}
-		}
-	}
-	if !isAssigned {
+	uid := contextUserID(c)
+	if !isActiveReviewer(gdb, cr.ID, uid) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
 		return
 	}
-	// Mark reviewer state if present
-	var actingUID uint
-	if uv, ok := c.Get("user_id"); ok {
-		switch v := uv.(type) {
-		case float64:
-			actingUID = uint(v)
-		case int:
-			actingUID = uint(v)
-		case uint:
-			actingUID = v
-		}
-	}
-	if strings.TrimSpace(cr.ReviewerStates) != "" && actingUID != 0 {
-		var states []map[string]any
-		_ = json.Unmarshal([]byte(cr.ReviewerStates), &states)
-		changed := false
-		for _, st := range states {
-			if idv, ok := st["id"].(float64); ok && uint(idv) == actingUID {
-				st["status"] = "rejected"
-				st["decided_at"] = time.Now().Format(time.RFC3339)
-				changed = true
-				break
-			}
-			if idu, ok := st["id"].(uint); ok && idu == actingUID {
-				st["status"] = "rejected"
-				st["decided_at"] = time.Now().Format(time.RFC3339)
-				changed = true
-				break
-			}
-		}
-		if changed {
-			if b, err := json.Marshal(states); err == nil {
-				cr.ReviewerStates = string(b)
-				_ = gdb.Save(&cr).Error
-			}
+	actingUID := uid
+	policy, _ := loadApprovalPolicy(gdb, cr.ProjectID, cr.DatasetID)
+	decision := recordReview(gdb, &cr, policy, actingUID, "rejected", reviewComment(c))
+	// Under a quorum the change stays open while enough reviewers can still approve it, and
+	// only the vote that closes it is audited as a rejection
+	if decision.Outcome == "rejected" {
+		if !rejectChange(c, gdb, &cr) {
+			return
 		}
+		crID := cr.ID
+		_ = RecordAuditEventWithMetadata(cr.ProjectID, cr.DatasetID, actingUID, models.AuditEventTypeCRRejected,
+			fmt.Sprintf("Change Request #%d rejected", cr.ID),
+			"Change request was rejected by reviewer",
+			&crID,
+			models.AuditEventSummary{},
+			decision.metadata(),
+		)
 	}
-	cr.Status = "rejected"
-	if err := gdb.Save(&cr).Error; err != nil {
-		c.JSON(500, gin.H{"error": "db"})
-		return
-	}
-	// Record audit event for CR rejection
-	crID := cr.ID
-	_ = RecordAuditEvent(cr.ProjectID, cr.DatasetID, actingUID, models.AuditEventTypeCRRejected,
-		fmt.Sprintf("Change Request #%d rejected", cr.ID),
-		fmt.Sprintf("Change request was rejected by reviewer"),
-		&crID,
-		models.AuditEventSummary{},
-		nil,
-	)
-	c.JSON(200, gin.H{"ok": true, "change_request": cr})
+	c.JSON(200, gin.H{"ok": true, "change_request": cr, "decision": decision})
 }
 
 // ChangeWithdraw lets the creator withdraw their pending request
@@ -615,7 +501,8 @@ func ChangeWithdraw(c *gin.Context) {
 		c.JSON(404, gin.H{"error": "not_found"})
 		return
 	}
-	if cr.Status != "pending" {
+	// The author may also give up on a request instead of revising it, or cancel a scheduled merge
+	if cr.Status != "pending" && cr.Status != "changes_requested" && cr.Status != "scheduled" {
 		c.JSON(409, gin.H{"error": "not_pending"})
 		return
 	}
@@ -635,12 +522,18 @@ func ChangeWithdraw(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "not_owner_of_change"})
 		return
 	}
-	cr.Status = "withdrawn"
-	cr.Summary = "Withdrawn by requester at " + time.Now().Format(time.RFC3339)
-	if err := gdb.Save(&cr).Error; err != nil {
+	summary := "Withdrawn by requester at " + time.Now().Format(time.RFC3339)
+	res := gdb.Model(&models.ChangeRequest{}).Where("id = ? AND status IN ?", cr.ID, []string{"pending", "changes_requested", "scheduled"}).
+		Updates(map[string]any{"status": "withdrawn", "summary": summary})
+	if res.Error != nil {
 		c.JSON(500, gin.H{"error": "db"})
 		return
 	}
+	if res.RowsAffected == 0 {
+		c.JSON(409, gin.H{"error": "not_pending"})
+		return
+	}
+	cr.Status, cr.Summary = "withdrawn", summary
 	// Record audit event for CR withdrawal
 	crID := cr.ID
 	_ = RecordAuditEvent(cr.ProjectID, cr.DatasetID, uid, models.AuditEventTypeCRWithdrawn,
@@ -680,7 +573,7 @@ func DatasetApprovalsListTop(c *gin.Context) {
 		status = "pending"
 	}
 	var items []models.ChangeRequest
-	q := gdb.Where("project_id = ? AND dataset_id = ?", ds.ProjectID, ds.ID)
+	q := gdb.Preload("Reviewers").Where("project_id = ? AND dataset_id = ?", ds.ProjectID, ds.ID)
 	if status != "all" {
 		q = q.Where("LOWER(status) = ?", status)
 	}
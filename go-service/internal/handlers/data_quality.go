@@ -0,0 +1,566 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	dbpkg "github.com/oreo-io/oreo.io-v2/go-service/internal/database"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	services "github.com/oreo-io/oreo.io-v2/go-service/internal/service"
+	"gorm.io/gorm"
+)
+
+// The quality rules of a dataset are the rows of data_quality_rules. Dataset.Rules mirrors them
+// as the JSON array the Python validator takes, [{"type": "unique", "column": "id"}, ...], and
+// setting it replaces them. Validations read the mirror, which saves a query inside their
+// transactions: appends of CSV and JSON are checked by appendCheck, other uploads and changes by
+// the Python service, which skips the foreign_key and expression rules it does not know. The
+// whole dataset is checked by a dq-check job, enqueued after every applied change and on demand,
+// which stores the outcome of each rule in data_quality_results.
+
+// dqJobType is the job type of data quality checks.
+const dqJobType = "dq-check"
+
+// dqSampleRows is how many failing rows a result keeps.
+const dqSampleRows = 10
+
+// dqRuleColumns lists the definition keys each rule type requires.
+var dqRuleColumns = map[string]string{
+	"required":       "columns",
+	"not_null":       "columns",
+	"unique":         "column",
+	"range":          "column",
+	"regex":          "column",
+	"allowed_values": "column",
+	"ref_in":         "column",
+	"foreign_key":    "column",
+	"expression":     "expression",
+}
+
+// DQJobType runs data quality checks. Metadata: {dataset_id}
+var DQJobType = services.JobType{Run: RunDQJob}
+
+// parseDQRule turns a rule in validator form into a rule row, checking its definition.
+func parseDQRule(obj map[string]any) (models.DataQualityRule, error) {
+	rt, _ := obj["type"].(string)
+	key, ok := dqRuleColumns[rt]
+	if !ok {
+		return models.DataQualityRule{}, fmt.Errorf("unknown rule type %q", rt)
+	}
+	severity, _ := obj["severity"].(string)
+	if severity == "" {
+		severity = "block"
+	}
+	if severity != "block" && severity != "warn" {
+		return models.DataQualityRule{}, fmt.Errorf("%s: severity must be block or warn", rt)
+	}
+	def := models.JSONB{}
+	for k, v := range obj {
+		if k != "type" && k != "severity" {
+			def[k] = v
+		}
+	}
+	switch rt {
+	case "expression":
+		src, _ := def["expression"].(string)
+		if strings.TrimSpace(src) == "" {
+			return models.DataQualityRule{}, fmt.Errorf("expression: expression is required")
+		}
+		if _, err := parseRuleExpr(src); err != nil {
+			return models.DataQualityRule{}, fmt.Errorf("expression: %v", err)
+		}
+		return models.DataQualityRule{RuleType: rt, Definition: def, Severity: severity}, nil
+	case "foreign_key":
+		if jobUint(def["dataset_id"]) == 0 {
+			return models.DataQualityRule{}, fmt.Errorf("foreign_key: dataset_id is required")
+		}
+	}
+	if len(dqColumns(def)) == 0 {
+		return models.DataQualityRule{}, fmt.Errorf("%s: %s is required", rt, key)
+	}
+	switch rt {
+	case "range":
+		_, hasMin := dqFloat(def["min"])
+		_, hasMax := dqFloat(def["max"])
+		if !hasMin && !hasMax {
+			return models.DataQualityRule{}, fmt.Errorf("range: min or max is required")
+		}
+	case "regex":
+		pattern, _ := def["pattern"].(string)
+		if _, err := regexp.Compile(pattern); pattern == "" || err != nil {
+			return models.DataQualityRule{}, fmt.Errorf("regex: invalid pattern")
+		}
+	case "allowed_values", "ref_in":
+		if values, _ := def["values"].([]any); len(values) == 0 {
+			return models.DataQualityRule{}, fmt.Errorf("%s: values are required", rt)
+		}
+	}
+	return models.DataQualityRule{RuleType: rt, Definition: def, Severity: severity}, nil
+}
+
+// parseDatasetRules parses rules as stored in Dataset.Rules; empty text means no rules.
+func parseDatasetRules(text string) ([]models.DataQualityRule, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	var objs []map[string]any
+	if err := json.Unmarshal([]byte(text), &objs); err != nil {
+		return nil, errors.New("rules must be a JSON array of rules")
+	}
+	rules := make([]models.DataQualityRule, 0, len(objs))
+	for _, obj := range objs {
+		r, err := parseDQRule(obj)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// dqRuleObject is a rule in validator form.
+func dqRuleObject(r models.DataQualityRule) map[string]any {
+	obj := map[string]any{"type": r.RuleType, "severity": r.Severity}
+	for k, v := range r.Definition {
+		obj[k] = v
+	}
+	return obj
+}
+
+// loadDatasetRules returns the rules of a dataset in validator form, or nil when it has none.
+func loadDatasetRules(gdb *gorm.DB, datasetID uint) []map[string]any {
+	var rules []models.DataQualityRule
+	if gdb == nil || gdb.Where("dataset_id = ?", datasetID).Order("id asc").Find(&rules).Error != nil || len(rules) == 0 {
+		return nil
+	}
+	out := make([]map[string]any, len(rules))
+	for i, r := range rules {
+		out[i] = dqRuleObject(r)
+	}
+	return out
+}
+
+// setDatasetRules replaces the rules of a dataset.
+func setDatasetRules(tx *gorm.DB, datasetID uint, rules []models.DataQualityRule) error {
+	if err := tx.Where("dataset_id = ?", datasetID).Delete(&models.DataQualityRule{}).Error; err != nil {
+		return err
+	}
+	for i := range rules {
+		rules[i].ID, rules[i].DatasetID = 0, datasetID
+	}
+	if len(rules) > 0 {
+		if err := tx.Create(&rules).Error; err != nil {
+			return err
+		}
+	}
+	return mirrorDatasetRules(tx, datasetID)
+}
+
+// mirrorDatasetRules copies the rules of a dataset to Dataset.Rules.
+func mirrorDatasetRules(tx *gorm.DB, datasetID uint) error {
+	text := ""
+	if rules := loadDatasetRules(tx, datasetID); rules != nil {
+		b, _ := json.Marshal(rules)
+		text = string(b)
+	}
+	return tx.Model(&models.Dataset{}).Where("id = ?", datasetID).Update("rules", text).Error
+}
+
+// MigrateDatasetRules moves rules kept only in Dataset.Rules, from before data_quality_rules
+// held them, to data_quality_rules. Rules that do not parse are left where they are.
+func MigrateDatasetRules(gdb *gorm.DB) {
+	var datasets []models.Dataset
+	if err := gdb.Select("id", "rules").Where("COALESCE(rules, '') <> '' AND NOT EXISTS (SELECT 1 FROM data_quality_rules r WHERE r.dataset_id = datasets.id)").
+		Find(&datasets).Error; err != nil {
+		log.Printf("[dq] migrate rules: %v", err)
+		return
+	}
+	for _, ds := range datasets {
+		rules, err := parseDatasetRules(ds.Rules)
+		if err != nil || len(rules) == 0 {
+			log.Printf("[dq] dataset %d: rules not migrated: %v", ds.ID, err)
+			continue
+		}
+		if err := gdb.Transaction(func(tx *gorm.DB) error { return setDatasetRules(tx, ds.ID, rules) }); err != nil {
+			log.Printf("[dq] dataset %d: migrate rules: %v", ds.ID, err)
+		}
+	}
+}
+
+// dqColumns lists the columns a rule definition checks.
+func dqColumns(def models.JSONB) []string {
+	var cols []string
+	if col, ok := def["column"].(string); ok && col != "" {
+		cols = append(cols, col)
+	}
+	if list, ok := def["columns"].([]any); ok {
+		for _, v := range list {
+			if col, ok := v.(string); ok && col != "" {
+				cols = append(cols, col)
+			}
+		}
+	}
+	return cols
+}
+
+func dqFloat(v any) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case float32:
+		return float64(x), true
+	case int:
+		return float64(x), true
+	case int32:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case json.Number:
+		f, err := x.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// dqKey identifies a value for uniqueness and allowed values: numbers compare by value whatever
+// their type, like in the Python validator.
+func dqKey(v any) string {
+	if _, isString := v.(string); !isString {
+		if f, ok := dqFloat(v); ok {
+			return "n:" + strconv.FormatFloat(f, 'g', -1, 64)
+		}
+	}
+	return fmt.Sprintf("%T:%v", v, v)
+}
+
+// RunDQJob checks every rule of a dataset against all its rows and stores a result per rule.
+// The dataset must belong to the job's project.
+func RunDQJob(ctx context.Context, gdb *gorm.DB, job *models.Job) error {
+	var ds models.Dataset
+	if err := gdb.First(&ds, jobUint(job.Metadata["dataset_id"])).Error; err != nil {
+		return services.Permanent(errors.New("dataset_not_found"))
+	}
+	if ds.ProjectID != job.ProjectID {
+		return services.Permanent(errors.New("dataset_not_in_project"))
+	}
+	var rules []models.DataQualityRule
+	if err := gdb.Where("dataset_id = ?", ds.ID).Order("id asc").Find(&rules).Error; err != nil {
+		return err
+	}
+	if err := checkRuleRefs(gdb, &ds, rules); err != nil {
+		return services.Permanent(err)
+	}
+	if err := services.ReportProgress(gdb, job, 10, "reading dataset"); err != nil {
+		return err
+	}
+	rows, err := selectDatasetRows(ctx, gdb, &ds, nil)
+	if errors.Is(err, errTooManyDeltaRows) {
+		// Checking part of the dataset would report it cleaner than it is
+		return services.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	if err := services.ReportProgress(gdb, job, 50, fmt.Sprintf("checking %d rules", len(rules))); err != nil {
+		return err
+	}
+	check, err := newRulesCheck(ctx, gdb, &ds, rules)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		check.add(row.Data)
+	}
+	results := make([]models.DataQualityResult, 0, len(rules))
+	failedRules := 0
+	for i, r := range rules {
+		rc := check.checks[i]
+		if rc.failingCount > 0 {
+			failedRules++
+		}
+		columns := map[string]int{}
+		for col, v := range rc.failed {
+			if col != "" {
+				columns[col] = v.Count
+			}
+		}
+		sort.Ints(rc.failingRows)
+		sample := []map[string]any{}
+		for _, at := range rc.failingRows {
+			if len(sample) == dqSampleRows {
+				break
+			}
+			sample = append(sample, rows[at].Data)
+		}
+		results = append(results, models.DataQualityResult{
+			DatasetID: ds.ID, JobID: &job.ID, RuleID: r.ID, Passed: rc.failingCount == 0,
+			TotalRows: int64(len(rows)), FailedRows: int64(rc.failingCount),
+			Details: models.JSONB{"rule": dqRuleObject(r), "columns": columns, "sample": sample},
+		})
+	}
+	// A job run again after losing its lease replaces the results of the earlier run
+	err = gdb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("job_id = ?", job.ID).Delete(&models.DataQualityResult{}).Error; err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			return nil
+		}
+		return tx.Create(&results).Error
+	})
+	if err != nil {
+		return err
+	}
+	job.Result = models.JSONB{"dataset_id": ds.ID, "rules": len(rules), "failed_rules": failedRules, "total_rows": len(rows)}
+	return nil
+}
+
+// enqueueDQCheck queues a check of a dataset's rules, run for userID.
+func enqueueDQCheck(gdb *gorm.DB, ds *models.Dataset, userID uint, trigger string) (*models.Job, error) {
+	job := models.Job{ID: uuid.New(), Type: dqJobType, Status: "pending", UserID: userID, ProjectID: ds.ProjectID, Metadata: models.JSONB{
+		"dataset_id": ds.ID,
+		"user_id":    userID,
+		"trigger":    trigger,
+	}}
+	if err := gdb.Create(&job).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// enqueueDQCheckAfterChange queues a check of the dataset a change request applied to, if the
+// dataset has rules. Call it in the transaction that completes the change.
+func enqueueDQCheckAfterChange(tx *gorm.DB, cr *models.ChangeRequest) error {
+	var n int64
+	if err := tx.Model(&models.DataQualityRule{}).Where("dataset_id = ?", cr.DatasetID).Count(&n).Error; err != nil || n == 0 {
+		return nil
+	}
+	_, err := enqueueDQCheck(tx, &models.Dataset{ID: cr.DatasetID, ProjectID: cr.ProjectID}, cr.UserID, "change")
+	return err
+}
+
+// dqDataset loads the dataset named by id for a caller with one of the roles, responding when
+// it cannot.
+func dqDataset(c *gin.Context, id string, roles ...string) (*models.Dataset, bool) {
+	n, err := strconv.ParseUint(strings.TrimSpace(id), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dataset_id"})
+		return nil, false
+	}
+	ds, ok := datasetWithAccess(c, uint(n), roles...)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return nil, false
+	}
+	return ds, true
+}
+
+// createDQRule adds a rule to a dataset.
+// Body: { dataset_id, rule_type, definition: {column|columns, ...}, severity?: "block"|"warn" }
+func createDQRule(c *gin.Context) {
+	var body struct {
+		DatasetID  uint         `json:"dataset_id"`
+		RuleType   string       `json:"rule_type"`
+		Definition models.JSONB `json:"definition"`
+		Severity   string       `json:"severity"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_payload"})
+		return
+	}
+	ds, ok := dqDataset(c, strconv.FormatUint(uint64(body.DatasetID), 10), "owner", "contributor")
+	if !ok {
+		return
+	}
+	obj := map[string]any{}
+	for k, v := range body.Definition {
+		obj[k] = v
+	}
+	obj["type"], obj["severity"] = body.RuleType, body.Severity
+	rule, err := parseDQRule(obj)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_rule", "message": err.Error()})
+		return
+	}
+	if err := checkRuleRefs(dbpkg.Get(), ds, []models.DataQualityRule{rule}); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_rule", "message": err.Error()})
+		return
+	}
+	rule.DatasetID = ds.ID
+	err = dbpkg.Get().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&rule).Error; err != nil {
+			return err
+		}
+		return mirrorDatasetRules(tx, ds.ID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db"})
+		return
+	}
+	c.JSON(http.StatusCreated, rule)
+}
+
+// listDQRULES lists the rules of a dataset. GET /api/security/dq/rules?dataset_id=1
+func listDQRULES(c *gin.Context) {
+	ds, ok := dqDataset(c, c.Query("dataset_id"), "owner", "contributor", "viewer")
+	if !ok {
+		return
+	}
+	rules := []models.DataQualityRule{}
+	if err := dbpkg.Get().Where("dataset_id = ?", ds.ID).Order("id asc").Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// deleteDQRule removes a rule from its dataset; its past results stay.
+func deleteDQRule(c *gin.Context) {
+	gdb := dbpkg.Get()
+	var rule models.DataQualityRule
+	if err := gdb.First(&rule, c.Param("ruleId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+		return
+	}
+	if _, ok := dqDataset(c, strconv.FormatUint(uint64(rule.DatasetID), 10), "owner", "contributor"); !ok {
+		return
+	}
+	err := gdb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&rule).Error; err != nil {
+			return err
+		}
+		return mirrorDatasetRules(tx, rule.DatasetID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// createDQResult checks the rules of a dataset now, as a job. Body: { dataset_id }
+func createDQResult(c *gin.Context) {
+	var body struct {
+		DatasetID uint `json:"dataset_id"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_payload"})
+		return
+	}
+	ds, ok := dqDataset(c, strconv.FormatUint(uint64(body.DatasetID), 10), "owner", "contributor")
+	if !ok {
+		return
+	}
+	job, err := enqueueDQCheck(dbpkg.Get(), ds, contextUserID(c), "manual")
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "jobs unavailable"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"job_id": job.ID, "status": job.Status})
+}
+
+// listDQResults lists the results of a dataset, newest first, optionally of one check.
+// GET /api/security/dq/results?dataset_id=1&job_id=...&limit=100
+func listDQResults(c *gin.Context) {
+	ds, ok := dqDataset(c, c.Query("dataset_id"), "owner", "contributor", "viewer")
+	if !ok {
+		return
+	}
+	limit := 100
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 500 {
+		limit = l
+	}
+	q := dbpkg.Get().Where("dataset_id = ?", ds.ID)
+	if id := c.Query("job_id"); id != "" {
+		q = q.Where("job_id = ?", id)
+	}
+	results := []models.DataQualityResult{}
+	if err := q.Order("id desc").Limit(limit).Find(&results).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// dqRun sums up one check of a dataset's rules.
+type dqRun struct {
+	JobID       string    `json:"job_id"`
+	LastID      uint64    `json:"-"`
+	CheckedAt   time.Time `json:"checked_at" gorm:"-"`
+	TotalRows   int64     `json:"total_rows"`
+	Rules       int64     `json:"rules"`
+	FailedRules int64     `json:"failed_rules"`
+	FailedRows  int64     `json:"failed_rows"`
+}
+
+// DatasetDQGet returns the rules of a dataset, the results of its latest check and the trend of
+// its last checks, oldest first. GET /api/datasets/:id/dq?runs=30
+func DatasetDQGet(c *gin.Context) {
+	ds, ok := dqDataset(c, c.Param("id"), "owner", "contributor", "viewer")
+	if !ok {
+		return
+	}
+	runs := 30
+	if n, err := strconv.Atoi(c.Query("runs")); err == nil && n > 0 && n <= 365 {
+		runs = n
+	}
+	gdb := dbpkg.Get()
+	trend := []dqRun{}
+	err := gdb.Model(&models.DataQualityResult{}).
+		Select("job_id, MAX(id) AS last_id, MAX(total_rows) AS total_rows, COUNT(*) AS rules, "+
+			"SUM(CASE WHEN passed THEN 0 ELSE 1 END) AS failed_rules, SUM(failed_rows) AS failed_rows").
+		Where("dataset_id = ? AND job_id IS NOT NULL", ds.ID).
+		Group("job_id").Order("last_id desc").Limit(runs).Scan(&trend).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db"})
+		return
+	}
+	// A check is as old as its last result; not every driver scans MAX of a timestamp as one
+	lastIDs := make([]uint64, len(trend))
+	for i, run := range trend {
+		lastIDs[i] = run.LastID
+	}
+	var stamps []models.DataQualityResult
+	if len(lastIDs) > 0 {
+		if err := gdb.Select("id", "created_at").Where("id IN ?", lastIDs).Find(&stamps).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db"})
+			return
+		}
+	}
+	checkedAt := map[uint64]time.Time{}
+	for _, r := range stamps {
+		checkedAt[r.ID] = r.CreatedAt
+	}
+	for i := range trend {
+		trend[i].CheckedAt = checkedAt[trend[i].LastID]
+	}
+	latest := []models.DataQualityResult{}
+	if len(trend) > 0 {
+		if err := gdb.Where("job_id = ?", trend[0].JobID).Order("rule_id asc").Find(&latest).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db"})
+			return
+		}
+	}
+	for i, j := 0, len(trend)-1; i < j; i, j = i+1, j-1 {
+		trend[i], trend[j] = trend[j], trend[i]
+	}
+	rules := loadDatasetRules(gdb, ds.ID)
+	if rules == nil {
+		rules = []map[string]any{}
+	}
+	c.JSON(http.StatusOK, gin.H{"dataset_id": ds.ID, "rules": rules, "latest": latest, "trend": trend})
+}
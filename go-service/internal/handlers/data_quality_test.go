@@ -0,0 +1,184 @@
+package handlers
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/config"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+    services "github.com/oreo-io/oreo.io-v2/go-service/internal/service"
+)
+
+func TestDQ_RulesJobsAndTrend(t *testing.T) {
+    gdb, r := rowChangeEnv(t)
+    if err := gdb.AutoMigrate(&models.DataQualityRule{}, &models.DataQualityResult{}); err != nil {
+        t.Fatalf("migrate: %v", err)
+    }
+    gdb.Exec(`CREATE TABLE jobs (id text primary key, type text, status text, metadata text, result text, user_id integer, project_id integer,
+        progress integer not null default 0, progress_message text, schedule_id integer, scheduled_for datetime, cancel_requested_at datetime, attempts integer not null default 0, run_at datetime, last_error text, locked_by text, lease_expires_at datetime, created_at datetime, updated_at datetime)`)
+    gdb.Create(&models.ProjectRole{ProjectID: 1, UserID: 3, Role: "viewer"})
+    services.RegisterJobType(dqJobType, DQJobType)
+    r.POST("/security/dq/rules", createDQRule)
+    r.GET("/security/dq/rules", listDQRULES)
+    r.DELETE("/security/dq/rules/:ruleId", deleteDQRule)
+    r.POST("/security/dq/results", createDQResult)
+    r.GET("/security/dq/results", listDQResults)
+    r.POST("/datasets/:id/rules", DatasetRulesSet)
+    r.GET("/datasets/:id/dq", DatasetDQGet)
+
+    ds := models.Dataset{ID: 5, ProjectID: 1, Name: "people", Schema: peopleSchema}
+    gdb.Create(&ds)
+    if err := ensureDatasetTable(gdb, &ds); err != nil {
+        t.Fatalf("ensure table: %v", err)
+    }
+    for _, row := range []string{`{"id":1,"name":"ann","age":31}`, `{"id":2,"name":"bob","age":142}`, `{"id":2,"name":null,"age":"seven"}`} {
+        gdb.Exec("INSERT INTO ds_5 (data) VALUES (?)", row)
+    }
+
+    // Legacy free-text rules move to data_quality_rules
+    gdb.Model(&ds).Update("rules", `[{"type":"unique","column":"id"}]`)
+    MigrateDatasetRules(gdb)
+    if rules := loadDatasetRules(gdb, 5); len(rules) != 1 || rules[0]["severity"] != "block" {
+        t.Fatalf("migrated rules: %v", rules)
+    }
+
+    if code, resp := doJSON(t, r, "POST", "/datasets/5/rules", 1, gin.H{"rules": `[{"type":"range","column":"age"}]`}); code != 400 || resp["error"] != "invalid_rules" {
+        t.Fatalf("invalid rules: %d %v", code, resp)
+    }
+    if code, _ := doJSON(t, r, "POST", "/security/dq/rules", 3, gin.H{"dataset_id": 5, "rule_type": "not_null", "definition": gin.H{"columns": []string{"name"}}}); code != 403 {
+        t.Fatalf("viewer create: %d", code)
+    }
+    if code, resp := doJSON(t, r, "POST", "/security/dq/rules", 2, gin.H{"dataset_id": 5, "rule_type": "regex", "definition": gin.H{"column": "name", "pattern": "("}}); code != 400 {
+        t.Fatalf("bad pattern: %d %v", code, resp)
+    }
+    code, resp := doJSON(t, r, "POST", "/security/dq/rules", 2, gin.H{"dataset_id": 5, "rule_type": "range", "definition": gin.H{"column": "age", "min": 0, "max": 120}, "severity": "warn"})
+    if code != 201 || resp["severity"] != "warn" {
+        t.Fatalf("create range: %d %v", code, resp)
+    }
+    code, resp = doJSON(t, r, "POST", "/security/dq/rules", 2, gin.H{"dataset_id": 5, "rule_type": "not_null", "definition": gin.H{"columns": []string{"name"}}})
+    if code != 201 {
+        t.Fatalf("create not_null: %d %v", code, resp)
+    }
+    notNullID := int(resp["id"].(float64))
+    if code, resp := doJSON(t, r, "GET", "/security/dq/rules?dataset_id=5", 3, nil); code != 200 || len(resp["rules"].([]any)) != 3 {
+        t.Fatalf("list rules: %d %v", code, resp)
+    }
+    gdb.First(&ds, 5)
+    if mirrored, _ := parseDatasetRules(ds.Rules); len(mirrored) != 3 || mirrored[2].RuleType != "not_null" {
+        t.Fatalf("mirrored rules: %s", ds.Rules)
+    }
+
+    check := func() string {
+        t.Helper()
+        code, resp := doJSON(t, r, "POST", "/security/dq/results", 2, gin.H{"dataset_id": 5})
+        if code != 202 || resp["status"] != "pending" {
+            t.Fatalf("check: %d %v", code, resp)
+        }
+        if !services.RunPendingJob(gdb) {
+            t.Fatalf("no job ran")
+        }
+        var job models.Job
+        gdb.First(&job, "id = ?", resp["job_id"])
+        if job.Status != "success" || job.Result["failed_rules"] != float64(3) || job.Result["total_rows"] != float64(3) {
+            t.Fatalf("job: %+v", job)
+        }
+        return job.ID.String()
+    }
+    if code, _ := doJSON(t, r, "POST", "/security/dq/results", 3, gin.H{"dataset_id": 5}); code != 403 {
+        t.Fatalf("viewer check: %d", code)
+    }
+    first := check()
+    code, resp = doJSON(t, r, "GET", "/security/dq/results?dataset_id=5&job_id="+first, 3, nil)
+    results, _ := resp["results"].([]any)
+    if code != 200 || len(results) != 3 {
+        t.Fatalf("results: %d %v", code, resp)
+    }
+    for _, res := range results {
+        res := res.(map[string]any)
+        details := res["details"].(map[string]any)
+        want := map[string]float64{"unique": 2, "range": 2, "not_null": 1}[details["rule"].(map[string]any)["type"].(string)]
+        if res["passed"] != false || res["total_rows"] != float64(3) || res["failed_rows"] != want || len(details["sample"].([]any)) != int(want) {
+            t.Fatalf("result: %v", res)
+        }
+    }
+
+    // With the rows fixed and not_null gone, the next check passes both remaining rules
+    gdb.Exec(`UPDATE ds_5 SET data = '{"id":3,"name":"cy","age":7}' WHERE data LIKE '%null%'`)
+    if code, _ := doJSON(t, r, "DELETE", fmt.Sprintf("/security/dq/rules/%d", notNullID), 2, nil); code != 200 {
+        t.Fatalf("delete rule: %d", code)
+    }
+    gdb.Exec(`UPDATE ds_5 SET data = '{"id":2,"name":"bob","age":42}' WHERE data LIKE '%142%'`)
+    code, resp = doJSON(t, r, "POST", "/security/dq/results", 2, gin.H{"dataset_id": 5})
+    if code != 202 || !services.RunPendingJob(gdb) {
+        t.Fatalf("second check: %d %v", code, resp)
+    }
+    var second models.Job
+    gdb.First(&second, "id = ?", resp["job_id"])
+    if second.Status != "success" || second.Result["rules"] != float64(2) || second.Result["failed_rules"] != float64(0) {
+        t.Fatalf("second job: %+v", second)
+    }
+
+    code, resp = doJSON(t, r, "GET", "/datasets/5/dq", 3, nil)
+    trend, _ := resp["trend"].([]any)
+    if code != 200 || len(trend) != 2 || len(resp["rules"].([]any)) != 2 || len(resp["latest"].([]any)) != 2 {
+        t.Fatalf("dq: %d %v", code, resp)
+    }
+    if old, last := trend[0].(map[string]any), trend[1].(map[string]any); old["job_id"] != first || old["failed_rules"] != float64(3) ||
+        old["failed_rows"] != float64(5) || last["job_id"] != second.ID.String() || last["failed_rules"] != float64(0) {
+        t.Fatalf("trend: %v", trend)
+    }
+
+    // Applying a change to a dataset with rules queues a check in the same transaction
+    cr := models.ChangeRequest{ProjectID: 1, DatasetID: 5, UserID: 1}
+    if err := enqueueDQCheckAfterChange(gdb, &cr); err != nil {
+        t.Fatalf("enqueue after change: %v", err)
+    }
+    var pending models.Job
+    if err := gdb.Where("type = ? AND status = ?", dqJobType, "pending").First(&pending).Error; err != nil || pending.Metadata["trigger"] != "change" || pending.UserID != 1 {
+        t.Fatalf("pending check: %v %+v", err, pending)
+    }
+}
+
+func TestDQ_FailsPastDeltaRowCap(t *testing.T) {
+    gdb, _ := rowChangeEnv(t)
+    if err := gdb.AutoMigrate(&models.DataQualityRule{}, &models.DataQualityResult{}); err != nil {
+        t.Fatalf("migrate: %v", err)
+    }
+    // The Python service has one row more than may be read
+    py := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        var body struct {
+            Limit int `json:"limit"`
+        }
+        json.NewDecoder(req.Body).Decode(&body)
+        out := bufio.NewWriter(w)
+        out.WriteString(`{"columns":["id"],"rows":[[0]`)
+        for i := 1; i < body.Limit && i <= maxDeltaRowSelect; i++ {
+            out.WriteString(",[0]")
+        }
+        out.WriteString("]}")
+        out.Flush()
+    }))
+    defer py.Close()
+    t.Setenv("PYTHON_SERVICE_URL", py.URL)
+    if _, err := config.Load(); err != nil {
+        t.Fatalf("config: %v", err)
+    }
+    gdb.Exec(`CREATE TABLE jobs (id text primary key, type text, status text, metadata text, result text, user_id integer, project_id integer,
+        progress integer not null default 0, progress_message text, schedule_id integer, scheduled_for datetime, cancel_requested_at datetime, attempts integer not null default 0, run_at datetime, last_error text, locked_by text, lease_expires_at datetime, created_at datetime, updated_at datetime)`)
+    gdb.Create(&models.Dataset{ID: 7, ProjectID: 1, Name: "orders", StorageBackend: "delta", TargetSchema: "sales", TargetTable: "orders"})
+
+    job := &models.Job{ID: uuid.New(), Type: dqJobType, Status: "running", ProjectID: 1, Metadata: models.JSONB{"dataset_id": 7}}
+    gdb.Create(job)
+    err := RunDQJob(context.Background(), gdb, job)
+    if !errors.Is(err, errTooManyDeltaRows) {
+        t.Fatalf("capped read: %v", err)
+    }
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/csv"
 	"encoding/json"
@@ -21,6 +22,7 @@ import (
 	"github.com/oreo-io/oreo.io-v2/go-service/internal/config"
 	dbpkg "github.com/oreo-io/oreo.io-v2/go-service/internal/database"
 	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/storage"
 	"github.com/oreo-io/oreo.io-v2/go-service/internal/utils"
 	"gorm.io/gorm"
 )
@@ -263,8 +265,12 @@ func upsertDatasetMeta(gdb *gorm.DB, ds *models.Dataset) {
 	// Count rows
 	var rows int64
 	tbl := datasetPhysicalTable(ds)
-	// For delta backend, try to get stats from Python service
-	if strings.EqualFold(ds.StorageBackend, "delta") {
+	// For delta backend, try to get stats from Python service (or the table itself for delta-native)
+	if nd, ok := nativeDelta(ds); ok {
+		if st, err := nd.Stats(context.Background(), storage.DeltaTableID(ds.ProjectID, ds.ID)); err == nil {
+			rows = st.NumRows
+		}
+	} else if isDeltaBackend(ds) {
 		// Attempt to get Delta table stats from Python service using hierarchical path
 		pyBase := getPythonServiceURL()
 		url := fmt.Sprintf("%s/delta/table-info?project_id=%d&dataset_id=%d", pyBase, ds.ProjectID, ds.ID)
@@ -296,7 +302,7 @@ func upsertDatasetMeta(gdb *gorm.DB, ds *models.Dataset) {
 	}
 
 	// Fallback: for non-delta, sample one row to infer columns count if schema failed
-	if cols == 0 && rows > 0 && !strings.EqualFold(ds.StorageBackend, "delta") {
+	if cols == 0 && rows > 0 && !isDeltaBackend(ds) {
 		if r1, err := gdb.Raw(fmt.Sprintf("SELECT data FROM %s LIMIT 1", tbl)).Rows(); err == nil {
 			defer r1.Close()
 			if r1.Next() {
@@ -360,10 +366,17 @@ func upsertDatasetMeta(gdb *gorm.DB, ds *models.Dataset) {
 }
 
 // ensureDeltaTable calls the Python service to create an empty Delta table for this dataset.
+// delta-native datasets create the table in-process instead.
 func ensureDeltaTable(ds *models.Dataset) error {
 	if ds == nil {
 		return fmt.Errorf("nil dataset")
 	}
+	if nd, ok := nativeDelta(ds); ok {
+		if err := nd.EnsureTable(context.Background(), storage.DeltaTableID(ds.ProjectID, ds.ID), deltaColumnsFromSchema(ds.Schema)); err != nil {
+			return fmt.Errorf("ensure_failed: %s", err.Error())
+		}
+		return nil
+	}
 	pyBase := getPythonServiceURL()
 	// Build schema object from ds.Schema if JSON; if empty or invalid use {} to satisfy pydantic Dict expectation
 	var schemaObj any
@@ -556,7 +569,7 @@ func DatasetsCreate(c *gin.Context) {
 		return
 	}
 	// Backend-specific ensure logic
-	if strings.EqualFold(ds.StorageBackend, "delta") {
+	if isDeltaBackend(&ds) {
 		// Call python /delta/ensure to create empty delta table (using dataset ID as table name)
 		if err := ensureDeltaTable(&ds); err != nil {
 			// Surface failure to client; delete the just-created dataset row to avoid dangling metadata
@@ -691,7 +704,7 @@ func DatasetsCreateTop(c *gin.Context) {
 		return
 	}
 	// Ensure physical storage depending on backend
-	if strings.EqualFold(ds.StorageBackend, "delta") {
+	if isDeltaBackend(&ds) {
 		if err := ensureDeltaTable(&ds); err != nil {
 			_ = gdb.Delete(&models.Dataset{}, ds.ID).Error
 			c.JSON(500, gin.H{"error": "delta_ensure_failed", "message": err.Error()})
@@ -782,8 +795,18 @@ func DatasetsPrepare(c *gin.Context) {
 			return
 		}
 		// Ensure physical table or delta path, with schema inference via Python service if delta
-		if strings.EqualFold(ds.StorageBackend, "delta") {
-			if strings.TrimSpace(ds.Schema) == "" {
+		if isDeltaBackend(&ds) {
+			if _, native := nativeDelta(&ds); native && strings.TrimSpace(ds.Schema) == "" {
+				// delta-native: infer locally so dataset creation does not depend on Python
+				recs, perr := recordsFromUpload(contentBytes, header.Filename)
+				if perr != nil {
+					_ = gdb.Delete(&models.Dataset{}, ds.ID).Error
+					c.JSON(400, gin.H{"error": "unsupported_format", "message": "Only .csv and .json are supported."})
+					return
+				}
+				ds.Schema = inferSchemaFromRecords(recs)
+				_ = gdb.Model(&ds).Update("schema", ds.Schema).Error
+			} else if strings.TrimSpace(ds.Schema) == "" {
 				// Call python /infer-schema
 				pyBase := getPythonServiceURL()
 				var mpBuf bytes.Buffer
@@ -847,7 +870,13 @@ func DatasetsPrepare(c *gin.Context) {
 			return
 		}
 		// Perform initial ingest
-		if strings.EqualFold(ds.StorageBackend, "delta") {
+		if nd, ok := nativeDelta(&ds); ok {
+			if _, err2 := nativeDeltaAppend(c.Request.Context(), nd, &ds, contentBytes, header.Filename); err2 != nil {
+				_ = gdb.Delete(&models.Dataset{}, ds.ID).Error
+				c.JSON(500, gin.H{"error": "ingest_failed", "message": err2.Error()})
+				return
+			}
+		} else if isDeltaBackend(&ds) {
 			pyBase := getPythonServiceURL()
 			var mpBuf bytes.Buffer
 			mw := multipart.NewWriter(&mpBuf)
@@ -1065,7 +1094,7 @@ func DatasetsFinalize(c *gin.Context) {
 	}
 
 	// Ensure Delta table structure
-	if strings.EqualFold(ds.StorageBackend, "delta") {
+	if isDeltaBackend(&ds) {
 		if err := ensureDeltaTable(&ds); err != nil {
 			log.Printf("DatasetsFinalize: ensureDeltaTable failed: %v", err)
 			_ = gdb.Delete(&models.Dataset{}, ds.ID).Error
@@ -1269,8 +1298,15 @@ func DatasetRulesSet(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "invalid_payload"})
 		return
 	}
-	ds.Rules = body.Rules
-	if err := gdb.Save(ds).Error; err != nil {
+	rules, err := parseDatasetRules(body.Rules)
+	if err == nil {
+		err = checkRuleRefs(gdb, ds, rules)
+	}
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid_rules", "message": err.Error()})
+		return
+	}
+	if err := gdb.Transaction(func(tx *gorm.DB) error { return setDatasetRules(tx, ds.ID, rules) }); err != nil {
 		c.JSON(500, gin.H{"error": "db"})
 		return
 	}
@@ -1308,7 +1344,7 @@ func DatasetDataGet(c *gin.Context) {
 	}
 
 	// Handle Delta storage backend
-	if strings.EqualFold(ds.StorageBackend, "delta") {
+	if isDeltaBackend(ds) {
 		nStr := c.Query("limit")
 		if nStr == "" {
 			nStr = "50"
@@ -1320,6 +1356,30 @@ func DatasetDataGet(c *gin.Context) {
 		n, _ := strconv.Atoi(nStr)
 		off, _ := strconv.Atoi(offStr)
 
+		// delta-native: read the table in-process
+		if nd, ok := nativeDelta(ds); ok {
+			res, err := nd.Query(c.Request.Context(), storage.QueryRequest{DatasetID: storage.DeltaTableID(ds.ProjectID, ds.ID), Limit: n, Offset: off})
+			if err != nil {
+				c.JSON(500, gin.H{"error": "delta_query_failed", "details": err.Error()})
+				return
+			}
+			dataRows := make([]map[string]interface{}, 0, len(res.Rows))
+			for _, row := range res.Rows {
+				rowMap := make(map[string]interface{})
+				for i, col := range res.Columns {
+					if i < len(row) {
+						rowMap[col] = row[i]
+					}
+				}
+				dataRows = append(dataRows, rowMap)
+			}
+			c.JSON(200, gin.H{
+				"data":    dataRows,
+				"columns": res.Columns,
+			})
+			return
+		}
+
 		// Get the table location from metadata
 		gdb := dbpkg.Get()
 		if gdb == nil {
@@ -1350,7 +1410,7 @@ func DatasetDataGet(c *gin.Context) {
 		queryReq := map[string]any{
 			"sql": fmt.Sprintf("SELECT * FROM %s", tableLocation),
 			"table_mappings": map[string]string{
-				tableLocation: fmt.Sprintf("%d/%d", ds.ProjectID, ds.ID),
+				tableLocation: storage.DeltaTableID(ds.ProjectID, ds.ID),
 			},
 			"limit":  n,
 			"offset": off,
@@ -1524,7 +1584,7 @@ func DatasetStats(c *gin.Context) {
 	}
 	if stats["row_count"] == nil || stats["row_count"] == int64(0) {
 		// For delta backend, try to refresh metadata
-		if strings.EqualFold(ds.StorageBackend, "delta") {
+		if isDeltaBackend(ds) {
 			fmt.Printf("DEBUG DatasetStats: row_count is 0 for Delta dataset, refreshing metadata\n")
 			upsertDatasetMeta(gdb, ds)
 			// Re-fetch metadata after update
@@ -1541,6 +1601,13 @@ func DatasetStats(c *gin.Context) {
 	var pending int64
 	_ = gdb.Model(&models.ChangeRequest{}).Where("dataset_id = ? AND status = ?", ds.ID, "pending").Count(&pending)
 	stats["pending_approvals"] = pending
+	// Open requests past their deadline the sweep has not expired yet, and approved appends
+	// waiting for their merge window
+	var overdue, scheduled int64
+	_ = gdb.Model(&models.ChangeRequest{}).Where("dataset_id = ? AND status IN ? AND due_at <= ?", ds.ID, openChangeStatuses, time.Now()).Count(&overdue)
+	_ = gdb.Model(&models.ChangeRequest{}).Where("dataset_id = ? AND status = ?", ds.ID, "scheduled").Count(&scheduled)
+	stats["overdue_approvals"] = overdue
+	stats["scheduled_merges"] = scheduled
 	c.JSON(200, stats)
 }
 
@@ -1685,6 +1752,14 @@ func DatasetsUpdate(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "invalid_payload"})
 		return
 	}
+	rules, err := parseDatasetRules(in.Rules)
+	if err == nil {
+		err = checkRuleRefs(gdb, &ds, rules)
+	}
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid_rules", "message": err.Error()})
+		return
+	}
 	ds.Name = in.Name
 	ds.Schema = in.Schema
 	// If a target DSN was provided in the payload, parse and store structured fields
@@ -1696,13 +1771,17 @@ func DatasetsUpdate(c *gin.Context) {
 		ds.TargetSchema = schemaName
 		ds.TargetTable = tableName
 	}
-	if in.Rules != "" {
-		ds.Rules = in.Rules
-	}
 	if err := gdb.Save(&ds).Error; err != nil {
 		c.JSON(409, gin.H{"error": "name_conflict"})
 		return
 	}
+	if in.Rules != "" {
+		if err := gdb.Transaction(func(tx *gorm.DB) error { return setDatasetRules(tx, ds.ID, rules) }); err != nil {
+			c.JSON(500, gin.H{"error": "db"})
+			return
+		}
+		_ = gdb.First(&ds, ds.ID).Error
+	}
 	c.JSON(200, ds)
 }
 
@@ -1763,7 +1842,13 @@ func DatasetsDelete(c *gin.Context) {
 		if err := tx.Where("project_id = ? AND dataset_id = ?", pid, ds.ID).Delete(&models.DatasetUpload{}).Error; err != nil {
 			return err
 		}
-		// Delete change requests
+		// Delete change requests with their reviewers and revisions
+		if err := tx.Where("change_request_id IN (?)", tx.Model(&models.ChangeRequest{}).Select("id").Where("project_id = ? AND dataset_id = ?", pid, ds.ID)).Delete(&models.ChangeRequestReviewer{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("change_request_id IN (?)", tx.Model(&models.ChangeRequest{}).Select("id").Where("project_id = ? AND dataset_id = ?", pid, ds.ID)).Delete(&models.ChangeRequestRevision{}).Error; err != nil {
+			return err
+		}
 		if err := tx.Where("project_id = ? AND dataset_id = ?", pid, ds.ID).Delete(&models.ChangeRequest{}).Error; err != nil {
 			return err
 		}
@@ -1771,10 +1856,13 @@ func DatasetsDelete(c *gin.Context) {
 		if err := tx.Where("dataset_id = ?", ds.ID).Delete(&models.DatasetVersion{}).Error; err != nil {
 			return err
 		}
-		// Delete data quality rules
+		// Delete data quality rules and their results
 		if err := tx.Where("dataset_id = ?", ds.ID).Delete(&models.DataQualityRule{}).Error; err != nil {
 			return err
 		}
+		if err := tx.Where("dataset_id = ?", ds.ID).Delete(&models.DataQualityResult{}).Error; err != nil {
+			return err
+		}
 		// Delete metadata
 		if err := tx.Where("dataset_id = ?", ds.ID).Delete(&models.DatasetMeta{}).Error; err != nil {
 			return err
@@ -1951,6 +2039,10 @@ func AppendUpload(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "reviewer_not_member"})
 		return
 	}
+	reviewersAll, okReviewers := applyPolicyReviewers(c, gdb, &ds, contextUserID(c), []uint{reviewerID})
+	if !okReviewers {
+		return
+	}
 	defer file.Close()
 	if header != nil && header.Size > maxUploadBytes {
 		respondTooLarge(c)
@@ -2052,23 +2144,9 @@ func AppendUpload(c *gin.Context) {
 	// Store payload as a small JSON with reference to upload id and filename
 	payloadObj := map[string]any{"upload_id": up.ID, "filename": up.Filename}
 	pb, _ := json.Marshal(payloadObj)
-	// Initialize reviewer state for single reviewer path
-	rs := []map[string]any{}
-	if reviewerID != 0 {
-		rs = append(rs, map[string]any{"id": reviewerID, "status": "pending", "decided_at": nil})
-	}
-	rsJSON, _ := json.Marshal(rs)
-	cr := models.ChangeRequest{ProjectID: uint(pid), DatasetID: ds.ID, Type: "append", Status: "pending", Title: "Append data", Payload: string(pb), ReviewerID: reviewerID, ReviewerStates: string(rsJSON)}
-	if uid, exists := c.Get("user_id"); exists {
-		switch v := uid.(type) {
-		case float64:
-			cr.UserID = uint(v)
-		case int:
-			cr.UserID = uint(v)
-		case uint:
-			cr.UserID = v
-		}
-	}
+	// The picked reviewer plus any the approval policy requires
+	cr := models.ChangeRequest{ProjectID: uint(pid), DatasetID: ds.ID, Type: "append", Status: "pending", Title: "Append data", Payload: string(pb), ReviewerID: reviewerID, Reviewers: newReviewerRows(reviewersAll), UserID: contextUserID(c)}
+	stampChangeBase(gdb, &ds, &cr)
 	if err := gdb.Create(&cr).Error; err != nil {
 		c.JSON(500, gin.H{"error": "db"})
 		return
@@ -2078,10 +2156,8 @@ func AppendUpload(c *gin.Context) {
 	_ = ensureStagingTable(gdb, ds.ID, cr.ID)
 	_ = ingestBytesToTable(gdb, up.Content, up.Filename, stagingTbl)
 	rowCount := countStagingRows(gdb, stagingTbl)
-	// Notify reviewer if present
-	if reviewerID != 0 {
-		_ = AddNotification(reviewerID, "You were requested to review a change", models.JSONB{"type": "reviewer_assigned", "project_id": uint(pid), "dataset_id": ds.ID, "change_request_id": cr.ID, "title": "Append data"})
-	}
+	// Notify reviewers
+	_ = AddNotificationsBulk(reviewersAll, "You were requested to review a change", models.JSONB{"type": "reviewer_assigned", "project_id": uint(pid), "dataset_id": ds.ID, "change_request_id": cr.ID, "title": "Append data"})
 	// Record audit event for CR creation
 	crID := cr.ID
 	_ = RecordAuditEvent(cr.ProjectID, cr.DatasetID, cr.UserID, models.AuditEventTypeCRCreated,
@@ -2091,6 +2167,9 @@ func AppendUpload(c *gin.Context) {
 		models.AuditEventSummary{RowsAdded: rowCount},
 		nil,
 	)
+	if rows, err := recordsFromUpload(up.Content, up.Filename); err == nil && autoApproveAppend(c, gdb, &ds, &cr, rows) {
+		return
+	}
 	c.JSON(201, gin.H{"ok": true, "change_request": cr})
 }
 
@@ -2226,6 +2305,21 @@ func AppendValidate(c *gin.Context) {
 		return
 	}
 
+	// CSV and JSON files are checked in process; other formats go through the Python service
+	if nativeUpload(header.Filename) {
+		check, err := newAppendCheck(c.Request.Context(), gdb, &ds)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid_rules", "message": err.Error()})
+			return
+		}
+		if err := check.readFile(up.Content, header.Filename); err != nil {
+			c.JSON(400, gin.H{"error": "invalid_file", "upload_id": up.ID, "message": err.Error()})
+			return
+		}
+		c.JSON(200, check.response(up.ID))
+		return
+	}
+
 	pyBase := getPythonServiceURL()
 	if pyBase == "" {
 		pyBase = "http://python-service:8000"
@@ -2248,41 +2342,16 @@ func AppendValidate(c *gin.Context) {
 			defer cmpResp.Body.Close()
 			cmpBody, _ := io.ReadAll(cmpResp.Body)
 			var cmpResult struct {
-				Compatible     bool     `json:"compatible"`
-				MissingColumns []string `json:"missing_columns"`
-				ExtraColumns   []string `json:"extra_columns"`
-				TypeMismatches []struct {
-					Column       string `json:"column"`
-					ExpectedType string `json:"expected_type"`
-					ActualType   string `json:"actual_type"`
-					Message      string `json:"message"`
-				} `json:"type_mismatches"`
-				Messages []string `json:"messages"`
-				Summary  string   `json:"summary"`
+				Compatible     bool                 `json:"compatible"`
+				MissingColumns []string             `json:"missing_columns"`
+				ExtraColumns   []string             `json:"extra_columns"`
+				TypeMismatches []schemaTypeMismatch `json:"type_mismatches"`
+				Messages       []string             `json:"messages"`
+				Summary        string               `json:"summary"`
 			}
 			if json.Unmarshal(cmpBody, &cmpResult) == nil && !cmpResult.Compatible {
-				// Schema mismatch detected - build user-friendly error
-				var userMessage string
-				if len(cmpResult.MissingColumns) > 0 {
-					userMessage = fmt.Sprintf("The data you are trying to upload does not match the destination schema. Missing columns: %s. Please contact your admin to review the data structure.", strings.Join(cmpResult.MissingColumns, ", "))
-				} else if len(cmpResult.TypeMismatches) > 0 {
-					userMessage = fmt.Sprintf("The data you are trying to upload has column type mismatches. %s. Please contact your admin to review the data format.", cmpResult.TypeMismatches[0].Message)
-				} else {
-					userMessage = "The data you are trying to upload does not match the destination schema. Please contact your admin to review."
-				}
-				schemaMismatch = &gin.H{
-					"ok":              false,
-					"upload_id":       up.ID,
-					"schema_mismatch": true,
-					"error":           "schema_mismatch",
-					"message":         userMessage,
-					"details": gin.H{
-						"missing_columns": cmpResult.MissingColumns,
-						"extra_columns":   cmpResult.ExtraColumns,
-						"type_mismatches": cmpResult.TypeMismatches,
-						"messages":        cmpResult.Messages,
-					},
-				}
+				m := schemaMismatchResponse(up.ID, cmpResult.MissingColumns, cmpResult.ExtraColumns, cmpResult.TypeMismatches, cmpResult.Messages)
+				schemaMismatch = &m
 			}
 		}
 	}
@@ -2430,20 +2499,13 @@ func AppendOpen(c *gin.Context) {
 		c.JSON(404, gin.H{"error": "upload_not_found"})
 		return
 	}
+	cleaned, okReviewers := applyPolicyReviewers(c, gdb, &ds, contextUserID(c), cleaned)
+	if !okReviewers {
+		return
+	}
 	// Create change request and staging ingest
 	payloadObj := map[string]any{"upload_id": up.ID, "filename": up.Filename, "edited_cells": body.EditedCells}
 	pb, _ := json.Marshal(payloadObj)
-	reviewersJSON, _ := json.Marshal(cleaned)
-	// Initialize reviewer states (pending)
-	reviewerStates := make([]map[string]any, 0, len(cleaned))
-	for _, rid := range cleaned {
-		reviewerStates = append(reviewerStates, map[string]any{
-			"id":         rid,
-			"status":     "pending",
-			"decided_at": nil,
-		})
-	}
-	reviewerStatesJSON, _ := json.Marshal(reviewerStates)
 	firstReviewer := uint(0)
 	if len(cleaned) > 0 {
 		firstReviewer = cleaned[0]
@@ -2452,17 +2514,8 @@ func AppendOpen(c *gin.Context) {
 	if title == "" {
 		title = "Append data"
 	}
-	cr := models.ChangeRequest{ProjectID: uint(pid), DatasetID: ds.ID, Type: "append", Status: "pending", Title: title, Payload: string(pb), ReviewerID: firstReviewer, Reviewers: string(reviewersJSON), ReviewerStates: string(reviewerStatesJSON)}
-	if uid, exists := c.Get("user_id"); exists {
-		switch v := uid.(type) {
-		case float64:
-			cr.UserID = uint(v)
-		case int:
-			cr.UserID = uint(v)
-		case uint:
-			cr.UserID = v
-		}
-	}
+	cr := models.ChangeRequest{ProjectID: uint(pid), DatasetID: ds.ID, Type: "append", Status: "pending", Title: title, Payload: string(pb), ReviewerID: firstReviewer, Reviewers: newReviewerRows(cleaned), UserID: contextUserID(c)}
+	stampChangeBase(gdb, &ds, &cr)
 	if err := gdb.Create(&cr).Error; err != nil {
 		c.JSON(500, gin.H{"error": "db"})
 		return
@@ -2505,6 +2558,12 @@ func AppendOpen(c *gin.Context) {
 		models.AuditEventSummary{}, // Stats recorded on merge, not creation
 		nil,
 	)
+	// Edited cells are reviewed by hand even when the rows would qualify for auto-approval
+	if len(body.EditedCells) == 0 {
+		if rows, err := recordsFromUpload(up.Content, up.Filename); err == nil && autoApproveAppend(c, gdb, &ds, &cr, rows) {
+			return
+		}
+	}
 	c.JSON(201, gin.H{"ok": true, "change_request": cr})
 }
 
@@ -2610,6 +2669,10 @@ func AppendJSON(c *gin.Context) {
 		}
 		reviewersAll = cleaned
 	}
+	reviewersAll, okReviewers := applyPolicyReviewers(c, gdb, &ds, contextUserID(c), reviewersAll)
+	if !okReviewers {
+		return
+	}
 	if len(body.Rows) == 0 {
 		c.JSON(400, gin.H{"error": "empty_rows"})
 		return
@@ -2685,27 +2748,12 @@ func AppendJSON(c *gin.Context) {
 
 	payloadObj := map[string]any{"upload_id": up.ID, "filename": up.Filename}
 	pb, _ := json.Marshal(payloadObj)
-	reviewersJSON, _ := json.Marshal(reviewersAll)
 	firstReviewer := uint(0)
 	if len(reviewersAll) > 0 {
 		firstReviewer = reviewersAll[0]
 	}
-	// Initialize reviewer states for edited-rows path
-	reviewerStates2 := make([]map[string]any, 0, len(reviewersAll))
-	for _, rid := range reviewersAll {
-		reviewerStates2 = append(reviewerStates2, map[string]any{
-			"id":         rid,
-			"status":     "pending",
-			"decided_at": nil,
-		})
-	}
-	reviewerStatesJSON2, _ := json.Marshal(reviewerStates2)
-	cr := models.ChangeRequest{ProjectID: uint(pid), DatasetID: ds.ID, Type: "append", Status: "pending", Title: "Append data (edited)", Payload: string(pb), ReviewerID: firstReviewer, Reviewers: string(reviewersJSON), ReviewerStates: string(reviewerStatesJSON2)}
-	if uid, exists := c.Get("user_id"); exists {
-		if u, ok := uid.(uint); ok {
-			cr.UserID = u
-		}
-	}
+	cr := models.ChangeRequest{ProjectID: uint(pid), DatasetID: ds.ID, Type: "append", Status: "pending", Title: "Append data (edited)", Payload: string(pb), ReviewerID: firstReviewer, Reviewers: newReviewerRows(reviewersAll), UserID: contextUserID(c)}
+	stampChangeBase(gdb, &ds, &cr)
 	if err := gdb.Create(&cr).Error; err != nil {
 		c.JSON(500, gin.H{"error": "db"})
 		return
@@ -2738,6 +2786,9 @@ func AppendJSON(c *gin.Context) {
 		models.AuditEventSummary{RowsAdded: rowCount3, CellsChanged: cellsEdited},
 		nil,
 	)
+	if autoApproveAppend(c, gdb, &ds, &cr, body.Rows) {
+		return
+	}
 	c.JSON(201, gin.H{"ok": true, "change_request": cr})
 }
 
@@ -2826,46 +2877,22 @@ func AppendJSONValidate(c *gin.Context) {
 		}
 	}
 
-	// Step 2: Validate via python
-	pyBase := getPythonServiceURL()
-	if pyBase == "" {
-		pyBase = "http://python-service:8000"
-	}
-	var rulesObj any
-	if strings.TrimSpace(ds.Rules) != "" {
-		_ = json.Unmarshal([]byte(ds.Rules), &rulesObj)
+	// Step 2: Validate against the schema and rules
+	check, err := newAppendCheck(c.Request.Context(), gdb, &ds)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid_rules", "message": err.Error()})
+		return
 	}
-	if schemaObj != nil {
-		sb, _ := json.Marshal(gin.H{"json_schema": schemaObj, "data": body.Rows})
-		r, err := http.Post(pyBase+"/validate", "application/json", bytes.NewReader(sb))
-		if err != nil || r == nil {
-			c.JSON(502, gin.H{"error": "python_unreachable"})
-			return
-		}
-		defer r.Body.Close()
-		var vr map[string]any
-		bb, _ := io.ReadAll(r.Body)
-		_ = json.Unmarshal(bb, &vr)
-		if v, _ := vr["valid"].(bool); !v {
-			c.JSON(200, gin.H{"ok": false, "schema": vr})
-			return
-		}
+	check.readRows(body.Rows)
+	idx := check.columnIndex()
+	if vr := check.schemaReport(idx); vr != nil && vr["valid"] != true {
+		c.JSON(200, gin.H{"ok": false, "schema": vr})
+		return
 	}
-	if rulesObj != nil {
-		rb, _ := json.Marshal(gin.H{"rules": rulesObj, "data": body.Rows})
-		r, err := http.Post(pyBase+"/rules/validate", "application/json", bytes.NewReader(rb))
-		if err != nil || r == nil {
-			c.JSON(502, gin.H{"error": "python_unreachable"})
-			return
-		}
-		defer r.Body.Close()
-		var rr map[string]any
-		bb, _ := io.ReadAll(r.Body)
-		_ = json.Unmarshal(bb, &rr)
-		if v, _ := rr["valid"].(bool); !v {
-			c.JSON(200, gin.H{"ok": false, "rules": rr})
-			return
-		}
+	rr := check.rulesReport(idx)
+	if rr != nil && rr["valid"] != true {
+		c.JSON(200, gin.H{"ok": false, "rules": rr})
+		return
 	}
 	// Store upload
 	fname := body.Filename
@@ -2882,5 +2909,6 @@ func AppendJSONValidate(c *gin.Context) {
 		c.JSON(500, gin.H{"error": "db_store_upload"})
 		return
 	}
-	c.JSON(200, gin.H{"ok": true, "upload_id": up.ID})
+	// Rules of severity warn may still have failed
+	c.JSON(200, gin.H{"ok": true, "upload_id": up.ID, "rules": rr})
 }
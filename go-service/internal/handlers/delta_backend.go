@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/storage"
+)
+
+// isDeltaBackend reports whether the dataset lives in a Delta table, either through the Python
+// service ("delta") or the in-process reader/writer ("delta-native").
+func isDeltaBackend(ds *models.Dataset) bool {
+	if ds == nil {
+		return false
+	}
+	b := strings.ToLower(strings.TrimSpace(ds.StorageBackend))
+	return b == "delta" || b == "delta-native"
+}
+
+// nativeDelta returns the in-process Delta adapter for "delta-native" datasets.
+func nativeDelta(ds *models.Dataset) (*storage.DeltaNativeAdapter, bool) {
+	if ds == nil || !strings.EqualFold(strings.TrimSpace(ds.StorageBackend), "delta-native") {
+		return nil, false
+	}
+	a, ok := storage.GetAdapterForDataset(ds).(*storage.DeltaNativeAdapter)
+	return a, ok
+}
+
+// deltaColumnsFromSchema converts a dataset JSON schema ({properties:{...}} or a bare column map)
+// to Delta columns, in declaration order, using the same type mapping as the Python service.
+func deltaColumnsFromSchema(schema string) []storage.DeltaColumn {
+	schema = strings.TrimSpace(schema)
+	if schema == "" {
+		return nil
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(schema), &obj); err != nil {
+		return nil
+	}
+	props := []byte(schema)
+	if p, ok := obj["properties"]; ok {
+		props = p
+	}
+	names, err := orderedJSONKeys(props)
+	if err != nil {
+		return nil
+	}
+	var defs map[string]struct {
+		Type any `json:"type"`
+	}
+	_ = json.Unmarshal(props, &defs)
+	cols := make([]storage.DeltaColumn, 0, len(names))
+	for _, name := range names {
+		def := defs[name]
+		t := ""
+		switch v := def.Type.(type) {
+		case string:
+			t = v
+		case []any:
+			// ["null","integer"] style: first non-null type
+			for _, x := range v {
+				if s, ok := x.(string); ok && s != "null" {
+					t = s
+					break
+				}
+			}
+		}
+		dt := "string"
+		switch strings.ToLower(t) {
+		case "integer":
+			dt = "long"
+		case "number":
+			dt = "double"
+		case "boolean":
+			dt = "boolean"
+		}
+		cols = append(cols, storage.DeltaColumn{Name: name, Type: dt, Nullable: true})
+	}
+	return cols
+}
+
+// orderedJSONKeys returns the top-level keys of a JSON object in document order.
+func orderedJSONKeys(raw []byte) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, fmt.Errorf("not an object")
+	}
+	var keys []string
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, t.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// recordsFromUpload parses an uploaded .csv or .json file into rows. CSV values stay strings,
+// as in ingestCSVToTable; typed Delta columns coerce them on write.
+func recordsFromUpload(content []byte, filename string) ([]map[string]interface{}, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(content))
+		dec.UseNumber()
+		var arr []map[string]interface{}
+		if err := dec.Decode(&arr); err != nil {
+			return nil, err
+		}
+		return arr, nil
+	case ".csv":
+		r := csv.NewReader(bytes.NewReader(content))
+		headers, err := r.Read()
+		if err != nil {
+			return nil, err
+		}
+		var out []map[string]interface{}
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			row := map[string]interface{}{}
+			for i := 0; i < len(headers) && i < len(rec); i++ {
+				row[headers[i]] = rec[i]
+			}
+			out = append(out, row)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unsupported_format")
+	}
+}
+
+// inferSchemaFromRecords builds a dataset JSON schema from parsed rows, standing in for the
+// Python /infer-schema call on delta-native datasets. Columns are ordered as in the first row
+// that has them (CSV header order is lost in maps, so names sort within a row).
+func inferSchemaFromRecords(records []map[string]interface{}) string {
+	order := []string{}
+	types := map[string]string{}
+	for _, rec := range records {
+		keys := make([]string, 0, len(rec))
+		for k := range rec {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			prev, seen := types[k]
+			if !seen {
+				order = append(order, k)
+			}
+			t := inferJSONType(rec[k])
+			switch {
+			case t == "":
+				if !seen {
+					types[k] = ""
+				}
+			case prev == "" || prev == t:
+				types[k] = t
+			case (prev == "integer" && t == "number") || (prev == "number" && t == "integer"):
+				types[k] = "number"
+			default:
+				types[k] = "string"
+			}
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString(`{"type":"object","properties":{`)
+	for i, k := range order {
+		t := types[k]
+		if t == "" {
+			t = "string"
+		}
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		kb, _ := json.Marshal(k)
+		fmt.Fprintf(&sb, `%s:{"type":%q}`, kb, t)
+	}
+	sb.WriteString("}}")
+	return sb.String()
+}
+
+func inferJSONType(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := x.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case float64:
+		return "number"
+	case string:
+		s := strings.TrimSpace(x)
+		if s == "" {
+			return ""
+		}
+		if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return "integer"
+		}
+		if _, err := strconv.ParseFloat(s, 64); err == nil {
+			return "number"
+		}
+		if _, err := strconv.ParseBool(strings.ToLower(s)); err == nil {
+			return "boolean"
+		}
+		return "string"
+	}
+	return "string"
+}
+
+// nativeDeltaAppend parses an uploaded file and appends it to a delta-native dataset.
+func nativeDeltaAppend(ctx context.Context, nd *storage.DeltaNativeAdapter, ds *models.Dataset, content []byte, filename string) (int, error) {
+	recs, err := recordsFromUpload(content, filename)
+	if err != nil {
+		return 0, err
+	}
+	if err := nd.EnsureTable(ctx, storage.DeltaTableID(ds.ProjectID, ds.ID), deltaColumnsFromSchema(ds.Schema)); err != nil {
+		return 0, err
+	}
+	if err := nd.Insert(ctx, storage.DeltaTableID(ds.ProjectID, ds.ID), recs); err != nil {
+		return 0, err
+	}
+	return len(recs), nil
+}
+
+// deltaHistoryEntries returns the table history (newest first) in the shape of the Python
+// /delta/history response, so snapshot and audit views render both backends alike.
+func deltaHistoryEntries(ds *models.Dataset) []map[string]interface{} {
+	if nd, ok := nativeDelta(ds); ok {
+		hist, err := nd.History(context.Background(), storage.DeltaTableID(ds.ProjectID, ds.ID))
+		if err != nil {
+			return nil
+		}
+		entries := make([]map[string]interface{}, 0, len(hist))
+		for _, h := range hist {
+			entry := map[string]interface{}{
+				"version":   float64(h.Version),
+				"timestamp": h.Timestamp,
+				"operation": h.Operation,
+			}
+			if len(h.OperationMetrics) > 0 {
+				// Delta records metrics as strings; the views expect numbers
+				metrics := map[string]interface{}{}
+				for k, v := range h.OperationMetrics {
+					if s, ok := v.(string); ok {
+						if f, err := strconv.ParseFloat(s, 64); err == nil {
+							metrics[k] = f
+							continue
+						}
+					}
+					metrics[k] = v
+				}
+				entry["operationMetrics"] = metrics
+			}
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+	resp, err := http.Get(fmt.Sprintf("%s/delta/history/%d/%d", getPythonServiceURL(), ds.ProjectID, ds.ID))
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	var historyResp struct {
+		History []map[string]interface{} `json:"history"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&historyResp); err != nil {
+		return nil
+	}
+	return historyResp.History
+}
+
+// deltaOperationStats reports the metrics of the latest commit plus the current row count.
+func deltaOperationStats(ds *models.Dataset) (rowsAdded, rowsUpdated, rowsDeleted, totalRows int) {
+	nd, ok := nativeDelta(ds)
+	if !ok {
+		return FetchDeltaOperationStats(ds.ProjectID, ds.ID)
+	}
+	if entries := deltaHistoryEntries(ds); len(entries) > 0 {
+		if metrics, ok := entries[0]["operationMetrics"].(map[string]interface{}); ok {
+			if v, ok := metrics["numOutputRows"].(float64); ok {
+				rowsAdded = int(v)
+			}
+			if v, ok := metrics["numTargetRowsInserted"].(float64); ok {
+				rowsAdded = int(v)
+			}
+			if v, ok := metrics["numTargetRowsUpdated"].(float64); ok {
+				rowsUpdated = int(v)
+			}
+			if v, ok := metrics["numTargetRowsDeleted"].(float64); ok {
+				rowsDeleted = int(v)
+			}
+			if v, ok := metrics["numDeletedRows"].(float64); ok {
+				rowsDeleted = int(v)
+			}
+		}
+	}
+	if st, err := nd.Stats(context.Background(), storage.DeltaTableID(ds.ProjectID, ds.ID)); err == nil {
+		totalRows = int(st.NumRows)
+	}
+	return rowsAdded, rowsUpdated, rowsDeleted, totalRows
+}
+
+// nativeSnapshotData mirrors the Python /delta/snapshot response for a delta-native dataset.
+func nativeSnapshotData(ctx context.Context, nd *storage.DeltaNativeAdapter, ds *models.Dataset, version, limit, offset int) (map[string]interface{}, error) {
+	id := storage.DeltaTableID(ds.ProjectID, ds.ID)
+	all, err := nd.Query(ctx, storage.QueryRequest{DatasetID: id, AsOfVersion: &version})
+	if err != nil {
+		return nil, err
+	}
+	if offset > len(all.Rows) {
+		offset = len(all.Rows)
+	}
+	end := offset + limit
+	if end > len(all.Rows) {
+		end = len(all.Rows)
+	}
+	data := make([]map[string]interface{}, 0, end-offset)
+	for _, row := range all.Rows[offset:end] {
+		rec := map[string]interface{}{}
+		for i, col := range all.Columns {
+			rec[col] = row[i]
+		}
+		data = append(data, rec)
+	}
+	return map[string]interface{}{
+		"columns": all.Columns,
+		"data":    data,
+		"total":   len(all.Rows),
+		"limit":   limit,
+		"offset":  offset,
+		"version": version,
+	}, nil
+}
+
+// nativeRestore restores a delta-native dataset and reports row deltas like Python /delta/restore.
+func nativeRestore(ctx context.Context, nd *storage.DeltaNativeAdapter, ds *models.Dataset, version int) (map[string]interface{}, error) {
+	id := storage.DeltaTableID(ds.ProjectID, ds.ID)
+	before, err := nd.Stats(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := nd.Restore(ctx, id, version); err != nil {
+		return nil, err
+	}
+	after, err := nd.Stats(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	added, deleted := int64(0), int64(0)
+	if after.NumRows > before.NumRows {
+		added = after.NumRows - before.NumRows
+	} else {
+		deleted = before.NumRows - after.NumRows
+	}
+	return map[string]interface{}{
+		"ok":               true,
+		"restored_version": version,
+		"new_version":      after.Version,
+		"rows_added":       float64(added),
+		"rows_deleted":     float64(deleted),
+		"total_rows":       float64(after.NumRows),
+	}, nil
+}
@@ -4,10 +4,24 @@ import (
 	"encoding/json"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	dbpkg "github.com/oreo-io/oreo.io-v2/go-service/internal/database"
 	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
 )
 
+// contextUserID returns the authenticated user's ID, or 0. JWT claims decode as float64.
+func contextUserID(c *gin.Context) uint {
+	switch v := c.Value("user_id").(type) {
+	case float64:
+		return uint(v)
+	case int:
+		return uint(v)
+	case uint:
+		return v
+	}
+	return 0
+}
+
 // AddNotification inserts a notification for a user.
 func AddNotification(userID uint, message string, metadata models.JSONB) error {
 	gdb := dbpkg.Get()
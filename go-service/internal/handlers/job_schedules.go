@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbpkg "github.com/oreo-io/oreo.io-v2/go-service/internal/database"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	services "github.com/oreo-io/oreo.io-v2/go-service/internal/service"
+	"gorm.io/gorm"
+)
+
+// A schedule enqueues a job of its type on a cron expression, e.g. a nightly stats refresh, see
+// services.StartScheduler. Project members see a project's schedules and their runs, which are
+// the jobs they enqueued. Contributors create schedules, whose jobs run as whoever last saved
+// them; the owner of a schedule or of its project may change or delete it.
+
+// scheduleBody is the body of the create and update endpoints; update leaves omitted fields as
+// they are.
+type scheduleBody struct {
+	Name     *string      `json:"name"`
+	Cron     *string      `json:"cron"`
+	Timezone *string      `json:"timezone"`
+	JobType  *string      `json:"job_type"`
+	Metadata models.JSONB `json:"metadata"`
+	Enabled  *bool        `json:"enabled"`
+	Misfire  *string      `json:"misfire"`
+}
+
+// apply sets the fields of the body on s, and returns the error to respond with when they are
+// invalid.
+func (b scheduleBody) apply(s *models.JobSchedule) string {
+	if b.Name != nil {
+		s.Name = strings.TrimSpace(*b.Name)
+	}
+	if b.Cron != nil {
+		s.Cron = strings.TrimSpace(*b.Cron)
+	}
+	if b.Timezone != nil {
+		s.Timezone = strings.TrimSpace(*b.Timezone)
+	}
+	if b.JobType != nil {
+		s.JobType = strings.TrimSpace(*b.JobType)
+	}
+	if b.Metadata != nil {
+		s.Metadata = b.Metadata
+	}
+	if b.Enabled != nil {
+		s.Enabled = *b.Enabled
+	}
+	if b.Misfire != nil {
+		s.Misfire = *b.Misfire
+	}
+	if s.Timezone == "" {
+		s.Timezone = "UTC"
+	}
+	switch {
+	case s.Name == "":
+		return "name_required"
+	case s.Misfire != "run_once" && s.Misfire != "skip":
+		return "invalid_misfire"
+	case !clientJobTypes[s.JobType] || !services.JobTypeRegistered(s.JobType):
+		return "unknown_job_type"
+	}
+	if _, err := services.ParseCron(s.Cron); err != nil {
+		return "invalid_cron"
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return "invalid_timezone"
+	}
+	return ""
+}
+
+// scheduleNextRun sets when s runs next, from now; a disabled schedule does not run.
+func scheduleNextRun(s *models.JobSchedule, now time.Time) {
+	s.NextRunAt = nil
+	if s.Enabled {
+		s.NextRunAt, _ = services.NextScheduleRun(s.Cron, s.Timezone, now)
+		s.LastError = ""
+	}
+}
+
+// scheduleProject checks that the caller is a member of the :id project and returns its id.
+func scheduleProject(c *gin.Context) (uint, bool) {
+	pid, _ := strconv.Atoi(c.Param("id"))
+	if !HasProjectRole(c, uint(pid), "owner", "contributor", "viewer") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return 0, false
+	}
+	return uint(pid), true
+}
+
+// loadSchedule loads the :scheduleId schedule of the :id project for one of its members.
+func loadSchedule(c *gin.Context, gdb *gorm.DB) (*models.JobSchedule, bool) {
+	pid, ok := scheduleProject(c)
+	if !ok {
+		return nil, false
+	}
+	var s models.JobSchedule
+	if err := gdb.Where("project_id = ?", pid).First(&s, c.Param("scheduleId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "schedule_not_found"})
+		return nil, false
+	}
+	return &s, true
+}
+
+// canManageSchedule reports whether the caller owns the schedule or its project. Either must
+// also still be a contributor, as the schedule's jobs will run as them.
+func canManageSchedule(c *gin.Context, s *models.JobSchedule) bool {
+	if s.OwnerID == contextUserID(c) {
+		return HasProjectRole(c, s.ProjectID, "contributor")
+	}
+	return HasProjectRole(c, s.ProjectID, "owner")
+}
+
+// SchedulesList lists the schedules of a project.
+func SchedulesList(c *gin.Context) {
+	pid, ok := scheduleProject(c)
+	if !ok {
+		return
+	}
+	schedules := []models.JobSchedule{}
+	if err := dbpkg.Get().Where("project_id = ?", pid).Order("id asc").Find(&schedules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"schedules": schedules})
+}
+
+// SchedulesCreate creates a schedule owned by the caller, enabled unless the body says otherwise.
+// Body: { name, cron, timezone?: "Europe/Paris", job_type, metadata?: {..}, enabled?, misfire?: "run_once"|"skip" }
+func SchedulesCreate(c *gin.Context) {
+	pid, ok := scheduleProject(c)
+	if !ok {
+		return
+	}
+	if !HasProjectRole(c, pid, "contributor") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	var body scheduleBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_payload"})
+		return
+	}
+	s := models.JobSchedule{ProjectID: pid, OwnerID: contextUserID(c), Enabled: true, Misfire: "run_once"}
+	if msg := body.apply(&s); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	scheduleNextRun(&s, time.Now())
+	if err := dbpkg.Get().Create(&s).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db"})
+		return
+	}
+	c.JSON(http.StatusCreated, s)
+}
+
+// SchedulesGet returns a schedule.
+func SchedulesGet(c *gin.Context) {
+	s, ok := loadSchedule(c, dbpkg.Get())
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// SchedulesUpdate changes a schedule, which the caller then owns. Its next run is worked out
+// again when its expression, time zone or enabled state change.
+func SchedulesUpdate(c *gin.Context) {
+	gdb := dbpkg.Get()
+	s, ok := loadSchedule(c, gdb)
+	if !ok {
+		return
+	}
+	if !canManageSchedule(c, s) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	var body scheduleBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_payload"})
+		return
+	}
+	before := *s
+	if msg := body.apply(s); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if s.Cron != before.Cron || s.Timezone != before.Timezone || s.Enabled != before.Enabled {
+		scheduleNextRun(s, time.Now())
+	}
+	s.OwnerID = contextUserID(c)
+	if err := gdb.Save(s).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db"})
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// SchedulesDelete deletes a schedule. Its runs stay in the jobs table.
+func SchedulesDelete(c *gin.Context) {
+	gdb := dbpkg.Get()
+	s, ok := loadSchedule(c, gdb)
+	if !ok {
+		return
+	}
+	if !canManageSchedule(c, s) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	if err := gdb.Delete(&models.JobSchedule{}, s.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// ScheduleRunsList lists the jobs a schedule enqueued, latest run first.
+// GET /api/projects/:id/schedules/:scheduleId/runs?status=failed,dead&limit=50&offset=0
+func ScheduleRunsList(c *gin.Context) {
+	gdb := dbpkg.Get()
+	s, ok := loadSchedule(c, gdb)
+	if !ok {
+		return
+	}
+	limit := 50
+	offset := 0
+	if l := c.Query("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+			limit = parsed
+		}
+	}
+	if o := c.Query("offset"); o != "" {
+		if parsed, err := strconv.Atoi(o); err == nil && parsed >= 0 {
+			offset = parsed
+		}
+	}
+	q := gdb.Model(&models.Job{}).Where("schedule_id = ?", s.ID)
+	if st := strings.TrimSpace(c.Query("status")); st != "" {
+		q = q.Where("status IN ?", strings.Split(st, ","))
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db"})
+		return
+	}
+	runs := []models.Job{}
+	if err := q.Order("scheduled_for desc").Limit(limit).Offset(offset).Find(&runs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"runs":   runs,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
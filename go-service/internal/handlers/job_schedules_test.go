@@ -0,0 +1,102 @@
+package handlers
+
+import (
+    "context"
+    "fmt"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+    services "github.com/oreo-io/oreo.io-v2/go-service/internal/service"
+    "gorm.io/gorm"
+)
+
+func TestSchedules_CRUDAndRuns(t *testing.T) {
+    gdb, r := rowChangeEnv(t)
+    if err := gdb.AutoMigrate(&models.JobSchedule{}); err != nil {
+        t.Fatalf("migrate: %v", err)
+    }
+    gdb.Exec(`CREATE TABLE jobs (id text primary key, type text, status text, metadata text, result text, user_id integer, project_id integer,
+        progress integer not null default 0, progress_message text, schedule_id integer, scheduled_for datetime, cancel_requested_at datetime, attempts integer not null default 0, run_at datetime, last_error text, locked_by text, lease_expires_at datetime, created_at datetime, updated_at datetime)`)
+    gdb.Create(&models.ProjectRole{ProjectID: 1, UserID: 3, Role: "viewer"})
+    gdb.Create(&models.ProjectRole{ProjectID: 1, UserID: 4, Role: "contributor"})
+    services.RegisterJobType("test-refresh", services.JobType{Run: func(ctx context.Context, gdb *gorm.DB, job *models.Job) error { return nil }})
+    clientJobTypes["test-refresh"] = true
+    t.Cleanup(func() { delete(clientJobTypes, "test-refresh") })
+    services.RegisterJobType("test-internal", services.JobType{Run: func(ctx context.Context, gdb *gorm.DB, job *models.Job) error { return nil }})
+    r.GET("/projects/:id/schedules", SchedulesList)
+    r.POST("/projects/:id/schedules", SchedulesCreate)
+    r.GET("/projects/:id/schedules/:scheduleId", SchedulesGet)
+    r.PUT("/projects/:id/schedules/:scheduleId", SchedulesUpdate)
+    r.DELETE("/projects/:id/schedules/:scheduleId", SchedulesDelete)
+    r.GET("/projects/:id/schedules/:scheduleId/runs", ScheduleRunsList)
+
+    body := gin.H{"name": "nightly stats", "cron": "0 2 * * *", "timezone": "Europe/Paris", "job_type": "test-refresh", "metadata": gin.H{"dataset_id": 5, "project_id": 2}}
+    if code, _ := doJSON(t, r, "POST", "/projects/1/schedules", 3, body); code != 403 {
+        t.Fatalf("viewer create: %d", code)
+    }
+    for field, bad := range map[string]any{"cron": "0 25 * * *", "timezone": "Mars/Olympus", "job_type": "test-internal", "misfire": "later", "name": " "} {
+        invalid := gin.H{}
+        for k, v := range body {
+            invalid[k] = v
+        }
+        invalid[field] = bad
+        if code, resp := doJSON(t, r, "POST", "/projects/1/schedules", 2, invalid); code != 400 {
+            t.Fatalf("invalid %s: %d %v", field, code, resp)
+        }
+    }
+    code, resp := doJSON(t, r, "POST", "/projects/1/schedules", 2, body)
+    if code != 201 || resp["owner_id"] != float64(2) || resp["enabled"] != true || resp["misfire"] != "run_once" {
+        t.Fatalf("create: %d %v", code, resp)
+    }
+    path := fmt.Sprintf("/projects/1/schedules/%d", int(resp["id"].(float64)))
+    next, _ := time.Parse(time.RFC3339, resp["next_run_at"].(string))
+    if paris, _ := time.LoadLocation("Europe/Paris"); next.In(paris).Hour() != 2 || !next.After(time.Now()) {
+        t.Fatalf("next run: %v", resp["next_run_at"])
+    }
+    if code, resp := doJSON(t, r, "GET", "/projects/1/schedules", 3, nil); code != 200 || len(resp["schedules"].([]any)) != 1 {
+        t.Fatalf("list: %d %v", code, resp)
+    }
+    if code, _ := doJSON(t, r, "GET", path, 5, nil); code != 403 {
+        t.Fatalf("outsider get: %d", code)
+    }
+    if code, _ := doJSON(t, r, "PUT", path, 4, gin.H{"enabled": false}); code != 403 {
+        t.Fatalf("other contributor update: %d", code)
+    }
+
+    // The project owner takes the schedule over; it runs as them
+    if code, resp := doJSON(t, r, "PUT", path, 1, gin.H{"enabled": false}); code != 200 || resp["owner_id"] != float64(1) || resp["next_run_at"] != nil {
+        t.Fatalf("disable: %d %v", code, resp)
+    }
+    code, resp = doJSON(t, r, "PUT", path, 1, gin.H{"enabled": true, "cron": "*/5 * * * *"})
+    if code != 200 || resp["next_run_at"] == nil || resp["name"] != "nightly stats" || resp["timezone"] != "Europe/Paris" {
+        t.Fatalf("enable: %d %v", code, resp)
+    }
+    next, _ = time.Parse(time.RFC3339, resp["next_run_at"].(string))
+    if n := services.EnqueueDueSchedules(gdb, next.Add(10*time.Second)); n != 1 {
+        t.Fatalf("enqueued %d", n)
+    }
+    code, resp = doJSON(t, r, "GET", path+"/runs", 3, nil)
+    runs, _ := resp["runs"].([]any)
+    if code != 200 || resp["total"] != float64(1) || len(runs) != 1 {
+        t.Fatalf("runs: %d %v", code, resp)
+    }
+    run := runs[0].(map[string]any)
+    if meta := run["metadata"].(map[string]any); run["type"] != "test-refresh" || run["user_id"] != float64(1) || meta["dataset_id"] != float64(5) || meta["project_id"] != float64(1) {
+        t.Fatalf("run: %v", run)
+    }
+    if code, resp := doJSON(t, r, "GET", path+"/runs?status=failed", 3, nil); code != 200 || resp["total"] != float64(0) {
+        t.Fatalf("failed runs: %d %v", code, resp)
+    }
+
+    if code, _ := doJSON(t, r, "DELETE", path, 2, nil); code != 403 {
+        t.Fatalf("former owner delete: %d", code)
+    }
+    if code, _ := doJSON(t, r, "DELETE", path, 1, nil); code != 200 {
+        t.Fatalf("delete: %d", code)
+    }
+    if code, _ := doJSON(t, r, "GET", path, 1, nil); code != 404 {
+        t.Fatalf("deleted get: %d", code)
+    }
+}
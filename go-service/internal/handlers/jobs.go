@@ -2,38 +2,195 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	dbpkg "github.com/oreo-io/oreo.io-v2/go-service/internal/database"
 	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	services "github.com/oreo-io/oreo.io-v2/go-service/internal/service"
+	"gorm.io/gorm"
 )
 
-// RegisterJobsRoutes attaches job endpoints to the router
+// Jobs belong to the user who submitted them and, when they have one, to a project: members of
+// the project see its jobs, and its owners can control them like the submitter. Admins see and
+// control every job.
+
+// RegisterJobsRoutes attaches job endpoints to the router, and pushes job status and progress
+// to the job's submitter over the notification stream.
 func RegisterJobsRoutes(r *gin.Engine) {
+	services.SetJobListener(publishJob)
 	api := r.Group("/api", AuthMiddleware())
 	jobs := api.Group("/jobs")
 	{
+		jobs.GET("", listJobs)
 		jobs.POST("", createJob)
 		jobs.GET(":id", getJob)
 		jobs.PUT(":id", updateJob)
+		jobs.POST(":id/cancel", cancelJob)
 	}
 }
 
-func createJob(c *gin.Context) {
-	var j models.Job
-	if err := c.ShouldBindJSON(&j); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
-		return
-	}
+func jobsDB(c *gin.Context) (*gorm.DB, bool) {
 	gdb := dbpkg.Get()
 	if gdb == nil {
 		if _, err := dbpkg.Init(); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "db"})
-			return
+			return nil, false
 		}
 		gdb = dbpkg.Get()
 	}
+	return gdb, true
+}
+
+func isAdmin(c *gin.Context) bool {
+	role, _ := c.Get("user_role")
+	return role == "admin"
+}
+
+// canSeeJob reports whether the caller submitted the job or is a member of its project.
+func canSeeJob(c *gin.Context, j *models.Job) bool {
+	if isAdmin(c) || (j.UserID != 0 && j.UserID == contextUserID(c)) {
+		return true
+	}
+	return j.ProjectID != 0 && HasProjectRole(c, j.ProjectID, "owner", "contributor", "viewer")
+}
+
+// canControlJob reports whether the caller submitted the job or owns its project.
+func canControlJob(c *gin.Context, j *models.Job) bool {
+	if isAdmin(c) || (j.UserID != 0 && j.UserID == contextUserID(c)) {
+		return true
+	}
+	return j.ProjectID != 0 && HasProjectRole(c, j.ProjectID, "owner")
+}
+
+// loadJob loads the job named by the :id parameter, responding when the caller cannot see it.
+func loadJob(c *gin.Context, gdb *gorm.DB) (*models.Job, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+		return nil, false
+	}
+	var j models.Job
+	if err := gdb.First(&j, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+		return nil, false
+	}
+	if !canSeeJob(c, &j) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return nil, false
+	}
+	return &j, true
+}
+
+// listJobs lists the jobs the caller can see, newest first.
+// GET /api/jobs?project_id=1&type=query&status=pending,running&limit=50&offset=0
+func listJobs(c *gin.Context) {
+	limit := 50
+	offset := 0
+	if l := c.Query("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+			limit = parsed
+		}
+	}
+	if o := c.Query("offset"); o != "" {
+		if parsed, err := strconv.Atoi(o); err == nil && parsed >= 0 {
+			offset = parsed
+		}
+	}
+	gdb, ok := jobsDB(c)
+	if !ok {
+		return
+	}
+
+	q := gdb.Model(&models.Job{})
+	if v := strings.TrimSpace(c.Query("project_id")); v != "" {
+		projectID, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid project id"})
+			return
+		}
+		if !isAdmin(c) && !HasProjectRole(c, uint(projectID), "owner", "contributor", "viewer") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		q = q.Where("project_id = ?", projectID)
+	} else if !isAdmin(c) {
+		uid := contextUserID(c)
+		var projects []uint
+		if err := gdb.Model(&models.ProjectRole{}).Where("user_id = ?", uid).Pluck("project_id", &projects).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db"})
+			return
+		}
+		q = q.Where("user_id = ? OR project_id IN ?", uid, projects)
+	}
+	if t := strings.TrimSpace(c.Query("type")); t != "" {
+		q = q.Where("type = ?", t)
+	}
+	if s := strings.TrimSpace(c.Query("status")); s != "" {
+		q = q.Where("status IN ?", strings.Split(s, ","))
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db"})
+		return
+	}
+	jobs := []models.Job{}
+	if err := q.Order("created_at desc").Limit(limit).Offset(offset).Find(&jobs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":   jobs,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// clientJobTypes are the job types clients may queue through /api/jobs and schedules; others
+// are queued by the service itself.
+var clientJobTypes = map[string]bool{queryJobType: true, dqJobType: true}
+
+// createJob queues a job of a client job type for a project the caller contributes to.
+func createJob(c *gin.Context) {
+	var body struct {
+		Type      string       `json:"type"`
+		ProjectID uint         `json:"project_id"`
+		Metadata  models.JSONB `json:"metadata"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		return
+	}
+	if !clientJobTypes[body.Type] || !services.JobTypeRegistered(body.Type) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown_job_type"})
+		return
+	}
+	if body.ProjectID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "project_id_required"})
+		return
+	}
+	if !HasProjectRole(c, body.ProjectID, "owner", "contributor") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	gdb, ok := jobsDB(c)
+	if !ok {
+		return
+	}
+	// Handlers read the user and project of their metadata, which are the submitter's
+	if body.Metadata == nil {
+		body.Metadata = models.JSONB{}
+	}
+	body.Metadata["user_id"] = contextUserID(c)
+	body.Metadata["project_id"] = body.ProjectID
+	j := models.Job{ID: uuid.New(), Type: body.Type, Status: "pending", UserID: contextUserID(c), ProjectID: body.ProjectID, Metadata: body.Metadata}
 	if err := gdb.Create(&j).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_create"})
 		return
@@ -42,60 +199,68 @@ func createJob(c *gin.Context) {
 }
 
 func getJob(c *gin.Context) {
-	id := c.Param("id")
-	gdb := dbpkg.Get()
-	if gdb == nil {
-		if _, err := dbpkg.Init(); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "db"})
-			return
-		}
-		gdb = dbpkg.Get()
+	gdb, ok := jobsDB(c)
+	if !ok {
+		return
 	}
-	var j models.Job
-	if err := gdb.First(&j, "id = ?", id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+	j, ok := loadJob(c, gdb)
+	if !ok {
 		return
 	}
 	c.JSON(http.StatusOK, j)
 }
 
+// updateJob cancels a job given {"status": "cancelled"}, as cancelJob does. Only the worker sets
+// any other status.
 func updateJob(c *gin.Context) {
-	id := c.Param("id")
 	var patch struct {
-		Status string      `json:"status"`
-		Result interface{} `json:"result"`
+		Status string `json:"status"`
 	}
 	if err := c.ShouldBindJSON(&patch); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
 		return
 	}
-	gdb := dbpkg.Get()
-	if gdb == nil {
-		if _, err := dbpkg.Init(); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "db"})
-			return
-		}
-		gdb = dbpkg.Get()
+	if patch.Status != "cancelled" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_status"})
+		return
 	}
-	var j models.Job
-	if err := gdb.First(&j, "id = ?", id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+	cancelJob(c)
+}
+
+// cancelJob stops a pending job, or asks a running one to stop.
+func cancelJob(c *gin.Context) {
+	gdb, ok := jobsDB(c)
+	if !ok {
+		return
+	}
+	j, ok := loadJob(c, gdb)
+	if !ok {
 		return
 	}
-	if patch.Status != "" {
-		j.Status = patch.Status
+	if !canControlJob(c, j) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
 	}
-	if patch.Result != nil {
-		if rb, err := json.Marshal(patch.Result); err == nil {
-			var m map[string]interface{}
-			if err2 := json.Unmarshal(rb, &m); err2 == nil {
-				j.Result = models.JSONB(m)
-			}
-		}
+	status, err := services.CancelJob(gdb, j.ID, time.Now())
+	if errors.Is(err, services.ErrJobEnded) {
+		c.JSON(http.StatusConflict, gin.H{"error": "not_running", "status": j.Status})
+		return
 	}
-	if err := gdb.Save(&j).Error; err != nil {
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_update"})
 		return
 	}
-	c.JSON(http.StatusOK, j)
+	c.JSON(http.StatusOK, gin.H{"ok": true, "job_id": j.ID, "status": status})
+}
+
+// publishJob pushes the status and progress of a job to its submitter.
+func publishJob(j *models.Job) {
+	if j.UserID == 0 {
+		return
+	}
+	b, _ := json.Marshal(gin.H{
+		"type": "job", "job_id": j.ID, "job_type": j.Type, "status": j.Status,
+		"progress": j.Progress, "progress_message": j.ProgressMessage,
+	})
+	NotifHub.Publish(j.UserID, b)
 }
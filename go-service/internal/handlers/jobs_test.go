@@ -0,0 +1,125 @@
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+    services "github.com/oreo-io/oreo.io-v2/go-service/internal/service"
+    "gorm.io/gorm"
+)
+
+func TestJobs_AccessProgressAndCancel(t *testing.T) {
+    gdb, r := rowChangeEnv(t)
+    gdb.Exec(`CREATE TABLE jobs (id text primary key, type text, status text, metadata text, result text, user_id integer, project_id integer,
+        progress integer not null default 0, progress_message text, schedule_id integer, scheduled_for datetime, cancel_requested_at datetime, attempts integer not null default 0, run_at datetime, last_error text, locked_by text, lease_expires_at datetime, created_at datetime, updated_at datetime)`)
+    services.SetJobListener(publishJob)
+    r.GET("/api/jobs", listJobs)
+    r.POST("/api/jobs", createJob)
+    r.GET("/api/jobs/:id", getJob)
+    r.PUT("/api/jobs/:id", updateJob)
+    r.POST("/api/jobs/:id/cancel", cancelJob)
+    started := make(chan struct{})
+    services.RegisterJobType("test-slow", services.JobType{Run: func(ctx context.Context, gdb *gorm.DB, job *models.Job) error {
+        if err := services.ReportProgress(gdb, job, 50, "halfway"); err != nil {
+            return err
+        }
+        close(started)
+        <-ctx.Done()
+        return context.Cause(ctx)
+    }})
+    clientJobTypes["test-slow"] = true
+    t.Cleanup(func() { delete(clientJobTypes, "test-slow") })
+
+    if code, resp := doJSON(t, r, "POST", "/api/jobs", 2, gin.H{"type": "nope", "project_id": 1}); code != 400 || resp["error"] != "unknown_job_type" {
+        t.Fatalf("unknown type: %d %v", code, resp)
+    }
+    // Only client job types can be queued, and only for a project
+    services.RegisterJobType("test-internal", services.JobType{Run: func(context.Context, *gorm.DB, *models.Job) error { return nil }})
+    if code, resp := doJSON(t, r, "POST", "/api/jobs", 2, gin.H{"type": "test-internal", "project_id": 1}); code != 400 || resp["error"] != "unknown_job_type" {
+        t.Fatalf("internal type: %d %v", code, resp)
+    }
+    if code, resp := doJSON(t, r, "POST", "/api/jobs", 2, gin.H{"type": "test-slow"}); code != 400 || resp["error"] != "project_id_required" {
+        t.Fatalf("no project: %d %v", code, resp)
+    }
+    if code, _ := doJSON(t, r, "POST", "/api/jobs", 3, gin.H{"type": "test-slow", "project_id": 1}); code != 403 {
+        t.Fatalf("outsider create: %d", code)
+    }
+    code, resp := doJSON(t, r, "POST", "/api/jobs", 2, gin.H{"type": "test-slow", "project_id": 1, "metadata": gin.H{"user_id": 1, "project_id": 2}})
+    if meta := resp["metadata"].(map[string]any); code != 201 || resp["user_id"] != float64(2) || meta["user_id"] != float64(2) || meta["project_id"] != float64(1) {
+        t.Fatalf("create: %d %v", code, resp)
+    }
+    slowID := resp["id"].(string)
+    _, resp = doJSON(t, r, "POST", "/api/jobs", 2, gin.H{"type": "test-slow", "project_id": 1})
+    personalID := resp["id"].(string)
+
+    if code, _ := doJSON(t, r, "GET", "/api/jobs/"+slowID, 3, nil); code != 403 {
+        t.Fatalf("outsider get: %d", code)
+    }
+    if code, _ := doJSON(t, r, "PUT", "/api/jobs/"+slowID, 3, gin.H{"status": "cancelled"}); code != 403 {
+        t.Fatalf("outsider update: %d", code)
+    }
+    if code, resp := doJSON(t, r, "PUT", "/api/jobs/"+slowID, 2, gin.H{"status": "success"}); code != 400 || resp["error"] != "invalid_status" {
+        t.Fatalf("set status: %d %v", code, resp)
+    }
+    if code, resp := doJSON(t, r, "GET", "/api/jobs?status=pending,running", 1, nil); code != 200 || resp["total"] != float64(2) {
+        t.Fatalf("owner list: %d %v", code, resp)
+    }
+    if code, resp := doJSON(t, r, "GET", "/api/jobs?type=test-slow", 2, nil); code != 200 || resp["total"] != float64(2) {
+        t.Fatalf("submitter list: %d %v", code, resp)
+    }
+    if code, resp := doJSON(t, r, "GET", "/api/jobs", 3, nil); code != 200 || resp["total"] != float64(0) {
+        t.Fatalf("outsider list: %d %v", code, resp)
+    }
+    if code, _ := doJSON(t, r, "GET", "/api/jobs?project_id=1", 3, nil); code != 403 {
+        t.Fatalf("outsider project list: %d", code)
+    }
+
+    if code, resp := doJSON(t, r, "PUT", "/api/jobs/"+personalID, 2, gin.H{"status": "cancelled"}); code != 200 || resp["status"] != "cancelled" {
+        t.Fatalf("cancel pending: %d %v", code, resp)
+    }
+    if code, _ := doJSON(t, r, "POST", "/api/jobs/"+personalID+"/cancel", 2, nil); code != 409 {
+        t.Fatalf("cancel twice: %d", code)
+    }
+
+    events, unsubscribe := NotifHub.Subscribe(2)
+    defer unsubscribe()
+    done := make(chan struct{})
+    go func() {
+        services.RunPendingJob(gdb)
+        close(done)
+    }()
+    <-started
+    if code, resp := doJSON(t, r, "GET", "/api/jobs/"+slowID, 1, nil); code != 200 || resp["progress"] != float64(50) || resp["progress_message"] != "halfway" {
+        t.Fatalf("progress: %d %v", code, resp)
+    }
+    if code, resp := doJSON(t, r, "POST", "/api/jobs/"+slowID+"/cancel", 1, nil); code != 200 || resp["status"] != "running" {
+        t.Fatalf("cancel running: %d %v", code, resp)
+    }
+    select {
+    case <-done:
+    case <-time.After(5 * time.Second):
+        t.Fatalf("job ignored cancellation")
+    }
+    if _, resp := doJSON(t, r, "GET", "/api/jobs/"+slowID, 2, nil); resp["status"] != "cancelled" {
+        t.Fatalf("after cancel: %v", resp)
+    }
+
+    var statuses []string
+    for len(events) > 0 {
+        var evt map[string]any
+        json.Unmarshal(<-events, &evt)
+        if evt["type"] == "job" {
+            statuses = append(statuses, evt["status"].(string))
+            if evt["status"] == "running" && evt["progress"] == float64(50) && evt["progress_message"] != "halfway" {
+                t.Fatalf("progress event: %v", evt)
+            }
+        }
+    }
+    if len(statuses) != 3 || statuses[0] != "running" || statuses[2] != "cancelled" {
+        t.Fatalf("job events: %v", statuses)
+    }
+}
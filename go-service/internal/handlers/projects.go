@@ -230,6 +230,12 @@ func ProjectsDelete(c *gin.Context) {
 			if err := tx.Where("project_id = ? AND dataset_id = ?", p.ID, ds.ID).Delete(&models.DatasetUpload{}).Error; err != nil {
 				return err
 			}
+			if err := tx.Where("change_request_id IN (?)", tx.Model(&models.ChangeRequest{}).Select("id").Where("project_id = ? AND dataset_id = ?", p.ID, ds.ID)).Delete(&models.ChangeRequestReviewer{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("change_request_id IN (?)", tx.Model(&models.ChangeRequest{}).Select("id").Where("project_id = ? AND dataset_id = ?", p.ID, ds.ID)).Delete(&models.ChangeRequestRevision{}).Error; err != nil {
+				return err
+			}
 			if err := tx.Where("project_id = ? AND dataset_id = ?", p.ID, ds.ID).Delete(&models.ChangeRequest{}).Error; err != nil {
 				return err
 			}
@@ -239,6 +245,9 @@ func ProjectsDelete(c *gin.Context) {
 			if err := tx.Where("dataset_id = ?", ds.ID).Delete(&models.DataQualityRule{}).Error; err != nil {
 				return err
 			}
+			if err := tx.Where("dataset_id = ?", ds.ID).Delete(&models.DataQualityResult{}).Error; err != nil {
+				return err
+			}
 			if err := tx.Where("dataset_id = ?", ds.ID).Delete(&models.DatasetMeta{}).Error; err != nil {
 				return err
 			}
@@ -264,6 +273,12 @@ func ProjectsDelete(c *gin.Context) {
 		if err := tx.Where("project_id = ?", p.ID).Delete(&models.ChangeComment{}).Error; err != nil {
 			return err
 		}
+		if err := tx.Where("change_request_id IN (?)", tx.Model(&models.ChangeRequest{}).Select("id").Where("project_id = ?", p.ID)).Delete(&models.ChangeRequestReviewer{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("change_request_id IN (?)", tx.Model(&models.ChangeRequest{}).Select("id").Where("project_id = ?", p.ID)).Delete(&models.ChangeRequestRevision{}).Error; err != nil {
+			return err
+		}
 		if err := tx.Where("project_id = ?", p.ID).Delete(&models.ChangeRequest{}).Error; err != nil {
 			return err
 		}
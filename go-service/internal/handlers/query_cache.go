@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/config"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"gorm.io/gorm"
+)
+
+// Results of editor queries are cached in memory, keyed on the normalized SQL, its parameter
+// values, the page and the version of every dataset the query reads: the latest Delta version
+// from the table history, or DataVersion for datasets stored in Postgres, which
+// bumpDatasetVersion advances when a change request is applied or a snapshot restored. A new
+// version changes the key, so stale results are never served; bumping also drops the dataset's
+// entries so they don't take up room.
+
+// volatileSQLWords make a query's result depend on more than its tables.
+var volatileSQLWords = map[string]bool{
+	"now": true, "random": true, "current_date": true, "current_time": true, "current_timestamp": true,
+	"localtime": true, "localtimestamp": true, "clock_timestamp": true, "statement_timestamp": true,
+	"transaction_timestamp": true, "timeofday": true, "gen_random_uuid": true, "uuid_generate_v4": true,
+	"nextval": true, "setseed": true, "current_user": true, "session_user": true, "user": true,
+}
+
+type queryCacheEntry struct {
+	key      string
+	datasets []uint
+	resp     QueryExecuteResponse
+}
+
+// queryResultCache is a least-recently-used cache of query results.
+type queryResultCache struct {
+	mu      sync.Mutex
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+}
+
+var queryCache = &queryResultCache{order: list.New(), entries: map[string]*list.Element{}}
+
+func (qc *queryResultCache) get(key string) (QueryExecuteResponse, bool) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	el, ok := qc.entries[key]
+	if !ok {
+		return QueryExecuteResponse{}, false
+	}
+	qc.order.MoveToFront(el)
+	return el.Value.(*queryCacheEntry).resp, true
+}
+
+func (qc *queryResultCache) put(key string, datasets []uint, resp QueryExecuteResponse) {
+	limit := config.Get().QueryCacheEntries
+	if limit <= 0 {
+		return
+	}
+	resp.QueryID, resp.Cache = "", ""
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	if el, ok := qc.entries[key]; ok {
+		el.Value.(*queryCacheEntry).resp = resp
+		qc.order.MoveToFront(el)
+		return
+	}
+	qc.entries[key] = qc.order.PushFront(&queryCacheEntry{key: key, datasets: datasets, resp: resp})
+	for qc.order.Len() > limit {
+		oldest := qc.order.Back()
+		qc.order.Remove(oldest)
+		delete(qc.entries, oldest.Value.(*queryCacheEntry).key)
+	}
+}
+
+// reset drops every entry.
+func (qc *queryResultCache) reset() {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	qc.order.Init()
+	qc.entries = map[string]*list.Element{}
+}
+
+// invalidate drops the entries of queries reading a dataset.
+func (qc *queryResultCache) invalidate(datasetID uint) {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	for el := qc.order.Front(); el != nil; {
+		next := el.Next()
+		entry := el.Value.(*queryCacheEntry)
+		for _, id := range entry.datasets {
+			if id == datasetID {
+				qc.order.Remove(el)
+				delete(qc.entries, entry.key)
+				break
+			}
+		}
+		el = next
+	}
+}
+
+// bumpDatasetVersion records that the rows of a dataset changed.
+func bumpDatasetVersion(tx *gorm.DB, datasetID uint) error {
+	err := tx.Model(&models.Dataset{}).Where("id = ?", datasetID).
+		UpdateColumn("data_version", gorm.Expr("data_version + 1")).Error
+	queryCache.invalidate(datasetID)
+	return err
+}
+
+// normalizedSQL renders the tokens of q with single spaces and keywords in lower case, so
+// queries differing only in layout and comments share cache entries.
+func normalizedSQL(q *parsedQuery) string {
+	parts := make([]string, len(q.tokens))
+	for i, t := range q.tokens {
+		if t.kind == sqlQuoted {
+			parts[i] = `"` + strings.ReplaceAll(t.value, `"`, `""`) + `"`
+		} else {
+			parts[i] = t.value
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// datasetCacheVersion returns the version of a dataset's data for cache keys, and false when it
+// cannot be known, as for tables in external databases that change without us.
+func datasetCacheVersion(ds *models.Dataset) (string, bool) {
+	if isDeltaBackend(ds) {
+		latest := -1
+		for _, h := range deltaHistoryEntries(ds) {
+			if v, ok := h["version"].(float64); ok && int(v) > latest {
+				latest = int(v)
+			}
+		}
+		if latest < 0 {
+			return "", false
+		}
+		return fmt.Sprintf("delta:%d", latest), true
+	}
+	if strings.TrimSpace(ds.TargetDSN) != "" {
+		return "", false
+	}
+	return fmt.Sprintf("rows:%d", ds.DataVersion), true
+}
+
+// queryCacheKey returns the cache key of a query and the datasets it reads, or false when its
+// result should not be cached.
+func queryCacheKey(q *parsedQuery, args []any, req QueryExecuteRequest, datasets [][]models.Dataset) (string, []uint, bool) {
+	if config.Get().QueryCacheEntries <= 0 {
+		return "", nil, false
+	}
+	for _, t := range q.tokens {
+		if t.kind == sqlWord && volatileSQLWords[t.value] {
+			return "", nil, false
+		}
+	}
+	var ids []uint
+	versions := map[uint]string{}
+	for _, matches := range datasets {
+		for i := range matches {
+			ds := &matches[i]
+			if _, seen := versions[ds.ID]; seen {
+				continue
+			}
+			v, ok := datasetCacheVersion(ds)
+			if !ok {
+				return "", nil, false
+			}
+			versions[ds.ID] = v
+			ids = append(ids, ds.ID)
+		}
+	}
+	b, err := json.Marshal(map[string]any{
+		"sql":      normalizedSQL(q),
+		"args":     args,
+		"project":  req.ProjectID,
+		"page":     req.Page,
+		"limit":    req.Limit,
+		"versions": versions,
+	})
+	if err != nil {
+		return "", nil, false
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), ids, true
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+    "fmt"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+)
+
+func TestQueryCache_InvalidatedByApprove(t *testing.T) {
+    gdb, r := rowChangeEnv(t)
+    if err := gdb.AutoMigrate(&models.QueryHistory{}); err != nil {
+        t.Fatalf("migrate: %v", err)
+    }
+    r.POST("/api/query/execute", ExecuteQueryHandler(gdb))
+    ds := models.Dataset{ID: 5, ProjectID: 1, Name: "people", Schema: peopleSchema}
+    gdb.Create(&ds)
+    if err := ensureDatasetTable(gdb, &ds); err != nil {
+        t.Fatalf("ensure table: %v", err)
+    }
+    for _, row := range []string{`{"id":1,"name":"ann","age":31}`, `{"id":2,"name":"bob","age":42}`} {
+        gdb.Exec("INSERT INTO ds_5 (data) VALUES (?)", row)
+    }
+
+    run := func(sql string, params gin.H) (string, int) {
+        t.Helper()
+        code, resp := doJSON(t, r, "POST", "/api/query/execute", 2, gin.H{"sql": sql, "project_id": 1, "params": params})
+        if code != 200 {
+            t.Fatalf("%s: %d %v", sql, code, resp)
+        }
+        cache, _ := resp["cache"].(string)
+        return cache, len(resp["rows"].([]any))
+    }
+    sql := "SELECT json_extract(data, '$.name') FROM ds_5 WHERE json_extract(data, '$.age') > :age"
+    if cache, n := run(sql, gin.H{"age": 30}); cache != "miss" || n != 2 {
+        t.Fatalf("first run: %s %d", cache, n)
+    }
+    if cache, n := run("select json_extract(data, '$.name')\n  from ds_5 -- same query\n  where json_extract(data, '$.age') > :age", gin.H{"age": 30}); cache != "hit" || n != 2 {
+        t.Fatalf("reformatted run: %s %d", cache, n)
+    }
+    if cache, _ := run(sql, gin.H{"age": 40}); cache != "miss" {
+        t.Fatalf("other params: %s", cache)
+    }
+    if cache, _ := run("SELECT random() FROM ds_5", nil); cache != "bypass" {
+        t.Fatalf("volatile query: %s", cache)
+    }
+
+    code, resp := doJSON(t, r, "POST", "/projects/1/datasets/5/changes/delete", 1, gin.H{"where": []gin.H{{"column": "name", "op": "eq", "value": "bob"}}, "reviewer_ids": []uint{2}})
+    if code != 201 {
+        t.Fatalf("create delete: %d %v", code, resp)
+    }
+    if code, resp := doJSON(t, r, "POST", fmt.Sprintf("/projects/1/changes/%d/approve", changeID(t, resp)), 2, nil); code != 200 {
+        t.Fatalf("approve: %d %v", code, resp)
+    }
+    if cache, n := run(sql, gin.H{"age": 30}); cache != "miss" || n != 1 {
+        t.Fatalf("after approve: %s %d", cache, n)
+    }
+    var after models.Dataset
+    gdb.First(&after, 5)
+    if after.DataVersion != 1 {
+        t.Fatalf("data version: %d", after.DataVersion)
+    }
+}
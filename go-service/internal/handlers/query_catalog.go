@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"gorm.io/gorm"
+)
+
+// The catalog lists the datasets a user can query. Besides GET /api/meta/catalog, queries can
+// read it as information_schema.tables and information_schema.columns: those relations are
+// replaced by CTEs holding the caller's catalog before the query is planned, so they work on
+// every backend and only ever show datasets the caller may view.
+
+// CatalogTable is a dataset as queries see it.
+type CatalogTable struct {
+	// Schema and Table are the dataset's target; datasets without one are queried as ds_<id>
+	Schema    string          `json:"schema"`
+	Table     string          `json:"table"`
+	Name      string          `json:"name"`
+	ProjectID uint            `json:"project_id"`
+	DatasetID uint            `json:"dataset_id"`
+	Dataset   string          `json:"dataset"`
+	Backend   string          `json:"backend"`
+	Columns   []CatalogColumn `json:"columns"`
+	RowCount  int64           `json:"row_count"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// CatalogColumn is a column declared by a dataset's schema, with its SQL type.
+type CatalogColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// catalogRelations are the information_schema relations queries can read, by the CTE replacing
+// each and its columns.
+var catalogRelations = map[string]struct {
+	cte     string
+	columns []string
+}{
+	"tables":  {"information_schema_tables", []string{"table_schema", "table_name", "project_id", "dataset_id", "dataset_name", "backend", "row_count", "updated_at"}},
+	"columns": {"information_schema_columns", []string{"table_schema", "table_name", "column_name", "ordinal_position", "data_type"}},
+}
+
+// queryCatalog lists the datasets of the projects a user can view, or of one of them when
+// projectID is set.
+func queryCatalog(db *gorm.DB, userID, projectID uint) ([]CatalogTable, error) {
+	var roles []models.ProjectRole
+	rq := db.Where("user_id = ?", userID)
+	if projectID != 0 {
+		rq = rq.Where("project_id = ?", projectID)
+	}
+	if err := rq.Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	var projects []uint
+	for _, pr := range roles {
+		for _, r := range expandAllowedRoles("viewer") {
+			if normalizeRole(pr.Role) == r {
+				projects = append(projects, pr.ProjectID)
+				break
+			}
+		}
+	}
+	out := []CatalogTable{}
+	if len(projects) == 0 {
+		return out, nil
+	}
+	var datasets []models.Dataset
+	if err := db.Where("project_id IN ?", projects).Order("project_id, id").Find(&datasets).Error; err != nil {
+		return nil, err
+	}
+	var metas []models.DatasetMeta
+	if err := db.Where("project_id IN ?", projects).Find(&metas).Error; err != nil {
+		return nil, err
+	}
+	metaByDataset := map[uint]models.DatasetMeta{}
+	for _, m := range metas {
+		metaByDataset[m.DatasetID] = m
+	}
+	for _, ds := range datasets {
+		t := CatalogTable{
+			Schema: strings.TrimSpace(ds.TargetSchema), Table: strings.TrimSpace(ds.TargetTable),
+			ProjectID: ds.ProjectID, DatasetID: ds.ID, Dataset: ds.Name,
+			Backend: strings.ToLower(strings.TrimSpace(ds.StorageBackend)), Columns: []CatalogColumn{}, UpdatedAt: ds.UpdatedAt,
+		}
+		switch {
+		case t.Schema != "" && t.Table != "":
+			t.Name = t.Schema + "." + t.Table
+		case t.Table != "":
+			t.Name = t.Table
+		default:
+			t.Schema, t.Table, t.Name = "", dsMainTable(ds.ID), dsMainTable(ds.ID)
+		}
+		if t.Backend == "" {
+			t.Backend = "postgres"
+		}
+		for _, col := range deltaColumnsFromSchema(ds.Schema) {
+			t.Columns = append(t.Columns, CatalogColumn{Name: col.Name, Type: col.Type})
+		}
+		if m, ok := metaByDataset[ds.ID]; ok {
+			t.RowCount = m.RowCount
+			if m.LastUpdateAt.After(t.UpdatedAt) {
+				t.UpdatedAt = m.LastUpdateAt
+			}
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// CatalogHandler lists the datasets the caller can query, optionally in one project.
+func CatalogHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		projectID := uint(0)
+		if v := strings.TrimSpace(c.Query("project_id")); v != "" {
+			id, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid project id"})
+				return
+			}
+			projectID = uint(id)
+			if !HasProjectRole(c, projectID, "owner", "contributor", "viewer") {
+				c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+				return
+			}
+		}
+		tables, err := queryCatalog(db, contextUserID(c), projectID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"tables": tables})
+	}
+}
+
+// catalogRelation returns which information_schema relation rel names, if any.
+func catalogRelation(rel relationRef) string {
+	if len(rel.Parts) != 2 || !strings.EqualFold(rel.Parts[0], "information_schema") {
+		return ""
+	}
+	name := strings.ToLower(rel.Parts[1])
+	if _, ok := catalogRelations[name]; !ok {
+		return ""
+	}
+	return name
+}
+
+// readsCatalog reports whether q reads information_schema.tables or information_schema.columns.
+func readsCatalog(q *parsedQuery) bool {
+	for _, rel := range q.Relations {
+		if catalogRelation(rel) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// withCatalog returns the text of q with its information_schema relations replaced by CTEs
+// holding tables. The relations keep their name as an alias.
+func withCatalog(q *parsedQuery, tables []CatalogTable) string {
+	var b strings.Builder
+	used := map[string]bool{}
+	last := 0
+	for _, rel := range q.Relations {
+		name := catalogRelation(rel)
+		if name == "" {
+			continue
+		}
+		used[name] = true
+		b.WriteString(q.SQL[last:rel.Start])
+		b.WriteString(catalogRelations[name].cte)
+		if rel.Alias == "" {
+			b.WriteString(" AS " + name)
+		}
+		last = rel.End
+	}
+	b.WriteString(q.SQL[last:])
+	body := b.String()
+
+	rows := map[string][][]string{}
+	for _, t := range tables {
+		rows["tables"] = append(rows["tables"], []string{
+			sqlQuote(t.Schema), sqlQuote(t.Table), fmt.Sprint(t.ProjectID), fmt.Sprint(t.DatasetID),
+			sqlQuote(t.Dataset), sqlQuote(t.Backend), fmt.Sprint(t.RowCount), sqlQuote(t.UpdatedAt.UTC().Format(time.RFC3339)),
+		})
+		for i, col := range t.Columns {
+			rows["columns"] = append(rows["columns"], []string{
+				sqlQuote(t.Schema), sqlQuote(t.Table), sqlQuote(col.Name), fmt.Sprint(i + 1), sqlQuote(col.Type),
+			})
+		}
+	}
+	names := make([]string, 0, len(used))
+	for name := range used {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var ctes []string
+	for _, name := range names {
+		rel := catalogRelations[name]
+		// A typed row that is never returned keeps the column types when there are no others
+		typed := make([]string, len(rel.columns))
+		for i, col := range rel.columns {
+			typed[i] = "''"
+			if col == "project_id" || col == "dataset_id" || col == "row_count" || col == "ordinal_position" {
+				typed[i] = "0"
+			}
+		}
+		selects := []string{"SELECT " + strings.Join(typed, ", ") + " WHERE 1 = 0"}
+		for _, row := range rows[name] {
+			selects = append(selects, "SELECT "+strings.Join(row, ", "))
+		}
+		ctes = append(ctes, fmt.Sprintf("%s(%s) AS (%s)", rel.cte, strings.Join(rel.columns, ", "), strings.Join(selects, " UNION ALL ")))
+	}
+
+	// The CTEs go first in the query's own WITH clause, if it has one
+	toks := q.tokens
+	if len(toks) > 0 && toks[0].is("with") {
+		at := toks[0].end
+		if len(toks) > 1 && toks[1].is("recursive") {
+			at = toks[1].end
+		}
+		return body[:at] + " " + strings.Join(ctes, ", ") + "," + body[at:]
+	}
+	return "WITH " + strings.Join(ctes, ", ") + " " + body
+}
+
+// sqlQuote renders s as a SQL string literal.
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
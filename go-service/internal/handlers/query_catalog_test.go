@@ -0,0 +1,72 @@
+package handlers
+
+import (
+    "reflect"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+    services "github.com/oreo-io/oreo.io-v2/go-service/internal/service"
+)
+
+func TestCatalog_ListsAndQueriesVisibleDatasets(t *testing.T) {
+    t.Setenv("QUERY_RESULTS_DIR", t.TempDir())
+    gdb, r := rowChangeEnv(t)
+    if err := gdb.AutoMigrate(&models.QueryHistory{}); err != nil {
+        t.Fatalf("migrate: %v", err)
+    }
+    r.GET("/api/meta/catalog", CatalogHandler(gdb))
+    r.POST("/api/query/execute", ExecuteQueryHandler(gdb))
+    r.GET("/api/query/jobs/:jobId/results", QueryJobResultsHandler(gdb))
+    ds := models.Dataset{ID: 5, ProjectID: 1, Name: "people", Schema: peopleSchema}
+    gdb.Create(&ds)
+    if err := ensureDatasetTable(gdb, &ds); err != nil {
+        t.Fatalf("ensure table: %v", err)
+    }
+    gdb.Create(&models.Dataset{ID: 7, ProjectID: 1, Name: "orders", StorageBackend: "delta", TargetSchema: "sales", TargetTable: "orders"})
+    gdb.Create(&models.Dataset{ID: 9, ProjectID: 2, Name: "secret"})
+    gdb.Create(&models.DatasetMeta{ProjectID: 1, DatasetID: 5, RowCount: 42})
+
+    code, resp := doJSON(t, r, "GET", "/api/meta/catalog", 2, nil)
+    tables, _ := resp["tables"].([]any)
+    if code != 200 || len(tables) != 2 {
+        t.Fatalf("catalog: %d %v", code, resp)
+    }
+    people, orders := tables[0].(map[string]any), tables[1].(map[string]any)
+    if people["name"] != "ds_5" || people["backend"] != "postgres" || people["row_count"] != float64(42) || len(people["columns"].([]any)) != 3 {
+        t.Fatalf("people: %v", people)
+    }
+    if orders["name"] != "sales.orders" || orders["backend"] != "delta" {
+        t.Fatalf("orders: %v", orders)
+    }
+    if code, _ := doJSON(t, r, "GET", "/api/meta/catalog?project_id=2", 2, nil); code != 403 {
+        t.Fatalf("other project: %d", code)
+    }
+
+    code, resp = doJSON(t, r, "POST", "/api/query/execute", 2, gin.H{"project_id": 1,
+        "sql": "WITH big AS (SELECT table_name FROM information_schema.tables WHERE row_count > 10) SELECT c.column_name, c.data_type FROM information_schema.columns c JOIN big ON big.table_name = c.table_name ORDER BY c.ordinal_position"})
+    if code != 200 {
+        t.Fatalf("query catalog: %d %v", code, resp)
+    }
+    want := []any{[]any{"id", "long"}, []any{"name", "string"}, []any{"age", "long"}}
+    if !reflect.DeepEqual(resp["rows"], want) {
+        t.Fatalf("columns: %v", resp["rows"])
+    }
+    if code, resp := doJSON(t, r, "POST", "/api/query/execute", 3, gin.H{"sql": "SELECT count(*) FROM information_schema.tables"}); code != 200 || !reflect.DeepEqual(resp["rows"], []any{[]any{float64(0)}}) {
+        t.Fatalf("outsider catalog: %d %v", code, resp)
+    }
+
+    // An async query runs on the catalog of its submitter too
+    gdb.Exec(`CREATE TABLE jobs (id text primary key, type text, status text, metadata text, result text, user_id integer, project_id integer,
+        progress integer not null default 0, progress_message text, schedule_id integer, scheduled_for datetime, cancel_requested_at datetime, attempts integer not null default 0, run_at datetime, last_error text, locked_by text, lease_expires_at datetime, created_at datetime, updated_at datetime)`)
+    services.RegisterJobType(queryJobType, QueryJobType)
+    code, resp = doJSON(t, r, "POST", "/api/query/execute", 2, gin.H{"project_id": 1, "async": true,
+        "sql": "SELECT table_name FROM information_schema.tables ORDER BY table_name"})
+    if code != 202 || !services.RunPendingJob(gdb) {
+        t.Fatalf("async catalog query: %d %v", code, resp)
+    }
+    code, resp = doJSON(t, r, "GET", "/api/query/jobs/"+resp["job_id"].(string)+"/results", 2, nil)
+    if code != 200 || !reflect.DeepEqual(resp["rows"], []any{[]any{"ds_5"}, []any{"orders"}}) {
+        t.Fatalf("async catalog rows: %d %v", code, resp)
+    }
+}
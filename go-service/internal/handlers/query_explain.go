@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/config"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// largeScanRows is the DatasetMeta.RowCount from which reading a whole dataset is warned about.
+const largeScanRows = 1000000
+
+// QueryExplainResponse tells where and how a query would run.
+type QueryExplainResponse struct {
+	// ExecuteOn is local, remote (a dataset's target database) or delta (DuckDB)
+	ExecuteOn      string `json:"execute_on"`
+	RemoteDatabase string `json:"remote_database,omitempty"`
+	RewrittenSQL   string `json:"rewritten_sql"`
+	// TableMappings are the Delta tables of a DuckDB query, and FederatedTables the tables it
+	// would pull from other backends
+	TableMappings   map[string]string `json:"table_mappings,omitempty"`
+	FederatedTables []string          `json:"federated_tables,omitempty"`
+	// Plan is the backend's EXPLAIN output, one line per entry
+	Plan     []string       `json:"plan"`
+	Warnings []QueryWarning `json:"warnings"`
+}
+
+// QueryWarning flags a dataset a query would read in full.
+type QueryWarning struct {
+	Table     string `json:"table"`
+	DatasetID uint   `json:"dataset_id"`
+	RowCount  int64  `json:"row_count"`
+	Message   string `json:"message"`
+}
+
+// ExplainQueryHandler plans a query like ExecuteQueryHandler and returns the plan instead of
+// running it.
+func ExplainQueryHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req QueryExecuteRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+		aq, ok := authorizeQuery(c, db, req, nil)
+		if !ok {
+			return
+		}
+		rq, ctx, err := startQuery(c.Request.Context(), contextUserID(c), "", queryTimeout())
+		if errors.Is(err, errTooManyQueries) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too_many_queries", "limit": config.Get().MaxConcurrentQueries})
+			return
+		}
+		defer rq.finish()
+
+		q := aq.q
+		out := QueryExplainResponse{Plan: []string{}, Warnings: []QueryWarning{}}
+		// fullScan reports whether the plan reads all of a dataset
+		var fullScan func(ds *models.Dataset, rel relationRef) bool
+		if mappings, isDelta := detectDeltaTables(db, q, req.ProjectID); isDelta {
+			order, pulls, pullDatasets, err := federationPulls(q, aq.datasets, mappings)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "federated query failed: " + err.Error()})
+				return
+			}
+			// DuckDB only needs the columns of pulled tables to plan
+			inline := map[string]federatedTable{}
+			pushed := map[string]bool{}
+			filterable := federationFilterable(q)
+			for _, key := range order {
+				ds := pullDatasets[key]
+				cols, err := datasetColumns(db, &ds)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("federated query failed: %s: %v", key, err)})
+					return
+				}
+				inline[key] = federatedTable{Columns: cols, Rows: [][]any{}}
+				rels := pulls[key]
+				pushed[key] = filterable && len(rels) == 1 && rels[0].TopLevel && len(pushableFilters(q, rels[0].refName(), cols, filterColumnExpr(db, &ds))) > 0
+			}
+			body := map[string]any{
+				"sql": deltaSQL(q), "table_mappings": mappings, "explain": true,
+				"query_id": rq.ID, "timeout_ms": rq.Timeout.Milliseconds(),
+			}
+			if len(inline) > 0 {
+				body["inline_tables"] = inline
+			}
+			if len(aq.args) > 0 {
+				body["params"] = aq.args
+			}
+			resp, err := postDeltaQuery(ctx, rq, body)
+			if err != nil {
+				if respondQueryEnded(c, rq, ctx) {
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "explain failed: " + err.Error()})
+				return
+			}
+			out.ExecuteOn, out.RewrittenSQL, out.TableMappings, out.FederatedTables = "delta", deltaSQL(q), mappings, order
+			out.Plan = planLines(resp.Rows)
+			hasWhere := false
+			for _, t := range q.tokens {
+				hasWhere = hasWhere || t.is("where")
+			}
+			fullScan = func(ds *models.Dataset, rel relationRef) bool {
+				if isDeltaBackend(ds) {
+					// DuckDB skips Delta files only by filters
+					return !hasWhere
+				}
+				return !pushed[rel.deltaKey()]
+			}
+		} else {
+			localDialect := dialect(db)
+			rewritten, execRemote, remoteDSN, remoteDB, err := planQueryExecution(db, q, req.ProjectID, currentDatabaseName(db, localDialect), localDialect)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			out.ExecuteOn, out.RewrittenSQL = "local", rewritten
+			execDB := db
+			if execRemote {
+				if strings.TrimSpace(remoteDSN) == "" {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Cross-database query requires Target DSN or FDW/dblink. Configure dataset TargetDSN or set up FDW."})
+					return
+				}
+				rdb, err := gorm.Open(postgres.Open(remoteDSN), &gorm.Config{})
+				if err != nil {
+					c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("failed connecting to remote DB '%s'", remoteDB)})
+					return
+				}
+				if sqlDB, err := rdb.DB(); err == nil {
+					defer sqlDB.Close()
+				}
+				execDB = rdb
+				out.ExecuteOn, out.RemoteDatabase = "remote", remoteDB
+			}
+			explain := "EXPLAIN " + rewritten
+			if dialect(execDB) != "postgres" {
+				explain = "EXPLAIN QUERY PLAN " + rewritten
+			}
+			plan, err := explainLocal(ctx, rq, execDB, explain, aq.args)
+			if err != nil {
+				if respondQueryEnded(c, rq, ctx) {
+					return
+				}
+				c.JSON(http.StatusBadRequest, gin.H{"error": "explain failed: " + err.Error()})
+				return
+			}
+			out.Plan = plan
+			fullScan = func(ds *models.Dataset, rel relationRef) bool {
+				return planScansTable(plan, datasetPhysicalTable(ds), rel.Alias)
+			}
+		}
+
+		seen := map[uint]bool{}
+		for i, matches := range aq.datasets {
+			for j := range matches {
+				ds := &matches[j]
+				if seen[ds.ID] || !fullScan(ds, q.Relations[i]) {
+					continue
+				}
+				seen[ds.ID] = true
+				var meta models.DatasetMeta
+				if err := db.Where("dataset_id = ?", ds.ID).First(&meta).Error; err != nil || meta.RowCount < largeScanRows {
+					continue
+				}
+				out.Warnings = append(out.Warnings, QueryWarning{
+					Table: ds.Name, DatasetID: ds.ID, RowCount: meta.RowCount,
+					Message: fmt.Sprintf("%s is read in full (%d rows); filter it to read less", ds.Name, meta.RowCount),
+				})
+			}
+		}
+		c.JSON(http.StatusOK, out)
+	}
+}
+
+// datasetColumns lists the columns of a dataset stored in Postgres, locally or at its target.
+// Those stored by the app have the columns of their schema.
+func datasetColumns(db *gorm.DB, ds *models.Dataset) ([]string, error) {
+	if strings.TrimSpace(ds.TargetDSN) == "" {
+		cols, _ := jsonDatasetColumns(ds)
+		return cols, nil
+	}
+	if dsn := strings.TrimSpace(ds.TargetDSN); dsn != "" {
+		rdb, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		if err != nil {
+			return nil, errors.New("failed connecting to target database")
+		}
+		if sqlDB, err := rdb.DB(); err == nil {
+			defer sqlDB.Close()
+		}
+		db = rdb
+	}
+	return tableColumns(db, datasetPhysicalTable(ds))
+}
+
+// explainLocal runs an EXPLAIN statement and returns its plan lines. sqlite's EXPLAIN QUERY PLAN
+// puts the step in its last column.
+func explainLocal(ctx context.Context, rq *runningQuery, db *gorm.DB, explain string, args []any) ([]string, error) {
+	rows, done, err := runQuery(ctx, rq, db, explain, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var out [][]any
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		out = append(out, append([]any(nil), vals...))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return planLines(out), nil
+}
+
+// planLines splits the last column of EXPLAIN output rows into lines.
+func planLines(rows [][]any) []string {
+	lines := []string{}
+	for _, row := range rows {
+		if len(row) == 0 {
+			continue
+		}
+		v := row[len(row)-1]
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		for _, line := range strings.Split(fmt.Sprint(v), "\n") {
+			if strings.TrimSpace(line) != "" {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
+
+// planScansTable reports whether a Postgres or sqlite plan reads a table sequentially: a
+// "Seq Scan on t" node, or a "SCAN t" step, which sqlite names by the table's alias if it has one.
+func planScansTable(plan []string, table, alias string) bool {
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		table = table[i+1:]
+	}
+	names := map[string]bool{strings.ToLower(strings.Trim(table, `"`)): true}
+	if alias != "" {
+		names[strings.ToLower(alias)] = true
+	}
+	for _, line := range plan {
+		l := strings.TrimLeft(strings.ToLower(strings.TrimSpace(line)), "-> ")
+		for _, prefix := range []string{"seq scan on ", "parallel seq scan on ", "scan "} {
+			rest, ok := strings.CutPrefix(l, prefix)
+			if !ok {
+				continue
+			}
+			if f := strings.Fields(rest); len(f) > 0 {
+				n := f[0]
+				if i := strings.LastIndex(n, "."); i >= 0 {
+					n = n[i+1:]
+				}
+				if names[strings.Trim(n, `"`)] {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
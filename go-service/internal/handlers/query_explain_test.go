@@ -0,0 +1,42 @@
+package handlers
+
+import (
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+)
+
+func TestExplainQuery_LocalPlanAndWarnings(t *testing.T) {
+    gdb, r := rowChangeEnv(t)
+    r.POST("/api/query/explain", ExplainQueryHandler(gdb))
+    for _, ds := range []models.Dataset{{ID: 5, ProjectID: 1, Name: "people", Schema: peopleSchema}, {ID: 6, ProjectID: 1, Name: "teams"}} {
+        gdb.Create(&ds)
+        if err := ensureDatasetTable(gdb, &ds); err != nil {
+            t.Fatalf("ensure table: %v", err)
+        }
+    }
+    gdb.Create(&models.DatasetMeta{ProjectID: 1, DatasetID: 5, RowCount: 2000000})
+    gdb.Create(&models.DatasetMeta{ProjectID: 1, DatasetID: 6, RowCount: 10})
+
+    code, resp := doJSON(t, r, "POST", "/api/query/explain", 2, gin.H{"project_id": 1,
+        "sql": "SELECT p.data FROM ds_5 p JOIN ds_6 t ON t.id = p.id WHERE json_extract(p.data, '$.age') > :age", "params": gin.H{"age": 30}})
+    if code != 200 || resp["execute_on"] != "local" || len(resp["plan"].([]any)) == 0 {
+        t.Fatalf("explain: %d %v", code, resp)
+    }
+    warnings, _ := resp["warnings"].([]any)
+    if len(warnings) != 1 || warnings[0].(map[string]any)["table"] != "people" || warnings[0].(map[string]any)["row_count"] != float64(2000000) {
+        t.Fatalf("warnings: %v (plan %v)", warnings, resp["plan"])
+    }
+    if code, _ := doJSON(t, r, "POST", "/api/query/explain", 3, gin.H{"project_id": 1, "sql": "SELECT * FROM ds_5"}); code != 403 {
+        t.Fatalf("outsider: %d", code)
+    }
+    if code, resp := doJSON(t, r, "POST", "/api/query/explain", 2, gin.H{"project_id": 1, "sql": "DELETE FROM ds_5"}); code != 403 || resp["error"] != "append_only" {
+        t.Fatalf("write: %d %v", code, resp)
+    }
+
+    pgPlan := []string{"Hash Join  (cost=1.09..2.22 rows=4 width=32)", "  ->  Seq Scan on salaries s  (cost=0.00..1.04 rows=4 width=36)", "  ->  Index Scan using ds_5_pkey on ds_5"}
+    if !planScansTable(pgPlan, "hr.salaries", "s") || planScansTable(pgPlan, "ds_5", "") {
+        t.Fatalf("postgres plan scans misread")
+    }
+}
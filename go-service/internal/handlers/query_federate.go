@@ -0,0 +1,455 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Queries that read Delta datasets run on DuckDB in the Python service. When such a query also
+// reads datasets stored in Postgres, locally or behind a target DSN, planFederation pulls those
+// tables first and they are sent along with the query, so the join runs in DuckDB. A pull selects
+// only the columns the query mentions and, when that cannot change the result, the WHERE
+// conditions that involve nothing but that table. Datasets stored by the app keep their rows in a
+// JSON data column; the query sees the columns of the dataset schema, which are read from it.
+
+// federatedRowLimit caps the rows pulled from one table for a federated query.
+const federatedRowLimit = 100000
+
+// errFederatedTooLarge is returned by a pull that finds more than federatedRowLimit rows.
+var errFederatedTooLarge = errors.New("too many rows to pull")
+
+// federatedTable is a table pulled from another backend for a federated query.
+type federatedTable struct {
+	Columns []string `json:"columns"`
+	Rows    [][]any  `json:"rows"`
+}
+
+// sqlFilterWords may appear in a WHERE condition pushed down to a table.
+var sqlFilterWords = map[string]bool{
+	"and": true, "or": true, "not": true, "is": true, "null": true, "true": true, "false": true,
+	"in": true, "like": true, "ilike": true, "between": true, "distinct": true, "from": true,
+}
+
+// planFederation pulls the relations of q the Python service cannot read itself. mappings holds
+// the Delta relations and gains any found among datasets, the relations' datasets from
+// queryDatasets. The pulled tables are keyed like mappings.
+func planFederation(ctx context.Context, db *gorm.DB, q *parsedQuery, datasets [][]models.Dataset, mappings map[string]string) (map[string]federatedTable, error) {
+	order, pulls, pullDatasets, err := federationPulls(q, datasets, mappings)
+	if err != nil || len(order) == 0 {
+		return nil, err
+	}
+	filterable := federationFilterable(q)
+	out := make(map[string]federatedTable, len(order))
+	for _, key := range order {
+		ds, rels := pullDatasets[key], pulls[key]
+		execDB := db
+		if dsn := strings.TrimSpace(ds.TargetDSN); dsn != "" {
+			rdb, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+			if err != nil {
+				return nil, fmt.Errorf("%s: failed connecting to target database", key)
+			}
+			if sqlDB, err := rdb.DB(); err == nil {
+				defer sqlDB.Close()
+			}
+			execDB = rdb
+		}
+		execDB = execDB.WithContext(ctx)
+		table := datasetPhysicalTable(&ds)
+		jsonRows := strings.TrimSpace(ds.TargetDSN) == ""
+		var cols []string
+		if jsonRows {
+			cols, _ = jsonDatasetColumns(&ds)
+		} else if cols, err = tableColumns(execDB, table); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		expr := filterColumnExpr(db, &ds)
+		var refNames []string
+		for _, rel := range rels {
+			refNames = append(refNames, rel.refName())
+		}
+		picked := referencedColumns(q, refNames, cols)
+		pullSQL := ""
+		if jsonRows {
+			pullSQL = fmt.Sprintf("SELECT data FROM %s", table)
+		} else {
+			quoted := make([]string, len(picked))
+			for i, col := range picked {
+				quoted[i] = quoteIfNeeded(col)
+			}
+			pullSQL = fmt.Sprintf("SELECT %s FROM %s", strings.Join(quoted, ", "), table)
+		}
+		// A table read twice under different names can't be filtered for one of them
+		if filterable && len(rels) == 1 && rels[0].TopLevel {
+			if conds := pushableFilters(q, rels[0].refName(), cols, expr); len(conds) > 0 {
+				pullSQL += " WHERE " + strings.Join(conds, " AND ")
+			}
+		}
+		pullSQL += fmt.Sprintf(" LIMIT %d", federatedRowLimit+1)
+		var t federatedTable
+		if jsonRows {
+			t, err = pullJSONTable(execDB, pullSQL, picked)
+		} else {
+			t, err = pullTable(execDB, pullSQL)
+		}
+		if errors.Is(err, errFederatedTooLarge) {
+			return nil, fmt.Errorf("%s: more than %d rows match; add filters on this table", key, federatedRowLimit)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		out[key] = t
+	}
+	return out, nil
+}
+
+// federationPulls finds the relations of q planFederation must pull, keyed like mappings, in
+// order of appearance, with the relations naming each and its dataset. Delta datasets among them
+// are added to mappings instead.
+func federationPulls(q *parsedQuery, datasets [][]models.Dataset, mappings map[string]string) ([]string, map[string][]relationRef, map[string]models.Dataset, error) {
+	pulls := map[string][]relationRef{}
+	pullDatasets := map[string]models.Dataset{}
+	var order []string
+	for i, rel := range q.Relations {
+		key := rel.deltaKey()
+		if _, ok := mappings[key]; ok {
+			continue
+		}
+		name := strings.Join(rel.Parts, ".")
+		if len(rel.Parts) > 2 {
+			return nil, nil, nil, fmt.Errorf("%s: database-qualified tables cannot be joined with Delta tables", name)
+		}
+		if len(datasets[i]) != 1 {
+			return nil, nil, nil, fmt.Errorf("%s: ambiguous table", name)
+		}
+		ds := datasets[i][0]
+		if isDeltaBackend(&ds) {
+			mappings[key] = deltaMapping(&ds, rel)
+			continue
+		}
+		if _, ok := pulls[key]; !ok {
+			order = append(order, key)
+		}
+		pulls[key] = append(pulls[key], rel)
+		pullDatasets[key] = ds
+	}
+	return order, pulls, pullDatasets, nil
+}
+
+// tableColumns lists the columns of a table.
+func tableColumns(db *gorm.DB, table string) ([]string, error) {
+	rows, err := db.Raw(fmt.Sprintf("SELECT * FROM %s LIMIT 0", table)).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rows.Columns()
+}
+
+// jsonDatasetColumns returns the columns of the dataset schema of a JSON-stored dataset, in
+// declaration order, with their Delta types.
+func jsonDatasetColumns(ds *models.Dataset) ([]string, map[string]string) {
+	var cols []string
+	types := map[string]string{}
+	for _, col := range deltaColumnsFromSchema(ds.Schema) {
+		cols = append(cols, col.Name)
+		types[col.Name] = col.Type
+	}
+	return cols, types
+}
+
+// jsonColumnExpr is the SQL reading a column from the data column of a dataset table, typed so
+// pushed-down comparisons mean what they do in DuckDB.
+func jsonColumnExpr(dialect, col, typ string) string {
+	key := strings.ReplaceAll(col, "'", "''")
+	if dialect != "postgres" {
+		// json_extract keeps JSON numbers and strings apart
+		return fmt.Sprintf(`json_extract(data, '$."%s"')`, strings.ReplaceAll(key, `"`, `\"`))
+	}
+	switch typ {
+	case "long", "double":
+		return fmt.Sprintf("(data->>'%s')::numeric", key)
+	case "boolean":
+		return fmt.Sprintf("(data->>'%s')::boolean", key)
+	}
+	return fmt.Sprintf("(data->>'%s')", key)
+}
+
+// filterColumnExpr returns how a pushed-down condition reads a column of ds.
+func filterColumnExpr(db *gorm.DB, ds *models.Dataset) func(col string) string {
+	if strings.TrimSpace(ds.TargetDSN) != "" {
+		return quoteIfNeeded
+	}
+	_, types := jsonDatasetColumns(ds)
+	d := dialect(db)
+	return func(col string) string { return jsonColumnExpr(d, col, types[col]) }
+}
+
+// pullTable runs a query and keeps its rows in memory, failing with errFederatedTooLarge past
+// federatedRowLimit rows.
+func pullTable(db *gorm.DB, sqlText string) (federatedTable, error) {
+	rows, err := db.Raw(sqlText).Rows()
+	if err != nil {
+		return federatedTable{}, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return federatedTable{}, err
+	}
+	t := federatedTable{Columns: cols, Rows: [][]any{}}
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		if len(t.Rows) == federatedRowLimit {
+			return federatedTable{}, errFederatedTooLarge
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return federatedTable{}, err
+		}
+		row := make([]any, len(vals))
+		for i, v := range vals {
+			if b, ok := v.([]byte); ok {
+				row[i] = string(b)
+			} else {
+				row[i] = v
+			}
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t, rows.Err()
+}
+
+// pullJSONTable runs a query selecting the data column of a dataset table and returns cols of its
+// rows, or every key found when the dataset has no schema. It fails like pullTable past the limit.
+func pullJSONTable(db *gorm.DB, sqlText string, cols []string) (federatedTable, error) {
+	raw, err := pullTable(db, sqlText)
+	if err != nil {
+		return federatedTable{}, err
+	}
+	objs := make([]map[string]any, 0, len(raw.Rows))
+	seen := map[string]bool{}
+	discover := len(cols) == 0
+	for _, r := range raw.Rows {
+		var text string
+		switch v := r[0].(type) {
+		case string:
+			text = v
+		case []byte:
+			text = string(v)
+		}
+		dec := json.NewDecoder(strings.NewReader(text))
+		dec.UseNumber()
+		var obj map[string]any
+		if err := dec.Decode(&obj); err != nil {
+			return federatedTable{}, fmt.Errorf("invalid row data: %w", err)
+		}
+		objs = append(objs, obj)
+		if discover {
+			keys := make([]string, 0, len(obj))
+			for k := range obj {
+				if !seen[k] {
+					seen[k] = true
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			cols = append(cols, keys...)
+		}
+	}
+	t := federatedTable{Columns: cols, Rows: make([][]any, 0, len(objs))}
+	for _, obj := range objs {
+		row := make([]any, len(cols))
+		for i, col := range cols {
+			row[i] = obj[col]
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t, nil
+}
+
+// referencedColumns returns the columns of a table named anywhere in q, or all of them when q
+// selects * from it. DuckDB matches identifiers case-insensitively, so names are compared that
+// way too.
+func referencedColumns(q *parsedQuery, refNames []string, cols []string) []string {
+	toks := q.tokens
+	for i, t := range toks {
+		if !t.isPunct("*") || i == 0 {
+			continue
+		}
+		prev := toks[i-1]
+		if prev.is("select") || prev.is("distinct") || prev.is("all") || prev.isPunct(",") {
+			return cols
+		}
+		if prev.isPunct(".") && i >= 2 {
+			for _, n := range refNames {
+				if strings.EqualFold(toks[i-2].value, n) {
+					return cols
+				}
+			}
+		}
+	}
+	var picked []string
+	for _, col := range cols {
+		for _, t := range toks {
+			if t.isName() && strings.EqualFold(t.value, col) {
+				picked = append(picked, col)
+				break
+			}
+		}
+	}
+	if len(picked) == 0 && len(cols) > 0 {
+		// Still one column per row, for counts
+		picked = cols[:1]
+	}
+	return picked
+}
+
+// federationFilterable reports whether WHERE conditions of q may be applied to its tables before
+// the join: not with outer joins, whose null-extended rows such a filter would change, nor with
+// set operations, whose WHERE clauses each apply to one branch.
+func federationFilterable(q *parsedQuery) bool {
+	for _, t := range q.tokens {
+		if t.kind != sqlWord {
+			continue
+		}
+		switch t.value {
+		case "left", "right", "full", "union", "intersect", "except":
+			return false
+		}
+	}
+	return true
+}
+
+// pushableFilters returns the top-level WHERE conditions of q that only compare columns of the
+// table refName names with constants, rewritten for a query on that table alone, where expr
+// reads a column.
+func pushableFilters(q *parsedQuery, refName string, cols []string, expr func(col string) string) []string {
+	toks := q.tokens
+	start, depth := -1, 0
+	for i, t := range toks {
+		switch {
+		case t.isPunct("("):
+			depth++
+		case t.isPunct(")"):
+			depth--
+		case depth == 0 && t.is("where"):
+			start = i + 1
+		}
+		if start >= 0 {
+			break
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+	var conds []string
+	from, depth, between := start, 0, false
+	for i := start; i <= len(toks); i++ {
+		end := i == len(toks)
+		if !end {
+			t := toks[i]
+			switch {
+			case t.isPunct("("):
+				depth++
+			case t.isPunct(")"):
+				depth--
+			}
+			if depth != 0 {
+				continue
+			}
+			if t.is("between") {
+				between = true
+			}
+			if t.is("and") && between {
+				between = false
+				continue
+			}
+			end = t.is("and") || (t.kind == sqlWord && sqlFromEndWords[t.value])
+		}
+		if !end {
+			continue
+		}
+		if cond, ok := pushableFilter(q, toks[from:i], refName, cols, expr); ok {
+			conds = append(conds, "("+cond+")")
+		}
+		if i == len(toks) || !toks[i].is("and") {
+			break
+		}
+		from = i + 1
+	}
+	return conds
+}
+
+// pushableFilter rewrites one WHERE condition for the table refName names, if it only involves
+// that table's columns, constants and operators.
+func pushableFilter(q *parsedQuery, toks []sqlToken, refName string, cols []string, expr func(col string) string) (string, bool) {
+	column := func(t sqlToken) string {
+		if !t.isName() {
+			return ""
+		}
+		for _, col := range cols {
+			if strings.EqualFold(t.value, col) {
+				return col
+			}
+		}
+		return ""
+	}
+	if len(toks) == 0 {
+		return "", false
+	}
+	var b strings.Builder
+	last := toks[0].start
+	hasColumn := false
+	for j := 0; j < len(toks); j++ {
+		t := toks[j]
+		switch t.kind {
+		case sqlString, sqlNumber:
+			continue
+		case sqlParam:
+			return "", false
+		case sqlPunct:
+			if t.isPunct(";") || t.isPunct("?") {
+				return "", false
+			}
+			continue
+		}
+		if j+1 < len(toks) && toks[j+1].isPunct("(") {
+			// Function calls and subqueries may not mean the same in both databases
+			if !t.is("in") {
+				return "", false
+			}
+			continue
+		}
+		start, col := t.start, ""
+		if j+2 < len(toks) && toks[j+1].isPunct(".") {
+			if !strings.EqualFold(t.value, refName) || (j+3 < len(toks) && toks[j+3].isPunct(".")) {
+				return "", false
+			}
+			j += 2
+			if col = column(toks[j]); col == "" {
+				return "", false
+			}
+		} else if t.kind == sqlWord && sqlFilterWords[t.value] {
+			continue
+		} else if col = column(t); col == "" {
+			return "", false
+		}
+		hasColumn = true
+		b.WriteString(q.SQL[last:start])
+		b.WriteString(expr(col))
+		last = toks[j].end
+	}
+	b.WriteString(q.SQL[last:toks[len(toks)-1].end])
+	return b.String(), hasColumn
+}
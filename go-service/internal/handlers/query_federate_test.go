@@ -0,0 +1,93 @@
+package handlers
+
+import (
+    "context"
+    "encoding/json"
+    "reflect"
+    "strings"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+)
+
+func TestPlanFederation_PushesColumnsAndFilters(t *testing.T) {
+    gdb, _ := rowChangeEnv(t)
+    ds := models.Dataset{ID: 5, ProjectID: 1, Name: "people", Schema: peopleSchema}
+    gdb.Create(&ds)
+    if err := ensureDatasetTable(gdb, &ds); err != nil {
+        t.Fatalf("ensure table: %v", err)
+    }
+    // The rows' ids differ from the table's serial ids, so reading the wrong one shows
+    for _, row := range []string{`{"id":10,"name":"ann"}`, `{"id":20,"name":"bob"}`, `{"id":30,"name":"cy"}`} {
+        gdb.Exec("INSERT INTO ds_5 (data) VALUES (?)", row)
+    }
+    gdb.Create(&models.Dataset{ID: 7, ProjectID: 1, Name: "orders", StorageBackend: "delta", TargetSchema: "sales", TargetTable: "orders"})
+
+    plan := func(sql string) (map[string]federatedTable, map[string]string) {
+        q, err := parseReadOnlyQuery(sql)
+        if err != nil {
+            t.Fatalf("parse: %v", err)
+        }
+        datasets, unknown := queryDatasets(gdb, q, 1)
+        if unknown != "" {
+            t.Fatalf("unknown table %s", unknown)
+        }
+        mappings, _ := detectDeltaTables(gdb, q, 1)
+        inline, err := planFederation(context.Background(), gdb, q, datasets, mappings)
+        if err != nil {
+            t.Fatalf("plan: %v", err)
+        }
+        return inline, mappings
+    }
+
+    inline, mappings := plan("SELECT o.amount FROM sales.orders o JOIN ds_5 p ON o.person_id = p.id WHERE p.id >= 20 AND o.amount > p.id AND p.id BETWEEN 10 AND 20")
+    if mappings["sales.orders"] != "1/7" {
+        t.Fatalf("delta mappings: %v", mappings)
+    }
+    people := inline["ds_5"]
+    if !reflect.DeepEqual(people.Columns, []string{"id"}) || !reflect.DeepEqual(people.Rows, [][]any{{json.Number("20")}}) {
+        t.Fatalf("pulled: %+v", people)
+    }
+
+    // Filters on the null-extended side of an outer join stay in DuckDB
+    inline, _ = plan("SELECT * FROM sales.orders o LEFT JOIN ds_5 p ON o.person_id = p.id WHERE p.id IS NULL")
+    if people := inline["ds_5"]; !reflect.DeepEqual(people.Columns, []string{"id", "name", "age"}) || len(people.Rows) != 3 || people.Rows[2][1] != "cy" {
+        t.Fatalf("outer join pull: %+v", people)
+    }
+
+    // Past the row cap the query fails rather than joining part of the table
+    gdb.Exec(`INSERT INTO ds_5 (data) WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < ?) SELECT json_object('id', i) FROM n`, federatedRowLimit)
+    q, _ := parseReadOnlyQuery("SELECT * FROM sales.orders o JOIN ds_5 p ON o.person_id = p.id")
+    datasets, _ := queryDatasets(gdb, q, 1)
+    mappings, _ = detectDeltaTables(gdb, q, 1)
+    if _, err := planFederation(context.Background(), gdb, q, datasets, mappings); err == nil || !strings.Contains(err.Error(), "more than 100000 rows") {
+        t.Fatalf("capped pull: %v", err)
+    }
+}
+
+func TestDetectDeltaTables_TimeTravel(t *testing.T) {
+    gdb, r := rowChangeEnv(t)
+    r.POST("/api/query/execute", ExecuteQueryHandler(gdb))
+    gdb.Create(&models.Dataset{ID: 7, ProjectID: 1, Name: "orders", StorageBackend: "delta", TargetSchema: "sales", TargetTable: "orders"})
+    ds := models.Dataset{ID: 5, ProjectID: 1, Name: "people", Schema: peopleSchema}
+    gdb.Create(&ds)
+    if err := ensureDatasetTable(gdb, &ds); err != nil {
+        t.Fatalf("ensure table: %v", err)
+    }
+
+    q, err := parseReadOnlyQuery("SELECT * FROM sales.orders VERSION AS OF 3 a JOIN orders TIMESTAMP AS OF '2026-01-01 08:30:00' b ON a.id = b.id JOIN sales.orders c ON c.id = a.id")
+    if err != nil {
+        t.Fatalf("parse: %v", err)
+    }
+    mappings, isDelta := detectDeltaTables(gdb, q, 1)
+    want := map[string]string{"sales.orders__v3": "1/7@v3", "orders__t20260101t083000z": "1/7@t2026-01-01T08:30:00Z", "sales.orders": "1/7"}
+    if !isDelta || !reflect.DeepEqual(mappings, want) {
+        t.Fatalf("mappings: %v", mappings)
+    }
+
+    code, resp := doJSON(t, r, "POST", "/api/query/execute", 2, gin.H{"project_id": 1, "sql": "SELECT * FROM ds_5 VERSION AS OF 1"})
+    if code != 400 || resp["error"] != "time_travel_unsupported" || resp["table"] != "ds_5" {
+        t.Fatalf("time travel on a table: %d %v", code, resp)
+    }
+}
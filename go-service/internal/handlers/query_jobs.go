@@ -0,0 +1,671 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/config"
+	dbpkg "github.com/oreo-io/oreo.io-v2/go-service/internal/database"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	services "github.com/oreo-io/oreo.io-v2/go-service/internal/service"
+	"github.com/parquet-go/parquet-go"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Async queries run as "query" jobs. The worker hands them to RunQueryJob, which runs the query
+// without paging and spills every row to a JSONL file: a {"columns": [...]} header, then one JSON
+// array per row; Delta queries have their rows streamed from the Python service. The job's user
+// must still be able to read every table when it runs. Clients poll GET /api/query/jobs/:jobId or
+// wait for a query_job event on the notifications stream, then page through the result or download
+// it as CSV, JSONL or Parquet. SweepQueryResults removes results after the retention period.
+
+// queryJobType is the models.Job type of async queries.
+const queryJobType = "query"
+
+// asyncQueryRowLimit caps the rows an async query keeps.
+const asyncQueryRowLimit = 1000000
+
+var errQueryCancelled = errors.New("query_cancelled")
+
+// submitQueryJob queues an authorized query as a job. boundSQL and args are the query with its
+// parameters bound.
+func submitQueryJob(c *gin.Context, db *gorm.DB, req QueryExecuteRequest, boundSQL string, args []any, historyProject uint) {
+	id := uuid.New()
+	if req.QueryID != "" {
+		parsed, err := uuid.Parse(req.QueryID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid query_id"})
+			return
+		}
+		id = parsed
+	}
+	// The job runs boundSQL, which may differ from the SQL submitted even without parameters:
+	// information_schema relations are already answered from the caller's catalog
+	job := models.Job{ID: id, Type: queryJobType, Status: "pending", UserID: contextUserID(c), ProjectID: historyProject, Metadata: models.JSONB{
+		"sql":             req.SQL,
+		"bound_sql":       boundSQL,
+		"project_id":      req.ProjectID,
+		"history_project": historyProject,
+		"user_id":         contextUserID(c),
+	}}
+	if len(args) > 0 {
+		job.Metadata["args"] = args
+	}
+	if err := db.Create(&job).Error; err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "async queries unavailable"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"job_id": job.ID, "query_id": job.ID, "status": job.Status})
+}
+
+// asyncQueryTimeout is the configured timeout of async queries.
+func asyncQueryTimeout() time.Duration {
+	return time.Duration(config.Get().AsyncQueryTimeout) * time.Second
+}
+
+// queryResultPath is where the result of a query job is spilled.
+func queryResultPath(jobID uuid.UUID) string {
+	return filepath.Join(config.Get().QueryResultsDir, jobID.String()+".jsonl")
+}
+
+// jobUint reads a number from job metadata.
+func jobUint(v any) uint {
+	switch x := v.(type) {
+	case float64:
+		return uint(x)
+	case uint:
+		return x
+	case int:
+		return uint(x)
+	case json.Number:
+		n, _ := x.Int64()
+		return uint(n)
+	}
+	return 0
+}
+
+// QueryJobType runs query jobs. A query that fails is not retried, but one whose worker went
+// away is run once more.
+var QueryJobType = services.JobType{Run: RunQueryJob, MaxAttempts: 2, Done: queryJobDone}
+
+// RunQueryJob runs a claimed query job.
+func RunQueryJob(ctx context.Context, gdb *gorm.DB, job *models.Job) error {
+	uid := job.UserID
+	sqlText, _ := job.Metadata["sql"].(string)
+	boundSQL, args := sqlText, jobArgs(job.Metadata["args"])
+	if s, ok := job.Metadata["bound_sql"].(string); ok {
+		boundSQL = s
+	}
+	result, err := executeQueryJob(ctx, gdb, job, uid, jobUint(job.Metadata["project_id"]), boundSQL, args)
+	if err != nil {
+		os.Remove(queryResultPath(job.ID))
+		job.Result = models.JSONB{"error": err.Error()}
+		if errors.Is(err, errQueryCancelled) {
+			job.Status = "cancelled"
+		}
+		return services.Permanent(err)
+	}
+	job.Result = result
+	recordQueryHistory(gdb, uid, jobUint(job.Metadata["history_project"]), sqlText, jobInt(result["row_count"]))
+	return nil
+}
+
+// queryJobDone tells the job's owner it finished.
+func queryJobDone(gdb *gorm.DB, job *models.Job) {
+	publishQueryJob(jobUint(job.Metadata["user_id"]), job)
+}
+
+// jobArgs reads the parameter values of a query job. Integers come back from JSON as floats.
+func jobArgs(v any) []any {
+	list, _ := v.([]any)
+	for i, x := range list {
+		if f, ok := x.(float64); ok && f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			list[i] = int64(f)
+		}
+	}
+	return list
+}
+
+func jobInt(v any) int {
+	switch x := v.(type) {
+	case int:
+		return x
+	case float64:
+		return int(x)
+	}
+	return 0
+}
+
+// publishQueryJob tells the job's owner it finished.
+func publishQueryJob(userID uint, job *models.Job) {
+	b, _ := json.Marshal(gin.H{"type": "query_job", "job_id": job.ID, "status": job.Status})
+	NotifHub.Publish(userID, b)
+}
+
+// executeQueryJob runs the query of a job, with its parameter values, into its spill file and
+// returns the job result. Access is checked again, as the user's roles may have changed since the
+// job was queued.
+func executeQueryJob(ctx context.Context, gdb *gorm.DB, job *models.Job, uid, projectID uint, sqlText string, args []any) (models.JSONB, error) {
+	q, err := parseReadOnlyQuery(sqlText)
+	if err != nil {
+		return nil, err
+	}
+	if projectID != 0 && !hasProjectRole(gdb, uid, projectID, "owner", "contributor", "viewer") {
+		return nil, errors.New("forbidden")
+	}
+	datasets, _, err := checkQueryTables(gdb, uid, projectID, q)
+	if err != nil {
+		return nil, err
+	}
+	rq, ctx, err := startQuery(ctx, uid, job.ID.String(), asyncQueryTimeout())
+	if err != nil {
+		return nil, err
+	}
+	defer rq.finish()
+	ended := func(err error) error {
+		switch {
+		case rq.isCancelled():
+			return errQueryCancelled
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			return errors.New("query_timeout")
+		}
+		return err
+	}
+
+	path := queryResultPath(job.ID)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	var columns []string
+	count, truncated := 0, false
+	writeRow := func(row []any) error {
+		if count == asyncQueryRowLimit {
+			truncated = true
+			return nil
+		}
+		count++
+		return enc.Encode(row)
+	}
+
+	if mappings, isDelta := detectDeltaTables(gdb, q, projectID); isDelta {
+		inline, err := planFederation(ctx, gdb, q, datasets, mappings)
+		if err != nil {
+			return nil, ended(err)
+		}
+		// Rows are written as they arrive rather than held in memory
+		err = streamDeltaQuery(ctx, rq, deltaSQL(q), args, mappings, inline, asyncQueryRowLimit+1, func(cols []string) error {
+			columns = cols
+			return enc.Encode(gin.H{"columns": columns})
+		}, writeRow)
+		if err != nil {
+			return nil, ended(err)
+		}
+	} else {
+		dialect := dialect(gdb)
+		rewritten, execRemote, remoteDSN, remoteDB, err := planQueryExecution(gdb, q, projectID, currentDatabaseName(gdb, dialect), dialect)
+		if err != nil {
+			return nil, err
+		}
+		execDB := gdb
+		if execRemote {
+			rdb, err := gorm.Open(postgres.Open(remoteDSN), &gorm.Config{})
+			if err != nil {
+				return nil, fmt.Errorf("failed connecting to remote DB '%s'", remoteDB)
+			}
+			if sqlDB, err := rdb.DB(); err == nil {
+				defer sqlDB.Close()
+			}
+			execDB = rdb
+		}
+		rows, done, err := runQuery(ctx, rq, execDB, fmt.Sprintf("SELECT * FROM (%s) AS q LIMIT %d", rewritten, asyncQueryRowLimit+1), args...)
+		if err != nil {
+			return nil, ended(err)
+		}
+		defer done()
+		if columns, err = rows.Columns(); err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(gin.H{"columns": columns}); err != nil {
+			return nil, err
+		}
+		vals := make([]any, len(columns))
+		ptrs := make([]any, len(columns))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		for rows.Next() {
+			if err := rows.Scan(ptrs...); err != nil {
+				return nil, err
+			}
+			row := make([]any, len(vals))
+			for i, v := range vals {
+				// Postgres text/jsonb can scan as []byte
+				if b, ok := v.([]byte); ok {
+					row[i] = string(b)
+				} else {
+					row[i] = v
+				}
+			}
+			if err := writeRow(row); err != nil {
+				return nil, err
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, ended(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	retention := time.Duration(config.Get().QueryResultRetention) * time.Hour
+	return models.JSONB{
+		"columns":    columns,
+		"row_count":  count,
+		"truncated":  truncated,
+		"expires_at": time.Now().Add(retention).UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// cancelPendingQueryJob cancels a query job of the caller that has not started yet, and reports
+// whether there was one.
+func cancelPendingQueryJob(c *gin.Context, id string) bool {
+	gdb := dbpkg.Get()
+	jobID, err := uuid.Parse(id)
+	if gdb == nil || err != nil || !gdb.Migrator().HasTable(&models.Job{}) {
+		return false
+	}
+	job, ok := loadQueryJob(c, gdb, jobID)
+	if !ok {
+		return true
+	}
+	res := gdb.Model(&models.Job{}).Where("id = ? AND status = ?", job.ID, "pending").
+		Updates(map[string]any{"status": "cancelled", "result": models.JSONB{"error": errQueryCancelled.Error()}})
+	if res.Error != nil || res.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "not_running", "status": job.Status})
+		return true
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "query_id": job.ID})
+	return true
+}
+
+// loadQueryJob loads a query job of the caller, responding when there is none.
+func loadQueryJob(c *gin.Context, gdb *gorm.DB, id uuid.UUID) (*models.Job, bool) {
+	var job models.Job
+	if err := gdb.Where("id = ? AND type = ?", id, queryJobType).First(&job).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+		return nil, false
+	}
+	if role, _ := c.Get("user_role"); jobUint(job.Metadata["user_id"]) != contextUserID(c) && role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return nil, false
+	}
+	return &job, true
+}
+
+// queryJobFromParam loads the query job named by the :jobId parameter.
+func queryJobFromParam(c *gin.Context, db *gorm.DB) (*models.Job, bool) {
+	id, err := uuid.Parse(c.Param("jobId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid job id"})
+		return nil, false
+	}
+	return loadQueryJob(c, db, id)
+}
+
+// QueryJobGetHandler reports the status of a query job.
+func QueryJobGetHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		job, ok := queryJobFromParam(c, db)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"job_id": job.ID, "status": job.Status, "result": job.Result,
+			"created_at": job.CreatedAt, "updated_at": job.UpdatedAt})
+	}
+}
+
+// openQueryResult opens the spilled result of a finished query job, responding when there is
+// none. The returned decoder is positioned at the first row.
+func openQueryResult(c *gin.Context, db *gorm.DB) (*models.Job, *os.File, *json.Decoder, []string, bool) {
+	job, ok := queryJobFromParam(c, db)
+	if !ok {
+		return nil, nil, nil, nil, false
+	}
+	switch job.Status {
+	case "success":
+	case "expired":
+		c.JSON(http.StatusGone, gin.H{"error": "expired"})
+		return nil, nil, nil, nil, false
+	case "pending", "running":
+		c.JSON(http.StatusConflict, gin.H{"error": "not_ready", "status": job.Status})
+		return nil, nil, nil, nil, false
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": "no_result", "status": job.Status, "result": job.Result})
+		return nil, nil, nil, nil, false
+	}
+	f, err := os.Open(queryResultPath(job.ID))
+	if err != nil {
+		c.JSON(http.StatusGone, gin.H{"error": "expired"})
+		return nil, nil, nil, nil, false
+	}
+	dec := json.NewDecoder(bufio.NewReader(f))
+	dec.UseNumber()
+	var header struct {
+		Columns []string `json:"columns"`
+	}
+	if err := dec.Decode(&header); err != nil {
+		f.Close()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "corrupt_result"})
+		return nil, nil, nil, nil, false
+	}
+	return job, f, dec, header.Columns, true
+}
+
+// QueryJobResultsHandler returns a page of a query job's result.
+func QueryJobResultsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		job, f, dec, columns, ok := openQueryResult(c, db)
+		if !ok {
+			return
+		}
+		defer f.Close()
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		if limit <= 0 || limit > 1000 {
+			limit = 250
+		}
+		page, _ := strconv.Atoi(c.Query("page"))
+		if page <= 0 {
+			page = 1
+		}
+		res := QueryExecuteResponse{QueryID: job.ID.String(), Columns: columns, Rows: [][]interface{}{}, Total: int64(jobInt(job.Result["row_count"]))}
+		for i := 0; i < page*limit; i++ {
+			var row []any
+			if err := dec.Decode(&row); err != nil {
+				break
+			}
+			if i >= (page-1)*limit {
+				res.Rows = append(res.Rows, row)
+			}
+		}
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+// QueryJobDownloadHandler streams a query job's full result as CSV, JSONL or Parquet.
+func QueryJobDownloadHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := c.DefaultQuery("format", "csv")
+		if format != "csv" && format != "jsonl" && format != "parquet" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid format"})
+			return
+		}
+		job, f, dec, columns, ok := openQueryResult(c, db)
+		if !ok {
+			return
+		}
+		defer f.Close()
+		contentType := map[string]string{"csv": "text/csv", "jsonl": "application/x-ndjson", "parquet": "application/vnd.apache.parquet"}[format]
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="query-%s.%s"`, job.ID, format))
+		c.Status(http.StatusOK)
+		var err error
+		switch format {
+		case "csv":
+			err = writeResultCSV(c.Writer, dec, columns)
+		case "jsonl":
+			err = writeResultJSONL(c.Writer, dec, columns)
+		case "parquet":
+			err = writeResultParquet(c.Writer, f, columns)
+		}
+		if err != nil {
+			// Headers are out; the client sees a truncated file
+			log.Printf("[query job] download %s as %s: %v", job.ID, format, err)
+		}
+	}
+}
+
+// nextResultRow decodes the next spilled row, returning io.EOF after the last.
+func nextResultRow(dec *json.Decoder) ([]any, error) {
+	var row []any
+	if err := dec.Decode(&row); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// resultCell formats a spilled value for CSV.
+func resultCell(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case json.Number:
+		return x.String()
+	case bool:
+		return strconv.FormatBool(x)
+	default:
+		b, _ := json.Marshal(x)
+		return string(b)
+	}
+}
+
+func writeResultCSV(out io.Writer, dec *json.Decoder, columns []string) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(columns); err != nil {
+		return err
+	}
+	for {
+		row, err := nextResultRow(dec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		rec := make([]string, len(row))
+		for i, v := range row {
+			rec[i] = resultCell(v)
+		}
+		if err := w.Write(rec); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// uniqueColumnNames suffixes repeated column names, as a join of two tables may produce, so they
+// can be object keys.
+func uniqueColumnNames(columns []string) []string {
+	seen := map[string]int{}
+	out := make([]string, len(columns))
+	for i, col := range columns {
+		name := col
+		for seen[name] > 0 {
+			seen[col]++
+			name = fmt.Sprintf("%s_%d", col, seen[col])
+		}
+		seen[name]++
+		out[i] = name
+	}
+	return out
+}
+
+func writeResultJSONL(out io.Writer, dec *json.Decoder, columns []string) error {
+	names := uniqueColumnNames(columns)
+	enc := json.NewEncoder(out)
+	for {
+		row, err := nextResultRow(dec)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		obj := make(map[string]any, len(row))
+		for i, v := range row {
+			if i < len(names) {
+				obj[names[i]] = v
+			}
+		}
+		if err := enc.Encode(obj); err != nil {
+			return err
+		}
+	}
+}
+
+// writeResultParquet writes a spilled result as Parquet. Column types are inferred from the values
+// in a first pass over the file: long, double, boolean, or string for anything else or mixed.
+func writeResultParquet(out io.Writer, f *os.File, columns []string) error {
+	readRows := func(fn func([]any) error) error {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		dec := json.NewDecoder(bufio.NewReader(f))
+		dec.UseNumber()
+		var header map[string]any
+		if err := dec.Decode(&header); err != nil {
+			return err
+		}
+		for {
+			row, err := nextResultRow(dec)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+	}
+	types := make([]string, len(columns))
+	err := readRows(func(row []any) error {
+		for i, v := range row {
+			if i >= len(types) || v == nil {
+				continue
+			}
+			t := "string"
+			switch x := v.(type) {
+			case bool:
+				t = "boolean"
+			case json.Number:
+				t = "double"
+				if _, err := x.Int64(); err == nil {
+					t = "long"
+				}
+			}
+			switch prev := types[i]; {
+			case prev == "" || prev == t:
+				types[i] = t
+			case (prev == "long" && t == "double") || (prev == "double" && t == "long"):
+				types[i] = "double"
+			default:
+				types[i] = "string"
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	names := uniqueColumnNames(columns)
+	group := parquet.Group{}
+	for i, name := range names {
+		switch types[i] {
+		case "long":
+			group[name] = parquet.Optional(parquet.Int(64))
+		case "double":
+			group[name] = parquet.Optional(parquet.Leaf(parquet.DoubleType))
+		case "boolean":
+			group[name] = parquet.Optional(parquet.Leaf(parquet.BooleanType))
+		default:
+			group[name] = parquet.Optional(parquet.String())
+		}
+	}
+	schema := parquet.NewSchema("root", group)
+	// parquet.Group orders columns by name; map each leaf back to its result column
+	leaves := schema.Fields()
+	index := map[string]int{}
+	for i, name := range names {
+		index[name] = i
+	}
+	w := parquet.NewWriter(out, schema, parquet.Compression(&parquet.Snappy))
+	err = readRows(func(row []any) error {
+		prow := make(parquet.Row, len(leaves))
+		for li, leaf := range leaves {
+			ci := index[leaf.Name()]
+			var v any
+			if ci < len(row) {
+				v = row[ci]
+			}
+			if v == nil {
+				prow[li] = parquet.Value{}.Level(0, 0, li)
+				continue
+			}
+			var pv parquet.Value
+			switch types[ci] {
+			case "long":
+				n, _ := v.(json.Number).Int64()
+				pv = parquet.ValueOf(n)
+			case "double":
+				x, _ := v.(json.Number).Float64()
+				pv = parquet.ValueOf(x)
+			case "boolean":
+				pv = parquet.ValueOf(v.(bool))
+			default:
+				pv = parquet.ValueOf(resultCell(v))
+			}
+			prow[li] = pv.Level(0, 1, li)
+		}
+		_, err := w.WriteRows([]parquet.Row{prow})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// SweepQueryResults expires query job results past the retention period.
+func SweepQueryResults(gdb *gorm.DB, now time.Time) {
+	retention := time.Duration(config.Get().QueryResultRetention) * time.Hour
+	var jobs []models.Job
+	if err := gdb.Where("type = ? AND status IN ? AND updated_at < ?", queryJobType, []string{"success", "failed", "cancelled"}, now.Add(-retention)).
+		Find(&jobs).Error; err != nil {
+		log.Printf("[SweepQueryResults] %v", err)
+		return
+	}
+	for _, job := range jobs {
+		if err := os.Remove(queryResultPath(job.ID)); err != nil && !os.IsNotExist(err) {
+			log.Printf("[SweepQueryResults] remove %s: %v", job.ID, err)
+			continue
+		}
+		gdb.Model(&models.Job{}).Where("id = ? AND status = ?", job.ID, job.Status).Update("status", "expired")
+	}
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+    "net/http/httptest"
+    "os"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+    services "github.com/oreo-io/oreo.io-v2/go-service/internal/service"
+)
+
+func TestQueryJobs_RunPageDownloadExpire(t *testing.T) {
+    t.Setenv("QUERY_RESULTS_DIR", t.TempDir())
+    gdb, r := rowChangeEnv(t)
+    if err := gdb.AutoMigrate(&models.QueryHistory{}); err != nil {
+        t.Fatalf("migrate: %v", err)
+    }
+    // models.Job defaults its id in Postgres; sqlite gets a plain table
+    gdb.Exec(`CREATE TABLE jobs (id text primary key, type text, status text, metadata text, result text, user_id integer, project_id integer,
+        progress integer not null default 0, progress_message text, schedule_id integer, scheduled_for datetime, cancel_requested_at datetime, attempts integer not null default 0, run_at datetime, last_error text, locked_by text, lease_expires_at datetime, created_at datetime, updated_at datetime)`)
+    services.RegisterJobType(queryJobType, QueryJobType)
+    r.POST("/api/query/execute", ExecuteQueryHandler(gdb))
+    r.DELETE("/api/query/:queryId", CancelQueryHandler)
+    r.GET("/api/query/jobs/:jobId", QueryJobGetHandler(gdb))
+    r.GET("/api/query/jobs/:jobId/results", QueryJobResultsHandler(gdb))
+    r.GET("/api/query/jobs/:jobId/download", QueryJobDownloadHandler(gdb))
+    ds := models.Dataset{ID: 5, ProjectID: 1, Name: "people", Schema: peopleSchema}
+    gdb.Create(&ds)
+    if err := ensureDatasetTable(gdb, &ds); err != nil {
+        t.Fatalf("ensure table: %v", err)
+    }
+    for _, row := range []string{`{"id":1,"name":"ann"}`, `{"id":2,"name":"bob"}`, `{"id":3,"name":"cy, jr"}`} {
+        gdb.Exec("INSERT INTO ds_5 (data) VALUES (?)", row)
+    }
+
+    sql := "SELECT json_extract(data, '$.id') AS id, json_extract(data, '$.name') AS name FROM ds_5 ORDER BY id"
+    code, resp := doJSON(t, r, "POST", "/api/query/execute", 2, gin.H{"sql": sql, "project_id": 1, "async": true})
+    if code != 202 || resp["status"] != "pending" {
+        t.Fatalf("submit: %d %v", code, resp)
+    }
+    jobID := resp["job_id"].(string)
+    if code, resp := doJSON(t, r, "GET", "/api/query/jobs/"+jobID+"/results", 2, nil); code != 409 || resp["error"] != "not_ready" {
+        t.Fatalf("results before run: %d %v", code, resp)
+    }
+    if code, _ := doJSON(t, r, "GET", "/api/query/jobs/"+jobID, 1, nil); code != 403 {
+        t.Fatalf("someone else's job: %d", code)
+    }
+
+    if !services.RunPendingJob(gdb) {
+        t.Fatalf("job not run")
+    }
+
+    code, resp = doJSON(t, r, "GET", "/api/query/jobs/"+jobID, 2, nil)
+    if code != 200 || resp["status"] != "success" || resp["result"].(map[string]any)["row_count"] != float64(3) {
+        t.Fatalf("status: %d %v", code, resp)
+    }
+    code, resp = doJSON(t, r, "GET", "/api/query/jobs/"+jobID+"/results?page=2&limit=2", 2, nil)
+    if rows, _ := resp["rows"].([]any); code != 200 || len(rows) != 1 || rows[0].([]any)[1] != "cy, jr" || resp["total"] != float64(3) {
+        t.Fatalf("page 2: %d %v", code, resp)
+    }
+    download := func(format string) (int, string) {
+        req := httptest.NewRequest("GET", "/api/query/jobs/"+jobID+"/download?format="+format, nil)
+        req.Header.Set("X-User", "2")
+        w := httptest.NewRecorder()
+        r.ServeHTTP(w, req)
+        return w.Code, w.Body.String()
+    }
+    if code, body := download("csv"); code != 200 || body != "id,name\n1,ann\n2,bob\n3,\"cy, jr\"\n" {
+        t.Fatalf("csv: %d %q", code, body)
+    }
+    if code, body := download("jsonl"); code != 200 || !strings.HasPrefix(body, `{"id":1,"name":"ann"}`+"\n") {
+        t.Fatalf("jsonl: %d %q", code, body)
+    }
+    if code, body := download("parquet"); code != 200 || !strings.HasPrefix(body, "PAR1") {
+        t.Fatalf("parquet: %d", code)
+    }
+    var hist []models.QueryHistory
+    gdb.Find(&hist)
+    if len(hist) != 1 || hist[0].UserID != 2 || hist[0].ResultRows != 3 {
+        t.Fatalf("history: %+v", hist)
+    }
+
+    // A job still waiting for the worker can be cancelled
+    code, resp = doJSON(t, r, "POST", "/api/query/execute", 2, gin.H{"sql": sql, "project_id": 1, "async": true})
+    pending := resp["job_id"].(string)
+    if code, _ := doJSON(t, r, "DELETE", "/api/query/"+pending, 2, nil); code != 200 {
+        t.Fatalf("cancel pending: %d", code)
+    }
+    if code, resp := doJSON(t, r, "GET", "/api/query/jobs/"+pending, 2, nil); code != 200 || resp["status"] != "cancelled" {
+        t.Fatalf("cancelled job: %d %v", code, resp)
+    }
+
+    // Access is checked again when the job runs
+    code, resp = doJSON(t, r, "POST", "/api/query/execute", 2, gin.H{"sql": sql, "project_id": 1, "async": true})
+    revoked := resp["job_id"].(string)
+    gdb.Where("project_id = ? AND user_id = ?", 1, 2).Delete(&models.ProjectRole{})
+    services.RunPendingJob(gdb)
+    if code, resp := doJSON(t, r, "GET", "/api/query/jobs/"+revoked, 2, nil); code != 200 || resp["status"] != "failed" || resp["result"].(map[string]any)["error"] != "forbidden" {
+        t.Fatalf("revoked access: %d %v", code, resp)
+    }
+
+    SweepQueryResults(gdb, time.Now().Add(25*time.Hour))
+    if _, err := os.Stat(queryResultPath(uuid.MustParse(jobID))); !os.IsNotExist(err) {
+        t.Fatalf("result file kept: %v", err)
+    }
+    if code, resp := doJSON(t, r, "GET", "/api/query/jobs/"+jobID+"/results", 2, nil); code != 410 {
+        t.Fatalf("expired results: %d %v", code, resp)
+    }
+}
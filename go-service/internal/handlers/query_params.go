@@ -0,0 +1,432 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/storage"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Queries name their parameters :name. Before a query is parsed, bindQueryParams replaces each
+// with a positional $n and checks its value, and the values travel beside the SQL to Postgres,
+// sqlite or DuckDB, so they are never spliced into the text. Saved queries declare their
+// parameters with a type; ad-hoc queries may pass any scalar JSON value.
+
+var paramNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// enumParamValueLimit caps the values an enum parameter offers.
+const enumParamValueLimit = 1000
+
+// namedParam is a :name placeholder in a query.
+type namedParam struct {
+	name       string
+	start, end int
+}
+
+// namedParams finds the :name placeholders of sqlText. A colon directly after another, as in
+// x::int, is a cast.
+func namedParams(sqlText string) ([]namedParam, []sqlToken, error) {
+	toks, err := tokenizeSQL(sqlText)
+	if err != nil {
+		return nil, nil, err
+	}
+	var out []namedParam
+	for i := 0; i+1 < len(toks); i++ {
+		t, next := toks[i], toks[i+1]
+		if !t.isPunct(":") || next.kind != sqlWord || next.start != t.end {
+			continue
+		}
+		if i > 0 && toks[i-1].isPunct(":") && toks[i-1].end == t.start {
+			continue
+		}
+		out = append(out, namedParam{name: sqlText[next.start:next.end], start: t.start, end: next.end})
+		i++
+	}
+	return out, toks, nil
+}
+
+// bindQueryParams rewrites the :name placeholders of sqlText to $1, $2, ... and returns the
+// values to pass with it. With defs, as for saved queries, every placeholder must be declared
+// and values are converted to the declared types; without, values may be any JSON scalar. A query
+// without values is left as written.
+func bindQueryParams(sqlText string, defs models.QueryParams, values map[string]any) (string, []any, error) {
+	params, toks, err := namedParams(sqlText)
+	if err != nil {
+		return "", nil, err
+	}
+	if defs == nil && len(values) == 0 {
+		return sqlText, nil, nil
+	}
+	for _, t := range toks {
+		if t.kind == sqlParam && strings.HasPrefix(t.value, "$") {
+			return "", nil, fmt.Errorf("positional parameter %s: name parameters as :name", t.value)
+		}
+	}
+	byName := map[string]models.QueryParam{}
+	for _, d := range defs {
+		byName[d.Name] = d
+	}
+	for name := range values {
+		if _, ok := byName[name]; defs != nil && !ok {
+			return "", nil, fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+	var b strings.Builder
+	var args []any
+	index := map[string]int{}
+	last := 0
+	for _, p := range params {
+		n, ok := index[p.name]
+		if !ok {
+			var v any
+			if defs != nil {
+				d, declared := byName[p.name]
+				if !declared {
+					return "", nil, fmt.Errorf("parameter :%s is not declared", p.name)
+				}
+				if v, err = paramValue(d, values[p.name]); err != nil {
+					return "", nil, err
+				}
+			} else {
+				raw, given := values[p.name]
+				if !given {
+					return "", nil, fmt.Errorf("missing parameter %q", p.name)
+				}
+				if v, err = scalarParam(p.name, raw); err != nil {
+					return "", nil, err
+				}
+			}
+			args = append(args, v)
+			n = len(args)
+			index[p.name] = n
+		}
+		b.WriteString(sqlText[last:p.start])
+		fmt.Fprintf(&b, "$%d", n)
+		last = p.end
+	}
+	b.WriteString(sqlText[last:])
+	return b.String(), args, nil
+}
+
+// scalarParam checks the value of an undeclared parameter.
+func scalarParam(name string, v any) (any, error) {
+	switch x := v.(type) {
+	case nil, string, bool:
+		return x, nil
+	case float64:
+		if x == math.Trunc(x) && math.Abs(x) < 1<<53 {
+			return int64(x), nil
+		}
+		return x, nil
+	}
+	return nil, fmt.Errorf("parameter %q must be a string, number, boolean or null", name)
+}
+
+// paramValue converts the value given for a declared parameter, or its default, to its type.
+func paramValue(d models.QueryParam, v any) (any, error) {
+	if v == nil {
+		v = d.Default
+	}
+	if v == nil {
+		return nil, fmt.Errorf("missing parameter %q", d.Name)
+	}
+	switch d.Type {
+	case "string", "enum":
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("parameter %q must be a string", d.Name)
+		}
+		return s, nil
+	case "int":
+		switch x := v.(type) {
+		case float64:
+			if x == math.Trunc(x) && math.Abs(x) < 1<<53 {
+				return int64(x), nil
+			}
+		case string:
+			if n, err := strconv.ParseInt(strings.TrimSpace(x), 10, 64); err == nil {
+				return n, nil
+			}
+		}
+		return nil, fmt.Errorf("parameter %q must be an integer", d.Name)
+	case "date":
+		s, _ := v.(string)
+		t, err := time.Parse("2006-01-02", strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("parameter %q must be a date (YYYY-MM-DD)", d.Name)
+		}
+		return t.Format("2006-01-02"), nil
+	}
+	return nil, fmt.Errorf("parameter %q has unknown type %q", d.Name, d.Type)
+}
+
+// validateParamDefs checks the parameters declared for a saved query of projectID.
+func validateParamDefs(db *gorm.DB, projectID uint, sqlText string, defs models.QueryParams) error {
+	seen := map[string]bool{}
+	for _, d := range defs {
+		if !paramNameRe.MatchString(d.Name) {
+			return fmt.Errorf("invalid parameter name %q", d.Name)
+		}
+		if seen[d.Name] {
+			return fmt.Errorf("parameter %q declared twice", d.Name)
+		}
+		seen[d.Name] = true
+		switch d.Type {
+		case "string", "int", "date":
+		case "enum":
+			if d.DatasetID == 0 || strings.TrimSpace(d.Column) == "" {
+				return fmt.Errorf("enum parameter %q needs dataset_id and column", d.Name)
+			}
+			var ds models.Dataset
+			if err := db.Where("id = ? AND project_id = ?", d.DatasetID, projectID).First(&ds).Error; err != nil {
+				return fmt.Errorf("enum parameter %q: dataset %d not found in project", d.Name, d.DatasetID)
+			}
+		default:
+			return fmt.Errorf("parameter %q has unknown type %q", d.Name, d.Type)
+		}
+		if d.Default != nil {
+			if _, err := paramValue(d, d.Default); err != nil {
+				return fmt.Errorf("default: %w", err)
+			}
+		}
+	}
+	params, _, err := namedParams(sqlText)
+	if err != nil {
+		return err
+	}
+	for _, p := range params {
+		if !seen[p.name] {
+			return fmt.Errorf("parameter :%s is not declared", p.name)
+		}
+	}
+	return nil
+}
+
+// enumParamSource loads the dataset of an enum parameter and opens the database holding its rows,
+// unless it is a Delta dataset. done closes that database.
+func enumParamSource(db *gorm.DB, d models.QueryParam) (ds *models.Dataset, execDB *gorm.DB, done func(), err error) {
+	ds = &models.Dataset{}
+	if err := db.First(ds, d.DatasetID).Error; err != nil {
+		return nil, nil, nil, fmt.Errorf("dataset %d not found", d.DatasetID)
+	}
+	done = func() {}
+	execDB = db
+	if dsn := strings.TrimSpace(ds.TargetDSN); dsn != "" && !isDeltaBackend(ds) {
+		rdb, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		if err != nil {
+			return nil, nil, nil, errors.New("failed connecting to target database")
+		}
+		if sqlDB, err := rdb.DB(); err == nil {
+			done = func() { sqlDB.Close() }
+		}
+		execDB = rdb
+	}
+	return ds, execDB, done, nil
+}
+
+// enumColumnExpr is the SQL reading an enum parameter's column from the JSON documents in the
+// data column of a dataset table, as text; it takes the column name as its one argument.
+func enumColumnExpr(execDB *gorm.DB) string {
+	if dialect(execDB) == "postgres" {
+		return "data->>?"
+	}
+	return "CAST(json_extract(data, '$.' || ?) AS TEXT)"
+}
+
+// enumParamHasValue reports whether v is among the values of an enum parameter's column.
+func enumParamHasValue(ctx context.Context, db *gorm.DB, userID uint, d models.QueryParam, v string) (bool, error) {
+	ds, execDB, done, err := enumParamSource(db, d)
+	if err != nil {
+		return false, err
+	}
+	defer done()
+	if isDeltaBackend(ds) {
+		rq, qctx, err := startQuery(ctx, userID, "", queryTimeout())
+		if err != nil {
+			return false, err
+		}
+		defer rq.finish()
+		sqlText := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM t WHERE CAST(%s AS VARCHAR) = $1) AS found`, quoteIfNeeded(d.Column))
+		resp, err := executeDeltaQuery(qctx, rq, sqlText, []any{v}, map[string]string{"t": storage.DeltaTableID(ds.ProjectID, ds.ID)}, nil, 1, 1)
+		if err != nil {
+			return false, err
+		}
+		return len(resp.Rows) == 1 && len(resp.Rows[0]) == 1 && resp.Rows[0][0] == true, nil
+	}
+	var found bool
+	expr := enumColumnExpr(execDB)
+	err = execDB.WithContext(ctx).Raw(fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE %s = ?)", datasetPhysicalTable(ds), expr), d.Column, v).
+		Row().Scan(&found)
+	return found, err
+}
+
+// enumParamValues lists the distinct values of an enum parameter's column, as strings.
+func enumParamValues(ctx context.Context, db *gorm.DB, userID uint, d models.QueryParam) ([]string, error) {
+	ds, execDB, done, err := enumParamSource(db, d)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	var values []any
+	if isDeltaBackend(ds) {
+		rq, qctx, err := startQuery(ctx, userID, "", queryTimeout())
+		if err != nil {
+			return nil, err
+		}
+		defer rq.finish()
+		sqlText := fmt.Sprintf(`SELECT DISTINCT %s FROM t WHERE %s IS NOT NULL ORDER BY 1 LIMIT %d`, quoteIfNeeded(d.Column), quoteIfNeeded(d.Column), enumParamValueLimit)
+		resp, err := executeDeltaQuery(qctx, rq, sqlText, nil, map[string]string{"t": storage.DeltaTableID(ds.ProjectID, ds.ID)}, nil, enumParamValueLimit, 1)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range resp.Rows {
+			values = append(values, row[0])
+		}
+	} else {
+		// Dataset rows are JSON documents in the data column
+		expr := enumColumnExpr(execDB)
+		rows, err := execDB.WithContext(ctx).Raw(fmt.Sprintf("SELECT DISTINCT %s AS v FROM %s WHERE %s IS NOT NULL ORDER BY 1 LIMIT %d",
+			expr, datasetPhysicalTable(ds), expr, enumParamValueLimit), d.Column, d.Column).Rows()
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var v any
+			if err := rows.Scan(&v); err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		out = append(out, fmt.Sprint(v))
+	}
+	return out, nil
+}
+
+// loadSavedQuery loads the saved query named by the :id parameter, if the caller can view its
+// project, responding otherwise.
+func loadSavedQuery(c *gin.Context, db *gorm.DB) (*models.SavedQuery, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, false
+	}
+	var sq models.SavedQuery
+	if err := db.First(&sq, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+		return nil, false
+	}
+	if !HasProjectRole(c, sq.ProjectID, "owner", "contributor", "viewer") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return nil, false
+	}
+	return &sq, true
+}
+
+// SavedQueryGetHandler returns a saved query with its parameters.
+func SavedQueryGetHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if sq, ok := loadSavedQuery(c, db); ok {
+			c.JSON(http.StatusOK, sq)
+		}
+	}
+}
+
+// SavedQueryParamValuesHandler lists the values an enum parameter of a saved query may take.
+func SavedQueryParamValuesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sq, ok := loadSavedQuery(c, db)
+		if !ok {
+			return
+		}
+		for _, d := range sq.Params {
+			if d.Name != c.Param("name") {
+				continue
+			}
+			if d.Type != "enum" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "not_enum"})
+				return
+			}
+			values, err := enumParamValues(c.Request.Context(), db, contextUserID(c), d)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "values failed: " + err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"name": d.Name, "values": values})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+	}
+}
+
+// SavedQueryRunRequest runs a saved query with values for its parameters.
+type SavedQueryRunRequest struct {
+	Params  map[string]any `json:"params,omitempty"`
+	Page    int            `json:"page,omitempty"`
+	Limit   int            `json:"limit,omitempty"`
+	QueryID string         `json:"query_id,omitempty"`
+	Async   bool           `json:"async,omitempty"`
+}
+
+// SavedQueryRunHandler runs a saved query by ID, like ExecuteQueryHandler runs its SQL.
+func SavedQueryRunHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body SavedQueryRunRequest
+		if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+		sq, ok := loadSavedQuery(c, db)
+		if !ok {
+			return
+		}
+		defs := sq.Params
+		if defs == nil {
+			defs = models.QueryParams{}
+		}
+		// Enum values must be among the column's values, listed or not
+		for _, d := range defs {
+			if d.Type != "enum" {
+				continue
+			}
+			v, err := paramValue(d, body.Params[d.Name])
+			if err != nil {
+				continue // reported when binding
+			}
+			found, err := enumParamHasValue(c.Request.Context(), db, contextUserID(c), d, fmt.Sprint(v))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "values failed: " + err.Error()})
+				return
+			}
+			if !found {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_params", "message": fmt.Sprintf("parameter %q: %q is not a value of %s", d.Name, v, d.Column)})
+				return
+			}
+		}
+		executeQueryRequest(c, db, QueryExecuteRequest{
+			SQL: sq.SQL, Params: body.Params, Page: body.Page, Limit: body.Limit, ProjectID: sq.ProjectID,
+			QueryID: body.QueryID, Async: body.Async,
+		}, defs)
+	}
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+    "fmt"
+    "reflect"
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+)
+
+func TestBindQueryParams(t *testing.T) {
+    defs := models.QueryParams{
+        {Name: "min_age", Type: "int"},
+        {Name: "since", Type: "date", Default: "2024-01-01"},
+        {Name: "name", Type: "string"},
+    }
+    sql := "SELECT x::int FROM t WHERE age >= :min_age AND d >= :since AND (n = :name OR m = :name) AND s = ':no'"
+    got, args, err := bindQueryParams(sql, defs, map[string]any{"min_age": "30", "name": "o'brien"})
+    if err != nil {
+        t.Fatalf("bind: %v", err)
+    }
+    if want := "SELECT x::int FROM t WHERE age >= $1 AND d >= $2 AND (n = $3 OR m = $3) AND s = ':no'"; got != want {
+        t.Fatalf("sql: %s", got)
+    }
+    if want := []any{int64(30), "2024-01-01", "o'brien"}; !reflect.DeepEqual(args, want) {
+        t.Fatalf("args: %#v", args)
+    }
+
+    for _, tc := range []struct {
+        values map[string]any
+        defs   models.QueryParams
+        sql    string
+    }{
+        {map[string]any{"min_age": 1.5, "name": "a"}, defs, sql},
+        {map[string]any{"min_age": 1}, defs, sql},
+        {map[string]any{"min_age": 1, "name": "a", "other": 1}, defs, sql},
+        {map[string]any{"min_age": 1, "name": "a", "since": "01/02/2024"}, defs, sql},
+        {map[string]any{}, defs, "SELECT :undeclared"},
+        {map[string]any{"a": 1}, nil, "SELECT $1, :a"},
+        {map[string]any{"a": []any{1}}, nil, "SELECT :a"},
+    } {
+        if _, _, err := bindQueryParams(tc.sql, tc.defs, tc.values); err == nil {
+            t.Fatalf("%s with %v: no error", tc.sql, tc.values)
+        }
+    }
+
+    // Ad-hoc queries without values are left alone
+    if got, args, err := bindQueryParams("SELECT a[1:n] FROM t", nil, nil); err != nil || got != "SELECT a[1:n] FROM t" || args != nil {
+        t.Fatalf("no params: %q %v %v", got, args, err)
+    }
+}
+
+func TestSavedQuery_RunWithParams(t *testing.T) {
+    gdb, r := rowChangeEnv(t)
+    if err := gdb.AutoMigrate(&models.QueryHistory{}, &models.SavedQuery{}); err != nil {
+        t.Fatalf("migrate: %v", err)
+    }
+    r.POST("/api/query/execute", ExecuteQueryHandler(gdb))
+    r.POST("/api/query/save", SaveQueryHandler(gdb))
+    r.GET("/api/query/saved/:id/params/:name/values", SavedQueryParamValuesHandler(gdb))
+    r.POST("/api/query/saved/:id/run", SavedQueryRunHandler(gdb))
+    ds := models.Dataset{ID: 5, ProjectID: 1, Name: "people", Schema: peopleSchema}
+    gdb.Create(&ds)
+    if err := ensureDatasetTable(gdb, &ds); err != nil {
+        t.Fatalf("ensure table: %v", err)
+    }
+    for _, row := range []string{`{"id":1,"name":"ann","age":31}`, `{"id":2,"name":"bob","age":25}`, `{"id":3,"name":"cy","age":40}`} {
+        gdb.Exec("INSERT INTO ds_5 (data) VALUES (?)", row)
+    }
+
+    sql := "SELECT json_extract(data, '$.name') AS name FROM ds_5 WHERE json_extract(data, '$.age') >= :min_age AND json_extract(data, '$.name') <> :skip ORDER BY name"
+    save := func(params []gin.H) (int, map[string]any) {
+        return doJSON(t, r, "POST", "/api/query/save", 2, gin.H{"project_id": 1, "name": "adults", "sql": sql, "params": params})
+    }
+    if code, resp := save([]gin.H{{"name": "min_age", "type": "int"}}); code != 400 || resp["error"] != "invalid_params" {
+        t.Fatalf("undeclared placeholder: %d %v", code, resp)
+    }
+    if code, resp := save([]gin.H{{"name": "min_age", "type": "int"}, {"name": "skip", "type": "enum", "dataset_id": 5}}); code != 400 {
+        t.Fatalf("enum without column: %d %v", code, resp)
+    }
+    code, resp := save([]gin.H{{"name": "min_age", "type": "int", "default": 30}, {"name": "skip", "type": "enum", "dataset_id": 5, "column": "name"}})
+    if code != 201 {
+        t.Fatalf("save: %d %v", code, resp)
+    }
+    path := fmt.Sprintf("/api/query/saved/%v", resp["id"])
+
+    code, resp = doJSON(t, r, "GET", path+"/params/skip/values", 2, nil)
+    if values, _ := resp["values"].([]any); code != 200 || len(values) != 3 || values[0] != "ann" {
+        t.Fatalf("enum values: %d %v", code, resp)
+    }
+    run := func(user uint, params gin.H) (int, map[string]any) {
+        return doJSON(t, r, "POST", path+"/run", user, gin.H{"params": params})
+    }
+    code, resp = run(2, gin.H{"skip": "ann"})
+    if rows, _ := resp["rows"].([]any); code != 200 || len(rows) != 1 || rows[0].([]any)[0] != "cy" {
+        t.Fatalf("run with default: %d %v", code, resp)
+    }
+    code, resp = run(2, gin.H{"skip": "cy", "min_age": "20"})
+    if rows, _ := resp["rows"].([]any); code != 200 || len(rows) != 2 || rows[1].([]any)[0] != "bob" {
+        t.Fatalf("run: %d %v", code, resp)
+    }
+    if code, resp := run(2, gin.H{"skip": "x' OR '1'='1"}); code != 400 || resp["error"] != "invalid_params" {
+        t.Fatalf("value outside enum: %d %v", code, resp)
+    }
+    if code, resp := run(2, gin.H{"skip": "ann", "min_age": "old"}); code != 400 || resp["error"] != "invalid_params" {
+        t.Fatalf("bad int: %d %v", code, resp)
+    }
+    if code, _ := run(3, gin.H{"skip": "ann"}); code != 403 {
+        t.Fatalf("outsider: %d", code)
+    }
+    // A value past the listed ones is still a value of the column
+    gdb.Exec(`INSERT INTO ds_5 (data) WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < ?) SELECT json_object('name', printf('p%04d', i), 'age', 1) FROM n`, enumParamValueLimit)
+    if code, resp := doJSON(t, r, "GET", path+"/params/skip/values", 2, nil); code != 200 || len(resp["values"].([]any)) != enumParamValueLimit {
+        t.Fatalf("capped enum values: %d %v", code, resp)
+    }
+    if code, resp := run(2, gin.H{"skip": fmt.Sprintf("p%04d", enumParamValueLimit)}); code != 200 {
+        t.Fatalf("unlisted enum value: %d %v", code, resp)
+    }
+
+    // Ad-hoc queries bind their params too
+    code, resp = doJSON(t, r, "POST", "/api/query/execute", 2, gin.H{"project_id": 1,
+        "sql": "SELECT json_extract(data, '$.name') FROM ds_5 WHERE json_extract(data, '$.name') = :n", "params": gin.H{"n": "bob' --"}})
+    if rows, _ := resp["rows"].([]any); code != 200 || len(rows) != 0 {
+        t.Fatalf("ad-hoc: %d %v", code, resp)
+    }
+}
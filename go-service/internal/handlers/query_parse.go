@@ -0,0 +1,598 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The query endpoints only run read-only statements. parseReadOnlyQuery tokenizes the SQL
+// (comments, string literals, dollar quotes and quoted identifiers are single tokens, so their
+// contents are never mistaken for SQL), rejects anything that could write, and lists the
+// relations the statement reads: the names in FROM and JOIN clauses, parenthesized join trees
+// included, minus the statement's CTEs. Functions that reach past the datasets (files, other
+// servers, SQL of their own, server state) are refused, and FROM only takes the table functions
+// of sqlTableFunctions. It is not a full grammar; what it accepts is still checked by the
+// database that runs it, in a read-only transaction.
+
+// errNotReadOnly is wrapped by parse errors for statements that are not read-only.
+var errNotReadOnly = errors.New("statement is not read-only")
+
+// sqlTableFunctions are the functions FROM may call; they only compute rows from their arguments.
+var sqlTableFunctions = map[string]bool{
+	"generate_series": true, "generate_subscripts": true, "unnest": true, "range": true,
+	"json_each": true, "json_each_text": true, "jsonb_each": true, "jsonb_each_text": true,
+	"json_array_elements": true, "json_array_elements_text": true, "jsonb_array_elements": true,
+	"jsonb_array_elements_text": true, "json_to_record": true, "json_to_recordset": true,
+	"jsonb_to_record": true, "jsonb_to_recordset": true, "json_populate_recordset": true,
+	"jsonb_populate_recordset": true, "regexp_matches": true, "regexp_split_to_table": true,
+	"string_to_table": true,
+}
+
+// sqlBlockedFunctions, and functions named as by sqlBlockedFunctionAffixes, read files or other
+// servers, run SQL given as a string, or change or reveal server state.
+var sqlBlockedFunctions = map[string]bool{
+	"setval": true, "nextval": true, "set_config": true, "current_setting": true, "txid_current": true,
+	"getenv": true, "glob": true, "sniff_csv": true, "load_extension": true, "readfile": true, "writefile": true,
+}
+
+var sqlBlockedFunctionAffixes = struct{ prefixes, suffixes, infixes []string }{
+	prefixes: []string{"pg_", "lo_", "dblink", "read_", "query", "parquet_", "http_", "duckdb_"},
+	suffixes: []string{"_scan", "_query", "_execute"},
+	infixes:  []string{"_to_xml"},
+}
+
+// sqlFunctionBlocked reports whether a query may not call the function name.
+func sqlFunctionBlocked(name string) bool {
+	if sqlBlockedFunctions[name] {
+		return true
+	}
+	a := sqlBlockedFunctionAffixes
+	for _, p := range a.prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	for _, suf := range a.suffixes {
+		if strings.HasSuffix(name, suf) {
+			return true
+		}
+	}
+	for _, in := range a.infixes {
+		if strings.Contains(name, in) {
+			return true
+		}
+	}
+	return false
+}
+
+type sqlTokenKind int
+
+const (
+	sqlWord   sqlTokenKind = iota // identifier or keyword
+	sqlQuoted                     // "quoted identifier"
+	sqlString                     // string literal
+	sqlNumber
+	sqlParam // $1 or ?
+	sqlPunct // any other single character
+)
+
+type sqlToken struct {
+	kind sqlTokenKind
+	// value is lowercased for words, unquoted for quoted identifiers and the raw text otherwise
+	value      string
+	start, end int
+}
+
+func (t sqlToken) is(word string) bool { return t.kind == sqlWord && t.value == word }
+
+func (t sqlToken) isPunct(p string) bool { return t.kind == sqlPunct && t.value == p }
+
+func (t sqlToken) isName() bool { return t.kind == sqlWord || t.kind == sqlQuoted }
+
+// relationRef is a table or view named by a query.
+type relationRef struct {
+	// Parts are the unquoted name parts: table, schema.table or database.schema.table
+	Parts []string
+	// Start and End are the byte span of the name in the query text
+	Start, End int
+	// Alias is the name the query gives the relation, if any
+	Alias string
+	// TopLevel is set for relations of the outermost SELECT, outside CTEs and subqueries
+	TopLevel bool
+	// AsOf is the VERSION AS OF or TIMESTAMP AS OF clause following the name, if any
+	AsOf *timeTravel
+}
+
+// timeTravel reads a Delta table as it was at a version or a time.
+type timeTravel struct {
+	// Version is the table version, unless Timestamp, in RFC 3339, is set
+	Version   int64
+	Timestamp string
+	// End is the byte offset where the clause ends in the query text
+	End int
+}
+
+// refName is the name the query uses for the relation's columns.
+func (r relationRef) refName() string {
+	if r.Alias != "" {
+		return r.Alias
+	}
+	return r.Parts[len(r.Parts)-1]
+}
+
+// parsedQuery is a read-only statement.
+type parsedQuery struct {
+	// SQL is the statement without its trailing semicolon and comments, ready to be wrapped
+	SQL       string
+	Relations []relationRef
+	tokens    []sqlToken
+}
+
+// sqlStatementWords start statements that write or change state; none may begin a statement or
+// subquery.
+var sqlStatementWords = map[string]bool{
+	"insert": true, "update": true, "delete": true, "merge": true, "upsert": true, "replace": true,
+	"truncate": true, "drop": true, "alter": true, "create": true, "grant": true, "revoke": true,
+	"copy": true, "call": true, "do": true, "execute": true, "prepare": true, "deallocate": true,
+	"lock": true, "vacuum": true, "analyze": true, "cluster": true, "reindex": true, "refresh": true,
+	"comment": true, "set": true, "reset": true, "discard": true, "listen": true, "notify": true,
+	"attach": true, "detach": true, "install": true, "load": true, "pragma": true, "export": true, "import": true,
+}
+
+// sqlClauseWords are keywords after which a parenthesis opens a subquery or a list rather than
+// the arguments of a function call.
+var sqlClauseWords = map[string]bool{
+	"select": true, "from": true, "where": true, "join": true, "on": true, "using": true, "as": true,
+	"and": true, "or": true, "not": true, "in": true, "exists": true, "any": true, "all": true,
+	"some": true, "array": true, "union": true, "intersect": true, "except": true, "with": true,
+	"recursive": true, "materialized": true, "lateral": true, "values": true, "by": true,
+	"having": true, "limit": true, "offset": true, "when": true, "then": true, "else": true,
+	"is": true, "between": true, "like": true, "ilike": true, "over": true, "filter": true,
+	"within": true, "case": true, "distinct": true,
+}
+
+// sqlJoinWords follow a table in a FROM clause and so are never its alias.
+var sqlJoinWords = map[string]bool{
+	"join": true, "inner": true, "left": true, "right": true, "full": true, "outer": true,
+	"cross": true, "natural": true, "on": true, "using": true, "tablesample": true, "for": true,
+}
+
+// sqlFromEndWords end a FROM clause; commas after them no longer separate tables.
+var sqlFromEndWords = map[string]bool{
+	"where": true, "group": true, "having": true, "order": true, "limit": true, "offset": true,
+	"fetch": true, "window": true, "union": true, "intersect": true, "except": true, "qualify": true,
+}
+
+// parseReadOnlyQuery checks that sqlText is a single read-only statement and returns the
+// relations it reads. Errors for statements that could write wrap errNotReadOnly; others are
+// syntax errors.
+func parseReadOnlyQuery(sqlText string) (*parsedQuery, error) {
+	toks, err := tokenizeSQL(sqlText)
+	if err != nil {
+		return nil, err
+	}
+	// A trailing semicolon is allowed; anything after a semicolon is another statement
+	for i, t := range toks {
+		if t.isPunct(";") {
+			if i != len(toks)-1 {
+				return nil, fmt.Errorf("%w: multiple statements", errNotReadOnly)
+			}
+			toks = toks[:i]
+		}
+	}
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("empty statement")
+	}
+	first := toks[0]
+	if !first.is("select") && !first.is("with") && !first.is("values") && !first.isPunct("(") {
+		return nil, fmt.Errorf("%w: %s", errNotReadOnly, strings.ToUpper(first.value))
+	}
+	p := sqlParser{toks: toks, ctes: map[string]bool{}}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return &parsedQuery{SQL: sqlText[:toks[len(toks)-1].end], Relations: p.relations, tokens: toks}, nil
+}
+
+// parseQualifiedName splits a possibly quoted, dotted name such as db."Schema".table.
+func parseQualifiedName(s string) ([]string, bool) {
+	toks, err := tokenizeSQL(s)
+	if err != nil || len(toks) == 0 || len(toks)%2 == 0 {
+		return nil, false
+	}
+	var parts []string
+	for i, t := range toks {
+		if i%2 == 1 {
+			if !t.isPunct(".") {
+				return nil, false
+			}
+			continue
+		}
+		if !t.isName() {
+			return nil, false
+		}
+		parts = append(parts, t.value)
+	}
+	return parts, true
+}
+
+// sqlFrame is one level of parentheses.
+type sqlFrame struct {
+	call   bool // function arguments: FROM in EXTRACT(... FROM ...) names no table
+	inFrom bool // inside a FROM clause, where commas separate tables
+	cte    bool // a CTE body, which another CTE or the main statement follows
+}
+
+type sqlParser struct {
+	toks      []sqlToken
+	frames    []sqlFrame
+	ctes      map[string]bool
+	relations []relationRef
+	// nextCTE marks the parenthesis that opens a CTE body
+	nextCTE int
+	// joinTree and tableFunc mark parentheses where FROM expects a table: one opening a
+	// parenthesized join, one holding the arguments of a table function
+	joinTree, tableFunc int
+	// err is the first malformed table reference found by fromItem
+	err error
+}
+
+func (p *sqlParser) tok(i int) sqlToken {
+	if i < 0 || i >= len(p.toks) {
+		return sqlToken{kind: sqlPunct}
+	}
+	return p.toks[i]
+}
+
+func (p *sqlParser) top() *sqlFrame { return &p.frames[len(p.frames)-1] }
+
+func (p *sqlParser) parse() error {
+	p.frames = []sqlFrame{{}}
+	p.nextCTE, p.joinTree, p.tableFunc = -1, -1, -1
+	if err := p.checkStatementStart(0); err != nil {
+		return err
+	}
+	for i := 0; i < len(p.toks); i++ {
+		t := p.toks[i]
+		switch {
+		case t.isPunct("("):
+			prev := p.tok(i - 1)
+			call := (prev.kind == sqlWord && !sqlClauseWords[prev.value]) || prev.kind == sqlQuoted
+			if call {
+				name := strings.ToLower(prev.value)
+				if sqlFunctionBlocked(name) || (i == p.tableFunc && !sqlTableFunctions[name]) {
+					return fmt.Errorf("function %s is not allowed", name)
+				}
+			}
+			p.frames = append(p.frames, sqlFrame{call: call, cte: i == p.nextCTE})
+			if !call {
+				if err := p.checkStatementStart(i + 1); err != nil {
+					return err
+				}
+			}
+			// A parenthesized join tree holds table references, unlike a subquery
+			if i == p.joinTree {
+				if n := p.tok(i + 1); !n.is("select") && !n.is("with") && !n.is("values") {
+					p.top().inFrom = true
+					i = p.fromItem(i+1) - 1
+				}
+			}
+		case t.isPunct(")"):
+			if len(p.frames) == 1 {
+				return fmt.Errorf("unbalanced parentheses")
+			}
+			closed := p.frames[len(p.frames)-1]
+			p.frames = p.frames[:len(p.frames)-1]
+			if closed.cte {
+				if p.tok(i + 1).isPunct(",") {
+					i = p.cteHeader(i+2) - 1
+					continue
+				}
+				if err := p.checkStatementStart(i + 1); err != nil {
+					return err
+				}
+			}
+		case t.isPunct(","):
+			if p.top().inFrom {
+				i = p.fromItem(i+1) - 1
+			}
+		case t.kind != sqlWord:
+		case t.value == "into":
+			return fmt.Errorf("%w: INTO", errNotReadOnly)
+		case t.value == "for":
+			// Locking clauses take row locks: FOR UPDATE, FOR NO KEY UPDATE, FOR SHARE, FOR KEY SHARE
+			if n := p.tok(i + 1); n.is("update") || n.is("share") || n.is("no") || n.is("key") {
+				return fmt.Errorf("%w: FOR %s", errNotReadOnly, strings.ToUpper(n.value))
+			}
+		case t.value == "with" && !p.top().call:
+			j := i + 1
+			if p.tok(j).is("recursive") {
+				j++
+			}
+			i = p.cteHeader(j) - 1
+		case t.value == "from" || t.value == "join":
+			// FROM inside function arguments, and IS [NOT] DISTINCT FROM, name no table
+			if p.top().call || p.tok(i-1).is("distinct") {
+				continue
+			}
+			p.top().inFrom = true
+			i = p.fromItem(i+1) - 1
+		case sqlFromEndWords[t.value]:
+			p.top().inFrom = false
+		}
+	}
+	if len(p.frames) != 1 {
+		return fmt.Errorf("unbalanced parentheses")
+	}
+	return p.err
+}
+
+// checkStatementStart rejects a statement or subquery starting at i with a writing keyword.
+func (p *sqlParser) checkStatementStart(i int) error {
+	if t := p.tok(i); t.kind == sqlWord && sqlStatementWords[t.value] {
+		return fmt.Errorf("%w: %s", errNotReadOnly, strings.ToUpper(t.value))
+	}
+	return nil
+}
+
+// cteHeader reads `name [(columns)] AS [NOT] [MATERIALIZED]` at i, records the name and returns
+// the index of the body's opening parenthesis.
+func (p *sqlParser) cteHeader(i int) int {
+	if !p.tok(i).isName() {
+		return i
+	}
+	p.ctes[strings.ToLower(p.tok(i).value)] = true
+	i++
+	if p.tok(i).isPunct("(") {
+		i = p.skipParens(i)
+	}
+	if !p.tok(i).is("as") {
+		return i
+	}
+	i++
+	for p.tok(i).is("not") || p.tok(i).is("materialized") {
+		i++
+	}
+	if p.tok(i).isPunct("(") {
+		p.nextCTE = i
+	}
+	return i
+}
+
+// skipParens returns the index after the parenthesis closing the one at i.
+func (p *sqlParser) skipParens(i int) int {
+	depth := 0
+	for ; i < len(p.toks); i++ {
+		switch {
+		case p.toks[i].isPunct("("):
+			depth++
+		case p.toks[i].isPunct(")"):
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
+
+// fromItem reads the table reference starting at i and returns the index to continue at.
+// Subqueries, join trees and table functions are left to the main loop.
+func (p *sqlParser) fromItem(i int) int {
+	for p.tok(i).is("lateral") || p.tok(i).is("only") {
+		i++
+	}
+	if p.tok(i).isPunct("(") {
+		p.joinTree = i
+		return i
+	}
+	if !p.tok(i).isName() {
+		return i
+	}
+	start := i
+	parts := []string{p.tok(i).value}
+	for p.tok(i+1).isPunct(".") && p.tok(i+2).isName() {
+		parts = append(parts, p.tok(i+2).value)
+		i += 2
+	}
+	if p.tok(i + 1).isPunct("(") {
+		// A table function such as generate_series(...)
+		p.tableFunc = i + 1
+		return start
+	}
+	if len(parts) == 1 && p.ctes[strings.ToLower(parts[0])] {
+		return i + 1
+	}
+	rel := relationRef{Parts: parts, Start: p.toks[start].start, End: p.toks[i].end, TopLevel: len(p.frames) == 1}
+	i++
+	if kw := p.tok(i); (kw.is("version") || kw.is("timestamp")) && p.tok(i+1).is("as") && p.tok(i+2).is("of") {
+		asOf, err := parseTimeTravel(kw.value, p.tok(i+3))
+		if err != nil && p.err == nil {
+			p.err = err
+		}
+		rel.AsOf = asOf
+		i += 4
+	}
+	if p.tok(i).is("as") {
+		i++
+	}
+	if a := p.tok(i); a.kind == sqlQuoted || (a.kind == sqlWord && !sqlJoinWords[a.value] && !sqlFromEndWords[a.value]) {
+		rel.Alias = a.value
+		i++
+	}
+	p.relations = append(p.relations, rel)
+	return i
+}
+
+// parseTimeTravel reads the value of a VERSION AS OF or TIMESTAMP AS OF clause: a version number,
+// or a quoted date or time, which is taken as UTC unless it has an offset.
+func parseTimeTravel(kind string, v sqlToken) (*timeTravel, error) {
+	if kind == "version" {
+		n, err := strconv.ParseInt(v.value, 10, 64)
+		if v.kind != sqlNumber || err != nil || n < 0 {
+			return nil, fmt.Errorf("VERSION AS OF needs a version number")
+		}
+		return &timeTravel{Version: n, End: v.end}, nil
+	}
+	if v.kind != sqlString || !strings.HasPrefix(v.value, "'") {
+		return nil, fmt.Errorf("TIMESTAMP AS OF needs a quoted timestamp")
+	}
+	s := strings.ReplaceAll(v.value[1:len(v.value)-1], "''", "'")
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if ts, err := time.Parse(layout, s); err == nil {
+			return &timeTravel{Timestamp: ts.UTC().Format(time.RFC3339Nano), End: v.end}, nil
+		}
+	}
+	return nil, fmt.Errorf("TIMESTAMP AS OF: invalid timestamp %s", v.value)
+}
+
+// tokenizeSQL splits SQL into tokens, dropping whitespace and comments.
+func tokenizeSQL(s string) ([]sqlToken, error) {
+	var toks []sqlToken
+	isWordStart := func(c byte) bool { return c == '_' || c >= 0x80 || (c|0x20 >= 'a' && c|0x20 <= 'z') }
+	isWordChar := func(c byte) bool { return isWordStart(c) || c == '$' || (c >= '0' && c <= '9') }
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case strings.HasPrefix(s[i:], "--"):
+			if j := strings.IndexByte(s[i:], '\n'); j >= 0 {
+				i += j + 1
+			} else {
+				i = len(s)
+			}
+		case strings.HasPrefix(s[i:], "/*"):
+			// Block comments nest
+			depth := 0
+			for i < len(s) {
+				if strings.HasPrefix(s[i:], "/*") {
+					depth++
+					i += 2
+				} else if strings.HasPrefix(s[i:], "*/") {
+					depth--
+					i += 2
+					if depth == 0 {
+						break
+					}
+				} else {
+					i++
+				}
+			}
+			if depth != 0 {
+				return nil, fmt.Errorf("unterminated comment")
+			}
+		case c == '\'':
+			end, err := scanSQLString(s, i, false)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+			toks = append(toks, sqlToken{kind: sqlString, value: s[start:i], start: start, end: i})
+		case c == '"':
+			var b strings.Builder
+			i++
+			for {
+				j := strings.IndexByte(s[i:], '"')
+				if j < 0 {
+					return nil, fmt.Errorf("unterminated quoted identifier")
+				}
+				b.WriteString(s[i : i+j])
+				i += j + 1
+				if i < len(s) && s[i] == '"' {
+					b.WriteByte('"')
+					i++
+					continue
+				}
+				break
+			}
+			toks = append(toks, sqlToken{kind: sqlQuoted, value: b.String(), start: start, end: i})
+		case c == '$' && i+1 < len(s) && isDigit(s[i+1]):
+			i++
+			for i < len(s) && isDigit(s[i]) {
+				i++
+			}
+			toks = append(toks, sqlToken{kind: sqlParam, value: s[start:i], start: start, end: i})
+		case c == '$':
+			// Dollar quoting: $$...$$ or $tag$...$tag$
+			j := i + 1
+			for j < len(s) && s[j] != '$' && isWordChar(s[j]) {
+				j++
+			}
+			if j >= len(s) || s[j] != '$' {
+				i++
+				toks = append(toks, sqlToken{kind: sqlPunct, value: "$", start: start, end: i})
+				continue
+			}
+			tag := s[i : j+1]
+			k := strings.Index(s[j+1:], tag)
+			if k < 0 {
+				return nil, fmt.Errorf("unterminated dollar-quoted string")
+			}
+			i = j + 1 + k + len(tag)
+			toks = append(toks, sqlToken{kind: sqlString, value: s[start:i], start: start, end: i})
+		case c == '?':
+			i++
+			toks = append(toks, sqlToken{kind: sqlParam, value: "?", start: start, end: i})
+		case isDigit(c) || (c == '.' && i+1 < len(s) && isDigit(s[i+1])):
+			for i < len(s) && (isDigit(s[i]) || s[i] == '.' || s[i] == '_') {
+				i++
+			}
+			if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+				j := i + 1
+				if j < len(s) && (s[j] == '+' || s[j] == '-') {
+					j++
+				}
+				if j < len(s) && isDigit(s[j]) {
+					for i = j; i < len(s) && isDigit(s[i]); i++ {
+					}
+				}
+			}
+			toks = append(toks, sqlToken{kind: sqlNumber, value: s[start:i], start: start, end: i})
+		case isWordStart(c):
+			for i < len(s) && isWordChar(s[i]) {
+				i++
+			}
+			word := strings.ToLower(s[start:i])
+			// String constants with a prefix: E'...' (backslash escapes), N'...', B'...', X'...'
+			if i < len(s) && s[i] == '\'' && (word == "e" || word == "n" || word == "b" || word == "x") {
+				end, err := scanSQLString(s, i, word == "e")
+				if err != nil {
+					return nil, err
+				}
+				i = end
+				toks = append(toks, sqlToken{kind: sqlString, value: s[start:i], start: start, end: i})
+				continue
+			}
+			toks = append(toks, sqlToken{kind: sqlWord, value: word, start: start, end: i})
+		default:
+			i++
+			toks = append(toks, sqlToken{kind: sqlPunct, value: s[start:i], start: start, end: i})
+		}
+	}
+	return toks, nil
+}
+
+// scanSQLString returns the index after the string literal whose opening quote is at i. A
+// doubled quote is an escaped quote; with backslashes, so is \'.
+func scanSQLString(s string, i int, backslashes bool) (int, error) {
+	for i++; i < len(s); i++ {
+		switch {
+		case backslashes && s[i] == '\\':
+			i++
+		case s[i] == '\'':
+			if i+1 < len(s) && s[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated string literal")
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+    "errors"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestParseReadOnlyQuery_Relations(t *testing.T) {
+    q, err := parseReadOnlyQuery(`-- people.a is a comment
+WITH recent AS (SELECT * FROM sales.orders WHERE note <> 'x.y; drop')
+SELECT extract(year FROM r.created_at), "My Db".public."Items".name
+FROM recent r JOIN "My Db".public."Items" ON true, other.t /* a.b.c */ ;`)
+    if err != nil {
+        t.Fatalf("parse: %v", err)
+    }
+    var got [][]string
+    for _, rel := range q.Relations {
+        got = append(got, rel.Parts)
+    }
+    want := [][]string{{"sales", "orders"}, {"My Db", "public", "Items"}, {"other", "t"}}
+    if !reflect.DeepEqual(got, want) {
+        t.Fatalf("relations: %v", got)
+    }
+    if q.SQL[len(q.SQL)-1] == ';' {
+        t.Fatalf("trailing semicolon kept: %q", q.SQL)
+    }
+
+    rewritten, remote, _, _, err := planQueryExecution(nil, q, 0, "My Db", "postgres")
+    if err != nil || remote {
+        t.Fatalf("plan: %v %v", remote, err)
+    }
+    if want := `JOIN public."Items" ON true`; !strings.Contains(rewritten, want) {
+        t.Fatalf("rewritten: %s", rewritten)
+    }
+}
+
+func TestParseReadOnlyQuery_JoinTrees(t *testing.T) {
+    for sql, want := range map[string][][]string{
+        "SELECT * FROM (users CROSS JOIN sales.orders)":                               {{"users"}, {"sales", "orders"}},
+        "SELECT * FROM a.b JOIN (users JOIN ds_1 ON true) ON true":                    {{"a", "b"}, {"users"}, {"ds_1"}},
+        "SELECT * FROM ((x JOIN y ON true) JOIN z ON true) AS j, w":                   {{"x"}, {"y"}, {"z"}, {"w"}},
+        "SELECT * FROM (SELECT a, b FROM t) s, generate_series(1, 3) g":               {{"t"}},
+        "SELECT * FROM t, LATERAL jsonb_array_elements(t.tags) AS e JOIN (u) ON true": {{"t"}, {"u"}},
+    } {
+        q, err := parseReadOnlyQuery(sql)
+        if err != nil {
+            t.Fatalf("%q: %v", sql, err)
+        }
+        var got [][]string
+        for _, rel := range q.Relations {
+            got = append(got, rel.Parts)
+        }
+        if !reflect.DeepEqual(got, want) {
+            t.Errorf("%q: relations %v, want %v", sql, got, want)
+        }
+    }
+}
+
+func TestParseReadOnlyQuery_Functions(t *testing.T) {
+    for _, sql := range []string{
+        "SELECT * FROM read_csv_auto('/etc/passwd')",
+        "SELECT * FROM dblink('host=db', 'SELECT 1') AS t(a int)",
+        "SELECT * FROM public.my_function()",
+        "SELECT * FROM a.b JOIN (users JOIN read_parquet('x') ON true) ON true",
+        "SELECT setval('users_id_seq', 1)",
+        "SELECT lo_import('/etc/passwd')",
+        "SELECT dblink_exec('host=db', 'DELETE FROM users')",
+        "SELECT pg_catalog.pg_read_file('/etc/passwd')",
+        `SELECT "pg_read_file"('/etc/passwd')`,
+        "SELECT * FROM a.b WHERE id IN (SELECT length(query_to_xml('SELECT * FROM users', true, true, '')::text))",
+    } {
+        if _, err := parseReadOnlyQuery(sql); err == nil || !strings.Contains(err.Error(), "is not allowed") {
+            t.Errorf("%q: %v", sql, err)
+        }
+    }
+    for _, sql := range []string{
+        "SELECT lower(name), count(*), CAST(n AS numeric(10, 2)) FROM a.b WHERE to_tsvector(note) @@ plainto_tsquery('x') GROUP BY 1, 3",
+        "SELECT * FROM unnest(ARRAY[1, 2]) AS u(n)",
+    } {
+        if _, err := parseReadOnlyQuery(sql); err != nil {
+            t.Errorf("%q: %v", sql, err)
+        }
+    }
+}
+
+func TestParseReadOnlyQuery_Rejects(t *testing.T) {
+    for _, sql := range []string{
+        "DELETE FROM a.b",
+        "SELECT * INTO backup FROM a.b",
+        "WITH gone AS (DELETE FROM a.b RETURNING *) SELECT * FROM gone",
+        "SELECT * FROM a.b FOR UPDATE",
+        "SELECT 1; DROP TABLE a.b",
+    } {
+        if _, err := parseReadOnlyQuery(sql); !errors.Is(err, errNotReadOnly) {
+            t.Errorf("%q: %v", sql, err)
+        }
+    }
+    if _, err := parseReadOnlyQuery("SELECT 'unterminated"); err == nil || errors.Is(err, errNotReadOnly) {
+        t.Errorf("unterminated string: %v", err)
+    }
+}
+
+func TestParseReadOnlyQuery_TimeTravel(t *testing.T) {
+    q, err := parseReadOnlyQuery("SELECT n.id FROM sales.orders VERSION AS OF 12 JOIN sales.orders TIMESTAMP AS OF '2026-01-01' AS n ON n.id = orders.id, sales.orders")
+    if err != nil {
+        t.Fatalf("parse: %v", err)
+    }
+    if len(q.Relations) != 3 || q.Relations[0].AsOf == nil || q.Relations[0].AsOf.Version != 12 || q.Relations[0].Alias != "" {
+        t.Fatalf("version relation: %+v", q.Relations)
+    }
+    if asOf := q.Relations[1].AsOf; asOf == nil || asOf.Timestamp != "2026-01-01T00:00:00Z" || q.Relations[1].Alias != "n" {
+        t.Fatalf("timestamp relation: %+v", q.Relations[1])
+    }
+    keys := []string{q.Relations[0].deltaKey(), q.Relations[1].deltaKey(), q.Relations[2].deltaKey()}
+    if !reflect.DeepEqual(keys, []string{"sales.orders__v12", "sales.orders__t20260101t000000z", "sales.orders"}) {
+        t.Fatalf("keys: %v", keys)
+    }
+    want := "SELECT n.id FROM sales.orders__v12 AS orders JOIN sales.orders__t20260101t000000z AS n ON n.id = orders.id, sales.orders"
+    if got := deltaSQL(q); got != want {
+        t.Fatalf("delta sql: %s", got)
+    }
+
+    for _, sql := range []string{"SELECT * FROM a.b VERSION AS OF 'x'", "SELECT * FROM a.b TIMESTAMP AS OF 'yesterday'"} {
+        if _, err := parseReadOnlyQuery(sql); err == nil || errors.Is(err, errNotReadOnly) {
+            t.Errorf("%q: %v", sql, err)
+        }
+    }
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/config"
+	"gorm.io/gorm"
+)
+
+// Every query run from the editor is registered under an ID until it finishes. Its context ends
+// at the configured statement timeout, when the client goes away, or when the query is cancelled
+// through DELETE /api/query/:queryId. Postgres also gets the timeout as statement_timeout and is
+// told to cancel the backend; the Python service is told to interrupt DuckDB.
+
+// runningQuery is a query being executed for a user.
+type runningQuery struct {
+	ID      string
+	UserID  uint
+	Started time.Time
+	Timeout time.Duration
+	cancel  context.CancelFunc
+
+	mu        sync.Mutex
+	cancelled bool
+	// stopBackend cancels the statement in the database running it, once one is
+	stopBackend func()
+}
+
+var (
+	runningQueriesMu sync.Mutex
+	runningQueries   = map[string]*runningQuery{}
+)
+
+var (
+	errTooManyQueries = errors.New("too many concurrent queries")
+	errQueryIDInUse   = errors.New("query id in use")
+)
+
+// queryTimeout is the configured statement timeout.
+func queryTimeout() time.Duration {
+	return time.Duration(config.Get().QueryTimeout) * time.Second
+}
+
+// startQuery registers a query for the user, under id if one is given, and derives its context
+// from parent, ending after timeout. The caller must call finish.
+func startQuery(parent context.Context, userID uint, id string, timeout time.Duration) (*runningQuery, context.Context, error) {
+	if id == "" {
+		id = uuid.NewString()
+	}
+	runningQueriesMu.Lock()
+	defer runningQueriesMu.Unlock()
+	if _, ok := runningQueries[id]; ok {
+		return nil, nil, errQueryIDInUse
+	}
+	n := 0
+	for _, rq := range runningQueries {
+		if rq.UserID == userID {
+			n++
+		}
+	}
+	if n >= config.Get().MaxConcurrentQueries {
+		return nil, nil, errTooManyQueries
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	rq := &runningQuery{ID: id, UserID: userID, Started: time.Now(), Timeout: timeout, cancel: cancel}
+	runningQueries[id] = rq
+	return rq, ctx, nil
+}
+
+// finish unregisters the query and releases its context.
+func (rq *runningQuery) finish() {
+	runningQueriesMu.Lock()
+	delete(runningQueries, rq.ID)
+	runningQueriesMu.Unlock()
+	rq.cancel()
+}
+
+// stop cancels the query.
+func (rq *runningQuery) stop() {
+	rq.mu.Lock()
+	rq.cancelled = true
+	// Under the lock, so the backend can't be handed to another query meanwhile
+	if rq.stopBackend != nil {
+		rq.stopBackend()
+	}
+	rq.mu.Unlock()
+	rq.cancel()
+}
+
+func (rq *runningQuery) isCancelled() bool {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+	return rq.cancelled
+}
+
+func (rq *runningQuery) setStopBackend(fn func()) {
+	rq.mu.Lock()
+	rq.stopBackend = fn
+	rq.mu.Unlock()
+}
+
+// respondQueryEnded reports a query that failed because its context ended, and whether it did.
+func respondQueryEnded(c *gin.Context, rq *runningQuery, ctx context.Context) bool {
+	switch {
+	case rq.isCancelled():
+		c.JSON(http.StatusConflict, gin.H{"error": "query_cancelled", "query_id": rq.ID})
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "query_timeout", "query_id": rq.ID, "timeout_seconds": int(rq.Timeout.Seconds())})
+	case ctx.Err() != nil:
+		// The client went away; nobody reads this
+		c.JSON(http.StatusRequestTimeout, gin.H{"error": "query_cancelled", "query_id": rq.ID})
+	default:
+		return false
+	}
+	return true
+}
+
+// runQuery runs sqlText in a read-only transaction on its own connection of db, so the database
+// refuses writes the parser missed. On Postgres the statement gets the query's timeout, and
+// cancelling the query cancels the backend. The returned func closes the rows, ends the
+// transaction and releases the connection, and may be called more than once.
+func runQuery(ctx context.Context, rq *runningQuery, db *gorm.DB, sqlText string, args ...any) (*sql.Rows, func(), error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	postgres := dialect(db) == "postgres"
+	var tx *sql.Tx
+	release := func() {
+		rq.setStopBackend(nil)
+		if tx != nil {
+			// Nothing was written; the context may already have rolled it back
+			_ = tx.Rollback()
+		}
+		if postgres {
+			// The connection goes back to the pool
+			_, _ = conn.ExecContext(context.Background(), "RESET statement_timeout")
+		}
+		conn.Close()
+	}
+	if postgres {
+		var pid int
+		if err := conn.QueryRowContext(ctx, "SELECT pg_backend_pid()").Scan(&pid); err != nil {
+			release()
+			return nil, nil, err
+		}
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("SET statement_timeout = %d", rq.Timeout.Milliseconds())); err != nil {
+			release()
+			return nil, nil, err
+		}
+		rq.setStopBackend(func() {
+			if _, err := sqlDB.Exec("SELECT pg_cancel_backend($1)", pid); err != nil {
+				log.Printf("[query] cancel %s backend=%d: %v", rq.ID, pid, err)
+			}
+		})
+	}
+	if tx, err = conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}); err != nil {
+		release()
+		return nil, nil, err
+	}
+	rows, err := tx.QueryContext(ctx, sqlText, args...)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	var once sync.Once
+	return rows, func() {
+		once.Do(func() {
+			rows.Close()
+			release()
+		})
+	}, nil
+}
+
+// CancelQueryHandler cancels a running query or pending async query of the caller; admins may
+// cancel any.
+func CancelQueryHandler(c *gin.Context) {
+	id := c.Param("queryId")
+	runningQueriesMu.Lock()
+	rq, ok := runningQueries[id]
+	runningQueriesMu.Unlock()
+	if !ok {
+		// An async query may still be waiting for the worker
+		if cancelPendingQueryJob(c, id) {
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+		return
+	}
+	if role, _ := c.Get("user_role"); rq.UserID != contextUserID(c) && role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	rq.stop()
+	c.JSON(http.StatusOK, gin.H{"ok": true, "query_id": rq.ID})
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+)
+
+func TestQueryRuns_LimitAndCancel(t *testing.T) {
+    _, r := rowChangeEnv(t)
+    r.DELETE("/api/query/:queryId", CancelQueryHandler)
+
+    var runs []*runningQuery
+    for i := 0; i < 3; i++ {
+        rq, _, err := startQuery(context.Background(), 2, "", time.Minute)
+        if err != nil {
+            t.Fatalf("start %d: %v", i, err)
+        }
+        runs = append(runs, rq)
+    }
+    if _, _, err := startQuery(context.Background(), 2, "", time.Minute); !errors.Is(err, errTooManyQueries) {
+        t.Fatalf("fourth query: %v", err)
+    }
+    if _, _, err := startQuery(context.Background(), 1, runs[0].ID, time.Minute); !errors.Is(err, errQueryIDInUse) {
+        t.Fatalf("reused id: %v", err)
+    }
+    for _, rq := range runs[1:] {
+        rq.finish()
+    }
+
+    rq, ctx, err := startQuery(context.Background(), 2, "", time.Minute)
+    if err != nil {
+        t.Fatalf("start after finish: %v", err)
+    }
+    defer rq.finish()
+    if code, _ := doJSON(t, r, "DELETE", "/api/query/"+rq.ID, 1, nil); code != 403 {
+        t.Fatalf("cancel someone else's query: %d", code)
+    }
+    if code, _ := doJSON(t, r, "DELETE", "/api/query/"+rq.ID, 2, nil); code != 200 {
+        t.Fatalf("cancel: %d", code)
+    }
+    select {
+    case <-ctx.Done():
+    default:
+        t.Fatalf("context still live after cancel")
+    }
+    runs[0].finish()
+    if code, _ := doJSON(t, r, "DELETE", "/api/query/"+runs[0].ID, 2, nil); code != 404 {
+        t.Fatalf("cancel finished query: %d", code)
+    }
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+    "testing"
+
+    "github.com/gin-gonic/gin"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+)
+
+func TestExecuteQuery_DatasetAccess(t *testing.T) {
+    gdb, r := rowChangeEnv(t)
+    if err := gdb.AutoMigrate(&models.QueryHistory{}); err != nil {
+        t.Fatalf("migrate: %v", err)
+    }
+    r.POST("/api/query/execute", ExecuteQueryHandler(gdb))
+    r.GET("/api/query/history/:projectId", HistoryHandler(gdb))
+    ds := models.Dataset{ID: 5, ProjectID: 1, Name: "people", Schema: peopleSchema}
+    gdb.Create(&ds)
+    if err := ensureDatasetTable(gdb, &ds); err != nil {
+        t.Fatalf("ensure table: %v", err)
+    }
+    gdb.Exec("INSERT INTO ds_5 (data) VALUES (?)", `{"id":1,"name":"ann","age":31}`)
+    gdb.Create(&models.Project{ID: 2, Name: "hr"})
+    gdb.Create(&models.Dataset{ID: 6, ProjectID: 2, Name: "salaries", TargetSchema: "hr", TargetTable: "salaries"})
+
+    run := func(user uint, sql string) (int, map[string]any) {
+        return doJSON(t, r, "POST", "/api/query/execute", user, gin.H{"sql": sql, "project_id": 1})
+    }
+    code, resp := run(2, "WITH p AS (SELECT json_extract(data, '$.name') AS name FROM ds_5) SELECT name FROM p")
+    if code != 200 || len(resp["rows"].([]any)) != 1 || resp["query_id"] == nil {
+        t.Fatalf("own dataset: %d %v", code, resp)
+    }
+    if code, resp := run(2, "SELECT * FROM ds_5 JOIN hr.salaries ON true"); code != 403 || resp["table"] != "salaries" {
+        t.Fatalf("other project's dataset: %d %v", code, resp)
+    }
+    if code, resp := run(2, "SELECT * FROM users"); code != 404 || resp["error"] != "table_not_found" {
+        t.Fatalf("non-dataset table: %d %v", code, resp)
+    }
+    // A dataset named like an app table does not open it, and a name must be the dataset's own table
+    gdb.Create(&models.Dataset{ID: 7, ProjectID: 1, Name: "accounts", TargetSchema: "public", TargetTable: "users"})
+    gdb.Create(&models.Dataset{ID: 8, ProjectID: 1, Name: "orders", TargetSchema: "sales", TargetTable: "orders"})
+    if code, resp := run(2, "SELECT * FROM users"); code != 403 || resp["table"] != "users" {
+        t.Fatalf("app table: %d %v", code, resp)
+    }
+    if code, resp := run(2, "SELECT * FROM orders"); code != 404 || resp["error"] != "table_not_found" {
+        t.Fatalf("other physical table: %d %v", code, resp)
+    }
+    if code, _ := run(3, "SELECT 1"); code != 403 {
+        t.Fatalf("outsider: %d", code)
+    }
+
+    var hist []models.QueryHistory
+    gdb.Find(&hist)
+    if len(hist) != 1 || hist[0].UserID != 2 || hist[0].ProjectID != 1 || hist[0].ResultRows != 1 {
+        t.Fatalf("history: %+v", hist)
+    }
+    if code, _ := doJSON(t, r, "GET", "/api/query/history/1", 3, nil); code != 403 {
+        t.Fatalf("outsider history: %d", code)
+    }
+}
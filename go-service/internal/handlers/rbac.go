@@ -6,6 +6,7 @@ import (
 	"github.com/gin-gonic/gin"
 	dbpkg "github.com/oreo-io/oreo.io-v2/go-service/internal/database"
 	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"gorm.io/gorm"
 )
 
 // HasProjectRole checks if the current user has any of the roles for the given project id.
@@ -23,9 +24,6 @@ func HasProjectRole(c *gin.Context, projectID uint, roles ...string) bool {
 	case uint:
 		uid = v
 	}
-	if uid == 0 {
-		return false
-	}
 	gdb := dbpkg.Get()
 	if gdb == nil {
 		if _, err := dbpkg.Init(); err != nil {
@@ -33,6 +31,14 @@ func HasProjectRole(c *gin.Context, projectID uint, roles ...string) bool {
 		}
 		gdb = dbpkg.Get()
 	}
+	return hasProjectRole(gdb, uid, projectID, roles...)
+}
+
+// hasProjectRole checks if user uid has any of the roles for the given project id.
+func hasProjectRole(gdb *gorm.DB, uid, projectID uint, roles ...string) bool {
+	if uid == 0 {
+		return false
+	}
 	var pr models.ProjectRole
 	if err := gdb.Where("project_id = ? AND user_id = ?", projectID, uid).First(&pr).Error; err != nil {
 		return false
@@ -50,7 +56,7 @@ func HasProjectRole(c *gin.Context, projectID uint, roles ...string) bool {
 			allowed[ar] = struct{}{}
 		}
 	}
-	_, ok = allowed[userRole]
+	_, ok := allowed[userRole]
 	return ok
 }
 
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"io"
+	"log"
 	"mime/multipart"
 	"net/http"
 	"strings"
@@ -16,6 +17,32 @@ import (
 	"github.com/oreo-io/oreo.io-v2/go-service/internal/storage"
 )
 
+// appModels are the app's own tables, migrated at startup; the jobs table, only on Postgres,
+// comes on top. Queries may read none of them.
+var appModels = []any{
+	&models.User{},
+	&models.Project{},
+	&models.Dataset{},
+	&models.ProjectRole{},
+	&models.ChangeRequest{},
+	&models.ChangeRequestReviewer{},
+	&models.ChangeRequestRevision{},
+	&models.ChangeComment{},
+	&models.DatasetUpload{},
+	&models.DatasetMeta{},
+	&models.DatasetVersion{},
+	&models.SavedQuery{},
+	&models.QueryHistory{},
+	// Ensure supporting tables exist in dev/test
+	&models.Notification{},
+	&models.ProjectActivity{},
+	&models.DataQualityRule{},
+	&models.DataQualityResult{},
+	&models.AuditEvent{},
+	&models.ApprovalPolicy{},
+	&models.JobSchedule{},
+}
+
 // SetupRouter configures the Gin engine. Exposed for tests.
 func SetupRouter() *gin.Engine {
 	r := gin.Default()
@@ -60,37 +87,35 @@ func SetupRouter() *gin.Engine {
 			gdb = dbpkg.Get()
 		}
 	}
+	// Async queries run as jobs, and quality rules are checked against whole datasets as jobs.
+	// Their types are known even where this process runs no worker, so they can be queued here
+	// for a worker elsewhere.
+	services.RegisterJobType(queryJobType, QueryJobType)
+	services.RegisterJobType(dqJobType, DQJobType)
 	if gdb != nil {
 		// Detect Postgres by DATABASE_URL env
 		if strings.HasPrefix(strings.ToLower(cfg.DatabaseURL), "postgres://") || strings.HasPrefix(strings.ToLower(cfg.DatabaseURL), "postgresql://") {
 			_ = gdb.Exec("CREATE SCHEMA IF NOT EXISTS sys").Error
 		}
-		_ = gdb.AutoMigrate(
-			&models.User{},
-			&models.Project{},
-			&models.Dataset{},
-			&models.ProjectRole{},
-			&models.ChangeRequest{},
-			&models.ChangeComment{},
-			&models.DatasetUpload{},
-			&models.DatasetMeta{},
-			&models.DatasetVersion{},
-			&models.SavedQuery{},
-			&models.QueryHistory{},
-			// Ensure supporting tables exist in dev/test
-			&models.Notification{},
-			&models.ProjectActivity{},
-			&models.DataQualityRule{},
-			&models.DataQualityResult{},
-			&models.AuditEvent{},
-		)
+		_ = gdb.AutoMigrate(appModels...)
+		if err := MigrateChangeRequestReviewers(gdb); err != nil {
+			log.Printf("[SetupRouter] migrate change request reviewers: %v", err)
+		}
+		RecoverStaleApplies(gdb)
+		MigrateDatasetRules(gdb)
 
 		// Only migrate jobs table and start worker when using Postgres (skip for sqlite tests)
 		if gdb.Dialector != nil && strings.EqualFold(gdb.Dialector.Name(), "postgres") {
 			_ = gdb.AutoMigrate(&models.Job{})
 			if !cfg.DisableWorker {
-				// Start background worker for dev (poll every 2s)
+				// Start background worker for dev (poll every 2s); it also runs the change
+				// request deadline and merge window sweep
+				services.RegisterSweep(SweepChangeRequests)
+				// Results of async queries expire in the sweep
+				services.RegisterSweep(SweepQueryResults)
 				services.StartWorker(2 * time.Second)
+				// Schedules enqueue their jobs for the worker
+				services.StartScheduler(15 * time.Second)
 			}
 		}
 	}
@@ -114,6 +139,8 @@ func SetupRouter() *gin.Engine {
 			switch a.(type) {
 			case *storage.DeltaAdapter:
 				name = "delta"
+			case *storage.DeltaNativeAdapter:
+				name = "delta-native"
 			case *storage.PostgresAdapter:
 				name = "postgres"
 			}
@@ -193,6 +220,22 @@ func SetupRouter() *gin.Engine {
 				mem.DELETE("/:userId", MembersDelete)
 			}
 
+			// Approval policy: project default
+			proj.GET("/:id/approval-policy", ApprovalPolicyGet)
+			proj.PUT("/:id/approval-policy", ApprovalPolicyPut)
+			proj.DELETE("/:id/approval-policy", ApprovalPolicyDelete)
+
+			// Scheduled jobs and the jobs each schedule ran
+			sch := proj.Group("/:id/schedules")
+			{
+				sch.GET("", SchedulesList)
+				sch.POST("", SchedulesCreate)
+				sch.GET("/:scheduleId", SchedulesGet)
+				sch.PUT("/:scheduleId", SchedulesUpdate)
+				sch.DELETE("/:scheduleId", SchedulesDelete)
+				sch.GET("/:scheduleId/runs", ScheduleRunsList)
+			}
+
 			// Datasets nested under a project (use same wildcard name to avoid Gin conflicts)
 			ds := proj.Group("/:id/datasets")
 			{
@@ -213,6 +256,15 @@ func SetupRouter() *gin.Engine {
 				ds.POST("/:datasetId/append/json", AppendJSON)
 				// New: validate edited JSON first, returns upload_id
 				ds.POST("/:datasetId/append/json/validate", AppendJSONValidate)
+				// Row-level change requests: delete rows by predicate, update cells by key
+				ds.POST("/:datasetId/changes/delete", ChangeDeleteCreate)
+				ds.POST("/:datasetId/changes/update", ChangeUpdateCreate)
+				// Upsert a validated upload on key columns
+				ds.POST("/:datasetId/changes/merge", ChangeMergeCreate)
+				// Approval policy overriding the project default for this dataset
+				ds.GET("/:datasetId/approval-policy", ApprovalPolicyGet)
+				ds.PUT("/:datasetId/approval-policy", ApprovalPolicyPut)
+				ds.DELETE("/:datasetId/approval-policy", ApprovalPolicyDelete)
 				// Preview sample from last upload
 				ds.GET("/:datasetId/sample", DatasetSample)
 				// Change Requests (approvals workflow)
@@ -222,6 +274,17 @@ func SetupRouter() *gin.Engine {
 					chg.POST("/:changeId/approve", ChangeApprove)
 					chg.POST("/:changeId/reject", ChangeReject)
 					chg.POST("/:changeId/withdraw", ChangeWithdraw)
+					// Reviewer management on open requests
+					chg.POST("/:changeId/reviewers", ChangeReviewersAdd)
+					chg.DELETE("/:changeId/reviewers/:userId", ChangeReviewerRemove)
+					chg.POST("/:changeId/delegate", ChangeReviewerDelegate)
+					chg.POST("/:changeId/rerequest-review", ChangeReRequestReview)
+					// "Changes requested" reviews and the author's revisions
+					chg.POST("/:changeId/request-changes", ChangeRequestChanges)
+					chg.GET("/:changeId/revisions", ChangeRevisionsList)
+					chg.POST("/:changeId/revisions", ChangeRevisionCreate)
+					// Deadline and merge window
+					chg.PUT("/:changeId/schedule", ChangeScheduleSet)
 					chg.GET("/:changeId", ChangeGet)
 					chg.GET("/:changeId/preview", ChangePreview)
 					chg.GET("/:changeId/comments", ChangeCommentsList)
@@ -262,6 +325,8 @@ func SetupRouter() *gin.Engine {
 			dsTop.POST(":id/data/append/open", DatasetAppendOpenTop)
 			dsTop.GET(":id/data", DatasetDataGet)
 			dsTop.GET(":id/stats", DatasetStats)
+			// Quality rules, latest check and trend
+			dsTop.GET(":id/dq", DatasetDQGet)
 			// Dataset-level approvals listing (filterable by status)
 			dsTop.GET(":id/approvals", DatasetApprovalsListTop)
 			dsTop.POST(":id/query", DatasetQuery)
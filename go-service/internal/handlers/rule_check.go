@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/storage"
+	"gorm.io/gorm"
+)
+
+// Rules are checked here rather than by the Python /rules/validate endpoint, with its
+// semantics: only required and not_null fail on null values. Rows stream through a rulesCheck
+// one at a time, so an upload is checked as it is read and a data quality job over the whole
+// dataset needs no second copy of it. The outcome has the shape of the Python errors, plus
+// where the failures are: data row indices from 0 and the column's position in the input.
+
+// ruleRowsLimit caps the rows a violation lists; its count covers them all.
+const ruleRowsLimit = 1000
+
+// ruleViolation is the failing rows of one column of a rule, or of an expression rule.
+type ruleViolation struct {
+	Rule        string   `json:"rule"`
+	Column      string   `json:"column,omitempty"`
+	ColumnIndex *int     `json:"column_index,omitempty"`
+	Columns     []string `json:"columns,omitempty"`
+	Rows        []int    `json:"rows"`
+	Count       int      `json:"count"`
+	Message     string   `json:"message"`
+	Severity    string   `json:"severity"`
+}
+
+// ruleCheck checks rows against one rule.
+type ruleCheck struct {
+	rule models.DataQualityRule
+	// cols are the columns failures are reported on; an expression rule reports on the row,
+	// under "", and exprCols are the columns it reads
+	cols     []string
+	exprCols []string
+	fails    func(i int, row map[string]any, col string) bool
+	message  func(col string) string
+	failed   map[string]*ruleViolation
+
+	// failing holds the rows failing on any column; failingRows lists ruleRowsLimit of them,
+	// failingCount counts them all
+	failing      map[int]bool
+	failingRows  []int
+	failingCount int
+}
+
+// fail records that row i fails on col. A row may fail on several columns, and the unique rule
+// reports a row once a later one repeats its value.
+func (rc *ruleCheck) fail(col string, i int) {
+	v := rc.failed[col]
+	if v == nil {
+		v = &ruleViolation{Rule: rc.rule.RuleType, Column: col, Message: rc.message(col), Severity: rc.rule.Severity}
+		rc.failed[col] = v
+	}
+	v.Count++
+	if len(v.Rows) < ruleRowsLimit {
+		v.Rows = append(v.Rows, i)
+	}
+	if !rc.failing[i] {
+		rc.failing[i] = true
+		rc.failingCount++
+		if len(rc.failingRows) < ruleRowsLimit {
+			rc.failingRows = append(rc.failingRows, i)
+		}
+	}
+}
+
+// newRuleCheck prepares the check of a rule, loading the values a foreign key refers to.
+func newRuleCheck(ctx context.Context, gdb *gorm.DB, ds *models.Dataset, r models.DataQualityRule) (*ruleCheck, error) {
+	rc := &ruleCheck{rule: r, cols: dqColumns(r.Definition), failed: map[string]*ruleViolation{}, failing: map[int]bool{}}
+	if rc.rule.Severity == "" {
+		rc.rule.Severity = "block"
+	}
+	def := r.Definition
+	switch r.RuleType {
+	case "required":
+		rc.fails = func(_ int, row map[string]any, col string) bool { return row[col] == nil || row[col] == "" }
+		rc.message = func(col string) string { return fmt.Sprintf("Column '%s' has missing values", col) }
+	case "not_null":
+		rc.fails = func(_ int, row map[string]any, col string) bool { return row[col] == nil }
+		rc.message = func(col string) string { return fmt.Sprintf("Column '%s' has nulls", col) }
+	case "unique":
+		// first holds the row each value of a column first appeared in, or -1 once that row was
+		// reported
+		first := map[string]int{}
+		rc.fails = func(i int, row map[string]any, col string) bool {
+			if row[col] == nil {
+				return false
+			}
+			k := col + "\x00" + dqKey(row[col])
+			at, dup := first[k]
+			if !dup {
+				first[k] = i
+				return false
+			}
+			if at >= 0 {
+				rc.fail(col, at)
+				first[k] = -1
+			}
+			return true
+		}
+		rc.message = func(col string) string { return fmt.Sprintf("Column '%s' contains duplicates", col) }
+	case "range":
+		lo, hasLo := dqFloat(def["min"])
+		hi, hasHi := dqFloat(def["max"])
+		rc.fails = func(_ int, row map[string]any, col string) bool {
+			if row[col] == nil {
+				return false
+			}
+			f, ok := dqFloat(row[col])
+			return !ok || (hasLo && f < lo) || (hasHi && f > hi)
+		}
+		rc.message = func(col string) string { return fmt.Sprintf("Column '%s' out of range", col) }
+	case "regex":
+		pattern, _ := def["pattern"].(string)
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("regex: invalid pattern")
+		}
+		rc.fails = func(_ int, row map[string]any, col string) bool {
+			return row[col] != nil && !re.MatchString(fmt.Sprint(row[col]))
+		}
+		rc.message = func(col string) string { return fmt.Sprintf("Column '%s' fails regex", col) }
+	case "allowed_values", "ref_in":
+		allowed := map[string]bool{}
+		values, _ := def["values"].([]any)
+		for _, v := range values {
+			allowed[dqKey(v)] = true
+		}
+		rc.fails = func(_ int, row map[string]any, col string) bool { return row[col] != nil && !allowed[dqKey(row[col])] }
+		rc.message = func(col string) string { return fmt.Sprintf("Column '%s' has values outside allowed set", col) }
+	case "foreign_key":
+		ref, refCol, err := foreignKeyTarget(gdb, ds, def)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := foreignKeySet(ctx, gdb, ref, refCol)
+		if err != nil {
+			return nil, err
+		}
+		rc.fails = func(_ int, row map[string]any, col string) bool { return row[col] != nil && !keys[dqKey(row[col])] }
+		rc.message = func(col string) string {
+			return fmt.Sprintf("Column '%s' has values not found in column '%s' of dataset '%s'", col, refCol, ref.Name)
+		}
+	case "expression":
+		src, _ := def["expression"].(string)
+		expr, err := parseRuleExpr(src)
+		if err != nil {
+			return nil, fmt.Errorf("expression: %v", err)
+		}
+		rc.cols, rc.exprCols = []string{""}, expr.columns
+		rc.fails = func(_ int, row map[string]any, _ string) bool { return expr.failsRow(row) }
+		msg, _ := def["message"].(string)
+		if msg == "" {
+			msg = fmt.Sprintf("Rows fail check '%s'", src)
+		}
+		rc.message = func(string) string { return msg }
+	default:
+		// Unknown rules are skipped, as by the Python validator
+		rc.cols = nil
+	}
+	return rc, nil
+}
+
+// foreignKeyTarget returns the dataset and column a foreign key rule refers to, which must be
+// in the project of ds.
+func foreignKeyTarget(gdb *gorm.DB, ds *models.Dataset, def models.JSONB) (*models.Dataset, string, error) {
+	refCol, _ := def["ref_column"].(string)
+	if refCol == "" {
+		refCol, _ = def["column"].(string)
+	}
+	var ref models.Dataset
+	if err := gdb.First(&ref, jobUint(def["dataset_id"])).Error; err != nil || ref.ProjectID != ds.ProjectID {
+		return nil, "", fmt.Errorf("foreign_key: dataset %v is not in this project", def["dataset_id"])
+	}
+	return &ref, refCol, nil
+}
+
+// foreignKeySet returns the values of column col of ref, keyed by dqKey, reading that column
+// alone where the backend can.
+func foreignKeySet(ctx context.Context, gdb *gorm.DB, ref *models.Dataset, col string) (map[string]bool, error) {
+	keys := map[string]bool{}
+	add := func(v any) {
+		if v != nil {
+			keys[dqKey(v)] = true
+		}
+	}
+	if nd, ok := nativeDelta(ref); ok {
+		res, err := nd.Query(ctx, storage.QueryRequest{DatasetID: storage.DeltaTableID(ref.ProjectID, ref.ID),
+			Where: storage.Predicate{{Column: col, Op: "not_null"}}})
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rowsFromMatrix(res.Columns, res.Rows) {
+			add(row.Data[col])
+		}
+		return keys, nil
+	}
+	if isDeltaBackend(ref) {
+		quoted := quoteIfNeeded(col)
+		_, rows, err := selectDeltaViaPython(ctx, ref, fmt.Sprintf("SELECT DISTINCT %s FROM t WHERE %s IS NOT NULL", quoted, quoted))
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			add(row[0])
+		}
+		return keys, nil
+	}
+	tbl := datasetPhysicalTable(ref)
+	if gdb == nil || !tableExists(gdb, tbl) {
+		return keys, nil
+	}
+	// Values come back as JSON so numbers and strings keep their types
+	expr := `json_quote(json_extract(data, '$."' || ? || '"'))`
+	if dialect(gdb) == "postgres" {
+		expr = "data->?"
+	}
+	rows, err := gdb.WithContext(ctx).Raw(fmt.Sprintf("SELECT DISTINCT %s FROM %s", expr, tbl), col).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var raw sql.NullString
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var v any
+		if raw.Valid && json.Unmarshal([]byte(raw.String), &v) == nil {
+			add(v)
+		}
+	}
+	return keys, rows.Err()
+}
+
+// checkRuleRefs checks that the foreign keys of rules for ds refer to datasets of its project.
+func checkRuleRefs(gdb *gorm.DB, ds *models.Dataset, rules []models.DataQualityRule) error {
+	for _, r := range rules {
+		if r.RuleType == "foreign_key" {
+			if _, _, err := foreignKeyTarget(gdb, ds, r.Definition); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// check checks row i.
+func (rc *ruleCheck) check(i int, row map[string]any) {
+	for _, col := range rc.cols {
+		if rc.fails(i, row, col) {
+			rc.fail(col, i)
+		}
+	}
+}
+
+// violations returns what failed, in rule column order; colIndex gives column positions.
+func (rc *ruleCheck) violations(colIndex map[string]int) []ruleViolation {
+	var out []ruleViolation
+	for _, col := range rc.cols {
+		v := rc.failed[col]
+		if v == nil {
+			continue
+		}
+		sort.Ints(v.Rows)
+		if col == "" {
+			v.Columns = rc.exprCols
+		} else if at, ok := colIndex[col]; ok {
+			v.ColumnIndex = &at
+		}
+		out = append(out, *v)
+	}
+	return out
+}
+
+// rulesCheck checks rows against the rules of a dataset.
+type rulesCheck struct {
+	checks []*ruleCheck
+	rows   int
+}
+
+// newRulesCheck prepares the check of rules for ds.
+func newRulesCheck(ctx context.Context, gdb *gorm.DB, ds *models.Dataset, rules []models.DataQualityRule) (*rulesCheck, error) {
+	rs := &rulesCheck{}
+	for _, r := range rules {
+		rc, err := newRuleCheck(ctx, gdb, ds, r)
+		if err != nil {
+			return nil, err
+		}
+		rs.checks = append(rs.checks, rc)
+	}
+	return rs, nil
+}
+
+// add checks the next row.
+func (rs *rulesCheck) add(row map[string]any) {
+	for _, rc := range rs.checks {
+		rc.check(rs.rows, row)
+	}
+	rs.rows++
+}
+
+// report returns the outcome in the shape of the Python /rules/validate response. Rows are
+// valid unless a rule of severity block fails; failures of warn rules are listed all the same.
+func (rs *rulesCheck) report(colIndex map[string]int) gin.H {
+	errs := []ruleViolation{}
+	valid := true
+	for _, rc := range rs.checks {
+		vs := rc.violations(colIndex)
+		if len(vs) > 0 && rc.rule.Severity == "block" {
+			valid = false
+		}
+		errs = append(errs, vs...)
+	}
+	return gin.H{"valid": valid, "errors": errs}
+}
+
+// datasetRules returns the rules mirrored in ds.Rules. Rules that do not parse, which only rules
+// set before data_quality_rules held them can be, are skipped as the Python validator skips
+// rules it does not know.
+func datasetRules(ds *models.Dataset) []models.DataQualityRule {
+	var objs []map[string]any
+	if strings.TrimSpace(ds.Rules) == "" || json.Unmarshal([]byte(ds.Rules), &objs) != nil {
+		return nil
+	}
+	var rules []models.DataQualityRule
+	for _, obj := range objs {
+		if r, err := parseDQRule(obj); err == nil {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
@@ -0,0 +1,473 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression rules check a condition across the columns of a row, e.g.
+// {"type": "expression", "expression": "end_date >= start_date and total = net + tax"}.
+// Conditions have column names ("double-quoted" or `backquoted` when they are not plain
+// identifiers), numbers, 'strings', true, false and null; + - * /; = != < <= > >=;
+// IS [NOT] NULL; AND, OR, NOT and parentheses. Values that both read as numbers compare as
+// numbers, others as text, so ISO dates compare in time order. As in a SQL CHECK constraint a
+// row fails only when the condition is false: a comparison with a null is unknown and passes.
+
+// ruleExpr is a parsed expression rule.
+type ruleExpr struct {
+	root    exprNode
+	columns []string
+}
+
+type exprNode interface {
+	eval(row map[string]any) (any, error)
+}
+
+type exprLit struct{ v any }
+type exprCol struct{ name string }
+type exprNot struct{ x exprNode }
+type exprNeg struct{ x exprNode }
+type exprIsNull struct {
+	x   exprNode
+	not bool
+}
+type exprBinary struct {
+	op   string
+	l, r exprNode
+}
+
+var errExprNotNumber = errors.New("not a number")
+
+func (n exprLit) eval(map[string]any) (any, error)     { return n.v, nil }
+func (n exprCol) eval(row map[string]any) (any, error) { return row[n.name], nil }
+
+func (n exprNot) eval(row map[string]any) (any, error) {
+	v, err := n.x.eval(row)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("NOT of %v", v)
+	}
+	return !b, nil
+}
+
+func (n exprNeg) eval(row map[string]any) (any, error) {
+	v, err := n.x.eval(row)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	f, ok := exprNumber(v)
+	if !ok {
+		return nil, errExprNotNumber
+	}
+	return -f, nil
+}
+
+func (n exprIsNull) eval(row map[string]any) (any, error) {
+	v, err := n.x.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	return (v == nil) != n.not, nil
+}
+
+func (n exprBinary) eval(row map[string]any) (any, error) {
+	l, err := n.l.eval(row)
+	if err != nil {
+		return nil, err
+	}
+	// AND and OR are three-valued: false AND null is false, true OR null is true
+	switch n.op {
+	case "and", "or":
+		lb, ok := l.(bool)
+		if l != nil && !ok {
+			return nil, fmt.Errorf("%s of %v", strings.ToUpper(n.op), l)
+		}
+		if l != nil && lb == (n.op == "or") {
+			return lb, nil
+		}
+		r, err := n.r.eval(row)
+		if err != nil {
+			return nil, err
+		}
+		rb, ok := r.(bool)
+		if r != nil && !ok {
+			return nil, fmt.Errorf("%s of %v", strings.ToUpper(n.op), r)
+		}
+		if r != nil && rb == (n.op == "or") {
+			return rb, nil
+		}
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return lb, nil
+	}
+	r, err := n.r.eval(row)
+	if err != nil || l == nil || r == nil {
+		return nil, err
+	}
+	switch n.op {
+	case "+", "-", "*", "/":
+		lf, lok := exprNumber(l)
+		rf, rok := exprNumber(r)
+		if !lok || !rok {
+			return nil, errExprNotNumber
+		}
+		switch n.op {
+		case "+":
+			return lf + rf, nil
+		case "-":
+			return lf - rf, nil
+		case "*":
+			return lf * rf, nil
+		}
+		if rf == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return lf / rf, nil
+	}
+	cmp, err := exprCompare(l, r, n.op == "=" || n.op == "!=")
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "=":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+// exprNumber reads a value as a number; booleans are not numbers.
+func exprNumber(v any) (float64, bool) {
+	if _, isBool := v.(bool); isBool {
+		return 0, false
+	}
+	return dqFloat(v)
+}
+
+// exprCompare orders two non-null values; booleans only compare for equality, with booleans.
+func exprCompare(l, r any, equality bool) (int, error) {
+	lb, lBool := l.(bool)
+	rb, rBool := r.(bool)
+	if lBool || rBool {
+		if !lBool || !rBool || !equality {
+			return 0, fmt.Errorf("cannot compare %v with %v", l, r)
+		}
+		if lb == rb {
+			return 0, nil
+		}
+		return 1, nil
+	}
+	if lf, ok := exprNumber(l); ok {
+		if rf, ok := exprNumber(r); ok {
+			switch {
+			case lf < rf:
+				return -1, nil
+			case lf > rf:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	return strings.Compare(fmt.Sprint(l), fmt.Sprint(r)), nil
+}
+
+// failsRow reports whether a row fails the expression: it is false, or cannot be worked out.
+func (e *ruleExpr) failsRow(row map[string]any) bool {
+	v, err := e.root.eval(row)
+	return err != nil || v == false
+}
+
+type exprToken struct {
+	kind byte // 'n' number, 's' string, 'i' identifier, 'q' quoted identifier, 'o' operator
+	text string
+}
+
+func tokenizeExpr(src string) ([]exprToken, error) {
+	var toks []exprToken
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		c := rs[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			j := i
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.' || rs[j] == 'e' || rs[j] == 'E' ||
+				((rs[j] == '+' || rs[j] == '-') && (rs[j-1] == 'e' || rs[j-1] == 'E'))) {
+				j++
+			}
+			toks = append(toks, exprToken{'n', string(rs[i:j])})
+			i = j
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(rs) && (rs[j] == '_' || rs[j] == '.' || unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j])) {
+				j++
+			}
+			toks = append(toks, exprToken{'i', string(rs[i:j])})
+			i = j
+		case c == '\'' || c == '"' || c == '`':
+			// A doubled quote stands for itself, as in SQL
+			var sb strings.Builder
+			j := i + 1
+			for {
+				if j >= len(rs) {
+					return nil, fmt.Errorf("unterminated %c", c)
+				}
+				if rs[j] == c {
+					if j+1 < len(rs) && rs[j+1] == c {
+						sb.WriteRune(c)
+						j += 2
+						continue
+					}
+					break
+				}
+				sb.WriteRune(rs[j])
+				j++
+			}
+			kind := byte('q')
+			if c == '\'' {
+				kind = 's'
+			}
+			toks = append(toks, exprToken{kind, sb.String()})
+			i = j + 1
+		default:
+			op, width := string(c), 1
+			if i+1 < len(rs) {
+				switch two := string(rs[i : i+2]); two {
+				case "<=", ">=", "!=", "<>", "==":
+					op, width = two, 2
+				}
+			}
+			switch op {
+			case "<>":
+				op = "!="
+			case "==":
+				op = "="
+			case "+", "-", "*", "/", "=", "!=", "<", "<=", ">", ">=", "(", ")":
+			default:
+				return nil, fmt.Errorf("unexpected %q", op)
+			}
+			toks = append(toks, exprToken{'o', op})
+			i += width
+		}
+	}
+	return toks, nil
+}
+
+// exprParser parses tokens by recursive descent, from the loosest binding operator down.
+type exprParser struct {
+	toks    []exprToken
+	pos     int
+	columns []string
+	seen    map[string]bool
+}
+
+// parseRuleExpr parses the condition of an expression rule.
+func parseRuleExpr(src string) (*ruleExpr, error) {
+	toks, err := tokenizeExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, errors.New("empty expression")
+	}
+	p := &exprParser{toks: toks, seen: map[string]bool{}}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q", p.toks[p.pos].text)
+	}
+	if !exprIsCondition(root) {
+		return nil, errors.New("expression is not a condition")
+	}
+	if len(p.columns) == 0 {
+		return nil, errors.New("expression uses no column")
+	}
+	return &ruleExpr{root: root, columns: p.columns}, nil
+}
+
+// exprIsCondition reports whether a node yields true, false or null.
+func exprIsCondition(n exprNode) bool {
+	switch x := n.(type) {
+	case exprNot, exprIsNull:
+		return true
+	case exprBinary:
+		return x.op != "+" && x.op != "-" && x.op != "*" && x.op != "/"
+	case exprLit:
+		_, ok := x.v.(bool)
+		return ok
+	}
+	return false
+}
+
+// keyword reports whether the next token is the keyword, and consumes it if so.
+func (p *exprParser) keyword(kw string) bool {
+	if p.pos < len(p.toks) && p.toks[p.pos].kind == 'i' && strings.EqualFold(p.toks[p.pos].text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// operator consumes the next token when it is one of ops and returns it.
+func (p *exprParser) operator(ops ...string) string {
+	if p.pos < len(p.toks) && p.toks[p.pos].kind == 'o' {
+		for _, op := range ops {
+			if p.toks[p.pos].text == op {
+				p.pos++
+				return op
+			}
+		}
+	}
+	return ""
+}
+
+func (p *exprParser) or() (exprNode, error) {
+	l, err := p.and()
+	for err == nil && p.keyword("or") {
+		var r exprNode
+		if r, err = p.and(); err == nil {
+			l = exprBinary{"or", l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) and() (exprNode, error) {
+	l, err := p.not()
+	for err == nil && p.keyword("and") {
+		var r exprNode
+		if r, err = p.not(); err == nil {
+			l = exprBinary{"and", l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) not() (exprNode, error) {
+	if p.keyword("not") {
+		x, err := p.not()
+		return exprNot{x}, err
+	}
+	return p.comparison()
+}
+
+func (p *exprParser) comparison() (exprNode, error) {
+	l, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if p.keyword("is") {
+		not := p.keyword("not")
+		if !p.keyword("null") {
+			return nil, errors.New("IS must be followed by NULL or NOT NULL")
+		}
+		return exprIsNull{l, not}, nil
+	}
+	if op := p.operator("=", "!=", "<", "<=", ">", ">="); op != "" {
+		r, err := p.sum()
+		return exprBinary{op, l, r}, err
+	}
+	return l, nil
+}
+
+func (p *exprParser) sum() (exprNode, error) {
+	l, err := p.product()
+	for err == nil {
+		op := p.operator("+", "-")
+		if op == "" {
+			break
+		}
+		var r exprNode
+		if r, err = p.product(); err == nil {
+			l = exprBinary{op, l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) product() (exprNode, error) {
+	l, err := p.unary()
+	for err == nil {
+		op := p.operator("*", "/")
+		if op == "" {
+			break
+		}
+		var r exprNode
+		if r, err = p.unary(); err == nil {
+			l = exprBinary{op, l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	if p.operator("-") != "" {
+		x, err := p.unary()
+		return exprNeg{x}, err
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	if p.pos >= len(p.toks) {
+		return nil, errors.New("unexpected end of expression")
+	}
+	t := p.toks[p.pos]
+	p.pos++
+	switch t.kind {
+	case 'n':
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t.text)
+		}
+		return exprLit{f}, nil
+	case 's':
+		return exprLit{t.text}, nil
+	case 'i', 'q':
+		if t.kind == 'i' {
+			switch strings.ToLower(t.text) {
+			case "true":
+				return exprLit{true}, nil
+			case "false":
+				return exprLit{false}, nil
+			case "null":
+				return exprLit{nil}, nil
+			case "and", "or", "not", "is":
+				return nil, fmt.Errorf("unexpected %s", strings.ToUpper(t.text))
+			}
+		}
+		if !p.seen[t.text] {
+			p.seen[t.text] = true
+			p.columns = append(p.columns, t.text)
+		}
+		return exprCol{t.text}, nil
+	}
+	if t.text == "(" {
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.operator(")") == "" {
+			return nil, errors.New("missing )")
+		}
+		return x, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
@@ -37,8 +37,10 @@ func RegisterSecurityRoutes(r *gin.Engine) {
 		sec.GET("/notifications/unread_count", unreadCount)
 		sec.GET("/notifications/stream", NotificationsStream) // SSE stream
 
+		// Data quality rules, and checks of a dataset against them (see data_quality.go)
 		sec.POST("/dq/rules", createDQRule)
 		sec.GET("/dq/rules", listDQRULES)
+		sec.DELETE("/dq/rules/:ruleId", deleteDQRule)
 		sec.POST("/dq/results", createDQResult)
 		sec.GET("/dq/results", listDQResults)
 	}
@@ -407,16 +409,3 @@ func unreadCount(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, gin.H{"ok": true, "count": cnt})
 }
-
-func createDQRule(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"ok": false, "reason": "todo"})
-}
-func listDQRULES(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"ok": false, "reason": "todo"})
-}
-func createDQResult(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"ok": false, "reason": "todo"})
-}
-func listDQResults(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"ok": false, "reason": "todo"})
-}
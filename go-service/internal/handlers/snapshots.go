@@ -72,8 +72,8 @@ func SnapshotCalendar(c *gin.Context) {
 		return
 	}
 
-	// Fetch Delta history from Python service
-	versions := fetchDeltaHistoryForSnapshots(dataset.ProjectID, uint(datasetID))
+	// Fetch Delta history (Python service, or in-process for delta-native)
+	versions := fetchDeltaHistoryForSnapshots(&dataset)
 
 	// Group by date
 	calendar := make(map[string][]SnapshotEntry)
@@ -95,38 +95,12 @@ func SnapshotCalendar(c *gin.Context) {
 	})
 }
 
-// fetchDeltaHistoryForSnapshots calls Python service to get Delta table history
-func fetchDeltaHistoryForSnapshots(projectID, datasetID uint) []SnapshotEntry {
+// fetchDeltaHistoryForSnapshots gets Delta table history as snapshot entries
+func fetchDeltaHistoryForSnapshots(dataset *models.Dataset) []SnapshotEntry {
 	var entries []SnapshotEntry
 
-	cfg := config.Get()
-	pyBase := cfg.PythonServiceURL
-	if pyBase == "" {
-		pyBase = "http://python-service:8000"
-	}
-
-	// Call /delta/history/{project_id}/{dataset_id}
-	url := fmt.Sprintf("%s/delta/history/%d/%d", pyBase, projectID, datasetID)
-	resp, err := http.Get(url)
-	if err != nil {
-		return entries
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return entries
-	}
-
-	body, _ := io.ReadAll(resp.Body)
-	var historyResp struct {
-		History []map[string]interface{} `json:"history"`
-	}
-	if err := json.Unmarshal(body, &historyResp); err != nil {
-		return entries
-	}
-
 	// Convert Delta history entries to snapshot entries
-	for _, entry := range historyResp.History {
+	for _, entry := range deltaHistoryEntries(dataset) {
 		version := int64(0)
 		if v, ok := entry["version"].(float64); ok {
 			version = int64(v)
@@ -168,6 +142,12 @@ func fetchDeltaHistoryForSnapshots(projectID, datasetID uint) []SnapshotEntry {
 			if v, ok := metrics["numTargetRowsDeleted"].(float64); ok {
 				rowsDeleted = int(v)
 			}
+			// DELETE commits: camelCase from delta-native, snake_case from delta-rs
+			for _, k := range []string{"numDeletedRows", "num_deleted_rows"} {
+				if v, ok := metrics[k].(float64); ok {
+					rowsDeleted = int(v)
+				}
+			}
 		}
 
 		entries = append(entries, SnapshotEntry{
@@ -316,8 +296,13 @@ func SnapshotData(c *gin.Context) {
 		return
 	}
 
-	// Call Python service for time-travel query
-	result, err := querySnapshotData(dataset.ProjectID, uint(datasetID), int(version), limit, offset)
+	// Time-travel query via Python service, or in-process for delta-native
+	var result map[string]interface{}
+	if nd, ok := nativeDelta(&dataset); ok {
+		result, err = nativeSnapshotData(c.Request.Context(), nd, &dataset, int(version), limit, offset)
+	} else {
+		result, err = querySnapshotData(dataset.ProjectID, uint(datasetID), int(version), limit, offset)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -407,12 +392,19 @@ func SnapshotRestore(c *gin.Context) {
 		}
 	}
 
-	// Call Python service to restore
-	result, err := executeRestore(dataset.ProjectID, uint(datasetID), int(version))
+	// Restore via Python service, or in-process for delta-native
+	var result map[string]interface{}
+	if nd, ok := nativeDelta(&dataset); ok {
+		result, err = nativeRestore(c.Request.Context(), nd, &dataset, int(version))
+	} else {
+		result, err = executeRestore(dataset.ProjectID, uint(datasetID), int(version))
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	// Cached query results of the dataset are now stale
+	_ = bumpDatasetVersion(gdb, dataset.ID)
 
 	// Extract stats from restore result
 	rowsAdded := 0
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// ApprovalPolicy decides when a change request is approved. A policy with DatasetID 0 is the
+// project default; a dataset policy replaces it for that dataset. Without any policy every
+// assigned reviewer must approve.
+type ApprovalPolicy struct {
+	ID        uint `json:"id" gorm:"primaryKey"`
+	ProjectID uint `json:"project_id" gorm:"not null;uniqueIndex:uniq_policy_scope"`
+	DatasetID uint `json:"dataset_id" gorm:"not null;default:0;uniqueIndex:uniq_policy_scope"` // 0 = project default
+	// MinApprovals is the quorum of reviewer approvals; 0 means every assigned reviewer
+	MinApprovals int `json:"min_approvals"`
+	// RequireOwnerApproval: one approval must come from a project owner. Owners are added as
+	// reviewers when the author picked none.
+	RequireOwnerApproval bool `json:"require_owner_approval"`
+	// ForbidSelfApproval: the author of a change cannot approve it and is not counted as a reviewer
+	ForbidSelfApproval bool `json:"forbid_self_approval"`
+	// AutoApproveMaxRows: appends of fewer rows that pass schema and rules are applied without
+	// review; 0 disables auto-approval
+	AutoApproveMaxRows int `json:"auto_approve_max_rows"`
+	// JSON array of project roles whose members are added as reviewers on every change and
+	// must all approve, e.g. ["owner"]
+	MandatoryRoles string    `json:"mandatory_roles" gorm:"type:text"`
+	UpdatedBy      uint      `json:"updated_by"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
@@ -51,6 +51,11 @@ const (
 	AuditEventTypeCRRejected   = "cr_rejected"
 	AuditEventTypeCRMerged     = "cr_merged"
 	AuditEventTypeCRWithdrawn  = "cr_withdrawn"
+	AuditEventTypeCRUpdated    = "cr_updated"
+	AuditEventTypeCRChangesReq = "cr_changes_requested"
+	AuditEventTypeCRScheduled  = "cr_scheduled"
+	AuditEventTypeCRExpired    = "cr_expired"
+	AuditEventTypeCREscalated  = "cr_escalated"
 	AuditEventTypeRestore      = "restore"
 	AuditEventTypeSchemaChange = "schema_change"
 	AuditEventTypeRuleChange   = "rule_change"
@@ -3,20 +3,76 @@ package models
 import "time"
 
 type ChangeRequest struct {
-	ID         uint `json:"id" gorm:"primaryKey"`
-	ProjectID  uint `json:"project_id" gorm:"index"`
-	DatasetID  uint `json:"dataset_id" gorm:"index"`
-	UserID     uint `json:"user_id" gorm:"index"`
+	ID        uint `json:"id" gorm:"primaryKey"`
+	ProjectID uint `json:"project_id" gorm:"index"`
+	DatasetID uint `json:"dataset_id" gorm:"index"`
+	UserID    uint `json:"user_id" gorm:"index"`
+	// ReviewerID is the first assigned reviewer, kept for single-reviewer clients
 	ReviewerID uint `json:"reviewer_id" gorm:"index"`
-	// JSON array of reviewer user IDs for multi-reviewer support
-	Reviewers string `json:"reviewers" gorm:"type:text"`
-	// JSON array of objects: [{"id":<user_id>, "status":"pending|approved|rejected", "decided_at":"RFC3339"}]
-	ReviewerStates string    `json:"reviewer_states" gorm:"type:text"`
-	Type           string    `json:"type" gorm:"size:50"`   // e.g., "append"
-	Status         string    `json:"status" gorm:"size:50"` // pending|approved|rejected|withdrawn
-	Title          string    `json:"title" gorm:"size:200"`
-	Payload        string    `json:"payload" gorm:"type:text"` // JSON rows or metadata
-	Summary        string    `json:"summary" gorm:"type:text"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
+	// Reviewers holds one row per assigned reviewer; load it with Preload("Reviewers")
+	Reviewers []ChangeRequestReviewer `json:"reviewers,omitempty" gorm:"foreignKey:ChangeRequestID"`
+	Type      string                  `json:"type" gorm:"size:50"`   // e.g., "append"
+	Status    string                  `json:"status" gorm:"size:50"` // pending|changes_requested|scheduled|applying|approved|completed|rejected|withdrawn|expired|failed
+	Title     string                  `json:"title" gorm:"size:200"`
+	Payload   string                  `json:"payload" gorm:"type:text"` // JSON rows or metadata
+	Summary   string                  `json:"summary" gorm:"type:text"`
+	// Revision counts the versions of the proposed data; see ChangeRequestRevision
+	Revision int `json:"revision" gorm:"not null;default:1"`
+	// BaseVersion, or BaseRowCount and BaseChecksum, identify the table state the request was
+	// validated against: the Delta version, or a JSONB table's data version, row count and
+	// highest id. Approval compares them with the current table to detect concurrent changes.
+	BaseVersion  *int64 `json:"base_version,omitempty"`
+	BaseRowCount int64  `json:"base_row_count,omitempty"`
+	BaseChecksum string `json:"base_checksum,omitempty" gorm:"size:64"`
+	// DueAt is an optional review deadline. Reviewers are reminded before it; past it the
+	// request expires, or with OnOverdue "escalate" is handed to the project owners.
+	DueAt          *time.Time `json:"due_at,omitempty" gorm:"index"`
+	OnOverdue      string     `json:"on_overdue,omitempty" gorm:"size:20"` // expire (default)|escalate
+	ReminderSentAt *time.Time `json:"reminder_sent_at,omitempty"`
+	EscalatedAt    *time.Time `json:"escalated_at,omitempty"`
+	// MergeAt delays the apply of an approved append to a maintenance window; until then the
+	// request is "scheduled"
+	MergeAt *time.Time `json:"merge_at,omitempty" gorm:"index"`
+	// ApplyKey identifies the approval that applies the change; Delta commits carry it as
+	// userMetadata so an interrupted apply can be matched to the table history.
+	ApplyKey       string     `json:"apply_key,omitempty" gorm:"size:64;index"`
+	ApplyStartedAt *time.Time `json:"apply_started_at,omitempty"`
+	AppliedAt      *time.Time `json:"applied_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// ChangeRequestReviewer is a reviewer assigned to a change request and their decision.
+type ChangeRequestReviewer struct {
+	ID              uint `json:"id" gorm:"primaryKey"`
+	ChangeRequestID uint `json:"change_request_id" gorm:"not null;uniqueIndex:uniq_cr_reviewer"`
+	UserID          uint `json:"user_id" gorm:"not null;uniqueIndex:uniq_cr_reviewer;index"`
+	// Status is pending|approved|rejected|changes_requested, or delegated once the review was
+	// handed to another user
+	Status    string     `json:"status" gorm:"size:20;not null;default:pending"`
+	DecidedAt *time.Time `json:"decided_at"`
+	Comment   string     `json:"comment,omitempty" gorm:"type:text"`
+	// DelegatedFrom is the reviewer who handed this review over; DelegatedTo the one it went to
+	DelegatedFrom uint      `json:"delegated_from,omitempty"`
+	DelegatedTo   uint      `json:"delegated_to,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// ChangeRequestRevision is one version of the data an append change request proposes. The
+// author submits a new revision after a reviewer requested changes; revision 1 is the original
+// upload and is recorded when the first new revision comes in.
+type ChangeRequestRevision struct {
+	ID              uint   `json:"id" gorm:"primaryKey"`
+	ChangeRequestID uint   `json:"change_request_id" gorm:"not null;uniqueIndex:uniq_cr_revision"`
+	Revision        int    `json:"revision" gorm:"not null;uniqueIndex:uniq_cr_revision"`
+	UploadID        uint   `json:"upload_id"`
+	Filename        string `json:"filename" gorm:"size:255"`
+	RowCount        int    `json:"row_count"`
+	// Valid and Validation are the schema and rules results for the revision's rows
+	Valid      bool      `json:"valid"`
+	Validation JSONB     `json:"validation" gorm:"type:jsonb"`
+	Note       string    `json:"note,omitempty" gorm:"type:text"`
+	CreatedBy  uint      `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
 }
@@ -19,6 +19,8 @@ type Dataset struct {
 	Rules          string     `json:"rules" gorm:"type:text"`
 	LastUploadPath string     `json:"last_upload_path" gorm:"size:500"`
 	LastUploadAt   *time.Time `json:"last_upload_at"`
+	// DataVersion counts the changes applied to the dataset's rows
+	DataVersion    int64      `json:"data_version" gorm:"not null;default:0"`
 	CreatedAt      time.Time  `json:"created_at"`
 	UpdatedAt      time.Time  `json:"updated_at"`
 
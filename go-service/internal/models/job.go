@@ -8,11 +8,32 @@ import (
 
 // Job represents an async background job (e.g. schema inference)
 type Job struct {
-	ID        uuid.UUID `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
-	Type      string    `json:"type" gorm:"size:200;index"`
-	Status    string    `json:"status" gorm:"size:50;index"` // pending|running|success|failed
-	Metadata  JSONB     `json:"metadata" gorm:"type:jsonb"`
-	Result    JSONB     `json:"result" gorm:"type:jsonb"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID       uuid.UUID `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	Type     string    `json:"type" gorm:"size:200;index"`
+	Status   string    `json:"status" gorm:"size:50;index"` // pending|running|success|failed|cancelled|dead
+	Metadata JSONB     `json:"metadata" gorm:"type:jsonb"`
+	Result   JSONB     `json:"result" gorm:"type:jsonb"`
+	// UserID submitted the job, for ProjectID if it belongs to a project; members of the
+	// project can see it
+	UserID    uint `json:"user_id" gorm:"index"`
+	ProjectID uint `json:"project_id" gorm:"index"`
+	// Progress is the percentage of the job done, as reported by its handler
+	Progress        int    `json:"progress" gorm:"not null;default:0"`
+	ProgressMessage string `json:"progress_message,omitempty" gorm:"size:500"`
+	// ScheduleID is the schedule that enqueued the job for its run at ScheduledFor
+	ScheduleID   *uint      `json:"schedule_id,omitempty" gorm:"index"`
+	ScheduledFor *time.Time `json:"scheduled_for,omitempty"`
+	// CancelRequestedAt is set when a running job is asked to stop
+	CancelRequestedAt *time.Time `json:"cancel_requested_at,omitempty"`
+	// Attempts counts the runs of the job. A failed run is retried at RunAt until the job runs
+	// out of attempts and is dead-lettered with its LastError.
+	Attempts  int        `json:"attempts" gorm:"not null;default:0"`
+	RunAt     *time.Time `json:"run_at,omitempty" gorm:"index"`
+	LastError string     `json:"last_error,omitempty" gorm:"type:text"`
+	// LockedBy is the worker running the job, which holds it until LeaseExpiresAt and renews
+	// the lease while the job runs
+	LockedBy       string     `json:"locked_by,omitempty" gorm:"size:200"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty" gorm:"index"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
 }
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// JobSchedule enqueues a job of JobType with its Metadata whenever its Cron expression, read
+// in Timezone, matches. The jobs run as OwnerID, who must be a contributor of ProjectID.
+type JobSchedule struct {
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	ProjectID uint   `json:"project_id" gorm:"not null;index"`
+	OwnerID   uint   `json:"owner_id" gorm:"not null;index"`
+	Name      string `json:"name" gorm:"size:200;not null"`
+	Cron      string `json:"cron" gorm:"size:200;not null"`
+	Timezone  string `json:"timezone" gorm:"size:100;not null"`
+	JobType   string `json:"job_type" gorm:"size:200;not null"`
+	Metadata  JSONB  `json:"metadata" gorm:"type:jsonb"`
+	Enabled   bool   `json:"enabled" gorm:"not null"`
+	// Misfire says what to do with runs missed while no scheduler was up: "run_once" enqueues
+	// one job for all of them, "skip" drops them
+	Misfire   string     `json:"misfire" gorm:"size:20;not null"`
+	NextRunAt *time.Time `json:"next_run_at,omitempty" gorm:"index"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	LastJobID *uuid.UUID `json:"last_job_id,omitempty" gorm:"type:uuid"`
+	// LastError says why the scheduler paused the schedule
+	LastError string    `json:"last_error,omitempty" gorm:"type:text"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -1,14 +1,60 @@
 package models
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
 // SavedQuery stores a user-saved query for a project
 type SavedQuery struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	ProjectID uint      `json:"project_id" gorm:"index"`
-	UserID    uint      `json:"user_id" gorm:"index"`
-	Name      string    `json:"name" gorm:"size:255"`
-	SQL       string    `json:"sql" gorm:"type:text"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        uint        `json:"id" gorm:"primaryKey"`
+	ProjectID uint        `json:"project_id" gorm:"index"`
+	UserID    uint        `json:"user_id" gorm:"index"`
+	Name      string      `json:"name" gorm:"size:255"`
+	SQL       string      `json:"sql" gorm:"type:text"`
+	Params    QueryParams `json:"params" gorm:"type:jsonb"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// QueryParam declares a named parameter of a saved query, written :name in its SQL
+type QueryParam struct {
+	Name string `json:"name"`
+	// Type is string, int, date (YYYY-MM-DD) or enum
+	Type string `json:"type"`
+	// Default is used when a run gives no value; without one the parameter is required
+	Default interface{} `json:"default,omitempty"`
+	// DatasetID and Column name the dataset column whose values an enum parameter takes
+	DatasetID uint   `json:"dataset_id,omitempty"`
+	Column    string `json:"column,omitempty"`
+}
+
+// QueryParams is stored as a JSON array
+type QueryParams []QueryParam
+
+func (p QueryParams) Value() (driver.Value, error) {
+	if p == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(p)
+	return string(b), err
+}
+
+func (p *QueryParams) Scan(src interface{}) error {
+	if src == nil {
+		*p = nil
+		return nil
+	}
+	var b []byte
+	switch v := src.(type) {
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("unsupported scan type for QueryParams: %T", src)
+	}
+	return json.Unmarshal(b, p)
 }
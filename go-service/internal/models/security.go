@@ -64,12 +64,18 @@ type DataQualityRule struct {
 	CreatedAt  time.Time `json:"created_at"`
 }
 
-// DataQualityResult stores validation results for uploads
+// DataQualityResult stores the outcome of a rule, for an upload or for a run of the dataset's
+// rules against the whole dataset by job JobID
 type DataQualityResult struct {
-	ID        uint64    `json:"id" gorm:"primaryKey;autoIncrement:true"`
-	UploadID  uint      `json:"upload_id" gorm:"index"`
-	RuleID    uint64    `json:"rule_id" gorm:"index"`
-	Passed    bool      `json:"passed"`
-	Details   JSONB     `json:"details" gorm:"type:jsonb"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        uint64     `json:"id" gorm:"primaryKey;autoIncrement:true"`
+	UploadID  uint       `json:"upload_id" gorm:"index"`
+	DatasetID uint       `json:"dataset_id" gorm:"index"`
+	JobID     *uuid.UUID `json:"job_id,omitempty" gorm:"type:uuid;index"`
+	RuleID    uint64     `json:"rule_id" gorm:"index"`
+	Passed    bool       `json:"passed"`
+	// TotalRows were checked, of which FailedRows broke the rule
+	TotalRows  int64     `json:"total_rows"`
+	FailedRows int64     `json:"failed_rows"`
+	Details    JSONB     `json:"details" gorm:"type:jsonb"`
+	CreatedAt  time.Time `json:"created_at"`
 }
@@ -0,0 +1,373 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/config"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Jobs are rows of the jobs table, run by the worker of any instance. A worker claims the oldest
+// due pending job with SELECT ... FOR UPDATE SKIP LOCKED, so no two workers claim the same job,
+// and holds a lease on it that a heartbeat renews while the job runs. A running job whose lease
+// ran out, because its worker died, is reclaimed on startup and by the sweep. Failed runs are
+// retried with exponential backoff; a job that fails all its attempts is dead-lettered with
+// status "dead" and its last error.
+//
+// CancelJob stops a job: a pending one at once, a running one by ending its context, right away
+// on the instance running it and otherwise at its next heartbeat. Handlers report progress with
+// ReportProgress; the job listener hears of every change of status or progress.
+
+// maxJobBackoff caps the wait before a job is retried.
+const maxJobBackoff = time.Hour
+
+// JobType says how the worker runs jobs of one type.
+type JobType struct {
+	// Run runs a claimed job, in status "running", and sets its Result. ctx ends when the job is
+	// cancelled, with cause ErrJobCancelled, or the worker loses its lease. A nil error completes
+	// the job; other errors are retried unless wrapped by Permanent. Run may also end the job as
+	// cancelled by setting its status.
+	Run func(ctx context.Context, gdb *gorm.DB, job *models.Job) error
+	// MaxAttempts overrides the configured number of attempts
+	MaxAttempts int
+	// Done, if set, is called once the job has ended and its state is saved
+	Done func(gdb *gorm.DB, job *models.Job)
+}
+
+// ErrJobCancelled ends the context of a job asked to stop.
+var ErrJobCancelled = errors.New("job_cancelled")
+
+// ErrJobEnded is returned by CancelJob for jobs that are no longer pending or running.
+var ErrJobEnded = errors.New("job_ended")
+
+var (
+	jobTypesMu sync.Mutex
+	jobTypes   = map[string]JobType{}
+
+	// runningJobs cancels the jobs this instance runs
+	runningMu   sync.Mutex
+	runningJobs = map[uuid.UUID]context.CancelCauseFunc{}
+
+	listenerMu  sync.Mutex
+	jobListener func(job *models.Job)
+
+	// workerID names this instance's worker in job leases.
+	workerID = func() string {
+		host, _ := os.Hostname()
+		return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), uuid.NewString()[:8])
+	}()
+)
+
+// RegisterJobType makes the worker run jobs of the given type, e.g. async queries.
+func RegisterJobType(name string, jt JobType) {
+	jobTypesMu.Lock()
+	defer jobTypesMu.Unlock()
+	jobTypes[name] = jt
+}
+
+// JobTypeRegistered reports whether the worker knows how to run jobs of a type.
+func JobTypeRegistered(name string) bool {
+	_, ok := lookupJobType(name)
+	return ok
+}
+
+// SetJobListener makes fn hear of every job that starts, reports progress or ends, e.g. to push
+// the change to the job's owner.
+func SetJobListener(fn func(job *models.Job)) {
+	listenerMu.Lock()
+	defer listenerMu.Unlock()
+	jobListener = fn
+}
+
+func notifyJob(job *models.Job) {
+	listenerMu.Lock()
+	fn := jobListener
+	listenerMu.Unlock()
+	if fn != nil {
+		fn(job)
+	}
+}
+
+func lookupJobType(name string) (JobType, bool) {
+	jobTypesMu.Lock()
+	defer jobTypesMu.Unlock()
+	jt, ok := jobTypes[name]
+	return jt, ok
+}
+
+type permanentError struct{ error }
+
+func (e permanentError) Unwrap() error { return e.error }
+
+// Permanent marks a job error that retrying won't fix; the job fails at once.
+func Permanent(err error) error { return permanentError{err} }
+
+func jobLease() time.Duration {
+	return time.Duration(config.Get().JobLeaseSeconds) * time.Second
+}
+
+func (jt JobType) maxAttempts() int {
+	if jt.MaxAttempts > 0 {
+		return jt.MaxAttempts
+	}
+	return config.Get().JobMaxAttempts
+}
+
+// jobBackoff is the wait before retrying a job that failed its attempt-th run.
+func jobBackoff(attempt int) time.Duration {
+	d := time.Duration(config.Get().JobRetryBaseSeconds) * time.Second
+	for i := 1; i < attempt && d < maxJobBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxJobBackoff)
+}
+
+// RunPendingJob claims the oldest due pending job and runs it, and reports whether there was one.
+func RunPendingJob(gdb *gorm.DB) bool {
+	job, err := claimJob(gdb, time.Now())
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("[jobs] claim: %v", err)
+		}
+		return false
+	}
+	runJob(gdb, job)
+	return true
+}
+
+// claimJob leases the oldest due pending job to this worker.
+func claimJob(gdb *gorm.DB, now time.Time) (*models.Job, error) {
+	var job models.Job
+	err := gdb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND (run_at IS NULL OR run_at <= ?)", "pending", now).
+			Order("created_at asc").First(&job).Error; err != nil {
+			return err
+		}
+		lease := now.Add(jobLease())
+		job.Status, job.LockedBy, job.LeaseExpiresAt = "running", workerID, &lease
+		job.Attempts++
+		return tx.Model(&job).Updates(map[string]any{
+			"status": job.Status, "locked_by": job.LockedBy, "lease_expires_at": lease, "attempts": job.Attempts,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	notifyJob(&job)
+	return &job, nil
+}
+
+// runJob runs a claimed job, renewing its lease until it ends, and saves how it ended.
+func runJob(gdb *gorm.DB, job *models.Job) {
+	jt, ok := lookupJobType(job.Type)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	runningMu.Lock()
+	runningJobs[job.ID] = cancel
+	runningMu.Unlock()
+	defer func() {
+		runningMu.Lock()
+		delete(runningJobs, job.ID)
+		runningMu.Unlock()
+	}()
+	go heartbeat(ctx, cancel, gdb, job.ID)
+
+	var err error
+	if !ok {
+		err = Permanent(errors.New("unknown_job_type"))
+	} else {
+		err = runJobSafely(ctx, jt, gdb, job)
+	}
+	cancelled := errors.Is(context.Cause(ctx), ErrJobCancelled)
+	cancel(nil)
+	finishJob(gdb, jt, job, err, cancelled, time.Now())
+}
+
+// runJobSafely turns a panicking job into a failed attempt.
+func runJobSafely(ctx context.Context, jt JobType, gdb *gorm.DB, job *models.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return jt.Run(ctx, gdb, job)
+}
+
+// heartbeat renews the lease of a running job until ctx ends. It cancels the job when the lease
+// is lost to another worker, or when another instance asked for the job to stop.
+func heartbeat(ctx context.Context, cancel context.CancelCauseFunc, gdb *gorm.DB, id uuid.UUID) {
+	ticker := time.NewTicker(jobLease() / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			res := gdb.Model(&models.Job{}).Where("id = ? AND status = ? AND locked_by = ?", id, "running", workerID).
+				Update("lease_expires_at", now.Add(jobLease()))
+			if res.Error != nil {
+				continue
+			}
+			if res.RowsAffected == 0 {
+				log.Printf("[jobs] lost lease on %s", id)
+				cancel(errors.New("lease lost"))
+				return
+			}
+			var job models.Job
+			if err := gdb.Select("cancel_requested_at").First(&job, "id = ?", id).Error; err == nil && job.CancelRequestedAt != nil {
+				cancel(ErrJobCancelled)
+				return
+			}
+		}
+	}
+}
+
+// CancelJob asks a job to stop, and returns its status after the request: cancelled for a job
+// that had not started, running for one whose handler has yet to stop.
+func CancelJob(gdb *gorm.DB, id uuid.UUID, now time.Time) (string, error) {
+	res := gdb.Model(&models.Job{}).Where("id = ? AND status = ?", id, "pending").
+		Updates(map[string]any{"status": "cancelled", "last_error": ErrJobCancelled.Error(), "cancel_requested_at": now, "run_at": nil})
+	if res.Error != nil {
+		return "", res.Error
+	}
+	if res.RowsAffected == 1 {
+		var job models.Job
+		if err := gdb.First(&job, "id = ?", id).Error; err == nil {
+			jt, _ := lookupJobType(job.Type)
+			notifyJob(&job)
+			if jt.Done != nil {
+				jt.Done(gdb, &job)
+			}
+		}
+		return "cancelled", nil
+	}
+	res = gdb.Model(&models.Job{}).Where("id = ? AND status = ?", id, "running").Update("cancel_requested_at", now)
+	if res.Error != nil {
+		return "", res.Error
+	}
+	if res.RowsAffected == 0 {
+		return "", ErrJobEnded
+	}
+	runningMu.Lock()
+	cancel := runningJobs[id]
+	runningMu.Unlock()
+	if cancel != nil {
+		cancel(ErrJobCancelled)
+	}
+	return "running", nil
+}
+
+// ReportProgress records how far a running job got, as a percentage and a message.
+func ReportProgress(gdb *gorm.DB, job *models.Job, percent int, message string) error {
+	percent = max(0, min(100, percent))
+	res := gdb.Model(&models.Job{}).Where("id = ? AND locked_by = ?", job.ID, workerID).
+		Updates(map[string]any{"progress": percent, "progress_message": message})
+	if res.Error != nil {
+		return res.Error
+	}
+	job.Progress, job.ProgressMessage = percent, message
+	notifyJob(job)
+	return nil
+}
+
+// finishJob saves the outcome of a run: done, cancelled, failed, retried later or dead-lettered.
+// cancelled is set when the job was asked to stop.
+func finishJob(gdb *gorm.DB, jt JobType, job *models.Job, err error, cancelled bool, now time.Time) {
+	var perm permanentError
+	switch {
+	case err == nil:
+		if job.Status == "running" {
+			job.Status = "success"
+			job.Progress = 100
+		}
+		job.LastError = ""
+	case cancelled || job.Status == "cancelled":
+		job.Status = "cancelled"
+	case errors.As(err, &perm):
+		job.Status = "failed"
+	case job.Attempts >= jt.maxAttempts():
+		job.Status = "dead"
+	default:
+		job.Status = "pending"
+		runAt := now.Add(jobBackoff(job.Attempts))
+		job.RunAt = &runAt
+	}
+	if err != nil {
+		job.LastError = err.Error()
+		if job.Result == nil {
+			job.Result = models.JSONB{"error": err.Error()}
+		}
+	}
+	res := gdb.Model(&models.Job{}).Where("id = ? AND locked_by = ?", job.ID, workerID).Updates(map[string]any{
+		"status": job.Status, "result": job.Result, "last_error": job.LastError, "run_at": job.RunAt,
+		"progress": job.Progress, "locked_by": "", "lease_expires_at": nil,
+	})
+	switch {
+	case res.Error != nil:
+		log.Printf("[jobs] save %s: %v", job.ID, res.Error)
+		return
+	case res.RowsAffected == 0:
+		// Another worker reclaimed the job after the lease ran out
+		log.Printf("[jobs] %s finished after losing its lease", job.ID)
+		return
+	}
+	job.LockedBy, job.LeaseExpiresAt = "", nil
+	notifyJob(job)
+	if job.Status == "pending" {
+		log.Printf("[jobs] %s %s attempt %d failed, retrying at %s: %v", job.Type, job.ID, job.Attempts, job.RunAt.Format(time.RFC3339), err)
+		return
+	}
+	if job.Status == "dead" {
+		log.Printf("[jobs] %s %s dead after %d attempts: %v", job.Type, job.ID, job.Attempts, err)
+	}
+	if jt.Done != nil {
+		jt.Done(gdb, job)
+	}
+}
+
+// ReclaimStaleJobs returns running jobs whose lease ran out to the queue, or dead-letters those
+// without attempts left. Jobs claimed before leases existed have none and are reclaimed too.
+func ReclaimStaleJobs(gdb *gorm.DB, now time.Time) {
+	var stale []models.Job
+	if err := gdb.Where("status = ? AND (lease_expires_at IS NULL OR lease_expires_at < ?)", "running", now).Find(&stale).Error; err != nil {
+		log.Printf("[jobs] reclaim: %v", err)
+		return
+	}
+	for i := range stale {
+		job := &stale[i]
+		jt, _ := lookupJobType(job.Type)
+		updates := map[string]any{"status": "pending", "locked_by": "", "lease_expires_at": nil, "run_at": now}
+		job.Status = "pending"
+		switch {
+		case job.CancelRequestedAt != nil:
+			job.Status, job.LastError = "cancelled", ErrJobCancelled.Error()
+		case job.Attempts >= jt.maxAttempts():
+			job.Status, job.LastError = "dead", "lease expired"
+		}
+		if job.Status != "pending" {
+			updates = map[string]any{"status": job.Status, "last_error": job.LastError, "locked_by": "", "lease_expires_at": nil}
+		}
+		res := gdb.Model(&models.Job{}).
+			Where("id = ? AND status = ? AND (lease_expires_at IS NULL OR lease_expires_at < ?)", job.ID, "running", now).
+			Updates(updates)
+		if res.Error != nil || res.RowsAffected == 0 {
+			continue
+		}
+		log.Printf("[jobs] reclaimed %s %s from %q: %s", job.Type, job.ID, job.LockedBy, job.Status)
+		job.LockedBy, job.LeaseExpiresAt = "", nil
+		notifyJob(job)
+		if job.Status != "pending" && jt.Done != nil {
+			jt.Done(gdb, job)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "os"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/glebarez/sqlite"
+    "github.com/google/uuid"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/config"
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+    "gorm.io/gorm"
+)
+
+// jobsEnv opens a sqlite database with a jobs table; models.Job defaults its id in Postgres only.
+func jobsEnv(t *testing.T) *gorm.DB {
+    t.Helper()
+    if os.Getenv("JWT_SECRET") == "" { t.Setenv("JWT_SECRET", strings.Repeat("s", 32)) }
+    if os.Getenv("ADMIN_PASSWORD") == "" { t.Setenv("ADMIN_PASSWORD", "test-admin-password") }
+    t.Setenv("JOB_MAX_ATTEMPTS", "3")
+    if _, err := config.Load(); err != nil { t.Fatalf("config: %v", err) }
+    gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+    if err != nil {
+        t.Fatalf("open sqlite: %v", err)
+    }
+    sqlDB, _ := gdb.DB()
+    sqlDB.SetMaxOpenConns(1)
+    gdb.Exec(`CREATE TABLE jobs (id text primary key, type text, status text, metadata text, result text, user_id integer, project_id integer,
+        progress integer not null default 0, progress_message text, schedule_id integer, scheduled_for datetime, cancel_requested_at datetime, attempts integer not null default 0, run_at datetime, last_error text, locked_by text, lease_expires_at datetime, created_at datetime, updated_at datetime)`)
+    return gdb
+}
+
+func TestJobs_RetryBackoffAndDeadLetter(t *testing.T) {
+    gdb := jobsEnv(t)
+    runs := 0
+    var done []string
+    RegisterJobType("test-flaky", JobType{
+        Run: func(ctx context.Context, gdb *gorm.DB, job *models.Job) error {
+            runs++
+            if job.Metadata["fail"] == "permanent" {
+                return Permanent(errors.New("bad input"))
+            }
+            if runs < 2 || job.Metadata["fail"] == "always" {
+                return errors.New("unavailable")
+            }
+            job.Result = models.JSONB{"ok": true}
+            return nil
+        },
+        Done: func(gdb *gorm.DB, job *models.Job) { done = append(done, job.Status) },
+    })
+    load := func(id uuid.UUID) models.Job {
+        var job models.Job
+        gdb.First(&job, "id = ?", id)
+        return job
+    }
+
+    flaky := models.Job{ID: uuid.New(), Type: "test-flaky", Status: "pending", Metadata: models.JSONB{}}
+    gdb.Create(&flaky)
+    if !RunPendingJob(gdb) {
+        t.Fatalf("no job claimed")
+    }
+    job := load(flaky.ID)
+    if job.Status != "pending" || job.Attempts != 1 || job.LastError != "unavailable" || job.RunAt == nil || job.LockedBy != "" {
+        t.Fatalf("after failed attempt: %+v", job)
+    }
+    if wait := time.Until(*job.RunAt); wait < 5*time.Second || wait > 10*time.Second {
+        t.Fatalf("backoff: %s", wait)
+    }
+    if RunPendingJob(gdb) {
+        t.Fatalf("job retried before its backoff")
+    }
+    gdb.Model(&models.Job{}).Where("id = ?", flaky.ID).Update("run_at", time.Now().Add(-time.Second))
+    RunPendingJob(gdb)
+    if job := load(flaky.ID); job.Status != "success" || job.Attempts != 2 || job.Result["ok"] != true || job.LastError != "" {
+        t.Fatalf("after retry: %+v", job)
+    }
+
+    doomed := models.Job{ID: uuid.New(), Type: "test-flaky", Status: "pending", Metadata: models.JSONB{"fail": "always"}}
+    gdb.Create(&doomed)
+    for i := 0; i < 3; i++ {
+        gdb.Model(&models.Job{}).Where("id = ?", doomed.ID).Update("run_at", nil)
+        RunPendingJob(gdb)
+    }
+    if job := load(doomed.ID); job.Status != "dead" || job.Attempts != 3 || job.LastError != "unavailable" {
+        t.Fatalf("dead letter: %+v", job)
+    }
+
+    invalid := models.Job{ID: uuid.New(), Type: "test-flaky", Status: "pending", Metadata: models.JSONB{"fail": "permanent"}}
+    unknown := models.Job{ID: uuid.New(), Type: "test-unknown", Status: "pending", CreatedAt: time.Now().Add(time.Second)}
+    gdb.Create(&invalid)
+    gdb.Create(&unknown)
+    RunPendingJob(gdb)
+    RunPendingJob(gdb)
+    if job := load(invalid.ID); job.Status != "failed" || job.Attempts != 1 {
+        t.Fatalf("permanent error: %+v", job)
+    }
+    if job := load(unknown.ID); job.Status != "failed" || job.LastError != "unknown_job_type" {
+        t.Fatalf("unknown type: %+v", job)
+    }
+    if strings.Join(done, ",") != "success,dead,failed" {
+        t.Fatalf("done: %v", done)
+    }
+}
+
+func TestJobs_ReclaimStaleLeases(t *testing.T) {
+    gdb := jobsEnv(t)
+    RegisterJobType("test-stale", JobType{Run: func(ctx context.Context, gdb *gorm.DB, job *models.Job) error { return nil }})
+    expired := time.Now().Add(-time.Minute)
+    live := time.Now().Add(time.Minute)
+    crashed := models.Job{ID: uuid.New(), Type: "test-stale", Status: "running", Attempts: 1, LockedBy: "gone", LeaseExpiresAt: &expired}
+    legacy := models.Job{ID: uuid.New(), Type: "test-stale", Status: "running"}
+    exhausted := models.Job{ID: uuid.New(), Type: "test-stale", Status: "running", Attempts: 3, LockedBy: "gone", LeaseExpiresAt: &expired}
+    busy := models.Job{ID: uuid.New(), Type: "test-stale", Status: "running", Attempts: 1, LockedBy: "alive", LeaseExpiresAt: &live}
+    for _, job := range []*models.Job{&crashed, &legacy, &exhausted, &busy} {
+        gdb.Create(job)
+    }
+
+    ReclaimStaleJobs(gdb, time.Now())
+    want := map[uuid.UUID]string{crashed.ID: "pending", legacy.ID: "pending", exhausted.ID: "dead", busy.ID: "running"}
+    for id, status := range want {
+        var job models.Job
+        gdb.First(&job, "id = ?", id)
+        if job.Status != status {
+            t.Errorf("%s: %s, want %s", id, job.Status, status)
+        }
+    }
+
+    // The reclaimed job runs again, and a worker that lost its lease cannot save over it
+    if !RunPendingJob(gdb) {
+        t.Fatalf("reclaimed job not claimed")
+    }
+    stale := busy
+    stale.LockedBy = "alive"
+    finishJob(gdb, JobType{}, &stale, nil, false, time.Now())
+    var job models.Job
+    gdb.First(&job, "id = ?", busy.ID)
+    if job.Status != "running" || job.LockedBy != "alive" {
+        t.Fatalf("finished by another worker: %+v", job)
+    }
+}
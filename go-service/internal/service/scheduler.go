@@ -0,0 +1,138 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Schedules enqueue jobs on a cron expression. The scheduler of every instance looks for due
+// schedules; like claimJob it locks each one with SKIP LOCKED, so a run is enqueued once however
+// many instances run a scheduler. A run more than misfireGrace late, because no scheduler was up
+// at the time, misfired: a schedule whose misfire policy is "run_once" enqueues one job for all
+// the runs it missed, one with "skip" drops them. The schedule then moves on to its next run
+// after now.
+//
+// Jobs run as the schedule's owner. The scheduler pauses a schedule whose owner is no longer a
+// contributor of its project, or whose expression stopped parsing, and says why in LastError.
+
+// misfireGrace is how late a scheduled run may be enqueued before it counts as missed.
+const misfireGrace = time.Minute
+
+// StartScheduler enqueues the jobs of due schedules, looking for them every interval.
+func StartScheduler(interval time.Duration) {
+	go func() {
+		gdb := workerDB()
+		for gdb == nil {
+			time.Sleep(interval)
+			gdb = workerDB()
+		}
+		for {
+			EnqueueDueSchedules(gdb, time.Now())
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// NextScheduleRun returns the first run of a cron expression in a time zone after t, or nil
+// when the expression matches no time in the next five years. An empty zone is UTC.
+func NextScheduleRun(cron, timezone string, t time.Time) (*time.Time, error) {
+	spec, err := ParseCron(cron)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+	next := spec.Next(t.In(loc))
+	if next.IsZero() {
+		return nil, nil
+	}
+	next = next.UTC()
+	return &next, nil
+}
+
+// EnqueueDueSchedules enqueues a job for every enabled schedule due at now and returns how many
+// it enqueued.
+func EnqueueDueSchedules(gdb *gorm.DB, now time.Time) int {
+	n := 0
+	for {
+		enqueued, err := enqueueDueSchedule(gdb, now)
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Printf("[scheduler] enqueue: %v", err)
+			}
+			return n
+		}
+		if enqueued {
+			n++
+		}
+	}
+}
+
+// enqueueDueSchedule moves the schedule due first to its next run, enqueueing the job of the run
+// unless it misfired and the schedule skips misfires, and reports whether it enqueued one.
+func enqueueDueSchedule(gdb *gorm.DB, now time.Time) (bool, error) {
+	enqueued := false
+	err := gdb.Transaction(func(tx *gorm.DB) error {
+		var s models.JobSchedule
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("enabled = ? AND next_run_at <= ?", true, now).
+			Order("next_run_at asc").First(&s).Error; err != nil {
+			return err
+		}
+		due := *s.NextRunAt
+		next, err := NextScheduleRun(s.Cron, s.Timezone, now)
+		if err == nil && !scheduleOwnerCanRun(tx, &s) {
+			err = errors.New("owner_not_contributor")
+		}
+		if err != nil {
+			log.Printf("[scheduler] pausing schedule %d: %v", s.ID, err)
+			return tx.Model(&s).Updates(map[string]any{"enabled": false, "next_run_at": nil, "last_error": err.Error()}).Error
+		}
+		updates := map[string]any{"next_run_at": next, "last_error": ""}
+		if now.Sub(due) > misfireGrace && s.Misfire == "skip" {
+			log.Printf("[scheduler] schedule %d missed its run at %s, skipped", s.ID, due.Format(time.RFC3339))
+			return tx.Model(&s).Updates(updates).Error
+		}
+		job := models.Job{
+			ID: uuid.New(), Type: s.JobType, Status: "pending", Metadata: scheduleJobMetadata(&s),
+			UserID: s.OwnerID, ProjectID: s.ProjectID, ScheduleID: &s.ID, ScheduledFor: &due,
+		}
+		if err := tx.Create(&job).Error; err != nil {
+			return err
+		}
+		updates["last_run_at"], updates["last_job_id"] = now, job.ID
+		enqueued = true
+		return tx.Model(&s).Updates(updates).Error
+	})
+	return enqueued, err
+}
+
+// scheduleOwnerCanRun reports whether the owner of a schedule may still run jobs in its project.
+func scheduleOwnerCanRun(gdb *gorm.DB, s *models.JobSchedule) bool {
+	var n int64
+	err := gdb.Model(&models.ProjectRole{}).
+		Where("project_id = ? AND user_id = ? AND LOWER(role) IN ?", s.ProjectID, s.OwnerID, []string{"owner", "contributor", "editor"}).
+		Count(&n).Error
+	return err == nil && n > 0
+}
+
+// scheduleJobMetadata is the metadata of a scheduled job: the schedule's, run as its owner in its
+// project whatever the metadata names.
+func scheduleJobMetadata(s *models.JobSchedule) models.JSONB {
+	meta := models.JSONB{}
+	for k, v := range s.Metadata {
+		meta[k] = v
+	}
+	meta["user_id"] = s.OwnerID
+	meta["project_id"] = s.ProjectID
+	meta["schedule_id"] = s.ID
+	return meta
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/models"
+    "gorm.io/gorm"
+)
+
+func TestCron_Next(t *testing.T) {
+    paris, _ := time.LoadLocation("Europe/Paris")
+    from := time.Date(2026, 3, 27, 10, 7, 30, 0, time.UTC) // a Friday
+    cases := []struct {
+        expr string
+        from time.Time
+        want time.Time
+    }{
+        {"*/15 * * * *", from, time.Date(2026, 3, 27, 10, 15, 0, 0, time.UTC)},
+        {"0 2 * * *", from, time.Date(2026, 3, 28, 2, 0, 0, 0, time.UTC)},
+        {"30 9 * * mon-fri", from, time.Date(2026, 3, 30, 9, 30, 0, 0, time.UTC)},
+        {"0 0 1,15 * *", from, time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+        {"0 0 13 * 5", from, time.Date(2026, 3, 27, 0, 0, 0, 0, time.UTC).AddDate(0, 0, 7)}, // the 13th or a Friday
+        {"@monthly", from, time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+        {"0 12 * feb 7", from, time.Date(2027, 2, 7, 12, 0, 0, 0, time.UTC)},
+        {"5/20 10 * * *", from, time.Date(2026, 3, 27, 10, 25, 0, 0, time.UTC)},
+        // 02:30 does not exist in Paris on the night clocks go forward
+        {"30 2 * * *", time.Date(2026, 3, 28, 12, 0, 0, 0, paris), time.Date(2026, 3, 30, 2, 30, 0, 0, paris)},
+        {"0 0 30 2 *", from, time.Time{}},
+    }
+    for _, tc := range cases {
+        spec, err := ParseCron(tc.expr)
+        if err != nil {
+            t.Fatalf("%s: %v", tc.expr, err)
+        }
+        if got := spec.Next(tc.from); !got.Equal(tc.want) {
+            t.Errorf("%s: next %s, want %s", tc.expr, got, tc.want)
+        }
+    }
+    for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "* * * foo *", "@often"} {
+        if _, err := ParseCron(expr); err == nil {
+            t.Errorf("%q parsed", expr)
+        }
+    }
+}
+
+func TestScheduler_EnqueueMisfireAndPause(t *testing.T) {
+    gdb := jobsEnv(t)
+    if err := gdb.AutoMigrate(&models.JobSchedule{}, &models.ProjectRole{}); err != nil {
+        t.Fatalf("migrate: %v", err)
+    }
+    RegisterJobType("test-scheduled", JobType{Run: func(ctx context.Context, gdb *gorm.DB, job *models.Job) error { return nil }})
+    gdb.Create(&models.ProjectRole{ProjectID: 1, UserID: 7, Role: "contributor"})
+    now := time.Date(2026, 3, 27, 10, 0, 20, 0, time.UTC)
+    at := func(d time.Duration) *time.Time { v := now.Add(d); return &v }
+    schedule := func(name, misfire string, owner uint, next *time.Time) *models.JobSchedule {
+        s := &models.JobSchedule{ProjectID: 1, OwnerID: owner, Name: name, Cron: "0 * * * *", Timezone: "UTC", JobType: "test-scheduled",
+            Metadata: models.JSONB{"table": name, "user_id": 1, "project_id": 2}, Enabled: true, Misfire: misfire, NextRunAt: next}
+        gdb.Create(s)
+        return s
+    }
+    onTime := schedule("on-time", "skip", 7, at(-20*time.Second))
+    caughtUp := schedule("caught-up", "run_once", 7, at(-3*time.Hour))
+    skipped := schedule("skipped", "skip", 7, at(-3*time.Hour))
+    later := schedule("later", "run_once", 7, at(time.Minute))
+    orphaned := schedule("orphaned", "run_once", 8, at(-time.Minute))
+
+    if n := EnqueueDueSchedules(gdb, now); n != 2 {
+        t.Fatalf("enqueued %d jobs, want 2", n)
+    }
+    if n := EnqueueDueSchedules(gdb, now); n != 0 {
+        t.Fatalf("enqueued %d jobs again", n)
+    }
+    nextHour := time.Date(2026, 3, 27, 11, 0, 0, 0, time.UTC)
+    for _, s := range []*models.JobSchedule{onTime, caughtUp, skipped, later, orphaned} {
+        var got models.JobSchedule
+        gdb.First(&got, s.ID)
+        var jobs []models.Job
+        gdb.Where("schedule_id = ?", s.ID).Find(&jobs)
+        switch s {
+        case onTime, caughtUp:
+            if len(jobs) != 1 || got.NextRunAt == nil || !got.NextRunAt.Equal(nextHour) || got.LastJobID == nil || *got.LastJobID != jobs[0].ID {
+                t.Fatalf("%s: %+v, jobs %+v", s.Name, got, jobs)
+            }
+            job := jobs[0]
+            if job.Type != "test-scheduled" || job.Status != "pending" || job.UserID != 7 || job.ProjectID != 1 || !job.ScheduledFor.Equal(*s.NextRunAt) ||
+                job.Metadata["table"] != s.Name || job.Metadata["user_id"] != float64(7) || job.Metadata["project_id"] != float64(1) {
+                t.Fatalf("%s job: %+v", s.Name, job)
+            }
+        case skipped:
+            if len(jobs) != 0 || !got.NextRunAt.Equal(nextHour) || !got.Enabled {
+                t.Fatalf("skipped: %+v, jobs %d", got, len(jobs))
+            }
+        case later:
+            if len(jobs) != 0 || !got.NextRunAt.Equal(*s.NextRunAt) {
+                t.Fatalf("later: %+v, jobs %d", got, len(jobs))
+            }
+        case orphaned:
+            if len(jobs) != 0 || got.Enabled || got.NextRunAt != nil || got.LastError != "owner_not_contributor" {
+                t.Fatalf("orphaned: %+v, jobs %d", got, len(jobs))
+            }
+        }
+    }
+}
@@ -2,11 +2,13 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/oreo-io/oreo.io-v2/go-service/internal/database"
@@ -15,53 +17,78 @@ import (
 	"gorm.io/gorm"
 )
 
-// StartWorker launches a background goroutine that polls the jobs table and
-// processes pending jobs. It's simple and intended for development; a real
-// production worker would use a separate process and robust locking.
+// sweepInterval is how often the worker runs the registered sweeps.
+const sweepInterval = time.Minute
+
+var (
+	sweepMu sync.Mutex
+	sweeps  []func(gdb *gorm.DB, now time.Time)
+)
+
+func init() {
+	RegisterJobType("infer-schema", JobType{Run: handleInferSchema})
+}
+
+// RegisterSweep adds a periodic maintenance task to the worker, e.g. the change
+// request deadline sweep. Sweeps run one after another every sweepInterval and
+// must tolerate other instances running the same sweep.
+func RegisterSweep(fn func(gdb *gorm.DB, now time.Time)) {
+	sweepMu.Lock()
+	defer sweepMu.Unlock()
+	sweeps = append(sweeps, fn)
+}
+
+// StartWorker reclaims jobs left running by instances that went away, then
+// launches the configured number of goroutines running jobs, each polling the
+// jobs table every pollInterval while it is empty, and runs the registered
+// sweeps. Any number of instances can run a worker.
 func StartWorker(pollInterval time.Duration) {
 	go func() {
-		for {
-			processOnce()
+		gdb := workerDB()
+		for gdb == nil {
 			time.Sleep(pollInterval)
+			gdb = workerDB()
+		}
+		// The first sweep reclaims stale jobs before any are claimed
+		sweepOnce(gdb)
+		for i := 0; i < config.Get().JobWorkers; i++ {
+			go func() {
+				for {
+					if !RunPendingJob(gdb) {
+						time.Sleep(pollInterval)
+					}
+				}
+			}()
+		}
+		for {
+			time.Sleep(sweepInterval)
+			sweepOnce(gdb)
 		}
 	}()
 }
 
-func processOnce() {
+func workerDB() *gorm.DB {
 	gdb := db.Get()
 	if gdb == nil {
 		if _, err := db.Init(); err != nil {
-			return
+			return nil
 		}
 		gdb = db.Get()
 	}
-	// Find one pending job and claim it (simple transaction)
-	var job models.Job
-	tx := gdb.Begin()
-	if tx.Where("status = ?", "pending").Order("created_at asc").First(&job).Error != nil {
-		tx.Rollback()
-		return
-	}
-	job.Status = "running"
-	if err := tx.Save(&job).Error; err != nil {
-		tx.Rollback()
-		return
-	}
-	tx.Commit()
+	return gdb
+}
 
-	// Dispatch by type
-	switch job.Type {
-	case "infer-schema":
-		_ = handleInferSchema(gdb, &job)
-	default:
-		// mark unknown types as failed
-		job.Status = "failed"
-		job.Result = models.JSONB{"error": "unknown_job_type"}
-		_ = gdb.Save(&job).Error
+func sweepOnce(gdb *gorm.DB) {
+	ReclaimStaleJobs(gdb, time.Now())
+	sweepMu.Lock()
+	fns := append([]func(*gorm.DB, time.Time){}, sweeps...)
+	sweepMu.Unlock()
+	for _, fn := range fns {
+		fn(gdb, time.Now())
 	}
 }
 
-func handleInferSchema(gdb *gorm.DB, job *models.Job) error {
+func handleInferSchema(ctx context.Context, gdb *gorm.DB, job *models.Job) error {
 	// Expect metadata to contain path and dataset_id
 	pathIface, _ := job.Metadata["path"]
 	dsIDIface, _ := job.Metadata["dataset_id"]
@@ -79,22 +106,19 @@ func handleInferSchema(gdb *gorm.DB, job *models.Job) error {
 	fw, _ := mw.CreateFormFile("file", "upload")
 	f, err := os.Open(pathStr)
 	if err != nil {
-		job.Status = "failed"
 		job.Result = models.JSONB{"error": "open_file_failed"}
-		_ = gdb.Save(job).Error
-		return err
+		return Permanent(err)
 	}
 	io.Copy(fw, f)
 	f.Close()
 	mw.Close()
 
-	req, _ := http.NewRequest(http.MethodPost, pyBase+"/infer-schema", &mpBuf)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, pyBase+"/infer-schema", &mpBuf)
 	req.Header.Set("Content-Type", mw.FormDataContentType())
 	resp, err := http.DefaultClient.Do(req)
-	if err != nil || resp == nil {
-		job.Status = "failed"
+	if err != nil {
+		// Retried: the Python service may be restarting
 		job.Result = models.JSONB{"error": "python_unreachable"}
-		_ = gdb.Save(job).Error
 		return err
 	}
 	defer resp.Body.Close()
@@ -128,7 +152,5 @@ func handleInferSchema(gdb *gorm.DB, job *models.Job) error {
 		}
 	}
 
-	job.Status = "success"
-	_ = gdb.Save(job).Error
 	return nil
 }
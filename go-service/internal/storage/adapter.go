@@ -10,10 +10,16 @@ type QueryRequest struct {
     DatasetID string
     // Filters is a simple key->value bag interpreted by the backend
     Filters  map[string]interface{}
+    // Where further restricts rows with a structured predicate (ANDed with Filters)
+    Where   Predicate
     // Optional ORDER BY clause (backend interpreted)
     OrderBy string
     // Limit number of rows returned
     Limit   int
+    // Offset skips rows before the first one returned
+    Offset  int
+    // AsOfVersion reads an older table version (Delta time travel); nil means latest
+    AsOfVersion *int
 }
 
 // QueryResult is a tabular response shape used across adapters.
@@ -27,25 +33,35 @@ type VersionInfo struct {
     Version   int
     Timestamp string
     Operation string
+    // OperationMetrics as recorded in the Delta commitInfo (may be nil)
+    OperationMetrics map[string]interface{}
+    // UserMetadata is the commit's userMetadata; tagged writes store their WithCommitTag here
+    UserMetadata string
 }
 
 // StorageAdapter is the swappable data access contract for dataset storage backends.
-// Implementations: PostgresAdapter (current), DeltaAdapter (migration target).
+// Implementations: PostgresAdapter (current), DeltaAdapter (migration target),
+// DeltaNativeAdapter (Delta tables read/written in-process).
 type StorageAdapter interface {
     Query(ctx context.Context, req QueryRequest) (QueryResult, error)
     Insert(ctx context.Context, datasetID string, records []map[string]interface{}) error
+    // Merge upserts the rows of the staging table (a datasetID resolved like the target's)
+    // into the target, matching rows on keys.
     Merge(ctx context.Context, datasetID string, stagingPath string, keys []string) error
-    Delete(ctx context.Context, datasetID string, filter string) error
+    // Delete removes the rows matching where; it is an error for where to be empty.
+    Delete(ctx context.Context, datasetID string, where Predicate) error
     History(ctx context.Context, datasetID string) ([]VersionInfo, error)
     Restore(ctx context.Context, datasetID string, version int) error
 }
 
 // NewAdapter returns a StorageAdapter implementation by name.
-// Recognized names: "postgres", "delta". Defaults to "postgres".
+// Recognized names: "postgres", "delta", "delta-native". Defaults to "postgres".
 func NewAdapter(name string) StorageAdapter {
     switch name {
     case "delta":
         return NewDeltaAdapter()
+    case "delta-native":
+        return NewDeltaNativeAdapter()
     case "postgres":
         fallthrough
     default:
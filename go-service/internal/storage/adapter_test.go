@@ -1,6 +1,21 @@
 package storage
 
-import "testing"
+import (
+    "os"
+    "strings"
+    "testing"
+
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/config"
+)
+
+// loadTestConfig (re)loads config from the current environment, filling in the secrets
+// Validate requires so adapters that read config.Get() can be constructed in tests.
+func loadTestConfig(t *testing.T) {
+    t.Helper()
+    if os.Getenv("JWT_SECRET") == "" { t.Setenv("JWT_SECRET", strings.Repeat("s", 32)) }
+    if os.Getenv("ADMIN_PASSWORD") == "" { t.Setenv("ADMIN_PASSWORD", "test-admin-password") }
+    if _, err := config.Load(); err != nil { t.Fatalf("config: %v", err) }
+}
 
 func TestAdapterFactory_DefaultPostgres(t *testing.T) {
     a := NewAdapter("")
@@ -10,9 +25,39 @@ func TestAdapterFactory_DefaultPostgres(t *testing.T) {
 }
 
 func TestAdapterFactory_Delta(t *testing.T) {
+    loadTestConfig(t)
     a := NewAdapter("delta")
     if _, ok := a.(*DeltaAdapter); !ok {
         t.Fatalf("expected DeltaAdapter for 'delta', got %T", a)
     }
 }
 
+func TestAdapterFactory_DeltaNative(t *testing.T) {
+    t.Setenv("DELTA_DATA_ROOT", "/delta-root/")
+    loadTestConfig(t)
+    a := NewAdapter("delta-native")
+    n, ok := a.(*DeltaNativeAdapter)
+    if !ok {
+        t.Fatalf("expected DeltaNativeAdapter for 'delta-native', got %T", a)
+    }
+    if n.root != "/delta-root" {
+        t.Fatalf("expected root from DELTA_DATA_ROOT, got %q", n.root)
+    }
+}
+
+func TestDeltaTablePath_SameForBothAdapters(t *testing.T) {
+    cases := map[string]string{
+        "1/2":          "/r/projects/1/datasets/2/main",
+        "customers":    "/r/customers",
+        "../../etc":    "/r/etc",
+        "a/b/c":        "/r/a/b/c",
+    }
+    for id, want := range cases {
+        got, err := DeltaTablePath("/r", id)
+        if err != nil || got != want { t.Fatalf("DeltaTablePath(%q) = %q, %v; want %q", id, got, err, want) }
+        native, _ := NewDeltaNativeAdapterAt("/r").TablePath(id)
+        if native != got { t.Fatalf("native adapter resolved %q to %q, proxy to %q", id, native, got) }
+    }
+    if _, err := DeltaTablePath("/r", " / "); err == nil { t.Fatalf("expected error for empty id") }
+    if DeltaTableID(3, 9) != "3/9" { t.Fatalf("unexpected DeltaTableID %q", DeltaTableID(3, 9)) }
+}
@@ -1,6 +1,10 @@
 package storage
 
-import "github.com/gin-gonic/gin"
+import (
+    "context"
+
+    "github.com/gin-gonic/gin"
+)
 
 // GetAdapter retrieves the StorageAdapter placed in Gin context during server init.
 // Falls back to a default Postgres adapter if missing.
@@ -9,7 +13,23 @@ func GetAdapter(c *gin.Context) StorageAdapter {
     if v, ok := c.Get("storage_adapter"); ok {
         if a, ok2 := v.(StorageAdapter); ok2 { return a }
         if a, ok2 := v.(*DeltaAdapter); ok2 { return a }
+        if a, ok2 := v.(*DeltaNativeAdapter); ok2 { return a }
         if a, ok2 := v.(*PostgresAdapter); ok2 { return a }
     }
     return NewAdapter("postgres")
 }
+
+type commitTagKey struct{}
+
+// WithCommitTag returns a context whose Delta writes record tag as the commit's userMetadata.
+// A tagged write is idempotent: if the table already has a commit with the same tag, the write
+// is skipped. An empty tag clears any inherited one.
+func WithCommitTag(ctx context.Context, tag string) context.Context {
+    return context.WithValue(ctx, commitTagKey{}, tag)
+}
+
+// CommitTag reports the tag set by WithCommitTag, or "".
+func CommitTag(ctx context.Context) string {
+    tag, _ := ctx.Value(commitTagKey{}).(string)
+    return tag
+}
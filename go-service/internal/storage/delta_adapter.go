@@ -13,6 +13,39 @@ import (
     "github.com/oreo-io/oreo.io-v2/go-service/internal/config"
 )
 
+// DeltaTableID is the datasetID of a project dataset's main table ("<pid>/<did>").
+func DeltaTableID(projectID, datasetID uint) string {
+    return fmt.Sprintf("%d/%d", projectID, datasetID)
+}
+
+// DeltaTablePath resolves a datasetID to its table directory under root. IDs of the form
+// "<pid>/<did>" (see DeltaTableID) map to the main table of the project dataset, laid out as
+// the Python service does; any other ID is a path relative to root.
+func DeltaTablePath(root, datasetID string) (string, error) {
+    id := strings.Trim(strings.TrimSpace(filepath.ToSlash(datasetID)), "/")
+    if id == "" { return "", fmt.Errorf("datasetID required") }
+    if parts := strings.Split(id, "/"); len(parts) == 2 && isDigits(parts[0]) && isDigits(parts[1]) {
+        return filepath.Join(root, "projects", parts[0], "datasets", parts[1], "main"), nil
+    }
+    clean := filepath.Clean("/" + id)
+    if clean == "/" { return "", fmt.Errorf("datasetID required") }
+    return filepath.Join(root, clean), nil
+}
+
+// DeltaStagingID is the datasetID of the staging table for a change request, next to the
+// dataset's main table (the Python service's staging/<change_request_id> layout).
+func DeltaStagingID(projectID, datasetID, changeID uint) string {
+    return fmt.Sprintf("projects/%d/datasets/%d/staging/%d", projectID, datasetID, changeID)
+}
+
+func isDigits(s string) bool {
+    if s == "" { return false }
+    for _, r := range s {
+        if r < '0' || r > '9' { return false }
+    }
+    return true
+}
+
 // DeltaAdapter proxies dataset operations to the Python FastAPI /delta endpoints.
 type DeltaAdapter struct {
     baseURL string
@@ -62,8 +95,10 @@ func (d *DeltaAdapter) Query(ctx context.Context, req QueryRequest) (QueryResult
     if root == "" { root = "/data/delta" }
     var fullPath string
     if strings.TrimSpace(req.DatasetID) != "" {
-        // Use filepath.Join to normalize separators; FastAPI expects posix-like paths but will handle windows mounts
-        fullPath = filepath.ToSlash(filepath.Join(root, req.DatasetID))
+        // FastAPI expects posix-like paths but will handle windows mounts
+        p, err := DeltaTablePath(root, req.DatasetID)
+        if err != nil { return QueryResult{}, err }
+        fullPath = filepath.ToSlash(p)
     }
     // Build payload for Python /delta/query
     payload := map[string]any{
@@ -71,6 +106,15 @@ func (d *DeltaAdapter) Query(ctx context.Context, req QueryRequest) (QueryResult
         "filters": req.Filters,
         "limit":   req.Limit,
     }
+    if req.Offset > 0 {
+        payload["offset"] = req.Offset
+    }
+    if req.AsOfVersion != nil {
+        payload["version"] = *req.AsOfVersion
+    }
+    if len(req.Where) > 0 {
+        payload["where"] = req.Where
+    }
     if strings.TrimSpace(req.OrderBy) != "" {
         payload["order_by"] = req.OrderBy
     }
@@ -114,14 +158,23 @@ func (d *DeltaAdapter) Merge(ctx context.Context, datasetID string, stagingPath
         "staging_path": stagingPath,
         "keys": keys,
     }
+    if tag := CommitTag(ctx); tag != "" { payload["commit_tag"] = tag }
     var resp map[string]any
     // Endpoint may not be implemented yet; keep contract in place
     return d.postJSON(ctx, "/delta/merge", payload, &resp)
 }
 
-func (d *DeltaAdapter) Delete(ctx context.Context, datasetID string, filter string) error {
-    // Not exposed yet; no-op for now
-    return nil
+// Delete sends the structured predicate to the Python service, which turns it into a
+// delta-rs delete (a new DELETE version of the table).
+func (d *DeltaAdapter) Delete(ctx context.Context, datasetID string, where Predicate) error {
+    if err := where.Validate(); err != nil { return err }
+    payload := map[string]any{
+        "table": datasetID,
+        "where": where,
+    }
+    if tag := CommitTag(ctx); tag != "" { payload["commit_tag"] = tag }
+    var resp map[string]any
+    return d.postJSON(ctx, "/delta/delete", payload, &resp)
 }
 
 func (d *DeltaAdapter) History(ctx context.Context, datasetID string) ([]VersionInfo, error) {
@@ -138,6 +191,8 @@ func (d *DeltaAdapter) History(ctx context.Context, datasetID string) ([]Version
         if v, ok := h["version"].(float64); ok { vi.Version = int(v) }
         if ts, ok := h["timestamp"].(string); ok { vi.Timestamp = ts }
         if op, ok := h["operation"].(string); ok { vi.Operation = op }
+        if m, ok := h["operationMetrics"].(map[string]any); ok { vi.OperationMetrics = m }
+        if u, ok := h["userMetadata"].(string); ok { vi.UserMetadata = u }
         result = append(result, vi)
     }
     return result, nil
@@ -25,6 +25,7 @@ func TestDeltaAdapter_Query_PathMapping(t *testing.T) {
     os.Setenv("PYTHON_SERVICE_URL", ts.URL)
     os.Setenv("DELTA_DATA_ROOT", "/delta-root")
 
+    loadTestConfig(t)
     a := NewDeltaAdapter()
     _, err := a.Query(context.Background(), QueryRequest{DatasetID: "customers", Limit: 1})
     if err != nil { t.Fatalf("query failed: %v", err) }
@@ -47,6 +48,7 @@ func TestDeltaAdapter_Query_FilterHandling(t *testing.T) {
     defer ts.Close()
     os.Setenv("PYTHON_SERVICE_URL", ts.URL)
     os.Setenv("DELTA_DATA_ROOT", "/delta-root")
+    loadTestConfig(t)
     a := NewDeltaAdapter()
     filters := map[string]interface{}{"country": "US", "active": true}
     _, err := a.Query(context.Background(), QueryRequest{DatasetID: "customers", Filters: filters, Limit: 10})
@@ -55,3 +57,27 @@ func TestDeltaAdapter_Query_FilterHandling(t *testing.T) {
         t.Fatalf("filters not transmitted correctly: %#v", receivedFilters)
     }
 }
+
+func TestDeltaAdapter_Delete_SendsStructuredPredicate(t *testing.T) {
+    var body struct {
+        Table string      `json:"table"`
+        Where []Condition `json:"where"`
+    }
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/delta/delete" { w.WriteHeader(404); return }
+        json.NewDecoder(r.Body).Decode(&body)
+        w.Header().Set("Content-Type", "application/json")
+        w.Write([]byte(`{"ok":true,"num_deleted_rows":1}`))
+    }))
+    defer ts.Close()
+    os.Setenv("PYTHON_SERVICE_URL", ts.URL)
+    loadTestConfig(t)
+    a := NewDeltaAdapter()
+    if err := a.Delete(context.Background(), "3/7", nil); err == nil { t.Fatalf("expected empty predicate to be rejected") }
+    if err := a.Delete(context.Background(), "3/7", Predicate{{Column: "id", Op: "eq", Value: float64(5)}}); err != nil {
+        t.Fatalf("delete failed: %v", err)
+    }
+    if body.Table != "3/7" || len(body.Where) != 1 || body.Where[0].Column != "id" || body.Where[0].Op != "eq" || body.Where[0].Value != float64(5) {
+        t.Fatalf("predicate not transmitted correctly: %+v", body)
+    }
+}
@@ -0,0 +1,364 @@
+package storage
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// Delta transaction log primitives used by DeltaNativeAdapter.
+// Only the JSON commit files are read; Parquet checkpoints are not, so a table whose early
+// commits were cleaned up by log retention cannot be opened natively.
+
+var (
+    errDeltaTableNotFound    = errors.New("delta table not found")
+    errDeltaConcurrentCommit = errors.New("delta commit conflict: version already exists")
+    errDeltaAppendOnly       = errors.New("delta table is append-only (delta.appendOnly): rows cannot be removed or changed")
+)
+
+// deltaAction is one line of a _delta_log commit file. Exactly one field is set.
+type deltaAction struct {
+    Protocol   *deltaProtocol         `json:"protocol,omitempty"`
+    MetaData   *deltaMetaData         `json:"metaData,omitempty"`
+    Add        *deltaAdd              `json:"add,omitempty"`
+    Remove     *deltaRemove           `json:"remove,omitempty"`
+    CommitInfo map[string]interface{} `json:"commitInfo,omitempty"`
+}
+
+type deltaProtocol struct {
+    MinReaderVersion int      `json:"minReaderVersion"`
+    MinWriterVersion int      `json:"minWriterVersion"`
+    ReaderFeatures   []string `json:"readerFeatures,omitempty"`
+    WriterFeatures   []string `json:"writerFeatures,omitempty"`
+}
+
+type deltaFormat struct {
+    Provider string            `json:"provider"`
+    Options  map[string]string `json:"options"`
+}
+
+type deltaMetaData struct {
+    ID               string             `json:"id"`
+    Name             *string            `json:"name"`
+    Description      *string            `json:"description"`
+    Format           deltaFormat        `json:"format"`
+    SchemaString     string             `json:"schemaString"`
+    PartitionColumns []string           `json:"partitionColumns"`
+    Configuration    map[string]*string `json:"configuration"`
+    CreatedTime      *int64             `json:"createdTime,omitempty"`
+}
+
+type deltaAdd struct {
+    Path             string             `json:"path"`
+    PartitionValues  map[string]*string `json:"partitionValues"`
+    Size             int64              `json:"size"`
+    ModificationTime int64              `json:"modificationTime"`
+    DataChange       bool               `json:"dataChange"`
+    Stats            string             `json:"stats,omitempty"`
+    Tags             map[string]string  `json:"tags,omitempty"`
+    DeletionVector   json.RawMessage    `json:"deletionVector,omitempty"`
+}
+
+type deltaRemove struct {
+    Path                 string             `json:"path"`
+    DeletionTimestamp    int64              `json:"deletionTimestamp"`
+    DataChange           bool               `json:"dataChange"`
+    ExtendedFileMetadata bool               `json:"extendedFileMetadata"`
+    PartitionValues      map[string]*string `json:"partitionValues"`
+    Size                 int64              `json:"size"`
+}
+
+// deltaField is a top-level column of the table schema (schemaString).
+type deltaField struct {
+    Name     string                 `json:"name"`
+    Type     json.RawMessage        `json:"type"`
+    Nullable bool                   `json:"nullable"`
+    Metadata map[string]interface{} `json:"metadata"`
+}
+
+// typeName returns the primitive type name ("string", "long", "decimal(10,2)", ...)
+// or "struct"/"array"/"map" for nested types.
+func (f deltaField) typeName() string {
+    var s string
+    if err := json.Unmarshal(f.Type, &s); err == nil {
+        return strings.ToLower(s)
+    }
+    var nested struct{ Type string `json:"type"` }
+    _ = json.Unmarshal(f.Type, &nested)
+    return strings.ToLower(nested.Type)
+}
+
+type deltaSchema struct {
+    Type   string       `json:"type"`
+    Fields []deltaField `json:"fields"`
+}
+
+// deltaSnapshot is the table state at a version, built by replaying commits 0..Version.
+type deltaSnapshot struct {
+    Path     string
+    Version  int64
+    Protocol deltaProtocol
+    MetaData deltaMetaData
+    Fields   []deltaField
+    // Files are the live data files keyed by their (relative) path
+    Files map[string]deltaAdd
+    // Timestamp of the commit that produced Version (ms since epoch)
+    Timestamp int64
+}
+
+// sortedFiles returns live files in path order so reads are deterministic.
+func (s *deltaSnapshot) sortedFiles() []deltaAdd {
+    out := make([]deltaAdd, 0, len(s.Files))
+    for _, f := range s.Files { out = append(out, f) }
+    sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+    return out
+}
+
+func (s *deltaSnapshot) columnNames() []string {
+    cols := make([]string, 0, len(s.Fields))
+    for _, f := range s.Fields { cols = append(cols, f.Name) }
+    return cols
+}
+
+func (s *deltaSnapshot) field(name string) (deltaField, bool) {
+    for _, f := range s.Fields {
+        if f.Name == name { return f, true }
+    }
+    return deltaField{}, false
+}
+
+func (s *deltaSnapshot) isPartitionColumn(name string) bool {
+    for _, p := range s.MetaData.PartitionColumns {
+        if p == name { return true }
+    }
+    return false
+}
+
+// configValue reads a table property such as delta.appendOnly.
+func (s *deltaSnapshot) configValue(key string) string {
+    if v, ok := s.MetaData.Configuration[key]; ok && v != nil { return *v }
+    return ""
+}
+
+// appendOnly reports whether the table has delta.appendOnly set, so that no rows may be removed
+// or changed.
+func (s *deltaSnapshot) appendOnly() bool {
+    return strings.EqualFold(s.configValue("delta.appendOnly"), "true")
+}
+
+// numRecords sums the numRecords stat over live files; ok=false if any file lacks stats.
+func (s *deltaSnapshot) numRecords() (int64, bool) {
+    var total int64
+    for _, f := range s.Files {
+        n, ok := addNumRecords(f)
+        if !ok { return 0, false }
+        total += n
+    }
+    return total, true
+}
+
+func addNumRecords(a deltaAdd) (int64, bool) {
+    if strings.TrimSpace(a.Stats) == "" { return 0, false }
+    var st struct{ NumRecords *int64 `json:"numRecords"` }
+    if err := json.Unmarshal([]byte(a.Stats), &st); err != nil || st.NumRecords == nil { return 0, false }
+    return *st.NumRecords, true
+}
+
+// checkReadable rejects tables using features this reader does not implement.
+func (s *deltaSnapshot) checkReadable() error {
+    if s.Protocol.MinReaderVersion > 3 {
+        return fmt.Errorf("delta reader version %d not supported", s.Protocol.MinReaderVersion)
+    }
+    for _, f := range s.Protocol.ReaderFeatures {
+        if f != "timestampNtz" {
+            return fmt.Errorf("delta reader feature %q not supported", f)
+        }
+    }
+    if m := s.configValue("delta.columnMapping.mode"); m != "" && m != "none" {
+        return fmt.Errorf("delta column mapping mode %q not supported", m)
+    }
+    for _, f := range s.Files {
+        if len(f.DeletionVector) > 0 && string(f.DeletionVector) != "null" {
+            return errors.New("delta deletion vectors not supported")
+        }
+    }
+    return nil
+}
+
+// checkWritable rejects tables whose writer protocol requires features this writer does not honour.
+func (s *deltaSnapshot) checkWritable() error {
+    if err := s.checkReadable(); err != nil { return err }
+    if s.Protocol.MinWriterVersion > 2 && len(s.Protocol.WriterFeatures) == 0 {
+        return fmt.Errorf("delta writer version %d not supported", s.Protocol.MinWriterVersion)
+    }
+    for _, f := range s.Protocol.WriterFeatures {
+        switch f {
+        case "appendOnly", "invariants", "timestampNtz":
+        default:
+            return fmt.Errorf("delta writer feature %q not supported", f)
+        }
+    }
+    return nil
+}
+
+var deltaCommitNameRe = regexp.MustCompile(`^(\d{20})\.json$`)
+
+func deltaLogDir(tablePath string) string { return filepath.Join(tablePath, "_delta_log") }
+
+func deltaCommitFile(tablePath string, version int64) string {
+    return filepath.Join(deltaLogDir(tablePath), fmt.Sprintf("%020d.json", version))
+}
+
+// listDeltaVersions returns the committed versions in ascending order.
+func listDeltaVersions(tablePath string) ([]int64, error) {
+    entries, err := os.ReadDir(deltaLogDir(tablePath))
+    if err != nil {
+        if os.IsNotExist(err) { return nil, errDeltaTableNotFound }
+        return nil, err
+    }
+    versions := make([]int64, 0, len(entries))
+    for _, e := range entries {
+        m := deltaCommitNameRe.FindStringSubmatch(e.Name())
+        if m == nil { continue }
+        v, _ := strconv.ParseInt(m[1], 10, 64)
+        versions = append(versions, v)
+    }
+    if len(versions) == 0 { return nil, errDeltaTableNotFound }
+    sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+    return versions, nil
+}
+
+func readDeltaCommit(tablePath string, version int64) ([]deltaAction, error) {
+    f, err := os.Open(deltaCommitFile(tablePath, version))
+    if err != nil { return nil, err }
+    defer f.Close()
+    sc := bufio.NewScanner(f)
+    sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+    var actions []deltaAction
+    for sc.Scan() {
+        line := strings.TrimSpace(sc.Text())
+        if line == "" { continue }
+        var a deltaAction
+        if err := json.Unmarshal([]byte(line), &a); err != nil {
+            return nil, fmt.Errorf("delta log %d: %w", version, err)
+        }
+        actions = append(actions, a)
+    }
+    return actions, sc.Err()
+}
+
+// loadDeltaSnapshot replays the log up to version (or the latest version when version < 0).
+func loadDeltaSnapshot(tablePath string, version int64) (*deltaSnapshot, error) {
+    versions, err := listDeltaVersions(tablePath)
+    if err != nil { return nil, err }
+    latest := versions[len(versions)-1]
+    if version < 0 { version = latest }
+    if version > latest {
+        return nil, fmt.Errorf("delta version %d does not exist (latest is %d)", version, latest)
+    }
+    if versions[0] != 0 {
+        return nil, fmt.Errorf("delta log starts at version %d; checkpoint-only tables are not supported", versions[0])
+    }
+    snap := &deltaSnapshot{Path: tablePath, Version: -1, Files: map[string]deltaAdd{}}
+    for _, v := range versions {
+        if v > version { break }
+        if v != snap.Version+1 {
+            return nil, fmt.Errorf("delta log is missing version %d", snap.Version+1)
+        }
+        actions, err := readDeltaCommit(tablePath, v)
+        if err != nil { return nil, err }
+        for _, a := range actions {
+            switch {
+            case a.Protocol != nil:
+                snap.Protocol = *a.Protocol
+            case a.MetaData != nil:
+                snap.MetaData = *a.MetaData
+                var schema deltaSchema
+                if err := json.Unmarshal([]byte(a.MetaData.SchemaString), &schema); err != nil {
+                    return nil, fmt.Errorf("delta schema at version %d: %w", v, err)
+                }
+                snap.Fields = schema.Fields
+            case a.Add != nil:
+                snap.Files[a.Add.Path] = *a.Add
+            case a.Remove != nil:
+                delete(snap.Files, a.Remove.Path)
+            case a.CommitInfo != nil:
+                if ts, ok := a.CommitInfo["timestamp"].(float64); ok { snap.Timestamp = int64(ts) }
+            }
+        }
+        snap.Version = v
+    }
+    return snap, nil
+}
+
+// writeDeltaCommit atomically publishes a commit file. The commit is staged in a temp file and
+// hard-linked into place so that a concurrent writer of the same version fails with
+// errDeltaConcurrentCommit instead of overwriting it.
+func writeDeltaCommit(tablePath string, version int64, actions []deltaAction) error {
+    dir := deltaLogDir(tablePath)
+    if err := os.MkdirAll(dir, 0o755); err != nil { return err }
+    var sb strings.Builder
+    for _, a := range actions {
+        b, err := json.Marshal(a)
+        if err != nil { return err }
+        sb.Write(b)
+        sb.WriteByte('\n')
+    }
+    tmp := filepath.Join(dir, fmt.Sprintf(".%020d.json.%s.tmp", version, uuid.NewString()))
+    if err := os.WriteFile(tmp, []byte(sb.String()), 0o644); err != nil { return err }
+    defer os.Remove(tmp)
+    if err := os.Link(tmp, deltaCommitFile(tablePath, version)); err != nil {
+        if os.IsExist(err) { return errDeltaConcurrentCommit }
+        return err
+    }
+    return nil
+}
+
+func newDeltaCommitInfo(ctx context.Context, operation string, params map[string]string, metrics map[string]interface{}) deltaAction {
+    info := map[string]interface{}{
+        "timestamp":           time.Now().UnixMilli(),
+        "operation":           operation,
+        "operationParameters": params,
+        "clientVersion":       "oreo-go-native",
+    }
+    if len(metrics) > 0 { info["operationMetrics"] = metrics }
+    if tag := CommitTag(ctx); tag != "" { info["userMetadata"] = tag }
+    return deltaAction{CommitInfo: info}
+}
+
+func newDeltaMetaData(fields []deltaField) (*deltaMetaData, error) {
+    b, err := json.Marshal(deltaSchema{Type: "struct", Fields: fields})
+    if err != nil { return nil, err }
+    created := time.Now().UnixMilli()
+    return &deltaMetaData{
+        ID:               uuid.NewString(),
+        Format:           deltaFormat{Provider: "parquet", Options: map[string]string{}},
+        SchemaString:     string(b),
+        PartitionColumns: []string{},
+        Configuration:    map[string]*string{},
+        CreatedTime:      &created,
+    }, nil
+}
+
+// deltaTableLocks serializes writers to the same table within this process;
+// cross-process safety comes from the exclusive commit in writeDeltaCommit.
+var deltaTableLocks sync.Map
+
+func lockDeltaTable(tablePath string) func() {
+    v, _ := deltaTableLocks.LoadOrStore(tablePath, &sync.Mutex{})
+    mu := v.(*sync.Mutex)
+    mu.Lock()
+    return mu.Unlock
+}
@@ -0,0 +1,784 @@
+package storage
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
+    "math/big"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/oreo-io/oreo.io-v2/go-service/internal/config"
+)
+
+// DeltaNativeAdapter reads and writes Delta tables directly under DeltaDataRoot, without the
+// Python service. Dataset IDs resolve exactly as for DeltaAdapter (see DeltaTablePath), so both
+// adapters operate on the same tables and either can be swapped in.
+type DeltaNativeAdapter struct {
+    root string
+}
+
+// NewDeltaNativeAdapter uses config DeltaDataRoot (default /data/delta).
+func NewDeltaNativeAdapter() *DeltaNativeAdapter {
+    cfg := config.Get(); root := strings.TrimRight(cfg.DeltaDataRoot, "/\\")
+    if root == "" { root = "/data/delta" }
+    return &DeltaNativeAdapter{root: root}
+}
+
+// NewDeltaNativeAdapterAt roots the adapter at an explicit directory (tests, tools).
+func NewDeltaNativeAdapterAt(root string) *DeltaNativeAdapter {
+    return &DeltaNativeAdapter{root: filepath.Clean(root)}
+}
+
+// DeltaColumn declares a column for EnsureTable. Type is a Delta primitive type name.
+type DeltaColumn struct {
+    Name     string
+    Type     string
+    Nullable bool
+}
+
+// TableStats summarizes the latest snapshot of a native Delta table.
+type TableStats struct {
+    Version    int
+    NumRows    int64
+    NumColumns int
+    NumFiles   int
+    SizeBytes  int64
+}
+
+func (d *DeltaNativeAdapter) TablePath(datasetID string) (string, error) {
+    return DeltaTablePath(d.root, datasetID)
+}
+
+func (d *DeltaNativeAdapter) Query(ctx context.Context, req QueryRequest) (QueryResult, error) {
+    if strings.TrimSpace(req.SQL) != "" {
+        return QueryResult{}, errors.New("delta-native: raw SQL not supported; use Filters/OrderBy/Limit")
+    }
+    path, err := d.TablePath(req.DatasetID)
+    if err != nil { return QueryResult{}, err }
+    version := int64(-1)
+    if req.AsOfVersion != nil { version = int64(*req.AsOfVersion) }
+    snap, err := loadDeltaSnapshot(path, version)
+    if errors.Is(err, errDeltaTableNotFound) { return QueryResult{Columns: []string{}, Rows: [][]interface{}{}}, nil }
+    if err != nil { return QueryResult{}, err }
+    if err := snap.checkReadable(); err != nil { return QueryResult{}, err }
+    cols := snap.columnNames()
+    for k := range req.Filters {
+        if _, ok := snap.field(k); !ok { return QueryResult{}, fmt.Errorf("unknown filter column %q", k) }
+    }
+    if len(req.Where) > 0 {
+        if err := req.Where.Validate(); err != nil { return QueryResult{}, err }
+        for _, col := range req.Where.Columns() {
+            if _, ok := snap.field(col); !ok { return QueryResult{}, fmt.Errorf("unknown predicate column %q", col) }
+        }
+    }
+    filters, where, err := snap.typedFilters(req.Filters, req.Where)
+    if err != nil { return QueryResult{}, err }
+    pruneBy := filters
+    if eq := where.equalities(); len(eq) > 0 {
+        pruneBy = map[string]interface{}{}
+        for k, v := range filters { pruneBy[k] = v }
+        for k, v := range eq { pruneBy[k] = v }
+    }
+    order, err := parseDeltaOrderBy(req.OrderBy, snap)
+    if err != nil { return QueryResult{}, err }
+    offset := req.Offset
+    if offset < 0 { offset = 0 }
+
+    var matched []map[string]interface{}
+    for _, add := range snap.sortedFiles() {
+        if err := ctx.Err(); err != nil { return QueryResult{}, err }
+        if !partitionMayMatch(snap, add, pruneBy) { continue }
+        recs, err := readDeltaFile(snap, add)
+        if err != nil { return QueryResult{}, err }
+        for _, rec := range recs {
+            if matchesDeltaFilters(rec, filters) && (len(where) == 0 || where.matchesTyped(rec)) { matched = append(matched, rec) }
+        }
+        // Without ordering we can stop as soon as the requested page is filled
+        if len(order) == 0 && req.Limit > 0 && len(matched) >= offset+req.Limit { break }
+    }
+    if len(order) > 0 {
+        sort.SliceStable(matched, func(i, j int) bool {
+            for _, o := range order {
+                c := compareDeltaValues(matched[i][o.col], matched[j][o.col])
+                if c == 0 { continue }
+                if o.desc { return c > 0 }
+                return c < 0
+            }
+            return false
+        })
+    }
+    if offset > len(matched) { offset = len(matched) }
+    matched = matched[offset:]
+    if req.Limit > 0 && len(matched) > req.Limit { matched = matched[:req.Limit] }
+    rows := make([][]interface{}, 0, len(matched))
+    for _, rec := range matched {
+        row := make([]interface{}, len(cols))
+        for i, c := range cols { row[i] = rec[c] }
+        rows = append(rows, row)
+    }
+    return QueryResult{Columns: cols, Rows: rows}, nil
+}
+
+// Insert appends records, creating the table (schema inferred from records) on first write.
+func (d *DeltaNativeAdapter) Insert(ctx context.Context, datasetID string, records []map[string]interface{}) error {
+    if len(records) == 0 { return nil }
+    path, err := d.TablePath(datasetID)
+    if err != nil { return err }
+    return commitWithRetry(ctx, path, func() error { return d.insertOnce(ctx, path, records) })
+}
+
+// commitWithRetry serializes writers in this process and retries when another process
+// committed the same version first. A write tagged with WithCommitTag is skipped when the
+// table already has a commit carrying the tag.
+func commitWithRetry(ctx context.Context, path string, attempt func() error) error {
+    unlock := lockDeltaTable(path)
+    defer unlock()
+    for i := 0; i < 3; i++ {
+        if err := ctx.Err(); err != nil { return err }
+        if tag := CommitTag(ctx); tag != "" {
+            done, err := deltaCommitTagged(path, tag)
+            if err != nil { return err }
+            if done { return nil }
+        }
+        err := attempt()
+        if !errors.Is(err, errDeltaConcurrentCommit) { return err }
+    }
+    return errDeltaConcurrentCommit
+}
+
+func (d *DeltaNativeAdapter) insertOnce(ctx context.Context, path string, records []map[string]interface{}) error {
+    var actions []deltaAction
+    snap, err := loadDeltaSnapshot(path, -1)
+    switch {
+    case errors.Is(err, errDeltaTableNotFound):
+        meta, err := newDeltaMetaData(inferDeltaFields(records))
+        if err != nil { return err }
+        actions = append(actions,
+            deltaAction{Protocol: &deltaProtocol{MinReaderVersion: 1, MinWriterVersion: 2}},
+            deltaAction{MetaData: meta},
+        )
+        snap, err = snapshotFromMetaData(path, meta)
+        if err != nil { return err }
+    case err != nil:
+        return err
+    default:
+        if err := snap.checkWritable(); err != nil { return err }
+    }
+    adds, err := writeDeltaDataFiles(snap, records)
+    if err != nil { return err }
+    var bytes int64
+    for i := range adds {
+        bytes += adds[i].Size
+        actions = append(actions, deltaAction{Add: &adds[i]})
+    }
+    actions = append(actions, newDeltaCommitInfo(ctx, "WRITE", map[string]string{"mode": "Append"}, map[string]interface{}{
+        "numFiles":       strconv.Itoa(len(adds)),
+        "numOutputRows":  strconv.Itoa(len(records)),
+        "numOutputBytes": strconv.FormatInt(bytes, 10),
+    }))
+    if err := writeDeltaCommit(path, snap.Version+1, actions); err != nil {
+        removeDeltaDataFiles(path, adds)
+        return err
+    }
+    return nil
+}
+
+// snapshotFromMetaData is the empty snapshot a create commit (version 0) will be applied to.
+func snapshotFromMetaData(path string, meta *deltaMetaData) (*deltaSnapshot, error) {
+    snap := &deltaSnapshot{Path: path, Version: -1, MetaData: *meta, Files: map[string]deltaAdd{}}
+    var schema deltaSchema
+    if err := json.Unmarshal([]byte(meta.SchemaString), &schema); err != nil { return nil, err }
+    snap.Fields = schema.Fields
+    return snap, nil
+}
+
+// EnsureTable creates an empty table with the given columns if none exists yet. With no
+// columns it does nothing and the first Insert infers the schema instead.
+func (d *DeltaNativeAdapter) EnsureTable(ctx context.Context, datasetID string, columns []DeltaColumn) error {
+    path, err := d.TablePath(datasetID)
+    if err != nil { return err }
+    if len(columns) == 0 { return nil }
+    fields := make([]deltaField, 0, len(columns))
+    for _, c := range columns {
+        if _, err := parquetNodeFor(c.Type, c.Nullable); err != nil { return fmt.Errorf("column %q: %w", c.Name, err) }
+        fields = append(fields, newDeltaField(c.Name, c.Type, c.Nullable))
+    }
+    return commitWithRetry(ctx, path, func() error {
+        if _, err := listDeltaVersions(path); !errors.Is(err, errDeltaTableNotFound) { return err }
+        meta, err := newDeltaMetaData(fields)
+        if err != nil { return err }
+        return writeDeltaCommit(path, 0, []deltaAction{
+            {Protocol: &deltaProtocol{MinReaderVersion: 1, MinWriterVersion: 2}},
+            {MetaData: meta},
+            newDeltaCommitInfo(ctx, "CREATE TABLE", map[string]string{"mode": "ErrorIfExists"}, nil),
+        })
+    })
+}
+
+// Stats reports row/column counts of the latest version. A missing table reports zeros.
+func (d *DeltaNativeAdapter) Stats(ctx context.Context, datasetID string) (TableStats, error) {
+    path, err := d.TablePath(datasetID)
+    if err != nil { return TableStats{}, err }
+    snap, err := loadDeltaSnapshot(path, -1)
+    if errors.Is(err, errDeltaTableNotFound) { return TableStats{Version: -1}, nil }
+    if err != nil { return TableStats{}, err }
+    st := TableStats{Version: int(snap.Version), NumColumns: len(snap.Fields), NumFiles: len(snap.Files)}
+    for _, add := range snap.Files {
+        if err := ctx.Err(); err != nil { return TableStats{}, err }
+        n, err := deltaFileNumRows(snap, add)
+        if err != nil { return TableStats{}, err }
+        st.NumRows += n
+        st.SizeBytes += add.Size
+    }
+    return st, nil
+}
+
+// Merge upserts the staging table's rows into the target in one MERGE version: a staging row
+// whose keys match a target row overwrites the staging table's columns (other target columns
+// are kept), any other staging row is inserted.
+// Only files holding rows that actually change are rewritten; if nothing changes no version
+// is written. Staging rows must have non-null keys, at most one per key, and only target columns.
+// An append-only table only takes merges that insert.
+func (d *DeltaNativeAdapter) Merge(ctx context.Context, datasetID string, stagingPath string, keys []string) error {
+    if len(keys) == 0 { return errors.New("merge keys required") }
+    path, err := d.TablePath(datasetID)
+    if err != nil { return err }
+    stage, err := d.TablePath(stagingPath)
+    if err != nil { return err }
+    source, err := readDeltaTable(ctx, stage)
+    if err != nil { return fmt.Errorf("merge source: %w", err) }
+    return commitWithRetry(ctx, path, func() error { return mergeOnce(ctx, path, source, keys) })
+}
+
+// readDeltaTable reads every row of the latest version of the table at path.
+func readDeltaTable(ctx context.Context, path string) ([]map[string]interface{}, error) {
+    snap, err := loadDeltaSnapshot(path, -1)
+    if err != nil { return nil, err }
+    if err := snap.checkReadable(); err != nil { return nil, err }
+    var out []map[string]interface{}
+    for _, add := range snap.sortedFiles() {
+        if err := ctx.Err(); err != nil { return nil, err }
+        recs, err := readDeltaFile(snap, add)
+        if err != nil { return nil, err }
+        out = append(out, recs...)
+    }
+    return out, nil
+}
+
+// RowKey renders a row's key column values as a map key, so that equal values of different
+// Go types (int64 from Parquet, float64 or numeric strings from JSON and CSV) produce the same
+// key. Numbers are rendered exactly, so keys beyond 2^53 stay distinct. It reports false when a
+// key column is null.
+func RowKey(rec map[string]interface{}, keys []string) (string, bool) {
+    var sb strings.Builder
+    for _, k := range keys {
+        v := rec[k]
+        if v == nil { return "", false }
+        if s, ok := v.(string); ok {
+            if n, ok := numericString(s); ok { v = n }
+        }
+        if n, ok := deltaNumber(v); ok {
+            sb.WriteString(n.RatString())
+        } else {
+            sb.WriteString(fmt.Sprint(v))
+        }
+        sb.WriteByte(0)
+    }
+    return sb.String(), true
+}
+
+func mergeOnce(ctx context.Context, path string, source []map[string]interface{}, keys []string) error {
+    snap, err := loadDeltaSnapshot(path, -1)
+    if err != nil { return err }
+    if err := snap.checkWritable(); err != nil { return err }
+    appendOnly := snap.appendOnly()
+    for _, k := range keys {
+        if _, ok := snap.field(k); !ok { return fmt.Errorf("unknown merge key %q", k) }
+    }
+    pending := make(map[string]map[string]interface{}, len(source))
+    order := make([]string, 0, len(source))
+    for _, rec := range source {
+        for col := range rec {
+            if _, ok := snap.field(col); !ok { return fmt.Errorf("merge source column %q is not in the target table", col) }
+        }
+        key, ok := RowKey(rec, keys)
+        if !ok { return fmt.Errorf("merge source row has a null key: %v", rec) }
+        if _, dup := pending[key]; dup { return fmt.Errorf("merge source has more than one row for key %v", keyValues(rec, keys)) }
+        pending[key] = rec
+        order = append(order, key)
+    }
+    now := time.Now().UnixMilli()
+    var actions []deltaAction
+    var written []deltaAdd
+    var updated, unchanged, removed int
+    matched := map[string]bool{}
+    for _, f := range snap.sortedFiles() {
+        if err := ctx.Err(); err != nil { removeDeltaDataFiles(path, written); return err }
+        recs, err := readDeltaFile(snap, f)
+        if err != nil { removeDeltaDataFiles(path, written); return err }
+        changed := 0
+        out := make([]map[string]interface{}, 0, len(recs))
+        for _, rec := range recs {
+            key, ok := RowKey(rec, keys)
+            src, hit := pending[key]
+            if !ok || !hit {
+                out = append(out, rec)
+                continue
+            }
+            if matched[key] { removeDeltaDataFiles(path, written); return fmt.Errorf("merge target has more than one row for key %v", keyValues(rec, keys)) }
+            matched[key] = true
+            next := make(map[string]interface{}, len(rec))
+            diff := false
+            for col, v := range rec { next[col] = v }
+            for col, v := range src {
+                if compareDeltaValues(rec[col], v) != 0 { diff = true }
+                next[col] = v
+            }
+            if diff { changed++ } else { unchanged++ }
+            out = append(out, next)
+        }
+        if changed == 0 { continue }
+        if appendOnly { removeDeltaDataFiles(path, written); return errDeltaAppendOnly }
+        updated += changed
+        actions = append(actions, deltaAction{Remove: &deltaRemove{
+            Path: f.Path, DeletionTimestamp: now, DataChange: true,
+            ExtendedFileMetadata: true, PartitionValues: f.PartitionValues, Size: f.Size,
+        }})
+        removed++
+        adds, err := writeDeltaDataFiles(snap, out)
+        if err != nil { removeDeltaDataFiles(path, written); return err }
+        written = append(written, adds...)
+    }
+    var inserts []map[string]interface{}
+    for _, key := range order {
+        if !matched[key] { inserts = append(inserts, pending[key]) }
+    }
+    if len(inserts) > 0 {
+        adds, err := writeDeltaDataFiles(snap, inserts)
+        if err != nil { removeDeltaDataFiles(path, written); return err }
+        written = append(written, adds...)
+    }
+    if updated == 0 && len(inserts) == 0 { return nil }
+    for i := range written { actions = append(actions, deltaAction{Add: &written[i]}) }
+    keyJSON, _ := json.Marshal(keys)
+    actions = append(actions, newDeltaCommitInfo(ctx, "MERGE", map[string]string{"keys": string(keyJSON)}, map[string]interface{}{
+        "numSourceRows":         strconv.Itoa(len(source)),
+        "numTargetRowsInserted": strconv.Itoa(len(inserts)),
+        "numTargetRowsUpdated":  strconv.Itoa(updated),
+        "numTargetRowsCopied":   strconv.Itoa(unchanged),
+        "numTargetFilesRemoved": strconv.Itoa(removed),
+        "numTargetFilesAdded":   strconv.Itoa(len(written)),
+    }))
+    if err := writeDeltaCommit(path, snap.Version+1, actions); err != nil {
+        removeDeltaDataFiles(path, written)
+        return err
+    }
+    return nil
+}
+
+func keyValues(rec map[string]interface{}, keys []string) map[string]interface{} {
+    out := make(map[string]interface{}, len(keys))
+    for _, k := range keys { out[k] = rec[k] }
+    return out
+}
+
+// DropTable deletes a table directory (used for staging tables once merged). A missing table
+// is not an error.
+func (d *DeltaNativeAdapter) DropTable(ctx context.Context, datasetID string) error {
+    path, err := d.TablePath(datasetID)
+    if err != nil { return err }
+    unlock := lockDeltaTable(path)
+    defer unlock()
+    return os.RemoveAll(path)
+}
+
+// Delete commits a DELETE version without the rows matching where. Files with no matching
+// rows are left alone; files with some are removed and their remaining rows rewritten. When
+// nothing matches no version is written. Append-only tables refuse deletes.
+func (d *DeltaNativeAdapter) Delete(ctx context.Context, datasetID string, where Predicate) error {
+    if err := where.Validate(); err != nil { return err }
+    path, err := d.TablePath(datasetID)
+    if err != nil { return err }
+    return commitWithRetry(ctx, path, func() error { return deleteOnce(ctx, path, where) })
+}
+
+func deleteOnce(ctx context.Context, path string, where Predicate) error {
+    snap, err := loadDeltaSnapshot(path, -1)
+    if err != nil { return err }
+    if err := snap.checkWritable(); err != nil { return err }
+    if snap.appendOnly() { return errDeltaAppendOnly }
+    for _, col := range where.Columns() {
+        if _, ok := snap.field(col); !ok { return fmt.Errorf("unknown predicate column %q", col) }
+    }
+    _, where, err = snap.typedFilters(nil, where)
+    if err != nil { return err }
+    eq := where.equalities()
+    now := time.Now().UnixMilli()
+    var actions []deltaAction
+    var written []deltaAdd
+    var deleted, copied, removed int
+    for _, f := range snap.sortedFiles() {
+        if err := ctx.Err(); err != nil { removeDeltaDataFiles(path, written); return err }
+        if !partitionMayMatch(snap, f, eq) { continue }
+        recs, err := readDeltaFile(snap, f)
+        if err != nil { removeDeltaDataFiles(path, written); return err }
+        keep := make([]map[string]interface{}, 0, len(recs))
+        for _, rec := range recs {
+            if !where.matchesTyped(rec) { keep = append(keep, rec) }
+        }
+        if len(keep) == len(recs) { continue }
+        deleted += len(recs) - len(keep)
+        copied += len(keep)
+        actions = append(actions, deltaAction{Remove: &deltaRemove{
+            Path: f.Path, DeletionTimestamp: now, DataChange: true,
+            ExtendedFileMetadata: true, PartitionValues: f.PartitionValues, Size: f.Size,
+        }})
+        removed++
+        if len(keep) == 0 { continue }
+        adds, err := writeDeltaDataFiles(snap, keep)
+        if err != nil { removeDeltaDataFiles(path, written); return err }
+        written = append(written, adds...)
+    }
+    if deleted == 0 { return nil }
+    for i := range written { actions = append(actions, deltaAction{Add: &written[i]}) }
+    actions = append(actions, newDeltaCommitInfo(ctx, "DELETE", map[string]string{"predicate": where.String()}, map[string]interface{}{
+        "numDeletedRows":  strconv.Itoa(deleted),
+        "numCopiedRows":   strconv.Itoa(copied),
+        "numRemovedFiles": strconv.Itoa(removed),
+        "numAddedFiles":   strconv.Itoa(len(written)),
+    }))
+    if err := writeDeltaCommit(path, snap.Version+1, actions); err != nil {
+        removeDeltaDataFiles(path, written)
+        return err
+    }
+    return nil
+}
+
+// deltaCommitInfoCache memoizes the VersionInfo of commit files, which are immutable once
+// written; entries are keyed by file path and revalidated against size and mtime.
+var deltaCommitInfoCache sync.Map
+
+type cachedVersionInfo struct {
+    size    int64
+    modTime time.Time
+    info    VersionInfo
+}
+
+// History lists versions newest first.
+func (d *DeltaNativeAdapter) History(ctx context.Context, datasetID string) ([]VersionInfo, error) {
+    path, err := d.TablePath(datasetID)
+    if err != nil { return nil, err }
+    versions, err := listDeltaVersions(path)
+    if errors.Is(err, errDeltaTableNotFound) { return []VersionInfo{}, nil }
+    if err != nil { return nil, err }
+    out := make([]VersionInfo, 0, len(versions))
+    for i := len(versions) - 1; i >= 0; i-- {
+        if err := ctx.Err(); err != nil { return nil, err }
+        info, err := deltaVersionInfo(path, versions[i])
+        if err != nil { return nil, err }
+        out = append(out, info)
+    }
+    return out, nil
+}
+
+func deltaVersionInfo(path string, version int64) (VersionInfo, error) {
+    file := deltaCommitFile(path, version)
+    st, err := os.Stat(file)
+    if err != nil { return VersionInfo{}, err }
+    if v, ok := deltaCommitInfoCache.Load(file); ok {
+        c := v.(cachedVersionInfo)
+        if c.size == st.Size() && c.modTime.Equal(st.ModTime()) { return c.info, nil }
+    }
+    actions, err := readDeltaCommit(path, version)
+    if err != nil { return VersionInfo{}, err }
+    info := VersionInfo{Version: int(version)}
+    for _, a := range actions {
+        if a.CommitInfo == nil { continue }
+        if op, ok := a.CommitInfo["operation"].(string); ok { info.Operation = op }
+        if ts, ok := a.CommitInfo["timestamp"].(float64); ok {
+            info.Timestamp = time.UnixMilli(int64(ts)).UTC().Format(time.RFC3339)
+        }
+        if m, ok := a.CommitInfo["operationMetrics"].(map[string]interface{}); ok { info.OperationMetrics = m }
+        if u, ok := a.CommitInfo["userMetadata"].(string); ok { info.UserMetadata = u }
+    }
+    deltaCommitInfoCache.Store(file, cachedVersionInfo{size: st.Size(), modTime: st.ModTime(), info: info})
+    return info, nil
+}
+
+// deltaCommitTagged reports whether any commit of the table at path has userMetadata tag.
+func deltaCommitTagged(path, tag string) (bool, error) {
+    versions, err := listDeltaVersions(path)
+    if errors.Is(err, errDeltaTableNotFound) { return false, nil }
+    if err != nil { return false, err }
+    for i := len(versions) - 1; i >= 0; i-- {
+        info, err := deltaVersionInfo(path, versions[i])
+        if err != nil { return false, err }
+        if info.UserMetadata == tag { return true, nil }
+    }
+    return false, nil
+}
+
+// Restore commits a new version whose live files (and schema) equal those of version. Append-only
+// tables refuse restores, which remove the files added since.
+func (d *DeltaNativeAdapter) Restore(ctx context.Context, datasetID string, version int) error {
+    if version < 0 { return fmt.Errorf("invalid version %d", version) }
+    path, err := d.TablePath(datasetID)
+    if err != nil { return err }
+    return commitWithRetry(ctx, path, func() error { return restoreOnce(ctx, path, version) })
+}
+
+func restoreOnce(ctx context.Context, path string, version int) error {
+    current, err := loadDeltaSnapshot(path, -1)
+    if err != nil { return err }
+    if err := current.checkWritable(); err != nil { return err }
+    if current.appendOnly() { return errDeltaAppendOnly }
+    target, err := loadDeltaSnapshot(path, int64(version))
+    if err != nil { return err }
+    if err := ctx.Err(); err != nil { return err }
+    now := time.Now().UnixMilli()
+    var actions []deltaAction
+    if target.MetaData.SchemaString != current.MetaData.SchemaString ||
+        strings.Join(target.MetaData.PartitionColumns, ",") != strings.Join(current.MetaData.PartitionColumns, ",") {
+        meta := target.MetaData
+        actions = append(actions, deltaAction{MetaData: &meta})
+    }
+    var removed, restored int
+    for _, f := range current.sortedFiles() {
+        if _, keep := target.Files[f.Path]; keep { continue }
+        actions = append(actions, deltaAction{Remove: &deltaRemove{
+            Path: f.Path, DeletionTimestamp: now, DataChange: true,
+            ExtendedFileMetadata: true, PartitionValues: f.PartitionValues, Size: f.Size,
+        }})
+        removed++
+    }
+    for _, f := range target.sortedFiles() {
+        if _, live := current.Files[f.Path]; live { continue }
+        if _, err := os.Stat(deltaFilePath(path, f.Path)); err != nil {
+            return fmt.Errorf("cannot restore version %d: data file %s is gone (vacuumed?)", version, f.Path)
+        }
+        add := f
+        add.DataChange = true
+        actions = append(actions, deltaAction{Add: &add})
+        restored++
+    }
+    actions = append(actions, newDeltaCommitInfo(ctx, "RESTORE", map[string]string{"version": strconv.Itoa(version)}, map[string]interface{}{
+        "numRemovedFile":  strconv.Itoa(removed),
+        "numRestoredFile": strconv.Itoa(restored),
+    }))
+    return writeDeltaCommit(path, current.Version+1, actions)
+}
+
+type deltaOrder struct {
+    col  string
+    desc bool
+}
+
+// parseDeltaOrderBy accepts "col [asc|desc], ..." with optionally double-quoted column names.
+func parseDeltaOrderBy(s string, snap *deltaSnapshot) ([]deltaOrder, error) {
+    s = strings.TrimSpace(s)
+    if s == "" { return nil, nil }
+    var out []deltaOrder
+    for _, part := range strings.Split(s, ",") {
+        fields := strings.Fields(strings.TrimSpace(part))
+        if len(fields) == 0 || len(fields) > 2 { return nil, fmt.Errorf("invalid order by %q", part) }
+        o := deltaOrder{col: strings.Trim(fields[0], `"`)}
+        if len(fields) == 2 {
+            switch strings.ToLower(fields[1]) {
+            case "asc":
+            case "desc":
+                o.desc = true
+            default:
+                return nil, fmt.Errorf("invalid order by %q", part)
+            }
+        }
+        if _, ok := snap.field(o.col); !ok { return nil, fmt.Errorf("unknown order by column %q", o.col) }
+        out = append(out, o)
+    }
+    return out, nil
+}
+
+// partitionMayMatch skips files whose partition values contradict an equality filter. Partition
+// values are read as their column type, as readDeltaFile does, before they are compared.
+func partitionMayMatch(snap *deltaSnapshot, add deltaAdd, filters map[string]interface{}) bool {
+    for col, want := range filters {
+        if !snap.isPartitionColumn(col) { continue }
+        pv := add.PartitionValues[col]
+        if pv == nil || *pv == deltaNullPartition {
+            if want != nil { return false }
+            continue
+        }
+        if want == nil { return false }
+        fld, _ := snap.field(col)
+        got, err := coerceDeltaValue(fld.typeName(), *pv)
+        if err != nil { continue }
+        if compareDeltaValues(deltaOutputValue(got, fld.typeName()), want) != 0 { return false }
+    }
+    return true
+}
+
+// typedFilters returns filters and where with their string values read as the types of their
+// columns, as DuckDB casts a string literal compared with a column, so "5" matches a long 5.
+// Other values are compared as they are.
+func (s *deltaSnapshot) typedFilters(filters map[string]interface{}, where Predicate) (map[string]interface{}, Predicate, error) {
+    typed := func(col string, v interface{}) (interface{}, error) {
+        str, ok := v.(string)
+        fld, _ := s.field(col)
+        if !ok || fld.typeName() == "string" { return v, nil }
+        cv, err := coerceDeltaValue(fld.typeName(), str)
+        if err != nil { return nil, fmt.Errorf("column %q: %w", col, err) }
+        return deltaOutputValue(cv, fld.typeName()), nil
+    }
+    outFilters := make(map[string]interface{}, len(filters))
+    for col, v := range filters {
+        tv, err := typed(col, v)
+        if err != nil { return nil, nil, err }
+        outFilters[col] = tv
+    }
+    outWhere := make(Predicate, len(where))
+    for i, c := range where {
+        outWhere[i] = c
+        switch list, isList := c.Value.([]interface{}); {
+        case isList:
+            vals := make([]interface{}, len(list))
+            for j, v := range list {
+                tv, err := typed(c.Column, v)
+                if err != nil { return nil, nil, err }
+                vals[j] = tv
+            }
+            outWhere[i].Value = vals
+        case c.Value != nil:
+            tv, err := typed(c.Column, c.Value)
+            if err != nil { return nil, nil, err }
+            outWhere[i].Value = tv
+        }
+    }
+    return outFilters, outWhere, nil
+}
+
+func matchesDeltaFilters(rec map[string]interface{}, filters map[string]interface{}) bool {
+    for col, want := range filters {
+        got := rec[col]
+        if got == nil || want == nil {
+            if got != want { return false }
+            continue
+        }
+        if compareDeltaValues(got, want) != 0 { return false }
+    }
+    return true
+}
+
+// compareDeltaValues orders values of the same kind: numbers by their exact value whatever their
+// Go type, booleans false first, strings (and so dates and timestamps) by their text. Values of
+// different kinds are never equal and order like jsonb: nil, strings, numbers, booleans, then
+// anything else, so a numeric string is not a number; see compareUntypedValues for rows without
+// column types.
+func compareDeltaValues(a, b interface{}) int {
+    ka, kb := deltaValueKind(a), deltaValueKind(b)
+    if ka != kb {
+        if ka < kb { return -1 }
+        return 1
+    }
+    switch ka {
+    case 0:
+        return 0
+    case 1:
+        return strings.Compare(a.(string), b.(string))
+    case 2:
+        // Values of one column share a type; only mixed types need exact arithmetic
+        switch x := a.(type) {
+        case int64:
+            if y, ok := b.(int64); ok { return compareOrdered(x, y) }
+        case float64:
+            if y, ok := b.(float64); ok { return compareOrdered(x, y) }
+        }
+        na, _ := deltaNumber(a)
+        nb, _ := deltaNumber(b)
+        return na.Cmp(nb)
+    case 3:
+        ab, bb := a.(bool), b.(bool)
+        switch {
+        case ab == bb:
+            return 0
+        case !ab:
+            return -1
+        default:
+            return 1
+        }
+    }
+    return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func compareOrdered[T int64 | float64](a, b T) int {
+    switch {
+    case a < b:
+        return -1
+    case a > b:
+        return 1
+    }
+    return 0
+}
+
+// deltaValueKind ranks the kinds compareDeltaValues orders: nil, string, number, bool, other.
+func deltaValueKind(v interface{}) int {
+    switch v.(type) {
+    case nil:
+        return 0
+    case string:
+        return 1
+    case bool:
+        return 3
+    }
+    if _, ok := deltaNumber(v); ok { return 2 }
+    return 4
+}
+
+// deltaNumber returns the exact value of a number: integers of any width as they are, floats at
+// their binary value, and json.Number as an integer when it spells one and as a float64
+// otherwise, like JSON decoded without UseNumber. NaN and infinities are not numbers.
+func deltaNumber(v interface{}) (*big.Rat, bool) {
+    switch x := v.(type) {
+    case int:
+        return new(big.Rat).SetInt64(int64(x)), true
+    case int32:
+        return new(big.Rat).SetInt64(int64(x)), true
+    case int64:
+        return new(big.Rat).SetInt64(x), true
+    case uint:
+        return new(big.Rat).SetUint64(uint64(x)), true
+    case uint64:
+        return new(big.Rat).SetUint64(x), true
+    case float32:
+        return floatRat(float64(x))
+    case float64:
+        return floatRat(x)
+    case json.Number:
+        if n, ok := new(big.Int).SetString(x.String(), 10); ok { return new(big.Rat).SetInt(n), true }
+        f, err := x.Float64()
+        if err != nil { return nil, false }
+        return floatRat(f)
+    }
+    return nil, false
+}
+
+func floatRat(f float64) (*big.Rat, bool) {
+    r := new(big.Rat).SetFloat64(f)
+    return r, r != nil
+}
+
+// numericString reads a string holding a number, such as a CSV cell, as that number: exactly
+// when it is an integer, as a float64 otherwise.
+func numericString(s string) (interface{}, bool) {
+    s = strings.TrimSpace(s)
+    if n, ok := new(big.Int).SetString(s, 10); ok { return json.Number(n.String()), true }
+    f, err := strconv.ParseFloat(s, 64)
+    if err != nil || math.IsInf(f, 0) || math.IsNaN(f) { return nil, false }
+    return f, true
+}
@@ -0,0 +1,424 @@
+package storage
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// copyFixtureTable copies testdata/<name> into a temp dir so tests can write to it.
+//
+// testdata/delta_rs_table mirrors what the Python service (deltalake / delta-rs 0.17) writes:
+// a CREATE TABLE commit followed by an Append, Hive-escaped partition directories ("a/b" is
+// stored on disk as region=a%2Fb and in the log as the URI region=a%252Fb), dictionary-encoded
+// strings, decimal(10,2) as FIXED_LEN_BYTE_ARRAY, DATE and microsecond UTC timestamps.
+func copyFixtureTable(t *testing.T, name string) string {
+    t.Helper()
+    src := filepath.Join("testdata", name)
+    dst := filepath.Join(t.TempDir(), name)
+    err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+        if err != nil { return err }
+        rel, _ := filepath.Rel(src, p)
+        if d.IsDir() { return os.MkdirAll(filepath.Join(dst, rel), 0o755) }
+        b, err := os.ReadFile(p)
+        if err != nil { return err }
+        return os.WriteFile(filepath.Join(dst, rel), b, 0o644)
+    })
+    if err != nil { t.Fatalf("copy fixture: %v", err) }
+    return dst
+}
+
+func rowsByID(t *testing.T, res QueryResult) map[int64]map[string]interface{} {
+    t.Helper()
+    out := map[int64]map[string]interface{}{}
+    for _, r := range res.Rows {
+        rec := map[string]interface{}{}
+        for i, c := range res.Columns { rec[c] = r[i] }
+        id, ok := rec["id"].(int64)
+        if !ok { t.Fatalf("row without int64 id: %+v", rec) }
+        out[id] = rec
+    }
+    return out
+}
+
+func TestDeltaNative_InsertQuery(t *testing.T) {
+    root := t.TempDir()
+    a := NewDeltaNativeAdapterAt(root)
+    ctx := context.Background()
+    recs := []map[string]interface{}{
+        {"id": float64(1), "name": "ann", "score": 2.5},
+        {"id": float64(2), "name": "bob", "score": 7.0},
+        {"id": float64(3), "name": "cy", "score": nil},
+    }
+    if err := a.Insert(ctx, DeltaTableID(1, 2), recs); err != nil { t.Fatalf("insert: %v", err) }
+    if _, err := os.Stat(filepath.Join(root, "projects", "1", "datasets", "2", "main", "_delta_log", "00000000000000000000.json")); err != nil {
+        t.Fatalf("expected python-compatible table layout: %v", err)
+    }
+    if err := a.Insert(ctx, "1/2", []map[string]interface{}{{"id": "4", "name": "dee", "score": "1.5"}}); err != nil {
+        t.Fatalf("second insert: %v", err)
+    }
+
+    res, err := a.Query(ctx, QueryRequest{DatasetID: "1/2", OrderBy: "id desc", Limit: 2})
+    if err != nil { t.Fatalf("query: %v", err) }
+    if len(res.Columns) != 3 || len(res.Rows) != 2 { t.Fatalf("unexpected result %+v", res) }
+    if res.Rows[0][0] != int64(4) || res.Rows[1][0] != int64(3) { t.Fatalf("order/limit not applied: %+v", res.Rows) }
+
+    res, err = a.Query(ctx, QueryRequest{DatasetID: "1/2", OrderBy: "id", Limit: 2, Offset: 3})
+    if err != nil { t.Fatalf("offset query: %v", err) }
+    if len(res.Rows) != 1 || res.Rows[0][0] != int64(4) { t.Fatalf("offset not applied: %+v", res.Rows) }
+
+    res, err = a.Query(ctx, QueryRequest{DatasetID: "1/2", Filters: map[string]interface{}{"name": "bob"}})
+    if err != nil { t.Fatalf("filter query: %v", err) }
+    if len(res.Rows) != 1 { t.Fatalf("expected 1 row, got %d", len(res.Rows)) }
+
+    if err := a.Insert(ctx, "1/2", []map[string]interface{}{{"nope": 1}}); err == nil {
+        t.Fatalf("expected unknown column to be rejected")
+    }
+    st, err := a.Stats(ctx, "1/2")
+    if err != nil || st.NumRows != 4 || st.NumColumns != 3 || st.Version != 1 { t.Fatalf("unexpected stats %+v, %v", st, err) }
+}
+
+func TestDeltaNative_HistoryRestore(t *testing.T) {
+    a := NewDeltaNativeAdapterAt(t.TempDir())
+    ctx := context.Background()
+    for i := 0; i < 3; i++ {
+        if err := a.Insert(ctx, "t", []map[string]interface{}{{"id": i}}); err != nil { t.Fatalf("insert: %v", err) }
+    }
+    hist, err := a.History(ctx, "t")
+    if err != nil { t.Fatalf("history: %v", err) }
+    if len(hist) != 3 || hist[0].Version != 2 || hist[0].Operation != "WRITE" || hist[0].OperationMetrics["numOutputRows"] != "1" {
+        t.Fatalf("unexpected history %+v", hist)
+    }
+    if err := a.Restore(ctx, "t", 0); err != nil { t.Fatalf("restore: %v", err) }
+    res, err := a.Query(ctx, QueryRequest{DatasetID: "t"})
+    if err != nil { t.Fatalf("query: %v", err) }
+    if len(res.Rows) != 1 || res.Rows[0][0] != int64(0) { t.Fatalf("restore did not roll back: %+v", res.Rows) }
+    hist, _ = a.History(ctx, "t")
+    if len(hist) != 4 || hist[0].Operation != "RESTORE" { t.Fatalf("expected RESTORE version, got %+v", hist) }
+
+    v := 1
+    res, err = a.Query(ctx, QueryRequest{DatasetID: "t", AsOfVersion: &v, OrderBy: "id"})
+    if err != nil || len(res.Rows) != 2 { t.Fatalf("time travel query: %+v, %v", res, err) }
+
+    cancelled, cancel := context.WithCancel(ctx)
+    cancel()
+    if _, err := a.History(cancelled, "t"); err == nil { t.Fatalf("expected History to honour ctx") }
+    if err := a.Restore(cancelled, "t", 1); err == nil { t.Fatalf("expected Restore to honour ctx") }
+}
+
+func TestDeltaNative_RestoreSchemaChange(t *testing.T) {
+    root := t.TempDir()
+    a := NewDeltaNativeAdapterAt(root)
+    ctx := context.Background()
+    if err := a.Insert(ctx, "t", []map[string]interface{}{{"id": 1}}); err != nil { t.Fatalf("insert: %v", err) }
+    // Evolve the schema the way an overwriteSchema write from another client would
+    path, _ := a.TablePath("t")
+    snap, err := loadDeltaSnapshot(path, -1)
+    if err != nil { t.Fatalf("load: %v", err) }
+    meta, _ := newDeltaMetaData(append(snap.Fields, newDeltaField("note", "string", true)))
+    if err := writeDeltaCommit(path, 1, []deltaAction{{MetaData: meta}, newDeltaCommitInfo(context.Background(), "SET TBLPROPERTIES", nil, nil)}); err != nil {
+        t.Fatalf("commit: %v", err)
+    }
+    if err := a.Insert(ctx, "t", []map[string]interface{}{{"id": 2, "note": "x"}}); err != nil { t.Fatalf("insert evolved: %v", err) }
+
+    if err := a.Restore(ctx, "t", 0); err != nil { t.Fatalf("restore: %v", err) }
+    res, err := a.Query(ctx, QueryRequest{DatasetID: "t"})
+    if err != nil { t.Fatalf("query: %v", err) }
+    if strings.Join(res.Columns, ",") != "id" || len(res.Rows) != 1 { t.Fatalf("schema not restored: %+v", res) }
+}
+
+func TestDeltaNative_ReadsDeltaRsTable(t *testing.T) {
+    path := copyFixtureTable(t, "delta_rs_table")
+    a := NewDeltaNativeAdapterAt(filepath.Dir(path))
+    ctx := context.Background()
+    id := filepath.Base(path)
+
+    res, err := a.Query(ctx, QueryRequest{DatasetID: id})
+    if err != nil { t.Fatalf("query: %v", err) }
+    if strings.Join(res.Columns, ",") != "id,name,amount,born,seen_at,region" { t.Fatalf("unexpected columns %v", res.Columns) }
+    rows := rowsByID(t, res)
+    if len(rows) != 3 { t.Fatalf("expected 3 rows, got %+v", res.Rows) }
+    want := map[string]interface{}{"name": "ann", "amount": 1234.56, "born": "1990-04-01", "seen_at": "2024-05-06T07:08:09.123456Z", "region": "North America"}
+    for k, v := range want {
+        if rows[1][k] != v { t.Fatalf("row 1 %s = %#v, want %#v", k, rows[1][k], v) }
+    }
+    if rows[2]["amount"] != -2.5 || rows[2]["born"] != nil { t.Fatalf("unexpected row 2 %+v", rows[2]) }
+    if rows[3]["region"] != "a/b" || rows[3]["name"] != nil { t.Fatalf("escaped partition not read: %+v", rows[3]) }
+
+    res, err = a.Query(ctx, QueryRequest{DatasetID: id, Filters: map[string]interface{}{"region": "a/b"}})
+    if err != nil || len(res.Rows) != 1 { t.Fatalf("partition filter: %+v, %v", res.Rows, err) }
+
+    hist, err := a.History(ctx, id)
+    if err != nil || len(hist) != 2 || hist[0].Operation != "WRITE" || hist[1].Operation != "CREATE TABLE" {
+        t.Fatalf("unexpected history %+v, %v", hist, err)
+    }
+    st, err := a.Stats(ctx, id)
+    if err != nil || st.NumRows != 3 || st.NumColumns != 6 { t.Fatalf("unexpected stats %+v, %v", st, err) }
+}
+
+func TestDeltaNative_PartitionedWriteEscaping(t *testing.T) {
+    path := copyFixtureTable(t, "delta_rs_table")
+    a := NewDeltaNativeAdapterAt(filepath.Dir(path))
+    ctx := context.Background()
+    id := filepath.Base(path)
+
+    recs := []map[string]interface{}{
+        {"id": 10, "name": "sp", "amount": "9.99", "born": "2020-02-29", "seen_at": "2024-01-01T00:00:00Z", "region": "x y"},
+        {"id": 11, "name": "sl", "region": "a/b"},
+        {"id": 12, "name": "pc", "region": "100%"},
+    }
+    if err := a.Insert(ctx, id, recs); err != nil { t.Fatalf("insert: %v", err) }
+    for _, dir := range []string{"region=x y", "region=a%2Fb", "region=100%25"} {
+        if _, err := os.Stat(filepath.Join(path, dir)); err != nil { t.Fatalf("expected partition dir %q: %v", dir, err) }
+    }
+    snap, err := loadDeltaSnapshot(path, -1)
+    if err != nil { t.Fatalf("load: %v", err) }
+    for p := range snap.Files {
+        if strings.HasPrefix(p, "region=x") && !strings.HasPrefix(p, "region=x%20y/") { t.Fatalf("add path not URI-encoded: %s", p) }
+    }
+
+    res, err := a.Query(ctx, QueryRequest{DatasetID: id, Filters: map[string]interface{}{"region": "a/b"}, OrderBy: "id"})
+    if err != nil || len(res.Rows) != 2 || res.Rows[1][0] != int64(11) { t.Fatalf("query a/b: %+v, %v", res.Rows, err) }
+    rows, err := a.Query(ctx, QueryRequest{DatasetID: id})
+    if err != nil { t.Fatalf("query: %v", err) }
+    got := rowsByID(t, rows)
+    if got[10]["region"] != "x y" || got[10]["amount"] != 9.99 || got[10]["born"] != "2020-02-29" || got[12]["region"] != "100%" {
+        t.Fatalf("round trip mismatch: %+v / %+v", got[10], got[12])
+    }
+
+    // Restore back to the delta-rs version removes the escaped files through the same path rule
+    if err := a.Restore(ctx, id, 1); err != nil { t.Fatalf("restore: %v", err) }
+    res, _ = a.Query(ctx, QueryRequest{DatasetID: id})
+    if len(res.Rows) != 3 { t.Fatalf("expected 3 rows after restore, got %d", len(res.Rows)) }
+}
+
+func TestDeltaNative_CoercionErrors(t *testing.T) {
+    a := NewDeltaNativeAdapterAt(t.TempDir())
+    ctx := context.Background()
+    cols := []DeltaColumn{
+        {Name: "id", Type: "long"},
+        {Name: "i", Type: "integer", Nullable: true},
+        {Name: "b", Type: "byte", Nullable: true},
+        {Name: "d", Type: "decimal(5,2)", Nullable: true},
+        {Name: "day", Type: "date", Nullable: true},
+    }
+    if err := a.EnsureTable(ctx, "t", cols); err != nil { t.Fatalf("ensure: %v", err) }
+    if err := a.EnsureTable(ctx, "t", cols); err != nil { t.Fatalf("ensure is not idempotent: %v", err) }
+
+    bad := map[string]map[string]interface{}{
+        "integer overflow":   {"id": 1, "i": float64(3000000000)},
+        "byte overflow":      {"id": 1, "b": 1000},
+        "not a number":       {"id": "abc"},
+        "fractional long":    {"id": 1.5},
+        "decimal precision":  {"id": 1, "d": "1234.5"},
+        "bad date":           {"id": 1, "day": "31/12/2020"},
+        "null in not null":   {"i": 1},
+    }
+    for name, rec := range bad {
+        if err := a.Insert(ctx, "t", []map[string]interface{}{rec}); err == nil { t.Fatalf("%s: expected error", name) }
+    }
+    if err := a.Insert(ctx, "t", []map[string]interface{}{{"id": 1, "i": -2147483648, "b": -128, "d": "999.99", "day": "2020-12-31"}}); err != nil {
+        t.Fatalf("in-range values rejected: %v", err)
+    }
+    st, _ := a.Stats(ctx, "t")
+    if st.NumRows != 1 || st.Version != 1 { t.Fatalf("failed inserts must not commit: %+v", st) }
+
+    if err := a.EnsureTable(ctx, "u", []DeltaColumn{{Name: "x", Type: "struct"}}); err == nil { t.Fatalf("expected unsupported type error") }
+}
+
+func TestDeltaNative_UnsupportedProtocol(t *testing.T) {
+    root := t.TempDir()
+    a := NewDeltaNativeAdapterAt(root)
+    ctx := context.Background()
+    path, _ := a.TablePath("dv")
+    meta, _ := newDeltaMetaData([]deltaField{newDeltaField("id", "long", true)})
+    err := writeDeltaCommit(path, 0, []deltaAction{
+        {Protocol: &deltaProtocol{MinReaderVersion: 3, MinWriterVersion: 7, ReaderFeatures: []string{"deletionVectors"}, WriterFeatures: []string{"deletionVectors"}}},
+        {MetaData: meta},
+    })
+    if err != nil { t.Fatalf("commit: %v", err) }
+    if _, err := a.Query(ctx, QueryRequest{DatasetID: "dv"}); err == nil || !strings.Contains(err.Error(), "deletionVectors") {
+        t.Fatalf("expected unsupported reader feature error, got %v", err)
+    }
+    if err := a.Insert(ctx, "dv", []map[string]interface{}{{"id": 1}}); err == nil { t.Fatalf("expected insert to be refused") }
+
+    path, _ = a.TablePath("cdf")
+    err = writeDeltaCommit(path, 0, []deltaAction{
+        {Protocol: &deltaProtocol{MinReaderVersion: 1, MinWriterVersion: 7, WriterFeatures: []string{"changeDataFeed"}}},
+        {MetaData: meta},
+    })
+    if err != nil { t.Fatalf("commit: %v", err) }
+    if _, err := a.Query(ctx, QueryRequest{DatasetID: "cdf"}); err != nil { t.Fatalf("reads should still work: %v", err) }
+    if err := a.Insert(ctx, "cdf", []map[string]interface{}{{"id": 1}}); err == nil || !strings.Contains(err.Error(), "changeDataFeed") {
+        t.Fatalf("expected unsupported writer feature error, got %v", err)
+    }
+}
+
+func TestDeltaNative_DeleteAndRestore(t *testing.T) {
+    path := copyFixtureTable(t, "delta_rs_table")
+    a := NewDeltaNativeAdapterAt(filepath.Dir(path))
+    ctx := context.Background()
+    id := filepath.Base(path)
+
+    if err := a.Delete(ctx, id, nil); err == nil { t.Fatalf("expected empty predicate to be rejected") }
+    if err := a.Delete(ctx, id, Predicate{{Column: "nope", Op: "eq", Value: 1}}); err == nil {
+        t.Fatalf("expected unknown column to be rejected")
+    }
+    // bob shares a file with ann, so that file is rewritten; the a/b partition is untouched
+    if err := a.Delete(ctx, id, Predicate{{Column: "region", Op: "eq", Value: "North America"}, {Column: "amount", Op: "lt", Value: 0}}); err != nil {
+        t.Fatalf("delete: %v", err)
+    }
+    res, err := a.Query(ctx, QueryRequest{DatasetID: id})
+    if err != nil { t.Fatalf("query: %v", err) }
+    rows := rowsByID(t, res)
+    if len(rows) != 2 || rows[2] != nil || rows[1]["seen_at"] != "2024-05-06T07:08:09.123456Z" || rows[1]["amount"] != 1234.56 {
+        t.Fatalf("unexpected rows after delete %+v", rows)
+    }
+    hist, err := a.History(ctx, id)
+    if err != nil || len(hist) != 3 || hist[0].Operation != "DELETE" { t.Fatalf("unexpected history %+v, %v", hist, err) }
+    m := hist[0].OperationMetrics
+    if m["numDeletedRows"] != "1" || m["numCopiedRows"] != "1" || m["numRemovedFiles"] != "1" || m["numAddedFiles"] != "1" {
+        t.Fatalf("unexpected metrics %+v", m)
+    }
+
+    // Nothing matches: no new version
+    if err := a.Delete(ctx, id, Predicate{{Column: "id", Op: "in", Value: []interface{}{float64(40), float64(50)}}}); err != nil {
+        t.Fatalf("no-op delete: %v", err)
+    }
+    if st, _ := a.Stats(ctx, id); st.Version != 2 { t.Fatalf("no-op delete wrote version %d", st.Version) }
+
+    // Whole-file delete only removes
+    if err := a.Delete(ctx, id, Predicate{{Column: "name", Op: "is_null"}}); err != nil { t.Fatalf("delete nulls: %v", err) }
+    if st, _ := a.Stats(ctx, id); st.NumRows != 1 || st.NumFiles != 1 { t.Fatalf("unexpected stats %+v", st) }
+
+    if err := a.Restore(ctx, id, 1); err != nil { t.Fatalf("restore: %v", err) }
+    res, err = a.Query(ctx, QueryRequest{DatasetID: id})
+    if err != nil || len(res.Rows) != 3 { t.Fatalf("restore did not bring rows back: %+v, %v", res.Rows, err) }
+}
+
+func TestDeltaNative_MergeAndWhere(t *testing.T) {
+    a := NewDeltaNativeAdapterAt(t.TempDir())
+    ctx := context.Background()
+    target := []map[string]interface{}{
+        {"id": float64(1), "name": "ann", "score": 2.5},
+        {"id": float64(2), "name": "bob", "score": 7.0},
+    }
+    if err := a.Insert(ctx, "1/2", target); err != nil { t.Fatalf("insert: %v", err) }
+    if err := a.Insert(ctx, "1/2", []map[string]interface{}{{"id": float64(3), "name": "cy", "score": nil}}); err != nil {
+        t.Fatalf("insert: %v", err)
+    }
+
+    res, err := a.Query(ctx, QueryRequest{DatasetID: "1/2", Where: Predicate{{Column: "score", Op: "gte", Value: float64(2.5)}}})
+    if err != nil || len(res.Rows) != 2 { t.Fatalf("where query: %+v, %v", res.Rows, err) }
+    // Strings are read as the column type, as DuckDB casts literals
+    res, err = a.Query(ctx, QueryRequest{DatasetID: "1/2", Where: Predicate{{Column: "id", Op: "in", Value: []interface{}{"2", " 3"}}}})
+    if err != nil || len(res.Rows) != 2 { t.Fatalf("string where query: %+v, %v", res.Rows, err) }
+    if _, err := a.Query(ctx, QueryRequest{DatasetID: "1/2", Where: Predicate{{Column: "id", Op: "eq", Value: "two"}}}); err == nil {
+        t.Fatalf("expected a value that is not a long to be rejected")
+    }
+    if _, err := a.Query(ctx, QueryRequest{DatasetID: "1/2", Where: Predicate{{Column: "nope", Op: "is_null"}}}); err == nil {
+        t.Fatalf("expected unknown where column to be rejected")
+    }
+
+    stage := DeltaStagingID(1, 2, 9)
+    source := []map[string]interface{}{
+        {"id": float64(2), "name": "bobby"},
+        {"id": float64(3), "name": "cy"},
+        {"id": float64(4), "name": "dee"},
+    }
+    // The staging table only has the columns being set; score is left alone
+    cols := []DeltaColumn{{Name: "id", Type: "long", Nullable: true}, {Name: "name", Type: "string", Nullable: true}}
+    if err := a.EnsureTable(ctx, stage, cols); err != nil { t.Fatalf("ensure staging: %v", err) }
+    if err := a.Insert(ctx, stage, source); err != nil { t.Fatalf("staging insert: %v", err) }
+    if err := a.Merge(ctx, "1/2", stage, []string{"nope"}); err == nil { t.Fatalf("expected unknown key to be rejected") }
+
+    if err := a.Merge(ctx, "1/2", stage, []string{"id"}); err != nil { t.Fatalf("merge: %v", err) }
+    rows := rowsByID(t, mustQuery(t, a, "1/2"))
+    if len(rows) != 4 || rows[2]["name"] != "bobby" || rows[2]["score"] != 7.0 || rows[4]["name"] != "dee" || rows[4]["score"] != nil || rows[1]["name"] != "ann" {
+        t.Fatalf("unexpected rows after merge %+v", rows)
+    }
+    hist, err := a.History(ctx, "1/2")
+    if err != nil || hist[0].Operation != "MERGE" { t.Fatalf("unexpected history %+v, %v", hist, err) }
+    // cy's staged row matches the target exactly, so it counts as copied
+    m := hist[0].OperationMetrics
+    if m["numTargetRowsInserted"] != "1" || m["numTargetRowsUpdated"] != "1" || m["numTargetRowsCopied"] != "1" || m["numTargetFilesRemoved"] != "1" {
+        t.Fatalf("unexpected metrics %+v", m)
+    }
+
+    // Replaying the same source changes nothing, so no version is written
+    if err := a.Merge(ctx, "1/2", stage, []string{"id"}); err != nil { t.Fatalf("replayed merge: %v", err) }
+    if st, _ := a.Stats(ctx, "1/2"); st.Version != hist[0].Version { t.Fatalf("no-op merge wrote version %d", st.Version) }
+
+    if err := a.Insert(ctx, stage, []map[string]interface{}{{"id": float64(4), "name": "dup"}}); err != nil { t.Fatalf("insert dup: %v", err) }
+    if err := a.Merge(ctx, "1/2", stage, []string{"id"}); err == nil { t.Fatalf("expected duplicate source keys to be rejected") }
+    if err := a.DropTable(ctx, stage); err != nil { t.Fatalf("drop staging: %v", err) }
+    if st, _ := a.Stats(ctx, stage); st.Version != -1 { t.Fatalf("staging table still present after drop: %+v", st) }
+}
+
+func TestDeltaNative_AppendOnly(t *testing.T) {
+    a := NewDeltaNativeAdapterAt(t.TempDir())
+    ctx := context.Background()
+    path, _ := a.TablePath("1/2")
+    meta, _ := newDeltaMetaData([]deltaField{newDeltaField("id", "long", true), newDeltaField("name", "string", true)})
+    on := "true"
+    meta.Configuration["delta.appendOnly"] = &on
+    if err := writeDeltaCommit(path, 0, []deltaAction{{Protocol: &deltaProtocol{MinReaderVersion: 1, MinWriterVersion: 2}}, {MetaData: meta}}); err != nil {
+        t.Fatalf("commit: %v", err)
+    }
+    if err := a.Insert(ctx, "1/2", []map[string]interface{}{{"id": 1, "name": "ann"}}); err != nil { t.Fatalf("insert: %v", err) }
+    if err := a.Delete(ctx, "1/2", Predicate{{Column: "id", Op: "eq", Value: 1}}); !errors.Is(err, errDeltaAppendOnly) {
+        t.Fatalf("delete: %v", err)
+    }
+    if err := a.Restore(ctx, "1/2", 0); !errors.Is(err, errDeltaAppendOnly) { t.Fatalf("restore: %v", err) }
+
+    stage := DeltaStagingID(1, 2, 1)
+    if err := a.Insert(ctx, stage, []map[string]interface{}{{"id": 2, "name": "bob"}}); err != nil { t.Fatalf("stage: %v", err) }
+    if err := a.Merge(ctx, "1/2", stage, []string{"id"}); err != nil { t.Fatalf("inserting merge: %v", err) }
+    if err := a.Insert(ctx, stage, []map[string]interface{}{{"id": 1, "name": "anne"}}); err != nil { t.Fatalf("stage: %v", err) }
+    if err := a.Merge(ctx, "1/2", stage, []string{"id"}); !errors.Is(err, errDeltaAppendOnly) { t.Fatalf("updating merge: %v", err) }
+    if st, _ := a.Stats(ctx, "1/2"); st.NumRows != 2 || st.Version != 2 { t.Fatalf("unexpected stats %+v", st) }
+}
+
+func TestRowKey_Exact(t *testing.T) {
+    key := func(v interface{}) string {
+        k, _ := RowKey(map[string]interface{}{"id": v}, []string{"id"})
+        return k
+    }
+    if key(int64(1<<53+1)) == key(int64(1<<53)) || key(int64(1<<53+1)) != key("9007199254740993") || key(json.Number("9007199254740993")) != key(int64(1<<53+1)) {
+        t.Fatalf("large integer keys lost precision")
+    }
+    if key(float64(5)) != key(int64(5)) || key("5") != key(int64(5)) || key(0.1) != key("0.1") || key(0.1) == key(0.2) || key("ann") != "ann\x00" {
+        t.Fatalf("equal keys of different types differ")
+    }
+}
+
+func mustQuery(t *testing.T, a *DeltaNativeAdapter, id string) QueryResult {
+    t.Helper()
+    res, err := a.Query(context.Background(), QueryRequest{DatasetID: id})
+    if err != nil { t.Fatalf("query: %v", err) }
+    return res
+}
+
+func TestDeltaNative_CommitTag(t *testing.T) {
+    a := NewDeltaNativeAdapterAt(t.TempDir())
+    ctx := context.Background()
+    if err := a.Insert(ctx, "1/2", []map[string]interface{}{{"id": 1, "name": "ann"}, {"id": 2, "name": "bob"}}); err != nil { t.Fatalf("insert: %v", err) }
+    tagged := WithCommitTag(ctx, "apply-1")
+    for i := 0; i < 2; i++ {
+        if err := a.Insert(tagged, "1/2", []map[string]interface{}{{"id": 3, "name": "cy"}}); err != nil { t.Fatalf("tagged insert %d: %v", i, err) }
+        if err := a.Delete(WithCommitTag(ctx, "apply-2"), "1/2", Predicate{{Column: "id", Op: "eq", Value: 1}}); err != nil { t.Fatalf("tagged delete %d: %v", i, err) }
+    }
+    // An empty tag clears the inherited one
+    if err := a.Insert(WithCommitTag(tagged, ""), "1/2", []map[string]interface{}{{"id": 4, "name": "dee"}}); err != nil { t.Fatalf("untagged insert: %v", err) }
+    st, err := a.Stats(ctx, "1/2")
+    if err != nil || st.NumRows != 3 || st.Version != 3 { t.Fatalf("tagged writes applied more than once: %+v, %v", st, err) }
+    hist, err := a.History(ctx, "1/2")
+    if err != nil || len(hist) != 4 || hist[0].UserMetadata != "" || hist[1].UserMetadata != "apply-2" || hist[2].UserMetadata != "apply-1" {
+        t.Fatalf("unexpected history %+v, %v", hist, err)
+    }
+}
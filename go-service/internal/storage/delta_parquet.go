@@ -0,0 +1,599 @@
+package storage
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "math"
+    "math/big"
+    "net/url"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/parquet-go/parquet-go"
+)
+
+// Parquet data file reading/writing for DeltaNativeAdapter. Only flat schemas of primitive
+// Delta types are supported; nested struct/array/map columns are rejected.
+
+const deltaNullPartition = "__HIVE_DEFAULT_PARTITION__"
+
+var deltaDecimalRe = regexp.MustCompile(`^decimal\((\d+),\s*(\d+)\)$`)
+
+// parseDecimalType returns precision and scale of a "decimal(p,s)" type name.
+func parseDecimalType(typeName string) (precision, scale int, ok bool) {
+    m := deltaDecimalRe.FindStringSubmatch(typeName)
+    if m == nil { return 0, 0, false }
+    precision, _ = strconv.Atoi(m[1])
+    scale, _ = strconv.Atoi(m[2])
+    return precision, scale, true
+}
+
+// parquetNodeFor maps a Delta primitive type to a Parquet column; nullable columns are Optional.
+func parquetNodeFor(typeName string, nullable bool) (parquet.Node, error) {
+    var n parquet.Node
+    switch typeName {
+    case "string":
+        n = parquet.String()
+    case "long":
+        n = parquet.Int(64)
+    case "integer":
+        n = parquet.Int(32)
+    case "short":
+        n = parquet.Int(16)
+    case "byte":
+        n = parquet.Int(8)
+    case "double":
+        n = parquet.Leaf(parquet.DoubleType)
+    case "float":
+        n = parquet.Leaf(parquet.FloatType)
+    case "boolean":
+        n = parquet.Leaf(parquet.BooleanType)
+    case "date":
+        n = parquet.Date()
+    case "timestamp", "timestamp_ntz":
+        n = parquet.Timestamp(parquet.Microsecond)
+    default:
+        p, s, ok := parseDecimalType(typeName)
+        if !ok { return nil, fmt.Errorf("delta column type %q not supported", typeName) }
+        // Decimals up to 18 digits fit the INT64 physical type allowed by the Parquet spec
+        if p > 18 { return nil, fmt.Errorf("delta column type %q not supported (precision > 18)", typeName) }
+        n = parquet.Decimal(s, p, parquet.Int64Type)
+    }
+    if nullable { return parquet.Optional(n), nil }
+    return parquet.Required(n), nil
+}
+
+// inferDeltaType picks a Delta type for a Go value coming from JSON/CSV ingestion.
+func inferDeltaType(v interface{}) string {
+    switch x := v.(type) {
+    case bool:
+        return "boolean"
+    case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+        return "long"
+    case float32:
+        return "double"
+    case float64:
+        if x == math.Trunc(x) && math.Abs(x) < 1<<53 { return "long" }
+        return "double"
+    case json.Number:
+        if _, err := x.Int64(); err == nil { return "long" }
+        return "double"
+    case time.Time:
+        return "timestamp"
+    default:
+        return "string"
+    }
+}
+
+// inferDeltaFields derives a schema from records. Columns are ordered by first appearance
+// (then name), a column that mixes long and double widens to double, anything else mixed is string.
+func inferDeltaFields(records []map[string]interface{}) []deltaField {
+    types := map[string]string{}
+    order := []string{}
+    for _, rec := range records {
+        keys := make([]string, 0, len(rec))
+        for k := range rec { keys = append(keys, k) }
+        sort.Strings(keys)
+        for _, k := range keys {
+            prev, seen := types[k]
+            if !seen { order = append(order, k) }
+            if rec[k] == nil {
+                if !seen { types[k] = "" }
+                continue
+            }
+            t := inferDeltaType(rec[k])
+            switch {
+            case prev == "" || prev == t:
+                types[k] = t
+            case (prev == "long" && t == "double") || (prev == "double" && t == "long"):
+                types[k] = "double"
+            default:
+                types[k] = "string"
+            }
+        }
+    }
+    fields := make([]deltaField, 0, len(order))
+    for _, k := range order {
+        t := types[k]
+        if t == "" { t = "string" }
+        fields = append(fields, newDeltaField(k, t, true))
+    }
+    return fields
+}
+
+func newDeltaField(name, typeName string, nullable bool) deltaField {
+    tb, _ := json.Marshal(typeName)
+    return deltaField{Name: name, Type: tb, Nullable: nullable, Metadata: map[string]interface{}{}}
+}
+
+// integerRange is the accepted range for the Delta integral types.
+func integerRange(typeName string) (int64, int64) {
+    switch typeName {
+    case "integer":
+        return math.MinInt32, math.MaxInt32
+    case "short":
+        return math.MinInt16, math.MaxInt16
+    case "byte":
+        return math.MinInt8, math.MaxInt8
+    }
+    return math.MinInt64, math.MaxInt64
+}
+
+// coerceDeltaValue converts an ingested value to the Go representation written for typeName:
+// string, int64, int32, float64, float32, bool, or time.Time for date/timestamp. Decimals are
+// float64 rounded to the column scale.
+func coerceDeltaValue(typeName string, v interface{}) (interface{}, error) {
+    if v == nil { return nil, nil }
+    if s, ok := v.(string); ok && typeName != "string" {
+        s = strings.TrimSpace(s)
+        if s == "" { return nil, nil }
+        v = s
+    }
+    switch typeName {
+    case "string":
+        switch x := v.(type) {
+        case string:
+            return x, nil
+        case time.Time:
+            return x.UTC().Format(time.RFC3339Nano), nil
+        case float64:
+            return strconv.FormatFloat(x, 'f', -1, 64), nil
+        }
+        return fmt.Sprint(v), nil
+    case "long", "integer", "short", "byte":
+        var n int64
+        switch x := v.(type) {
+        case string:
+            i, err := strconv.ParseInt(x, 10, 64)
+            if err != nil {
+                f, ferr := strconv.ParseFloat(x, 64)
+                if ferr != nil || f != math.Trunc(f) || math.Abs(f) >= 1<<63 { return nil, fmt.Errorf("%q is not a %s", x, typeName) }
+                i = int64(f)
+            }
+            n = i
+        case float64:
+            if x != math.Trunc(x) || math.Abs(x) >= 1<<63 { return nil, fmt.Errorf("%v is not a %s", x, typeName) }
+            n = int64(x)
+        case float32:
+            if float64(x) != math.Trunc(float64(x)) || math.Abs(float64(x)) >= 1<<63 { return nil, fmt.Errorf("%v is not a %s", x, typeName) }
+            n = int64(x)
+        case json.Number:
+            i, err := x.Int64()
+            if err != nil { return nil, fmt.Errorf("%s is not a %s", x, typeName) }
+            n = i
+        case bool:
+            return nil, fmt.Errorf("%v is not a %s", x, typeName)
+        default:
+            i, err := strconv.ParseInt(fmt.Sprint(x), 10, 64)
+            if err != nil { return nil, fmt.Errorf("%v is not a %s", x, typeName) }
+            n = i
+        }
+        lo, hi := integerRange(typeName)
+        if n < lo || n > hi { return nil, fmt.Errorf("%d is out of range for %s", n, typeName) }
+        if typeName == "long" { return n, nil }
+        return int32(n), nil
+    case "double", "float":
+        f, err := coerceFloat(typeName, v)
+        if err != nil { return nil, err }
+        if typeName == "float" {
+            if math.Abs(f) > math.MaxFloat32 { return nil, fmt.Errorf("%v is out of range for float", f) }
+            return float32(f), nil
+        }
+        return f, nil
+    case "boolean":
+        switch x := v.(type) {
+        case bool:
+            return x, nil
+        case string:
+            b, err := strconv.ParseBool(strings.ToLower(x))
+            if err != nil { return nil, fmt.Errorf("%q is not a boolean", x) }
+            return b, nil
+        case float64:
+            return x != 0, nil
+        }
+        return nil, fmt.Errorf("%v is not a boolean", v)
+    case "date", "timestamp", "timestamp_ntz":
+        switch x := v.(type) {
+        case time.Time:
+            return x.UTC(), nil
+        case string:
+            for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05", "2006-01-02"} {
+                if t, err := time.Parse(layout, x); err == nil { return t.UTC(), nil }
+            }
+            return nil, fmt.Errorf("%q is not a %s", x, typeName)
+        }
+        return nil, fmt.Errorf("%v is not a %s", v, typeName)
+    }
+    if p, s, ok := parseDecimalType(typeName); ok {
+        f, err := coerceFloat(typeName, v)
+        if err != nil { return nil, err }
+        unscaled := math.Round(f * math.Pow10(s))
+        if math.Abs(unscaled) >= math.Pow10(p) { return nil, fmt.Errorf("%v is out of range for %s", v, typeName) }
+        return unscaled / math.Pow10(s), nil
+    }
+    return nil, fmt.Errorf("delta column type %q not supported", typeName)
+}
+
+func coerceFloat(typeName string, v interface{}) (float64, error) {
+    switch x := v.(type) {
+    case string:
+        p, err := strconv.ParseFloat(x, 64)
+        if err != nil { return 0, fmt.Errorf("%q is not a %s", x, typeName) }
+        return p, nil
+    case float64:
+        return x, nil
+    case float32:
+        return float64(x), nil
+    case json.Number:
+        p, err := x.Float64()
+        if err != nil { return 0, fmt.Errorf("%s is not a %s", x, typeName) }
+        return p, nil
+    case bool:
+        return 0, fmt.Errorf("%v is not a %s", x, typeName)
+    }
+    p, err := strconv.ParseFloat(fmt.Sprint(v), 64)
+    if err != nil { return 0, fmt.Errorf("%v is not a %s", v, typeName) }
+    return p, nil
+}
+
+// parquetValueFor converts a coerced Go value to a Parquet value for its column type.
+func parquetValueFor(typeName string, v interface{}) parquet.Value {
+    switch typeName {
+    case "date":
+        t := v.(time.Time)
+        return parquet.ValueOf(int32(t.Unix() / 86400))
+    case "timestamp", "timestamp_ntz":
+        return parquet.ValueOf(v.(time.Time).UnixMicro())
+    }
+    if _, s, ok := parseDecimalType(typeName); ok {
+        return parquet.ValueOf(int64(math.Round(v.(float64) * math.Pow10(s))))
+    }
+    return parquet.ValueOf(v)
+}
+
+// partitionValueString renders a partition column value the way Delta stores it in partitionValues.
+func partitionValueString(typeName string, v interface{}) (*string, error) {
+    cv, err := coerceDeltaValue(typeName, v)
+    if err != nil || cv == nil { return nil, err }
+    var s string
+    switch x := cv.(type) {
+    case time.Time:
+        if typeName == "date" {
+            s = x.Format("2006-01-02")
+        } else {
+            s = x.Format("2006-01-02 15:04:05.999999")
+        }
+    case float64:
+        s = strconv.FormatFloat(x, 'f', -1, 64)
+    default:
+        s = fmt.Sprint(x)
+    }
+    return &s, nil
+}
+
+// escapePartitionValue applies Hive escaping to a partition value used as a directory name,
+// matching what Spark and delta-rs write on disk.
+func escapePartitionValue(v string) string {
+    var sb strings.Builder
+    for _, r := range v {
+        if r < 0x20 || r == 0x7f || strings.ContainsRune("\"#%'*/:=?\\{[]^", r) {
+            fmt.Fprintf(&sb, "%%%02X", r)
+            continue
+        }
+        sb.WriteRune(r)
+    }
+    return sb.String()
+}
+
+// deltaURIPath encodes a relative on-disk path for add/remove actions (the log stores URIs).
+func deltaURIPath(rel string) string {
+    return (&url.URL{Path: rel}).EscapedPath()
+}
+
+// deltaFilePath resolves the path of an add/remove action to the data file on disk.
+func deltaFilePath(tablePath, actionPath string) string {
+    if u, err := url.Parse(actionPath); err == nil {
+        if u.Scheme == "file" { return filepath.FromSlash(u.Path) }
+        if u.Scheme == "" { return filepath.Join(tablePath, filepath.FromSlash(u.Path)) }
+    }
+    return filepath.Join(tablePath, filepath.FromSlash(actionPath))
+}
+
+// writeDeltaDataFiles writes records as Parquet files under the table (one per partition)
+// and returns the add actions to commit. Records must only use columns from the schema.
+func writeDeltaDataFiles(snap *deltaSnapshot, records []map[string]interface{}) ([]deltaAdd, error) {
+    known := map[string]bool{}
+    for _, f := range snap.Fields { known[f.Name] = true }
+    for _, rec := range records {
+        for k := range rec {
+            if !known[k] { return nil, fmt.Errorf("column %q is not in the table schema", k) }
+        }
+    }
+    // Group records by partition values (a single group for unpartitioned tables)
+    type group struct {
+        values map[string]*string
+        dir    string
+        rows   []map[string]interface{}
+    }
+    groups := map[string]*group{}
+    keys := []string{}
+    for _, rec := range records {
+        values := map[string]*string{}
+        dirParts := []string{}
+        for _, col := range snap.MetaData.PartitionColumns {
+            f, _ := snap.field(col)
+            pv, err := partitionValueString(f.typeName(), rec[col])
+            if err != nil { return nil, fmt.Errorf("column %q: %w", col, err) }
+            if pv == nil && !f.Nullable { return nil, fmt.Errorf("column %q is not nullable", col) }
+            values[col] = pv
+            seg := deltaNullPartition
+            if pv != nil { seg = escapePartitionValue(*pv) }
+            dirParts = append(dirParts, col+"="+seg)
+        }
+        key := strings.Join(dirParts, "/")
+        g, ok := groups[key]
+        if !ok {
+            g = &group{values: values, dir: key}
+            groups[key] = g
+            keys = append(keys, key)
+        }
+        g.rows = append(g.rows, rec)
+    }
+    dataFields := make([]deltaField, 0, len(snap.Fields))
+    for _, f := range snap.Fields {
+        if !snap.isPartitionColumn(f.Name) { dataFields = append(dataFields, f) }
+    }
+    adds := make([]deltaAdd, 0, len(groups))
+    for _, key := range keys {
+        g := groups[key]
+        rel := fmt.Sprintf("part-00000-%s-c000.snappy.parquet", uuid.NewString())
+        if g.dir != "" { rel = g.dir + "/" + rel }
+        size, err := writeParquetFile(filepath.Join(snap.Path, filepath.FromSlash(rel)), dataFields, g.rows)
+        if err != nil {
+            removeDeltaDataFiles(snap.Path, adds)
+            return nil, err
+        }
+        stats, _ := json.Marshal(map[string]interface{}{"numRecords": len(g.rows)})
+        adds = append(adds, deltaAdd{
+            Path:             deltaURIPath(rel),
+            PartitionValues:  g.values,
+            Size:             size,
+            ModificationTime: time.Now().UnixMilli(),
+            DataChange:       true,
+            Stats:            string(stats),
+        })
+    }
+    return adds, nil
+}
+
+func removeDeltaDataFiles(tablePath string, adds []deltaAdd) {
+    for _, a := range adds { _ = os.Remove(deltaFilePath(tablePath, a.Path)) }
+}
+
+func writeParquetFile(path string, fields []deltaField, records []map[string]interface{}) (int64, error) {
+    group := parquet.Group{}
+    for _, f := range fields {
+        node, err := parquetNodeFor(f.typeName(), f.Nullable)
+        if err != nil { return 0, fmt.Errorf("column %q: %w", f.Name, err) }
+        group[f.Name] = node
+    }
+    schema := parquet.NewSchema("root", group)
+    // parquet.Group orders columns by name; map each column index back to its Delta field
+    leaves := schema.Fields()
+    byName := map[string]deltaField{}
+    for _, f := range fields { byName[f.Name] = f }
+
+    rows := make([]parquet.Row, 0, len(records))
+    for _, rec := range records {
+        row := make(parquet.Row, len(leaves))
+        for i, leaf := range leaves {
+            f := byName[leaf.Name()]
+            v, err := coerceDeltaValue(f.typeName(), rec[leaf.Name()])
+            if err != nil { return 0, fmt.Errorf("column %q: %w", leaf.Name(), err) }
+            switch {
+            case v == nil && !f.Nullable:
+                return 0, fmt.Errorf("column %q is not nullable", leaf.Name())
+            case v == nil:
+                row[i] = parquet.Value{}.Level(0, 0, i)
+            case f.Nullable:
+                row[i] = parquetValueFor(f.typeName(), v).Level(0, 1, i)
+            default:
+                row[i] = parquetValueFor(f.typeName(), v).Level(0, 0, i)
+            }
+        }
+        rows = append(rows, row)
+    }
+
+    if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil { return 0, err }
+    out, err := os.Create(path)
+    if err != nil { return 0, err }
+    w := parquet.NewWriter(out, schema, parquet.Compression(&parquet.Snappy))
+    _, err = w.WriteRows(rows)
+    if err == nil { err = w.Close() }
+    if cerr := out.Close(); err == nil { err = cerr }
+    if err != nil {
+        os.Remove(path)
+        return 0, err
+    }
+    st, err := os.Stat(path)
+    if err != nil { return 0, err }
+    return st.Size(), nil
+}
+
+func openDeltaFile(snap *deltaSnapshot, add deltaAdd) (*os.File, *parquet.File, error) {
+    f, err := os.Open(deltaFilePath(snap.Path, add.Path))
+    if err != nil {
+        if os.IsNotExist(err) { return nil, nil, fmt.Errorf("delta data file %s is missing (vacuumed?)", add.Path) }
+        return nil, nil, err
+    }
+    st, err := f.Stat()
+    if err != nil {
+        f.Close()
+        return nil, nil, err
+    }
+    pf, err := parquet.OpenFile(f, st.Size())
+    if err != nil {
+        f.Close()
+        return nil, nil, fmt.Errorf("delta data file %s: %w", add.Path, err)
+    }
+    return f, pf, nil
+}
+
+// deltaFileNumRows returns the row count of a data file from its stats, or the Parquet footer.
+func deltaFileNumRows(snap *deltaSnapshot, add deltaAdd) (int64, error) {
+    if n, ok := addNumRecords(add); ok { return n, nil }
+    f, pf, err := openDeltaFile(snap, add)
+    if err != nil { return 0, err }
+    defer f.Close()
+    return pf.NumRows(), nil
+}
+
+// readDeltaFile reads one data file into row maps, filling partition columns from the add action.
+func readDeltaFile(snap *deltaSnapshot, add deltaAdd) ([]map[string]interface{}, error) {
+    f, pf, err := openDeltaFile(snap, add)
+    if err != nil { return nil, err }
+    defer f.Close()
+    leaves := pf.Schema().Fields()
+    for _, l := range leaves {
+        if !l.Leaf() { return nil, fmt.Errorf("nested column %q not supported", l.Name()) }
+    }
+    partitions := map[string]interface{}{}
+    for col, pv := range add.PartitionValues {
+        if pv == nil || *pv == deltaNullPartition {
+            partitions[col] = nil
+            continue
+        }
+        fld, _ := snap.field(col)
+        v, err := coerceDeltaValue(fld.typeName(), *pv)
+        if err != nil { return nil, fmt.Errorf("partition %q: %w", col, err) }
+        partitions[col] = deltaOutputValue(v, fld.typeName())
+    }
+    out := make([]map[string]interface{}, 0, pf.NumRows())
+    buf := make([]parquet.Row, 256)
+    for _, rg := range pf.RowGroups() {
+        rows := rg.Rows()
+        for {
+            n, err := rows.ReadRows(buf)
+            for _, row := range buf[:n] {
+                rec := make(map[string]interface{}, len(leaves)+len(partitions))
+                for _, v := range row {
+                    col := v.Column()
+                    if col < 0 || col >= len(leaves) { continue }
+                    rec[leaves[col].Name()] = parquetValueToGo(v, leaves[col].Type())
+                }
+                for k, v := range partitions { rec[k] = v }
+                out = append(out, rec)
+            }
+            if err == io.EOF { break }
+            if err != nil {
+                rows.Close()
+                return nil, err
+            }
+            if n == 0 { break }
+        }
+        rows.Close()
+    }
+    return out, nil
+}
+
+// deltaOutputValue renders coerced values the way Query returns them (dates/timestamps as strings).
+func deltaOutputValue(v interface{}, typeName string) interface{} {
+    switch x := v.(type) {
+    case time.Time:
+        if typeName == "date" { return x.Format("2006-01-02") }
+        return x.Format(time.RFC3339Nano)
+    case int32:
+        return int64(x)
+    case float32:
+        return float64(x)
+    }
+    return v
+}
+
+func parquetValueToGo(v parquet.Value, t parquet.Type) interface{} {
+    if v.IsNull() { return nil }
+    lt := t.LogicalType()
+    switch v.Kind() {
+    case parquet.Boolean:
+        return v.Boolean()
+    case parquet.Int32:
+        if lt != nil && lt.Date != nil {
+            return time.Unix(int64(v.Int32())*86400, 0).UTC().Format("2006-01-02")
+        }
+        if lt != nil && lt.Decimal != nil {
+            return scaleDecimal(big.NewInt(int64(v.Int32())), lt.Decimal.Scale)
+        }
+        return int64(v.Int32())
+    case parquet.Int64:
+        if lt != nil && lt.Timestamp != nil {
+            n := v.Int64()
+            var ts time.Time
+            switch {
+            case lt.Timestamp.Unit.Millis != nil:
+                ts = time.UnixMilli(n)
+            case lt.Timestamp.Unit.Nanos != nil:
+                ts = time.Unix(0, n)
+            default:
+                ts = time.UnixMicro(n)
+            }
+            return ts.UTC().Format(time.RFC3339Nano)
+        }
+        if lt != nil && lt.Decimal != nil {
+            return scaleDecimal(big.NewInt(v.Int64()), lt.Decimal.Scale)
+        }
+        return v.Int64()
+    case parquet.Int96:
+        // Legacy timestamps: 8 bytes nanos-of-day + 4 bytes Julian day
+        i96 := v.Int96()
+        nanos := int64(i96[1])<<32 | int64(i96[0])
+        days := int64(i96[2]) - 2440588
+        return time.Unix(days*86400, nanos).UTC().Format(time.RFC3339Nano)
+    case parquet.Float:
+        return float64(v.Float())
+    case parquet.Double:
+        return v.Double()
+    case parquet.ByteArray, parquet.FixedLenByteArray:
+        b := v.ByteArray()
+        if lt != nil && lt.Decimal != nil {
+            n := new(big.Int).SetBytes(b)
+            if len(b) > 0 && b[0]&0x80 != 0 {
+                n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+            }
+            return scaleDecimal(n, lt.Decimal.Scale)
+        }
+        return string(b)
+    }
+    return nil
+}
+
+func scaleDecimal(unscaled *big.Int, scale int32) float64 {
+    f, _ := new(big.Float).Quo(new(big.Float).SetInt(unscaled), new(big.Float).SetFloat64(math.Pow10(int(scale)))).Float64()
+    return f
+}
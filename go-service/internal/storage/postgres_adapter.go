@@ -118,12 +118,50 @@ func (p *PostgresAdapter) Insert(ctx context.Context, datasetID string, records
     return nil
 }
 
+// Merge upserts the staging table (as created by Insert) into the target table in one
+// transaction: target rows whose keys match a staging row take the staging values, the other
+// staging rows are inserted. Columns come from the staging table and must exist in the target.
 func (p *PostgresAdapter) Merge(ctx context.Context, datasetID string, stagingPath string, keys []string) error {
-    // Not implemented for legacy path.
-    return nil
+    table, stage := sanitizeIdent(datasetID), sanitizeIdent(stagingPath)
+    if table == "" || stage == "" { return errors.New("invalid dataset/table name") }
+    if len(keys) == 0 { return errors.New("merge keys required") }
+    db, err := p.getDB()
+    if err != nil { return err }
+    cols, err := tableColumns(ctx, db, stage)
+    if err != nil { return err }
+    isCol := map[string]bool{}
+    for _, c := range cols { isCol[c] = true }
+    keyConds := make([]string, len(keys))
+    for i, k := range keys {
+        k = sanitizeIdent(k)
+        if k == "" || !isCol[k] { return fmt.Errorf("merge key %q is not a staging column", keys[i]) }
+        keyConds[i] = fmt.Sprintf("s.\"%s\" = \"%s\".\"%s\"", k, table, k)
+    }
+    match := strings.Join(keyConds, " AND ")
+    sets := make([]string, 0, len(cols))
+    for _, c := range cols {
+        sets = append(sets, fmt.Sprintf("\"%s\" = (SELECT s.\"%s\" FROM \"%s\" s WHERE %s)", c, c, stage, match))
+    }
+    tx, err := db.BeginTx(ctx, nil)
+    if err != nil { return err }
+    defer tx.Rollback()
+    updateSQL := fmt.Sprintf("UPDATE \"%s\" SET %s WHERE EXISTS (SELECT 1 FROM \"%s\" s WHERE %s)", table, strings.Join(sets, ", "), stage, match)
+    if _, err := tx.ExecContext(ctx, updateSQL); err != nil { return err }
+    insertSQL := fmt.Sprintf("INSERT INTO \"%s\" (%s) SELECT %s FROM \"%s\" s WHERE NOT EXISTS (SELECT 1 FROM \"%s\" WHERE %s)",
+        table, joinQuoted(cols), joinQuoted(cols), stage, table, match)
+    if _, err := tx.ExecContext(ctx, insertSQL); err != nil { return err }
+    return tx.Commit()
+}
+
+// tableColumns lists a table's columns in declaration order.
+func tableColumns(ctx context.Context, db *sql.DB, table string) ([]string, error) {
+    rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT * FROM \"%s\" WHERE 1 = 0", table))
+    if err != nil { return nil, err }
+    defer rows.Close()
+    return rows.Columns()
 }
 
-func (p *PostgresAdapter) Delete(ctx context.Context, datasetID string, filter string) error {
+func (p *PostgresAdapter) Delete(ctx context.Context, datasetID string, where Predicate) error {
     // Not implemented for legacy path.
     return nil
 }
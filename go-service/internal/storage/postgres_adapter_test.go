@@ -59,3 +59,31 @@ func TestPostgresAdapter_Query(t *testing.T) {
         t.Fatalf("expected 2 rows, got %d", len(res.Rows))
     }
 }
+
+func TestPostgresAdapter_Merge(t *testing.T) {
+    ensureDB(t)
+    a := &PostgresAdapter{}
+    ctx := context.Background()
+    gdb := dbpkg.Get()
+    _ = gdb.Exec("DROP TABLE IF EXISTS t_merge").Error
+    _ = gdb.Exec("DROP TABLE IF EXISTS t_merge_stg").Error
+    if err := a.Insert(ctx, "t_merge", []map[string]interface{}{{"id": 1, "name": "alice"}, {"id": 2, "name": "bob"}}); err != nil {
+        t.Fatalf("insert target: %v", err)
+    }
+    if err := a.Insert(ctx, "t_merge_stg", []map[string]interface{}{{"id": 2, "name": "bobby"}, {"id": 3, "name": "cy"}}); err != nil {
+        t.Fatalf("insert staging: %v", err)
+    }
+    if err := a.Merge(ctx, "t_merge", "t_merge_stg", []string{"nope"}); err == nil {
+        t.Fatalf("expected unknown key to be rejected")
+    }
+    if err := a.Merge(ctx, "t_merge", "t_merge_stg", []string{"id"}); err != nil {
+        t.Fatalf("merge: %v", err)
+    }
+    res, err := a.Query(ctx, QueryRequest{SQL: "select id, name from t_merge order by id"})
+    if err != nil {
+        t.Fatalf("query: %v", err)
+    }
+    if len(res.Rows) != 3 || res.Rows[0][1] != "alice" || res.Rows[1][1] != "bobby" || res.Rows[2][1] != "cy" {
+        t.Fatalf("unexpected rows after merge: %v", res.Rows)
+    }
+}
@@ -0,0 +1,171 @@
+package storage
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// Condition compares one column with a value. Op is one of eq, ne, lt, lte, gt, gte, in,
+// is_null or not_null. Value holds a list for "in" and is ignored by the null checks.
+// Comparisons follow SQL: a null column value only matches is_null.
+type Condition struct {
+    Column string      `json:"column"`
+    Op     string      `json:"op"`
+    Value  interface{} `json:"value,omitempty"`
+}
+
+// Predicate is a structured row filter: a row matches when every condition holds. It is used
+// instead of raw SQL so that callers cannot inject expressions and backends can validate it.
+type Predicate []Condition
+
+var predicateOps = map[string]string{
+    "eq": "=", "ne": "!=", "lt": "<", "lte": "<=", "gt": ">", "gte": ">=",
+    "in": "IN", "is_null": "IS NULL", "not_null": "IS NOT NULL",
+}
+
+// Validate rejects empty predicates (which would match every row), unknown operators and
+// values that do not fit their operator.
+func (p Predicate) Validate() error {
+    if len(p) == 0 { return errors.New("predicate must have at least one condition") }
+    for _, c := range p {
+        if strings.TrimSpace(c.Column) == "" { return errors.New("predicate condition has no column") }
+        switch c.Op {
+        case "is_null", "not_null":
+        case "in":
+            list, ok := c.Value.([]interface{})
+            if !ok || len(list) == 0 { return fmt.Errorf("condition on %q: in needs a non-empty list", c.Column) }
+            for _, v := range list {
+                if v == nil { return fmt.Errorf("condition on %q: in list cannot contain null", c.Column) }
+            }
+        case "eq", "ne", "lt", "lte", "gt", "gte":
+            if c.Value == nil { return fmt.Errorf("condition on %q: %s needs a value (use is_null/not_null for nulls)", c.Column, c.Op) }
+            if _, ok := c.Value.([]interface{}); ok { return fmt.Errorf("condition on %q: %s needs a single value", c.Column, c.Op) }
+        default:
+            return fmt.Errorf("condition on %q: unknown operator %q", c.Column, c.Op)
+        }
+    }
+    return nil
+}
+
+// Columns lists the distinct columns the predicate refers to.
+func (p Predicate) Columns() []string {
+    seen := map[string]bool{}
+    var out []string
+    for _, c := range p {
+        if !seen[c.Column] { seen[c.Column] = true; out = append(out, c.Column) }
+    }
+    return out
+}
+
+// Matches evaluates the predicate against one row without column types, such as a JSON
+// document ingested from CSV: a string holding a number compares with numbers by value.
+func (p Predicate) Matches(rec map[string]interface{}) bool {
+    return p.matches(rec, compareUntypedValues)
+}
+
+// matchesTyped evaluates the predicate against a row of typed columns, comparing values with
+// compareDeltaValues.
+func (p Predicate) matchesTyped(rec map[string]interface{}) bool {
+    return p.matches(rec, compareDeltaValues)
+}
+
+func (p Predicate) matches(rec map[string]interface{}, compare func(a, b interface{}) int) bool {
+    for _, c := range p {
+        if !c.matches(rec[c.Column], compare) { return false }
+    }
+    return true
+}
+
+func (c Condition) matches(got interface{}, compare func(a, b interface{}) int) bool {
+    switch c.Op {
+    case "is_null":
+        return got == nil
+    case "not_null":
+        return got != nil
+    }
+    if got == nil { return false }
+    switch c.Op {
+    case "in":
+        list, _ := c.Value.([]interface{})
+        for _, v := range list {
+            if compare(got, v) == 0 { return true }
+        }
+        return false
+    }
+    cmp := compare(got, c.Value)
+    switch c.Op {
+    case "eq":
+        return cmp == 0
+    case "ne":
+        return cmp != 0
+    case "lt":
+        return cmp < 0
+    case "lte":
+        return cmp <= 0
+    case "gt":
+        return cmp > 0
+    case "gte":
+        return cmp >= 0
+    }
+    return false
+}
+
+// compareUntypedValues is compareDeltaValues with strings that hold numbers read as numbers.
+func compareUntypedValues(a, b interface{}) int {
+    if s, ok := a.(string); ok {
+        if n, ok := numericString(s); ok { a = n }
+    }
+    if s, ok := b.(string); ok {
+        if n, ok := numericString(s); ok { b = n }
+    }
+    return compareDeltaValues(a, b)
+}
+
+// equalities returns the eq conditions as a filter map, for partition pruning.
+func (p Predicate) equalities() map[string]interface{} {
+    out := map[string]interface{}{}
+    for _, c := range p {
+        if c.Op == "eq" { out[c.Column] = c.Value }
+    }
+    return out
+}
+
+// String renders the predicate as a SQL boolean expression, as recorded in commit metadata.
+func (p Predicate) String() string {
+    parts := make([]string, 0, len(p))
+    for _, c := range p {
+        col := `"` + strings.ReplaceAll(c.Column, `"`, `""`) + `"`
+        op := predicateOps[c.Op]
+        switch c.Op {
+        case "is_null", "not_null":
+            parts = append(parts, col+" "+op)
+        case "in":
+            list, _ := c.Value.([]interface{})
+            vals := make([]string, len(list))
+            for i, v := range list { vals[i] = sqlLiteral(v) }
+            parts = append(parts, col+" IN ("+strings.Join(vals, ", ")+")")
+        default:
+            parts = append(parts, col+" "+op+" "+sqlLiteral(c.Value))
+        }
+    }
+    return strings.Join(parts, " AND ")
+}
+
+func sqlLiteral(v interface{}) string {
+    switch x := v.(type) {
+    case nil:
+        return "NULL"
+    case bool:
+        return strconv.FormatBool(x)
+    case float64:
+        return strconv.FormatFloat(x, 'f', -1, 64)
+    case int, int32, int64:
+        return fmt.Sprint(x)
+    case json.Number:
+        return x.String()
+    }
+    return "'" + strings.ReplaceAll(fmt.Sprint(v), "'", "''") + "'"
+}
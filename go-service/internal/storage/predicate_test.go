@@ -0,0 +1,49 @@
+package storage
+
+import "testing"
+
+func TestPredicate_ValidateAndMatch(t *testing.T) {
+    bad := []Predicate{
+        nil,
+        {{Column: "", Op: "eq", Value: 1}},
+        {{Column: "a", Op: "like", Value: "x%"}},
+        {{Column: "a", Op: "eq"}},
+        {{Column: "a", Op: "in", Value: []interface{}{}}},
+        {{Column: "a", Op: "gt", Value: []interface{}{1}}},
+    }
+    for _, p := range bad {
+        if err := p.Validate(); err == nil { t.Fatalf("expected %+v to be rejected", p) }
+    }
+
+    p := Predicate{{Column: "n", Op: "gte", Value: float64(2)}, {Column: "s", Op: "in", Value: []interface{}{"x", "y"}}}
+    if err := p.Validate(); err != nil { t.Fatalf("validate: %v", err) }
+    cases := []struct {
+        rec  map[string]interface{}
+        want bool
+    }{
+        {map[string]interface{}{"n": int64(2), "s": "x"}, true},
+        {map[string]interface{}{"n": "10", "s": "y"}, true},
+        {map[string]interface{}{"n": int64(1), "s": "x"}, false},
+        {map[string]interface{}{"n": nil, "s": "x"}, false},
+        {map[string]interface{}{"n": int64(3), "s": "z"}, false},
+    }
+    for _, c := range cases {
+        if got := p.Matches(c.rec); got != c.want { t.Fatalf("Matches(%v) = %v, want %v", c.rec, got, c.want) }
+    }
+    // Integers beyond 2^53 compare exactly, also against floats
+    big := map[string]interface{}{"n": int64(1<<53 + 1)}
+    if !(Predicate{{Column: "n", Op: "gt", Value: float64(1 << 53)}}).Matches(big) || (Predicate{{Column: "n", Op: "eq", Value: int64(1 << 53)}}).Matches(big) {
+        t.Fatalf("large integers lost precision")
+    }
+    // Typed columns keep their types: a numeric string is not a number
+    if p.matchesTyped(map[string]interface{}{"n": "10", "s": "y"}) || !(Predicate{{Column: "s", Op: "lt", Value: "9"}}).matchesTyped(map[string]interface{}{"s": "10"}) {
+        t.Fatalf("typed match compared strings as numbers")
+    }
+    if (Predicate{{Column: "n", Op: "ne", Value: 1}}).Matches(map[string]interface{}{"n": nil}) {
+        t.Fatalf("ne must not match null")
+    }
+    if got := p.String(); got != `"n" >= 2 AND "s" IN ('x', 'y')` { t.Fatalf("String() = %s", got) }
+    if got := (Predicate{{Column: `a"b`, Op: "eq", Value: "it's"}, {Column: "c", Op: "not_null"}}).String(); got != `"a""b" = 'it''s' AND "c" IS NOT NULL` {
+        t.Fatalf("String() = %s", got)
+    }
+}
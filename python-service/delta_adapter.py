@@ -193,11 +193,13 @@ class DeltaStorageAdapter:
         
         return path
 
-    def append_to_main(self, project_id: int, dataset_id: int, rows: List[Dict[str, Any]]) -> Dict[str, Any]:
+    def append_to_main(self, project_id: int, dataset_id: int, rows: List[Dict[str, Any]],
+                       commit_tag: Optional[str] = None) -> Dict[str, Any]:
         """Append rows to the main Delta table using MERGE semantics.
         
         Uses DuckDB-based MERGE to prevent duplicate rows.
         A row is considered duplicate if ALL column values already exist in the table.
+        With a commit_tag the append is idempotent: see _tagged_commit.
         
         WARNING: Only use this for approved, validated data!
         For change requests, use staging tables instead.
@@ -206,6 +208,8 @@ class DeltaStorageAdapter:
             raise RuntimeError("pyarrow required for delta operations")
         
         path = self._main_path(project_id, dataset_id)
+        if self._tagged_commit(path, commit_tag) is not None:
+            return {"ok": True, "inserted": 0, "duplicates": 0, "already_applied": True}
         at = pa.Table.from_pylist(rows)
         
         # Schema alignment logic (same as before)
@@ -221,7 +225,7 @@ class DeltaStorageAdapter:
         if not main_exists:
             # No existing table, just write as new
             try:
-                write_deltalake(path, at, mode="overwrite")
+                write_deltalake(path, at, mode="overwrite", custom_metadata=self._commit_metadata(commit_tag))
             except ValueError as e:
                 if "Schema of data does not match" in str(e):
                     at = self._handle_schema_mismatch(path, at)
@@ -280,7 +284,7 @@ class DeltaStorageAdapter:
         inserted_count = len(rows) - dup_count
         
         # Write merged result back
-        write_deltalake(path, merged_table, mode="overwrite")
+        write_deltalake(path, merged_table, mode="overwrite", custom_metadata=self._commit_metadata(commit_tag))
         
         logger.info(json.dumps({
             "event": "append_to_main",
@@ -630,6 +634,11 @@ class DeltaStorageAdapter:
                 rows_updated = int(metrics["numTargetRowsUpdated"])
             if "numTargetRowsDeleted" in metrics:
                 rows_deleted = int(metrics["numTargetRowsDeleted"])
+
+            # For DELETE operations (delta-rs records snake_case metrics)
+            for key in ("num_deleted_rows", "numDeletedRows"):
+                if key in metrics:
+                    rows_deleted = int(metrics[key])
             
             # For RESTORE operations - calculate diff
             if latest.get("operation") == "RESTORE":
@@ -770,8 +779,125 @@ class DeltaStorageAdapter:
                 raise ValueError(f"Version {version} not restorable: files may have been deleted by VACUUM")
             raise
 
+    def delete_rows(self, table: str, where: List[Dict[str, Any]], commit_tag: Optional[str] = None) -> Dict[str, Any]:
+        """Delete the rows matching a structured predicate, as a new DELETE version.
+
+        `table` is "<project_id>/<dataset_id>" for a dataset's main table, otherwise a
+        legacy table name. `where` is a list of {column, op, value} conditions that must
+        all hold; see _predicate_sql for the operators. With a commit_tag the delete is
+        idempotent: see _tagged_commit.
+        """
+        path = self._resolve_table(table)
+        dt = DeltaTable(path)
+        predicate = self._predicate_sql(dt, where)
+        done = self._tagged_commit(path, commit_tag)
+        if done is not None:
+            return {"ok": True, "predicate": predicate, "rows_deleted": 0, "version": done, "already_applied": True}
+        metrics = dt.delete(predicate, custom_metadata=self._commit_metadata(commit_tag))
+        deleted = int(metrics.get("num_deleted_rows", 0) or 0)
+
+        logger.info(json.dumps({
+            "event": "delete",
+            "table": table,
+            "predicate": predicate,
+            "rows_deleted": deleted
+        }))
+
+        return {
+            "ok": True,
+            "predicate": predicate,
+            "rows_deleted": deleted,
+            "version": dt.version()
+        }
+
     # ==================== Helper Methods ====================
 
+    @staticmethod
+    def _commit_metadata(commit_tag: Optional[str]) -> Optional[Dict[str, str]]:
+        """commitInfo metadata recording a caller's commit tag (the change request apply key)."""
+        return {"userMetadata": commit_tag} if commit_tag else None
+
+    @staticmethod
+    def _tagged_commit(path: str, commit_tag: Optional[str]) -> Optional[int]:
+        """Version of the commit carrying commit_tag, or None.
+
+        Writers called with a tag skip the write when the table already has it, so a retried
+        change request approval never applies twice.
+        """
+        if not commit_tag or not os.path.exists(os.path.join(path, "_delta_log")):
+            return None
+        for entry in DeltaTable(path).history():
+            if entry.get("userMetadata") == commit_tag:
+                return entry.get("version")
+        return None
+
+    def _resolve_table(self, table: str) -> str:
+        """Map "<project_id>/<dataset_id>" to the main table, anything else to a legacy path."""
+        parts = table.strip("/").split("/")
+        if len(parts) == 2 and all(p.isdigit() for p in parts):
+            return self._main_path(int(parts[0]), int(parts[1]))
+        return self._table_path(table)
+
+    _PREDICATE_OPS = {"eq": "=", "ne": "!=", "lt": "<", "lte": "<=", "gt": ">", "gte": ">="}
+
+    def _predicate_sql(self, dt: DeltaTable, where: List[Dict[str, Any]]) -> str:
+        """Render structured conditions as a delta-rs predicate.
+
+        Operators: eq, ne, lt, lte, gt, gte, in (value is a list), is_null, not_null.
+        Columns must exist and literals are rendered from the column type, so no caller
+        text reaches the expression unquoted.
+        """
+        if not where:
+            raise ValueError("predicate must have at least one condition")
+        types = {f.name: str(getattr(f.type, "type", f.type)) for f in dt.schema().fields}
+        parts = []
+        for cond in where:
+            col = cond.get("column")
+            op = cond.get("op")
+            if col not in types:
+                raise ValueError(f"unknown predicate column {col!r}")
+            ident = '"' + col.replace('"', '""') + '"'
+            if op == "is_null":
+                parts.append(f"{ident} IS NULL")
+            elif op == "not_null":
+                parts.append(f"{ident} IS NOT NULL")
+            elif op == "in":
+                values = cond.get("value")
+                if not isinstance(values, list) or not values or any(v is None for v in values):
+                    raise ValueError(f"condition on {col!r}: in needs a non-empty list without nulls")
+                parts.append(f"{ident} IN (" + ", ".join(self._sql_literal(v, types[col]) for v in values) + ")")
+            elif op in self._PREDICATE_OPS:
+                value = cond.get("value")
+                if value is None or isinstance(value, list):
+                    raise ValueError(f"condition on {col!r}: {op} needs a single value")
+                parts.append(f"{ident} {self._PREDICATE_OPS[op]} {self._sql_literal(value, types[col])}")
+            else:
+                raise ValueError(f"condition on {col!r}: unknown operator {op!r}")
+        return " AND ".join(parts)
+
+    @staticmethod
+    def _sql_literal(value: Any, delta_type: str) -> str:
+        if delta_type in ("long", "integer", "short", "byte"):
+            try:
+                f = float(value)
+            except (TypeError, ValueError):
+                raise ValueError(f"{value!r} is not a {delta_type}")
+            if f != int(f):
+                raise ValueError(f"{value!r} is not a {delta_type}")
+            return str(int(f))
+        if delta_type in ("double", "float") or delta_type.startswith("decimal"):
+            try:
+                return repr(float(value))
+            except (TypeError, ValueError):
+                raise ValueError(f"{value!r} is not a {delta_type}")
+        if delta_type == "boolean":
+            if isinstance(value, bool):
+                return "true" if value else "false"
+            if str(value).lower() in ("true", "false"):
+                return str(value).lower()
+            raise ValueError(f"{value!r} is not a boolean")
+        return "'" + str(value).replace("'", "''") + "'"
+
     def _align_to_existing_schema(self, path: str, at: pa.Table) -> pa.Table:
         """Align an Arrow table to match existing Delta table schema."""
         con = duckdb.connect()
@@ -1105,15 +1231,22 @@ class DeltaStorageAdapter:
         return self._query_table(path, sql_where, limit, offset, filters, order_by)
 
     def merge(self, name: str, rows: Optional[List[Dict[str, Any]]] = None, keys: Optional[List[str]] = None,
-              staging_path: Optional[str] = None) -> Dict[str, Any]:
-        """Legacy merge method for backward compatibility."""
+              staging_path: Optional[str] = None, commit_tag: Optional[str] = None) -> Dict[str, Any]:
+        """Legacy merge method for backward compatibility.
+
+        With a commit_tag the merge is idempotent: see _tagged_commit.
+        """
         if keys is None or len(keys) == 0:
             raise ValueError("keys are required for merge")
         
-        target_path = self._table_path(name)
+        target_path = self._resolve_table(name)
+        done = self._tagged_commit(target_path, commit_tag)
+        if done is not None:
+            return {"ok": True, "rows_inserted": 0, "rows_updated": 0, "version": done, "already_applied": True}
         
         if staging_path:
-            stage_path = staging_path
+            # Relative staging paths (e.g. projects/<p>/datasets/<d>/staging/<cr>) live under the root
+            stage_path = staging_path if os.path.isabs(staging_path) else os.path.join(self.cfg.root, staging_path)
         else:
             if pa is None:
                 raise RuntimeError("pyarrow required for delta operations")
@@ -1128,14 +1261,20 @@ class DeltaStorageAdapter:
             src = DeltaTable(stage_path)
             pred = " AND ".join([f"t.\"{k}\" = s.\"{k}\"" for k in keys])
             
-            (tgt.alias("t")
-                .merge(src.alias("s"), pred)
+            metrics = (tgt.alias("t")
+                .merge(src.alias("s"), pred, custom_metadata=self._commit_metadata(commit_tag))
                 .when_matched_update_all()
                 .when_not_matched_insert_all()
                 .execute())
             
             logger.info(json.dumps({"event": "merge", "table": name, "keys": keys, "method": "native"}))
-            return {"ok": True, "method": "native"}
+            return {
+                "ok": True,
+                "method": "native",
+                "rows_inserted": (metrics or {}).get("num_target_rows_inserted", 0),
+                "rows_updated": (metrics or {}).get("num_target_rows_updated", 0),
+                "version": tgt.version(),
+            }
         
         except Exception as e:
             con = duckdb.connect()
@@ -1156,7 +1295,7 @@ class DeltaStorageAdapter:
             """
             
             rel = con.execute(upsert_sql).fetch_arrow_table()
-            write_deltalake(target_path, rel, mode="overwrite")
+            write_deltalake(target_path, rel, mode="overwrite", custom_metadata=self._commit_metadata(commit_tag))
             
             logger.info(json.dumps({"event": "merge", "table": name, "keys": keys, "method": "fallback", "error": str(e)}))
             return {"ok": True, "method": "fallback"}
@@ -5,6 +5,8 @@ from jsonschema import Draft202012Validator, exceptions as js_exceptions
 import io
 import json
 import os
+import re
+import threading
 try:
     import pandas as pd
 except Exception:  # optional dependency guard
@@ -367,7 +369,8 @@ def delta_append_file(
     file: UploadFile = File(...),
     project_id: Optional[int] = Form(None),
     dataset_id: Optional[int] = Form(None),
-    table: Optional[str] = Form(None)  # Legacy support
+    table: Optional[str] = Form(None),  # Legacy support
+    commit_tag: Optional[str] = Form(None),
 ):
     if _delta_adapter is None:
         raise HTTPException(status_code=500, detail="Delta adapter not available")
@@ -416,12 +419,13 @@ def delta_append_file(
         raise HTTPException(status_code=400, detail="invalid rows")
     
     if use_hierarchical:
-        result = _delta_adapter.append_to_main(project_id, dataset_id, rows or [])
+        result = _delta_adapter.append_to_main(project_id, dataset_id, rows or [], commit_tag=commit_tag)
         return {
             "ok": True, 
             "total_rows": len(rows), 
             "inserted": result.get("inserted", len(rows)),
-            "duplicates": result.get("duplicates", 0)
+            "duplicates": result.get("duplicates", 0),
+            "already_applied": result.get("already_applied", False)
         }
     else:
         _delta_adapter.append_rows(table, rows or [])  # Legacy
@@ -792,6 +796,30 @@ def delta_snapshot_data(project_id: int, dataset_id: int, version: int,
         raise HTTPException(status_code=500, detail=f"Failed to read version {version}: {str(e)}")
 
 
+class DeltaDeletePayload(BaseModel):
+    model_config = ConfigDict(extra="ignore")
+    table: str
+    where: List[Dict[str, Any]]
+    commit_tag: Optional[str] = None
+
+
+@app.post("/delta/delete")
+def delta_delete(payload: DeltaDeletePayload):
+    """Delete rows matching a structured predicate; the delete becomes a new table version.
+
+    `table` is "<project_id>/<dataset_id>" (or a legacy table name) and `where` a list of
+    {column, op, value} conditions that must all hold. An empty `where` is rejected.
+    """
+    if _delta_adapter is None:
+        raise HTTPException(status_code=500, detail="Delta adapter not available")
+    try:
+        return _delta_adapter.delete_rows(payload.table, payload.where, commit_tag=payload.commit_tag)
+    except ValueError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+    except Exception as e:
+        raise HTTPException(status_code=500, detail=f"Delete failed: {str(e)}")
+
+
 class DeltaMergePayload(BaseModel):
     model_config = ConfigDict(extra="ignore")
     table: Optional[str] = None
@@ -799,6 +827,7 @@ class DeltaMergePayload(BaseModel):
     staging_path: Optional[str] = None
     keys: List[str]
     rows: Optional[List[Dict[str, Any]]] = None
+    commit_tag: Optional[str] = None
 
 
 @app.post("/delta/merge")
@@ -816,13 +845,16 @@ def delta_merge(payload: DeltaMergePayload):
         r = root.rstrip("/\\")
         if p.startswith(r):
             tbl = p[len(r):].lstrip("/\\")
+        elif re.fullmatch(r"\d+/\d+", p.strip("/")):
+            # "<project_id>/<dataset_id>" as sent by the Go DeltaAdapter
+            tbl = p.strip("/")
         else:
             tbl = p.strip("/\\").split("/")[-1]
     if not tbl:
         raise HTTPException(status_code=400, detail="table or target_path is required")
     if not payload.keys:
         raise HTTPException(status_code=400, detail="keys are required")
-    result = _delta_adapter.merge(tbl, rows=payload.rows, keys=payload.keys, staging_path=payload.staging_path)
+    result = _delta_adapter.merge(tbl, rows=payload.rows, keys=payload.keys, staging_path=payload.staging_path, commit_tag=payload.commit_tag)
     return result
 
 
@@ -1389,9 +1421,91 @@ def delta_table_info(
 
 class DeltaQueryRequest(BaseModel):
     sql: str
-    table_mappings: Dict[str, str]  # {"schema.table": "project_id/dataset_id"}
+    table_mappings: Dict[str, str]  # {"schema.table": "project_id/dataset_id[@v<version>|@t<timestamp>]"}
+    # Tables pulled from other backends for federated joins: {"schema.table": {"columns": [...], "rows": [[...]]}}
+    inline_tables: Dict[str, Dict[str, Any]] = {}
     limit: int = 250
     offset: int = 0
+    # Set by the Go service so the query can be cancelled; timeout_ms interrupts it when due
+    query_id: Optional[str] = None
+    timeout_ms: Optional[int] = None
+    # Values of the query's $1, $2, ... parameters, bound by DuckDB
+    params: List[Any] = []
+    # Return DuckDB's plan for the query instead of its rows
+    explain: bool = False
+    # Stream the rows as NDJSON instead of returning one JSON page, see _stream_delta_query
+    stream: bool = False
+
+
+# Running DuckDB connections by query_id, for cancellation
+_running_queries: Dict[str, Any] = {}
+_running_queries_lock = threading.Lock()
+
+
+@app.post("/delta/query/{query_id}/cancel")
+def delta_query_cancel(query_id: str):
+    """Interrupt a running /delta/query call."""
+    with _running_queries_lock:
+        con = _running_queries.get(query_id)
+    if con is None:
+        raise HTTPException(status_code=404, detail="Query not running")
+    con.interrupt()
+    return {"ok": True, "query_id": query_id}
+
+
+# Rows fetched from DuckDB at a time when streaming a query
+_STREAM_BATCH_ROWS = 10000
+
+
+def _stream_delta_query(con, req: DeltaQueryRequest):
+    """Run req.sql and stream at most req.limit rows from req.offset as NDJSON: a {"columns": [...]}
+    line, then one JSON array per row. The query fails with an HTTP error before the first line;
+    a failure after it ends the stream with an {"error": ...} line."""
+    from fastapi.encoders import jsonable_encoder
+    from fastapi.responses import StreamingResponse
+    import duckdb
+
+    timer = None
+    if req.query_id:
+        with _running_queries_lock:
+            _running_queries[req.query_id] = con
+    if req.timeout_ms:
+        timer = threading.Timer(req.timeout_ms / 1000.0, con.interrupt)
+        timer.start()
+
+    def cleanup():
+        if timer is not None:
+            timer.cancel()
+        if req.query_id:
+            with _running_queries_lock:
+                _running_queries.pop(req.query_id, None)
+
+    try:
+        con.execute(f"SELECT * FROM ({req.sql}) AS subquery LIMIT {req.limit} OFFSET {req.offset}", req.params or None)
+        columns = [d[0] for d in con.description]
+    except duckdb.InterruptException:
+        cleanup()
+        raise HTTPException(status_code=408, detail="Query cancelled")
+    except Exception as e:
+        cleanup()
+        raise HTTPException(status_code=500, detail=f"Query execution failed: {str(e)}")
+
+    def lines():
+        try:
+            yield json.dumps({"columns": columns}) + "\n"
+            while True:
+                batch = con.fetchmany(_STREAM_BATCH_ROWS)
+                if not batch:
+                    break
+                yield "".join(json.dumps(jsonable_encoder(list(row))) + "\n" for row in batch)
+        except duckdb.InterruptException:
+            yield json.dumps({"error": "Query cancelled"}) + "\n"
+        except Exception as e:
+            yield json.dumps({"error": f"Query execution failed: {str(e)}"}) + "\n"
+        finally:
+            cleanup()
+
+    return StreamingResponse(lines(), media_type="application/x-ndjson")
 
 
 @app.post("/delta/query")
@@ -1418,7 +1532,9 @@ def delta_query(req: DeltaQueryRequest):
         
         # Register each Delta table as a view in DuckDB
         for table_ref, path_info in req.table_mappings.items():
-            # path_info can be "project_id/dataset_id" or just "dataset_id"
+            # path_info can be "project_id/dataset_id" or just "dataset_id", followed by
+            # "@v<version>" or "@t<RFC 3339 timestamp>" to read the table as it was then
+            path_info, _, as_of = path_info.partition("@")
             delta_path = None
             
             if "/" in path_info:
@@ -1456,8 +1572,21 @@ def delta_query(req: DeltaQueryRequest):
             # Create view with the table reference name
             # Replace dots with underscores for DuckDB view names, but keep original in SELECT
             view_name = table_ref.replace(".", "_")
+            source_sql = f"delta_scan('{duckdb_path}')"
+            if as_of:
+                try:
+                    if as_of.startswith("v"):
+                        dt = DeltaTable(delta_path, version=int(as_of[1:]))
+                    else:
+                        dt = DeltaTable(delta_path)
+                        dt.load_with_datetime(as_of[1:])
+                except Exception as e:
+                    raise HTTPException(status_code=400, detail=f"{table_ref}: cannot read table as of {as_of[1:]}: {e}")
+                source = f"__asof_{view_name}"
+                con.register(source, dt.to_pyarrow_dataset())
+                source_sql = f'"{source}"'
             try:
-                con.execute(f"CREATE OR REPLACE VIEW {view_name} AS SELECT * FROM delta_scan('{duckdb_path}')")
+                con.execute(f"CREATE OR REPLACE VIEW {view_name} AS SELECT * FROM {source_sql}")
                 print(f"DEBUG: Created view {view_name}")
             except Exception as e:
                 print(f"DEBUG: Failed to create view {view_name}: {e}")
@@ -1469,17 +1598,55 @@ def delta_query(req: DeltaQueryRequest):
                 schema_name, table_name = table_ref.split(".", 1)
                 try:
                     con.execute(f"CREATE SCHEMA IF NOT EXISTS {schema_name}")
-                    con.execute(f"CREATE OR REPLACE VIEW {schema_name}.{table_name} AS SELECT * FROM delta_scan('{duckdb_path}')")
+                    con.execute(f"CREATE OR REPLACE VIEW {schema_name}.{table_name} AS SELECT * FROM {source_sql}")
                     print(f"DEBUG: Created schema view {schema_name}.{table_name}")
                 except Exception as e:
                     print(f"DEBUG: Failed to create schema view {schema_name}.{table_name}: {e}")
                     raise
         
+        # Register tables pulled from other backends so the query can join them with Delta tables
+        if req.inline_tables:
+            import pyarrow as pa
+
+            for table_ref, table in req.inline_tables.items():
+                columns = table.get("columns") or []
+                rows = table.get("rows") or []
+                arrow_table = pa.table({col: [row[i] for row in rows] for i, col in enumerate(columns)})
+                view_name = table_ref.replace(".", "_")
+                source = f"__inline_{view_name}"
+                con.register(source, arrow_table)
+                con.execute(f'CREATE OR REPLACE VIEW "{view_name}" AS SELECT * FROM "{source}"')
+                if "." in table_ref:
+                    schema_name, table_name = table_ref.split(".", 1)
+                    con.execute(f'CREATE SCHEMA IF NOT EXISTS "{schema_name}"')
+                    con.execute(f'CREATE OR REPLACE VIEW "{schema_name}"."{table_name}" AS SELECT * FROM "{source}"')
+
+        if req.stream and not req.explain:
+            return _stream_delta_query(con, req)
+
         # Execute the query with pagination
         # Wrap user query to apply limit/offset
         full_query = f"SELECT * FROM ({req.sql}) AS subquery LIMIT {req.limit} OFFSET {req.offset}"
+        if req.explain:
+            full_query = f"EXPLAIN {req.sql}"
         
-        result = con.execute(full_query).fetch_arrow_table()
+        timer = None
+        if req.query_id:
+            with _running_queries_lock:
+                _running_queries[req.query_id] = con
+        if req.timeout_ms:
+            timer = threading.Timer(req.timeout_ms / 1000.0, con.interrupt)
+            timer.start()
+        try:
+            result = con.execute(full_query, req.params or None).fetch_arrow_table()
+        except duckdb.InterruptException:
+            raise HTTPException(status_code=408, detail="Query cancelled")
+        finally:
+            if timer is not None:
+                timer.cancel()
+            if req.query_id:
+                with _running_queries_lock:
+                    _running_queries.pop(req.query_id, None)
         
         # Convert to Python native types for JSON serialization
         columns = result.column_names
@@ -41,3 +41,32 @@ def test_append_and_query_roundtrip():
         assert isinstance(hist, list) and len(hist) >= 1
     finally:
         shutil.rmtree(tmp, ignore_errors=True)
+
+
+def test_delete_rows_structured_predicate():
+    tmp = tempfile.mkdtemp()
+    try:
+        os.environ["DELTA_DATA_ROOT"] = tmp
+        adapter = DeltaStorageAdapter(DeltaConfig.from_env())
+        adapter.append_rows("unit_del", [
+            {"id": 1, "name": "alice"},
+            {"id": 2, "name": "o'brien"},
+            {"id": 3, "name": "carol"},
+        ])
+        with pytest.raises(ValueError):
+            adapter.delete_rows("unit_del", [])
+        with pytest.raises(ValueError):
+            adapter.delete_rows("unit_del", [{"column": "nope", "op": "eq", "value": 1}])
+        with pytest.raises(ValueError):
+            adapter.delete_rows("unit_del", [{"column": "id", "op": "eq", "value": "1 OR 1=1"}])
+
+        resp = adapter.delete_rows("unit_del", [
+            {"column": "id", "op": "in", "value": [2, 3]},
+            {"column": "name", "op": "ne", "value": "carol"},
+        ])
+        assert resp["ok"] and resp["rows_deleted"] == 1
+        res = adapter.query("unit_del", limit=10, offset=0)
+        assert {r["id"] for r in res["rows"]} == {1, 3}
+        assert DeltaTable(adapter._table_path("unit_del")).history(limit=1)[0]["operation"] == "DELETE"
+    finally:
+        shutil.rmtree(tmp, ignore_errors=True)